@@ -0,0 +1,256 @@
+// Package exporter lets Go applications that embed Simple Traces send their OpenTelemetry spans
+// straight to its database, skipping the OTLP/HTTP hop. Spans go through the same ingest
+// pipeline as exported ones, so models, conversations, categories and the other derived fields
+// are filled in alike.
+//
+//	db, _ := backend.InitDatabase(&config)
+//	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter.New(db)))
+//	otel.SetTracerProvider(tp)
+package exporter
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	collectorpb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+
+	"github.com/abi-jey/simple-traces/src/simple-traces/backend"
+)
+
+// errShutdown is returned by exports after Shutdown
+var errShutdown = errors.New("exporter is shut down")
+
+// Exporter implements sdktrace.SpanExporter on top of a Simple Traces database
+type Exporter struct {
+	h *backend.OTLPHandler
+
+	mu       sync.RWMutex
+	shutdown bool
+}
+
+var _ sdktrace.SpanExporter = (*Exporter)(nil)
+
+// Option configures an Exporter
+type Option func(*options)
+
+type options struct {
+	logger *backend.Logger
+}
+
+// WithLogger logs ingest through logger instead of the global Simple Traces logger
+func WithLogger(logger *backend.Logger) Option {
+	return func(o *options) { o.logger = logger }
+}
+
+// New creates an exporter storing spans in db. The database stays owned by the caller; Shutdown
+// doesn't close it.
+func New(db backend.Database, opts ...Option) *Exporter {
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.logger == nil {
+		o.logger = backend.GetLogger()
+	}
+	return NewWithHandler(backend.NewOTLPHandler(db, o.logger))
+}
+
+// NewWithHandler creates an exporter feeding an already configured ingest handler, for
+// applications that also install transforms, dedup or noise filters on it
+func NewWithHandler(h *backend.OTLPHandler) *Exporter {
+	return &Exporter{h: h}
+}
+
+// ExportSpans stores a batch of ended spans
+func (e *Exporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.shutdown {
+		return errShutdown
+	}
+	if len(spans) == 0 {
+		return nil
+	}
+	res, err := e.h.Export(toRequest(spans))
+	if err != nil {
+		return err
+	}
+	if res.Rejected > 0 {
+		return errors.New(res.Message)
+	}
+	return nil
+}
+
+// Shutdown stops accepting spans; exports after it fail
+func (e *Exporter) Shutdown(ctx context.Context) error {
+	e.mu.Lock()
+	e.shutdown = true
+	e.mu.Unlock()
+	return ctx.Err()
+}
+
+// toRequest groups spans by resource and instrumentation scope into one OTLP export
+func toRequest(spans []sdktrace.ReadOnlySpan) *collectorpb.ExportTraceServiceRequest {
+	type scopeKey struct {
+		resource attribute.Distinct
+		name     string
+		version  string
+	}
+	req := &collectorpb.ExportTraceServiceRequest{}
+	resources := make(map[attribute.Distinct]*tracepb.ResourceSpans)
+	scopes := make(map[scopeKey]*tracepb.ScopeSpans)
+	for _, s := range spans {
+		if s == nil {
+			continue
+		}
+		var rkey attribute.Distinct
+		res := s.Resource()
+		if res != nil {
+			rkey = res.Equivalent()
+		}
+		rs, ok := resources[rkey]
+		if !ok {
+			rs = &tracepb.ResourceSpans{Resource: &resourcepb.Resource{}}
+			if res != nil {
+				rs.Resource.Attributes = keyValues(res.Attributes())
+				rs.SchemaUrl = res.SchemaURL()
+			}
+			resources[rkey] = rs
+			req.ResourceSpans = append(req.ResourceSpans, rs)
+		}
+		scope := s.InstrumentationScope()
+		skey := scopeKey{resource: rkey, name: scope.Name, version: scope.Version}
+		ss, ok := scopes[skey]
+		if !ok {
+			ss = &tracepb.ScopeSpans{
+				Scope: &commonpb.InstrumentationScope{
+					Name:       scope.Name,
+					Version:    scope.Version,
+					Attributes: keyValues(scope.Attributes.ToSlice()),
+				},
+				SchemaUrl: scope.SchemaURL,
+			}
+			scopes[skey] = ss
+			rs.ScopeSpans = append(rs.ScopeSpans, ss)
+		}
+		ss.Spans = append(ss.Spans, toSpan(s))
+	}
+	return req
+}
+
+func toSpan(s sdktrace.ReadOnlySpan) *tracepb.Span {
+	sc := s.SpanContext()
+	tid, sid := sc.TraceID(), sc.SpanID()
+	span := &tracepb.Span{
+		TraceId:                tid[:],
+		SpanId:                 sid[:],
+		TraceState:             sc.TraceState().String(),
+		Flags:                  uint32(sc.TraceFlags()),
+		Name:                   s.Name(),
+		Kind:                   spanKind(s.SpanKind()),
+		StartTimeUnixNano:      uint64(s.StartTime().UnixNano()),
+		EndTimeUnixNano:        uint64(s.EndTime().UnixNano()),
+		Attributes:             keyValues(s.Attributes()),
+		DroppedAttributesCount: uint32(s.DroppedAttributes()),
+		DroppedEventsCount:     uint32(s.DroppedEvents()),
+		DroppedLinksCount:      uint32(s.DroppedLinks()),
+	}
+	if parent := s.Parent(); parent.IsValid() {
+		psid := parent.SpanID()
+		span.ParentSpanId = psid[:]
+	}
+	switch st := s.Status(); st.Code {
+	case codes.Ok:
+		span.Status = &tracepb.Status{Code: tracepb.Status_STATUS_CODE_OK, Message: st.Description}
+	case codes.Error:
+		span.Status = &tracepb.Status{Code: tracepb.Status_STATUS_CODE_ERROR, Message: st.Description}
+	}
+	for _, ev := range s.Events() {
+		span.Events = append(span.Events, &tracepb.Span_Event{
+			Name:                   ev.Name,
+			TimeUnixNano:           uint64(ev.Time.UnixNano()),
+			Attributes:             keyValues(ev.Attributes),
+			DroppedAttributesCount: uint32(ev.DroppedAttributeCount),
+		})
+	}
+	for _, l := range s.Links() {
+		ltid, lsid := l.SpanContext.TraceID(), l.SpanContext.SpanID()
+		span.Links = append(span.Links, &tracepb.Span_Link{
+			TraceId:                ltid[:],
+			SpanId:                 lsid[:],
+			TraceState:             l.SpanContext.TraceState().String(),
+			Attributes:             keyValues(l.Attributes),
+			DroppedAttributesCount: uint32(l.DroppedAttributeCount),
+			Flags:                  uint32(l.SpanContext.TraceFlags()),
+		})
+	}
+	return span
+}
+
+func spanKind(k trace.SpanKind) tracepb.Span_SpanKind {
+	switch k {
+	case trace.SpanKindInternal:
+		return tracepb.Span_SPAN_KIND_INTERNAL
+	case trace.SpanKindServer:
+		return tracepb.Span_SPAN_KIND_SERVER
+	case trace.SpanKindClient:
+		return tracepb.Span_SPAN_KIND_CLIENT
+	case trace.SpanKindProducer:
+		return tracepb.Span_SPAN_KIND_PRODUCER
+	case trace.SpanKindConsumer:
+		return tracepb.Span_SPAN_KIND_CONSUMER
+	}
+	return tracepb.Span_SPAN_KIND_UNSPECIFIED
+}
+
+func keyValues(attrs []attribute.KeyValue) []*commonpb.KeyValue {
+	if len(attrs) == 0 {
+		return nil
+	}
+	out := make([]*commonpb.KeyValue, 0, len(attrs))
+	for _, kv := range attrs {
+		out = append(out, &commonpb.KeyValue{Key: string(kv.Key), Value: anyValue(kv.Value)})
+	}
+	return out
+}
+
+func anyValue(v attribute.Value) *commonpb.AnyValue {
+	switch v.Type() {
+	case attribute.BOOL:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: v.AsBool()}}
+	case attribute.INT64:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: v.AsInt64()}}
+	case attribute.FLOAT64:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: v.AsFloat64()}}
+	case attribute.STRING:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: v.AsString()}}
+	case attribute.BOOLSLICE:
+		return arrayValue(v.AsBoolSlice(), func(b bool) *commonpb.AnyValue { return anyValue(attribute.BoolValue(b)) })
+	case attribute.INT64SLICE:
+		return arrayValue(v.AsInt64Slice(), func(i int64) *commonpb.AnyValue { return anyValue(attribute.Int64Value(i)) })
+	case attribute.FLOAT64SLICE:
+		return arrayValue(v.AsFloat64Slice(), func(f float64) *commonpb.AnyValue { return anyValue(attribute.Float64Value(f)) })
+	case attribute.STRINGSLICE:
+		return arrayValue(v.AsStringSlice(), func(s string) *commonpb.AnyValue { return anyValue(attribute.StringValue(s)) })
+	}
+	return &commonpb.AnyValue{}
+}
+
+func arrayValue[T any](values []T, conv func(T) *commonpb.AnyValue) *commonpb.AnyValue {
+	arr := &commonpb.ArrayValue{Values: make([]*commonpb.AnyValue, len(values))}
+	for i, v := range values {
+		arr.Values[i] = conv(v)
+	}
+	return &commonpb.AnyValue{Value: &commonpb.AnyValue_ArrayValue{ArrayValue: arr}}
+}
@@ -9,9 +9,38 @@ import (
 
 func main() {
 	logLevel := flag.String("log-level", "", "Set log level (DEBUG, INFO, WARN, ERROR)")
+	demo := flag.Bool("demo", false, "Serve bundled demo traces from an in-memory database")
 	flag.Parse()
 
-	if err := backend.Run(*logLevel); err != nil {
+	var err error
+	switch flag.Arg(0) {
+	case "":
+		err = backend.Run(*logLevel, *demo)
+	case "mcp":
+		// MCP server on stdin/stdout for AI assistants
+		err = backend.RunMCP(*logLevel)
+	case "tail":
+		// follow live spans of a running server
+		err = backend.RunTail(flag.Args()[1:])
+	case "query":
+		// trace groups or stats as a table, JSON or CSV
+		err = backend.RunQuery(flag.Args()[1:])
+	case "assert":
+		// evaluate trace assertions, non-zero exit when one fails
+		err = backend.RunAssert(flag.Args()[1:])
+	case "import":
+		// upload span JSONL or Langfuse exports to a running server
+		err = backend.RunImport(flag.Args()[1:])
+	case "migrate-data":
+		// copy every table from one database into another, resumable
+		err = backend.RunMigrateData(flag.Args()[1:])
+	case "loadgen":
+		// synthetic traces at a target rate against a server's OTLP endpoint
+		err = backend.RunLoadgen(flag.Args()[1:])
+	default:
+		log.Fatalf("unknown command %q", flag.Arg(0))
+	}
+	if err != nil {
 		log.Fatal(err)
 	}
 }
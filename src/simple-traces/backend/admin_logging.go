@@ -0,0 +1,47 @@
+package backend
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+type logLevelBody struct {
+	Level string `json:"level"`
+}
+
+// getLogLevelHandler reports the current log level
+func getLogLevelHandler(logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(logLevelBody{Level: logger.Level().String()})
+	}
+}
+
+// setLogLevelHandler changes the log level without a restart, e.g. to capture DEBUG output of
+// traffic that is misbehaving right now. The level is not persisted; a restart goes back to
+// LOG_LEVEL.
+func setLogLevelHandler(logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body logLevelBody
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		level, ok := lookupLogLevel(body.Level)
+		if !ok {
+			http.Error(w, "level must be DEBUG, INFO, WARN or ERROR", http.StatusBadRequest)
+			return
+		}
+		previous := logger.Level()
+		// logged while the more verbose of the two levels is active, so the change always shows up
+		if level > previous {
+			logger.Warn("Log level changed from %s to %s through the admin API", previous, level)
+			logger.SetLevel(level)
+		} else {
+			logger.SetLevel(level)
+			logger.Warn("Log level changed from %s to %s through the admin API", previous, level)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(logLevelBody{Level: level.String()})
+	}
+}
@@ -0,0 +1,293 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+)
+
+// TableStorage is the size of one table and its indexes. Sizes are nil when the database
+// cannot report them (SQLite builds without the dbstat table).
+type TableStorage struct {
+	Name       string `json:"name"`
+	Rows       int64  `json:"rows"`
+	TableBytes *int64 `json:"table_bytes,omitempty"`
+	IndexBytes *int64 `json:"index_bytes,omitempty"`
+	// Per-index sizes by index name
+	Indexes map[string]int64 `json:"indexes,omitempty"`
+}
+
+// StorageUsage describes the space used by the database
+type StorageUsage struct {
+	Dialect    string         `json:"dialect"`
+	TotalBytes int64          `json:"total_bytes"`
+	Tables     []TableStorage `json:"tables"`
+
+	// SQLite only
+	FileBytes   int64 `json:"file_bytes,omitempty"`
+	WALBytes    int64 `json:"wal_bytes,omitempty"`
+	PageSize    int64 `json:"page_size,omitempty"`
+	PageCount   int64 `json:"page_count,omitempty"`
+	FreePages   int64 `json:"free_pages,omitempty"`
+	FreeBytes   int64 `json:"free_bytes,omitempty"`
+	SizeMissing bool  `json:"table_sizes_unavailable,omitempty"`
+}
+
+// VacuumResult reports a maintenance run
+type VacuumResult struct {
+	Mode        string   `json:"mode"`
+	BytesBefore int64    `json:"bytes_before"`
+	BytesAfter  int64    `json:"bytes_after"`
+	DurationMS  int64    `json:"duration_ms"`
+	Statements  []string `json:"statements"`
+}
+
+// GetStorageUsage reports row counts and table/index sizes of all tables
+func (g *GormDB) GetStorageUsage() (*StorageUsage, error) {
+	tables, err := g.db.Migrator().GetTables()
+	if err != nil {
+		return nil, err
+	}
+	usage := &StorageUsage{Dialect: g.db.Dialector.Name()}
+	for _, name := range tables {
+		t := TableStorage{Name: name}
+		if err := g.db.Table(name).Count(&t.Rows).Error; err != nil {
+			return nil, fmt.Errorf("count %s: %w", name, err)
+		}
+		usage.Tables = append(usage.Tables, t)
+	}
+
+	switch usage.Dialect {
+	case "postgres":
+		err = g.postgresStorage(usage)
+	case "sqlite":
+		err = g.sqliteStorage(usage)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(usage.Tables, func(i, j int) bool {
+		return tableBytes(usage.Tables[i]) > tableBytes(usage.Tables[j]) ||
+			(tableBytes(usage.Tables[i]) == tableBytes(usage.Tables[j]) && usage.Tables[i].Rows > usage.Tables[j].Rows)
+	})
+	return usage, nil
+}
+
+func tableBytes(t TableStorage) int64 {
+	var n int64
+	if t.TableBytes != nil {
+		n += *t.TableBytes
+	}
+	if t.IndexBytes != nil {
+		n += *t.IndexBytes
+	}
+	return n
+}
+
+func (g *GormDB) postgresStorage(usage *StorageUsage) error {
+	if err := g.db.Raw("SELECT pg_database_size(current_database())").Scan(&usage.TotalBytes).Error; err != nil {
+		return err
+	}
+	for i := range usage.Tables {
+		t := &usage.Tables[i]
+		var sizes struct {
+			TableBytes int64
+			IndexBytes int64
+		}
+		// pg_table_size includes TOAST, where large attribute payloads end up
+		if err := g.db.Raw("SELECT pg_table_size(?::regclass) AS table_bytes, pg_indexes_size(?::regclass) AS index_bytes", t.Name, t.Name).
+			Scan(&sizes).Error; err != nil {
+			return err
+		}
+		t.TableBytes, t.IndexBytes = &sizes.TableBytes, &sizes.IndexBytes
+		var indexes []struct {
+			Name  string
+			Bytes int64
+		}
+		if err := g.db.Raw("SELECT indexrelid::regclass::text AS name, pg_relation_size(indexrelid) AS bytes FROM pg_index WHERE indrelid = ?::regclass", t.Name).
+			Scan(&indexes).Error; err != nil {
+			return err
+		}
+		t.Indexes = make(map[string]int64, len(indexes))
+		for _, ix := range indexes {
+			t.Indexes[ix.Name] = ix.Bytes
+		}
+	}
+	return nil
+}
+
+func (g *GormDB) sqliteStorage(usage *StorageUsage) error {
+	g.db.Raw("PRAGMA page_size").Scan(&usage.PageSize)
+	g.db.Raw("PRAGMA page_count").Scan(&usage.PageCount)
+	g.db.Raw("PRAGMA freelist_count").Scan(&usage.FreePages)
+	usage.FreeBytes = usage.FreePages * usage.PageSize
+	usage.TotalBytes = usage.PageCount * usage.PageSize
+	if path := g.sqliteFile(); path != "" {
+		if fi, err := os.Stat(path); err == nil {
+			usage.FileBytes = fi.Size()
+		}
+		if fi, err := os.Stat(path + "-wal"); err == nil {
+			usage.WALBytes = fi.Size()
+		}
+	}
+
+	// dbstat is an optional SQLite extension; without it only row counts are available
+	var objects []struct {
+		Name    string
+		TblName string
+		Type    string
+		Bytes   int64
+	}
+	err := g.db.Raw(`SELECT m.name, m.tbl_name, m.type, SUM(s.pgsize) AS bytes
+		FROM dbstat s JOIN sqlite_master m ON m.name = s.name GROUP BY m.name, m.tbl_name, m.type`).Scan(&objects).Error
+	if err != nil {
+		usage.SizeMissing = true
+		return nil
+	}
+	byTable := make(map[string]*TableStorage, len(usage.Tables))
+	for i := range usage.Tables {
+		t := &usage.Tables[i]
+		var zero, zeroIdx int64
+		t.TableBytes, t.IndexBytes = &zero, &zeroIdx
+		t.Indexes = make(map[string]int64)
+		byTable[t.Name] = t
+	}
+	for _, o := range objects {
+		t := byTable[o.TblName]
+		if t == nil {
+			continue
+		}
+		if o.Type == "index" {
+			*t.IndexBytes += o.Bytes
+			t.Indexes[o.Name] = o.Bytes
+		} else {
+			*t.TableBytes += o.Bytes
+		}
+	}
+	return nil
+}
+
+// sqliteFile returns the path of the main database file, or "" for in-memory databases
+func (g *GormDB) sqliteFile() string {
+	var rows []struct {
+		Name string
+		File string
+	}
+	if err := g.db.Raw("PRAGMA database_list").Scan(&rows).Error; err != nil {
+		return ""
+	}
+	for _, r := range rows {
+		if r.Name == "main" {
+			return r.File
+		}
+	}
+	return ""
+}
+
+// Vacuum runs database maintenance. Mode "optimize" only refreshes planner statistics and is
+// cheap; "incremental" also returns free pages on SQLite databases in incremental auto-vacuum
+// mode; "full" rewrites the database to return all free pages (SQLite VACUUM locks the
+// database while it runs) and switches SQLite to incremental auto-vacuum.
+func (g *GormDB) Vacuum(mode string) (*VacuumResult, error) {
+	res := &VacuumResult{Mode: mode}
+	var statements []string
+	switch g.db.Dialector.Name() {
+	case "postgres":
+		g.db.Raw("SELECT pg_database_size(current_database())").Scan(&res.BytesBefore)
+		// regular vacuuming is left to autovacuum
+		statements = []string{"ANALYZE"}
+		if mode == "full" {
+			statements = []string{"VACUUM ANALYZE"}
+		}
+	default:
+		var pages, size int64
+		g.db.Raw("PRAGMA page_count").Scan(&pages)
+		g.db.Raw("PRAGMA page_size").Scan(&size)
+		res.BytesBefore = pages * size
+		statements = []string{"PRAGMA optimize"}
+		switch mode {
+		case "incremental":
+			var autoVacuum, free int64
+			g.db.Raw("PRAGMA auto_vacuum").Scan(&autoVacuum)
+			g.db.Raw("PRAGMA freelist_count").Scan(&free)
+			if autoVacuum == sqliteAutoVacuumIncremental && free > 0 {
+				statements = append(statements, "PRAGMA incremental_vacuum")
+			}
+		case "full":
+			statements = []string{"PRAGMA auto_vacuum = INCREMENTAL", "VACUUM", "PRAGMA optimize", "PRAGMA wal_checkpoint(TRUNCATE)"}
+		}
+	}
+
+	start := time.Now()
+	for _, stmt := range statements {
+		if err := g.execMaintenance(stmt); err != nil {
+			return nil, fmt.Errorf("%s: %w", stmt, err)
+		}
+		res.Statements = append(res.Statements, stmt)
+	}
+	res.DurationMS = time.Since(start).Milliseconds()
+
+	if g.db.Dialector.Name() == "postgres" {
+		g.db.Raw("SELECT pg_database_size(current_database())").Scan(&res.BytesAfter)
+	} else {
+		var pages, size int64
+		g.db.Raw("PRAGMA page_count").Scan(&pages)
+		g.db.Raw("PRAGMA page_size").Scan(&size)
+		res.BytesAfter = pages * size
+	}
+	return res, nil
+}
+
+// execMaintenance runs a maintenance statement to completion. SQLite's incremental_vacuum
+// frees one page per result row, so its rows must be read rather than executed once.
+func (g *GormDB) execMaintenance(stmt string) error {
+	rows, err := g.db.Raw(stmt).Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+	}
+	return rows.Err()
+}
+
+// getStorageUsageHandler reports database, table and index sizes
+func getStorageUsageHandler(db Database, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		usage, err := db.GetStorageUsage()
+		if err != nil {
+			logger.Error("Failed to get storage usage: %v", err)
+			http.Error(w, fmt.Sprintf("Failed to get storage usage: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(usage)
+	}
+}
+
+// vacuumHandler runs maintenance; ?mode=full reclaims all free space, ?mode=incremental what
+// incremental auto-vacuum allows, the default only optimizes
+func vacuumHandler(db Database, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		mode := r.URL.Query().Get("mode")
+		if mode == "" {
+			mode = "optimize"
+		}
+		if mode != "optimize" && mode != "incremental" && mode != "full" {
+			http.Error(w, "mode must be optimize, incremental or full", http.StatusBadRequest)
+			return
+		}
+		res, err := db.Vacuum(mode)
+		if err != nil {
+			logger.Error("Database %s failed: %v", mode, err)
+			http.Error(w, fmt.Sprintf("Failed to vacuum database: %v", err), http.StatusInternalServerError)
+			return
+		}
+		logger.Info("Database %s finished in %dms (%d -> %d bytes)", mode, res.DurationMS, res.BytesBefore, res.BytesAfter)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res)
+	}
+}
@@ -0,0 +1,187 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// AnnotationPrefix namespaces user-supplied annotations so they never collide with ingested attributes
+const AnnotationPrefix = "user."
+
+// SpanAnnotation is a reviewer-supplied key/value attached to a span, stored apart from
+// the ingested attributes. Values are kept as text: strings as-is, other JSON values encoded.
+type SpanAnnotation struct {
+	SpanID    string    `gorm:"primaryKey" json:"span_id"`
+	Key       string    `gorm:"primaryKey;index:idx_annotation_kv" json:"key"`
+	Value     string    `gorm:"type:text;index:idx_annotation_kv" json:"value"`
+	TraceID   string    `gorm:"index" json:"trace_id"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// AnnotationFilter matches spans having annotation Key, and Value when non-empty
+type AnnotationFilter struct {
+	Key   string
+	Value string
+}
+
+// parseAnnotationFilter reads "user.key" or "user.key:value"
+func parseAnnotationFilter(s string) AnnotationFilter {
+	key, value, _ := strings.Cut(strings.TrimSpace(s), ":")
+	return AnnotationFilter{Key: normalizeAnnotationKey(key), Value: value}
+}
+
+func normalizeAnnotationKey(key string) string {
+	key = strings.TrimSpace(key)
+	if key != "" && !strings.HasPrefix(key, AnnotationPrefix) {
+		key = AnnotationPrefix + key
+	}
+	return key
+}
+
+// annotationValue renders a JSON value as stored annotation text
+func annotationValue(v any) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// UpdateSpanAnnotations sets (or with a nil value removes) annotations of a span and
+// returns all of its annotations. gorm.ErrRecordNotFound is returned for unknown spans.
+func (g *GormDB) UpdateSpanAnnotations(spanID string, changes map[string]any) (map[string]string, error) {
+	var span Span
+	if err := g.db.Select("span_id, trace_id").Where("span_id = ?", spanID).First(&span).Error; err != nil {
+		return nil, err
+	}
+	err := g.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		for key, v := range changes {
+			if v == nil {
+				if err := tx.Where("span_id = ? AND key = ?", spanID, key).Delete(&SpanAnnotation{}).Error; err != nil {
+					return err
+				}
+				continue
+			}
+			row := SpanAnnotation{SpanID: spanID, Key: key, Value: annotationValue(v), TraceID: span.TraceID, UpdatedAt: now}
+			if err := tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "span_id"}, {Name: "key"}},
+				DoUpdates: clause.AssignmentColumns([]string{"value", "updated_at"}),
+			}).Create(&row).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	all, err := g.getAnnotations([]string{spanID})
+	if err != nil {
+		return nil, err
+	}
+	return all[spanID], nil
+}
+
+// getAnnotations loads annotations of the given spans keyed by span id
+func (g *GormDB) getAnnotations(spanIDs []string) (map[string]map[string]string, error) {
+	out := make(map[string]map[string]string)
+	if len(spanIDs) == 0 {
+		return out, nil
+	}
+	var rows []SpanAnnotation
+	if err := g.db.Where("span_id IN ?", spanIDs).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, r := range rows {
+		if out[r.SpanID] == nil {
+			out[r.SpanID] = make(map[string]string)
+		}
+		out[r.SpanID][r.Key] = r.Value
+	}
+	return out, nil
+}
+
+// attachAnnotations fills Span.Annotations; failures only drop the annotations
+func (g *GormDB) attachAnnotations(spans []Span) {
+	if len(spans) == 0 {
+		return
+	}
+	ids := make([]string, len(spans))
+	for i, sp := range spans {
+		ids[i] = sp.SpanID
+	}
+	all, err := g.getAnnotations(ids)
+	if err != nil {
+		return
+	}
+	for i := range spans {
+		spans[i].Annotations = all[spans[i].SpanID]
+	}
+}
+
+// applyAnnotationFilters restricts a spans query to spans carrying all given annotations
+func (g *GormDB) applyAnnotationFilters(query *gorm.DB, filters []AnnotationFilter) *gorm.DB {
+	for _, f := range filters {
+		sub := g.db.Model(&SpanAnnotation{}).Select("span_id").Where("key = ?", f.Key)
+		if f.Value != "" {
+			sub = sub.Where("value = ?", f.Value)
+		}
+		query = query.Where("span_id IN (?)", sub)
+	}
+	return query
+}
+
+// patchSpanHandler adds, updates or removes (null value) user.* annotations of a span
+func patchSpanHandler(db Database, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		spanID := strings.TrimSpace(mux.Vars(r)["id"])
+		var body map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		// accept both {"user.x": ...} and {"annotations": {"x": ...}}
+		if nested, ok := body["annotations"].(map[string]any); ok && len(body) == 1 {
+			body = nested
+		}
+		if len(body) == 0 {
+			http.Error(w, "No annotations given", http.StatusBadRequest)
+			return
+		}
+		changes := make(map[string]any, len(body))
+		for k, v := range body {
+			key := normalizeAnnotationKey(k)
+			if key == AnnotationPrefix || len(key) > 200 {
+				http.Error(w, fmt.Sprintf("Invalid annotation key %q", k), http.StatusBadRequest)
+				return
+			}
+			changes[key] = v
+		}
+		annotations, err := db.UpdateSpanAnnotations(spanID, changes)
+		if err == gorm.ErrRecordNotFound {
+			http.Error(w, "Span not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error("Failed to annotate span %s: %v", spanID, err)
+			http.Error(w, fmt.Sprintf("Failed to annotate span: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"span_id":     spanID,
+			"annotations": annotations,
+		})
+	}
+}
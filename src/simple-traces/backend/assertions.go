@@ -0,0 +1,220 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/expr-lang/expr"
+)
+
+// maxMetricSpans bounds how many spans one assertion scope reads
+const maxMetricSpans = 200000
+
+// SpanMetrics aggregates the spans of an assertion scope. The JSON names are the variables
+// assertion expressions can use.
+type SpanMetrics struct {
+	SpanCount       int64   `json:"span_count" expr:"span_count"`
+	TraceCount      int64   `json:"trace_count" expr:"trace_count"`
+	ErrorCount      int64   `json:"error_count" expr:"error_count"`
+	ErrorTraceCount int64   `json:"error_trace_count" expr:"error_trace_count"`
+	ErrorRate       float64 `json:"error_rate" expr:"error_rate"`
+	ViolationCount  int64   `json:"violation_count" expr:"violation_count"`
+	LLMCalls        int64   `json:"llm_calls" expr:"llm_calls"`
+	InputTokens     int64   `json:"input_tokens" expr:"input_tokens"`
+	OutputTokens    int64   `json:"output_tokens" expr:"output_tokens"`
+	AvgMS           float64 `json:"avg_ms" expr:"avg_ms"`
+	P50MS           int64   `json:"p50_ms" expr:"p50_ms"`
+	P90MS           int64   `json:"p90_ms" expr:"p90_ms"`
+	P95MS           int64   `json:"p95_ms" expr:"p95_ms"`
+	P99MS           int64   `json:"p99_ms" expr:"p99_ms"`
+	MaxMS           int64   `json:"max_ms" expr:"max_ms"`
+	// Derived holds the total of each derived metric (DERIVED_METRICS_FILE), e.g. metrics.cost
+	Derived map[string]float64 `json:"metrics,omitempty" expr:"metrics"`
+	// Truncated is set when the scope matched more than maxMetricSpans spans
+	Truncated bool `json:"truncated,omitempty" expr:"-"`
+}
+
+// GetSpanMetrics computes counts and latency percentiles over the spans matching filter
+func (g *GormDB) GetSpanMetrics(filter SpanFilter) (SpanMetrics, error) {
+	var rows []struct {
+		TraceID       string
+		StatusCode    string
+		Category      string
+		ViolationType string
+		DurationMS    int64
+		InputTokens   int64
+		OutputTokens  int64
+	}
+	query := g.applySpanFilter(g.db.Model(&Span{}), filter).
+		Select("trace_id, status_code, category, violation_type, duration_ms, input_tokens, output_tokens").
+		Limit(maxMetricSpans + 1)
+	if err := query.Scan(&rows).Error; err != nil {
+		return SpanMetrics{}, err
+	}
+	var m SpanMetrics
+	if len(rows) > maxMetricSpans {
+		rows, m.Truncated = rows[:maxMetricSpans], true
+	}
+	traces := make(map[string]bool)
+	durations := make([]int64, 0, len(rows))
+	for _, r := range rows {
+		failed := r.StatusCode == "ERROR"
+		traces[r.TraceID] = traces[r.TraceID] || failed
+		if failed {
+			m.ErrorCount++
+		}
+		if r.ViolationType != "" {
+			m.ViolationCount++
+		}
+		if r.Category == "llm" {
+			m.LLMCalls++
+		}
+		m.InputTokens += r.InputTokens
+		m.OutputTokens += r.OutputTokens
+		durations = append(durations, r.DurationMS)
+	}
+	for _, failed := range traces {
+		if failed {
+			m.ErrorTraceCount++
+		}
+	}
+	m.SpanCount = int64(len(rows))
+	m.TraceCount = int64(len(traces))
+	m.ErrorRate = ratio(m.ErrorCount, m.SpanCount)
+	m.AvgMS = average(durations)
+	m.P50MS = percentile(durations, 50)
+	m.P90MS = percentile(durations, 90)
+	m.P95MS = percentile(durations, 95)
+	m.P99MS = percentile(durations, 99)
+	if len(durations) > 0 {
+		m.MaxMS = durations[len(durations)-1]
+	}
+	derived, err := g.sumMetricValues(filter)
+	if err != nil {
+		return m, err
+	}
+	if len(derived) > 0 {
+		m.Derived = derived
+	}
+	return m, nil
+}
+
+// Assertion is a boolean expression over SpanMetrics, e.g. "error_count == 0" or "p95_ms < 3000".
+// In JSON it is either the expression string or {"name": ..., "expr": ...}.
+type Assertion struct {
+	Name string `json:"name,omitempty"`
+	Expr string `json:"expr"`
+}
+
+func (a *Assertion) UnmarshalJSON(data []byte) error {
+	var s string
+	if json.Unmarshal(data, &s) == nil {
+		*a = Assertion{Expr: s}
+		return nil
+	}
+	type plain Assertion
+	return json.Unmarshal(data, (*plain)(a))
+}
+
+// AssertionResult reports one evaluated assertion
+type AssertionResult struct {
+	Name   string `json:"name"`
+	Expr   string `json:"expr"`
+	Passed bool   `json:"passed"`
+	Error  string `json:"error,omitempty"`
+}
+
+// AssertionReport is the answer of POST /api/assertions
+type AssertionReport struct {
+	Passed  bool              `json:"passed"`
+	Metrics SpanMetrics       `json:"metrics"`
+	Results []AssertionResult `json:"results"`
+}
+
+// evaluateAssertions runs every assertion against m; all must hold for the report to pass
+func evaluateAssertions(assertions []Assertion, m SpanMetrics) (AssertionReport, error) {
+	report := AssertionReport{Passed: true, Metrics: m, Results: make([]AssertionResult, 0, len(assertions))}
+	for _, a := range assertions {
+		if strings.TrimSpace(a.Expr) == "" {
+			return report, fmt.Errorf("empty assertion")
+		}
+		if a.Name == "" {
+			a.Name = a.Expr
+		}
+		program, err := expr.Compile(a.Expr, expr.Env(SpanMetrics{}), expr.AsBool())
+		if err != nil {
+			return report, fmt.Errorf("invalid assertion %q: %v", a.Name, err)
+		}
+		res := AssertionResult{Name: a.Name, Expr: a.Expr}
+		out, err := expr.Run(program, m)
+		if err != nil {
+			res.Error = err.Error()
+		} else {
+			res.Passed = out.(bool)
+		}
+		report.Passed = report.Passed && res.Passed
+		report.Results = append(report.Results, res)
+	}
+	return report, nil
+}
+
+// assertionsHandler evaluates assertions over a scope of spans. The body is
+// {"filters": {<GET /api/spans parameters, plus window>}, "assertions": [...]}; filters may hold
+// a list for repeatable parameters such as attr. The response is 200 either way, check "passed".
+func assertionsHandler(db Database, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Filters    map[string]any `json:"filters"`
+			Assertions []Assertion    `json:"assertions"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Assertions) == 0 {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		q := url.Values{}
+		for k, v := range req.Filters {
+			switch v := v.(type) {
+			case []any:
+				for _, item := range v {
+					q.Add(k, fmt.Sprint(item))
+				}
+			case nil:
+			default:
+				q.Set(k, fmt.Sprint(v))
+			}
+		}
+		filter, err := parseSpanFilter(q)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if s := strings.TrimSpace(q.Get("window")); s != "" && filter.Since.IsZero() {
+			d, err := parseWindow(s)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid window: %v", err), http.StatusBadRequest)
+				return
+			}
+			filter.Since = time.Now().Add(-d)
+		}
+		m, err := db.GetSpanMetrics(filter)
+		if err != nil {
+			logger.Error("Failed to compute span metrics: %v", err)
+			http.Error(w, fmt.Sprintf("Failed to compute span metrics: %v", err), http.StatusInternalServerError)
+			return
+		}
+		report, err := evaluateAssertions(req.Assertions, m)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !report.Passed {
+			logger.Info("Assertions failed for %s", q.Encode())
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
@@ -0,0 +1,263 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// AttributeOverride corrects an attribute of a span that was ingested wrong. The ingested
+// attributes are never rewritten: reads merge the override over them, and the original value
+// is kept next to the correction for auditing. Values are stored JSON encoded.
+type AttributeOverride struct {
+	SpanID    string    `gorm:"primaryKey" json:"span_id"`
+	Key       string    `gorm:"primaryKey" json:"key"`
+	Value     string    `gorm:"type:text" json:"value"`
+	Original  string    `gorm:"type:text" json:"original,omitempty"`
+	TraceID   string    `gorm:"index" json:"trace_id"`
+	Reason    string    `json:"reason,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// overrideColumns maps attributes that spans are grouped by to the column derived from them.
+// Overriding one of them also updates the column, so listings and stats group by the corrected
+// value; removing the override restores the column from the ingested attribute.
+var overrideColumns = map[string]string{
+	"simpleTraces.project.id":      "project_id",
+	"simpleTraces.conversation.id": "conversation_id",
+	"simpleTraces.run.id":          "run_id",
+	"simpleTraces.model":           "model",
+	"simpleTraces.category":        "category",
+}
+
+// columnDefaults are the values of derived columns whose attribute is missing
+var columnDefaults = map[string]string{"project_id": "default"}
+
+// SetAttributeOverrides sets (or with a nil value removes) attribute overrides of one span, or
+// of every span of a trace when spanID is empty, and returns the overrides of those spans.
+// gorm.ErrRecordNotFound is returned when no span matches.
+func (g *GormDB) SetAttributeOverrides(traceID, spanID string, changes map[string]any, reason string) ([]AttributeOverride, error) {
+	scope := func(q *gorm.DB) *gorm.DB {
+		if spanID != "" {
+			return q.Where("span_id = ?", spanID)
+		}
+		return q.Where("trace_id = ?", traceID)
+	}
+	var spans []Span
+	if err := scope(g.db.Model(&Span{})).Select("span_id, trace_id, conversation_id, attributes").Find(&spans).Error; err != nil {
+		return nil, err
+	}
+	if len(spans) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	err := g.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		// conversations that lose spans come first, so new ones take their project and user
+		var from, to []string
+		add := func(ids []string, id string) []string {
+			if id == "" || slices.Contains(ids, id) {
+				return ids
+			}
+			return append(ids, id)
+		}
+		for _, sp := range spans {
+			attrs := make(map[string]any)
+			if sp.Attributes != "" {
+				json.Unmarshal([]byte(sp.Attributes), &attrs)
+			}
+			columns := make(map[string]any)
+			for key, v := range changes {
+				column := overrideColumns[key]
+				if v == nil {
+					if err := tx.Where("span_id = ? AND key = ?", sp.SpanID, key).Delete(&AttributeOverride{}).Error; err != nil {
+						return err
+					}
+					if column != "" {
+						s, _ := attrs[key].(string)
+						if s == "" {
+							s = columnDefaults[column]
+						}
+						columns[column] = s
+					}
+					continue
+				}
+				row := AttributeOverride{SpanID: sp.SpanID, Key: key, Value: overrideValue(v), TraceID: sp.TraceID, Reason: reason, UpdatedAt: now}
+				if orig, ok := attrs[key]; ok {
+					row.Original = overrideValue(orig)
+				}
+				if err := tx.Clauses(clause.OnConflict{
+					Columns:   []clause.Column{{Name: "span_id"}, {Name: "key"}},
+					DoUpdates: clause.AssignmentColumns([]string{"value", "reason", "updated_at"}),
+				}).Create(&row).Error; err != nil {
+					return err
+				}
+				if column != "" {
+					columns[column] = annotationValue(v)
+				}
+			}
+			if len(columns) == 0 {
+				continue
+			}
+			if c, ok := columns["conversation_id"].(string); ok && c != sp.ConversationID {
+				from = add(from, sp.ConversationID)
+				to = add(to, c)
+			}
+			if err := tx.Model(&Span{}).Where("span_id = ?", sp.SpanID).Updates(columns).Error; err != nil {
+				return err
+			}
+		}
+		ids := from
+		for _, id := range to {
+			ids = add(ids, id)
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		return refreshConversations(tx, ids...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	var rows []AttributeOverride
+	err = scope(g.db).Order("span_id, key").Find(&rows).Error
+	return rows, err
+}
+
+// GetAttributeOverrides lists the overrides of a trace, or all of them when traceID is empty
+func (g *GormDB) GetAttributeOverrides(traceID string, limit int) ([]AttributeOverride, error) {
+	query := g.db.Order("updated_at DESC").Limit(limit)
+	if traceID != "" {
+		query = query.Where("trace_id = ?", traceID)
+	}
+	var rows []AttributeOverride
+	err := query.Find(&rows).Error
+	return rows, err
+}
+
+// overrideValue JSON encodes an attribute value for storage
+func overrideValue(v any) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// attachOverrides merges attribute overrides into Span.Attributes; failures leave the ingested
+// attributes
+func (g *GormDB) attachOverrides(spans []Span) {
+	if len(spans) == 0 {
+		return
+	}
+	ids := make([]string, len(spans))
+	for i, sp := range spans {
+		ids[i] = sp.SpanID
+	}
+	var rows []AttributeOverride
+	if err := g.db.Where("span_id IN ?", ids).Find(&rows).Error; err != nil || len(rows) == 0 {
+		return
+	}
+	bySpan := make(map[string][]AttributeOverride)
+	for _, r := range rows {
+		bySpan[r.SpanID] = append(bySpan[r.SpanID], r)
+	}
+	for i := range spans {
+		overrides := bySpan[spans[i].SpanID]
+		if len(overrides) == 0 {
+			continue
+		}
+		attrs := make(map[string]any)
+		if spans[i].Attributes != "" {
+			if err := json.Unmarshal([]byte(spans[i].Attributes), &attrs); err != nil {
+				continue
+			}
+		}
+		keys := make([]string, 0, len(overrides))
+		for _, o := range overrides {
+			var v any
+			if err := json.Unmarshal([]byte(o.Value), &v); err != nil {
+				continue
+			}
+			attrs[o.Key] = v
+			keys = append(keys, o.Key)
+		}
+		attrs["simpleTraces.overridden"] = keys
+		if b, err := json.Marshal(attrs); err == nil {
+			spans[i].Attributes = string(b)
+		}
+	}
+}
+
+type attributeOverrideBody struct {
+	// Overrides maps attribute keys to their corrected value, or null to remove the override
+	Overrides map[string]any `json:"overrides"`
+	Reason    string         `json:"reason"`
+}
+
+// setAttributeOverridesHandler corrects attributes of a span ({id}) or of every span of a
+// trace ({trace_id})
+func setAttributeOverridesHandler(db Database, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		spanID, traceID := strings.TrimSpace(vars["id"]), strings.TrimSpace(vars["trace_id"])
+		var body attributeOverrideBody
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		if len(body.Overrides) == 0 {
+			http.Error(w, "No overrides given", http.StatusBadRequest)
+			return
+		}
+		for k := range body.Overrides {
+			if strings.TrimSpace(k) == "" || len(k) > 200 || strings.HasPrefix(k, AnnotationPrefix) {
+				http.Error(w, fmt.Sprintf("Invalid attribute key %q", k), http.StatusBadRequest)
+				return
+			}
+		}
+		rows, err := db.SetAttributeOverrides(traceID, spanID, body.Overrides, strings.TrimSpace(body.Reason))
+		if err == gorm.ErrRecordNotFound {
+			http.Error(w, "Span not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error("Failed to override attributes of %s%s: %v", traceID, spanID, err)
+			http.Error(w, fmt.Sprintf("Failed to override attributes: %v", err), http.StatusInternalServerError)
+			return
+		}
+		logger.Info("Attribute overrides of %s%s changed: %d keys (%s)", traceID, spanID, len(body.Overrides), body.Reason)
+		if rows == nil {
+			rows = []AttributeOverride{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rows)
+	}
+}
+
+// getAttributeOverridesHandler lists overrides, most recent first, optionally of one trace
+func getAttributeOverridesHandler(db Database, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit := 100
+		if s := strings.TrimSpace(r.URL.Query().Get("limit")); s != "" {
+			if v, err := strconv.Atoi(s); err == nil && v > 0 {
+				limit = v
+			}
+		}
+		rows, err := db.GetAttributeOverrides(strings.TrimSpace(r.URL.Query().Get("trace_id")), limit)
+		if err != nil {
+			logger.Error("Failed to get attribute overrides: %v", err)
+			http.Error(w, fmt.Sprintf("Failed to get attribute overrides: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if rows == nil {
+			rows = []AttributeOverride{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rows)
+	}
+}
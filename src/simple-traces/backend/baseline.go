@@ -0,0 +1,202 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// baselineWindow is the history a conversation is compared against
+	baselineWindow = 7 * 24 * time.Hour
+	// baselineMinSamples is the history needed before percentile ranks are reported
+	baselineMinSamples = 5
+)
+
+// BaselineMetric places one value of a conversation in the distribution of its baseline
+type BaselineMetric struct {
+	Value float64 `json:"value"`
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+	// PercentileRank is the share of baseline conversations below the value (0-100);
+	// omitted without enough history
+	PercentileRank *float64 `json:"percentile_rank,omitempty"`
+}
+
+// ConversationBaseline compares a conversation with the conversations of the same project and
+// model over the trailing 7 days
+type ConversationBaseline struct {
+	ConversationID string `json:"conversation_id"`
+	ProjectID      string `json:"project_id"`
+	// Model is the model of most LLM calls of the conversation
+	Model      string `json:"model"`
+	SampleSize int    `json:"sample_size"`
+	// LatencyMS is the time spent in LLM calls
+	LatencyMS BaselineMetric `json:"latency_ms"`
+	Tokens    BaselineMetric `json:"tokens"`
+	CostUSD   BaselineMetric `json:"cost_usd"`
+}
+
+// conversationUsage is the LLM usage of one conversation
+type conversationUsage struct {
+	projectID  string
+	model      string
+	calls      int64
+	durationMS int64
+	tokens     int64
+	cost       float64
+}
+
+type conversationModelUsage struct {
+	ConversationID string
+	ProjectID      string
+	Model          string
+	Calls          int64
+	DurationMS     int64
+	InputTokens    int64
+	OutputTokens   int64
+}
+
+// usageSelect aggregates LLM spans per conversation and model
+const usageSelect = "conversation_id, MIN(project_id) as project_id, model, COUNT(*) as calls, SUM(duration_ms) as duration_ms, " +
+	"SUM(input_tokens) as input_tokens, SUM(output_tokens) as output_tokens"
+
+// foldUsage sums the per-model rows of each conversation; the model with most calls names it
+func foldUsage(rows []conversationModelUsage, prices ModelPrices) map[string]*conversationUsage {
+	out := make(map[string]*conversationUsage)
+	mostCalls := make(map[string]int64)
+	for _, r := range rows {
+		u := out[r.ConversationID]
+		if u == nil {
+			u = &conversationUsage{projectID: r.ProjectID}
+			out[r.ConversationID] = u
+		}
+		u.calls += r.Calls
+		u.durationMS += r.DurationMS
+		u.tokens += r.InputTokens + r.OutputTokens
+		u.cost += prices.Cost(r.Model, r.InputTokens, r.OutputTokens)
+		if r.Calls > mostCalls[r.ConversationID] || (r.Calls == mostCalls[r.ConversationID] && r.Model < u.model) {
+			mostCalls[r.ConversationID], u.model = r.Calls, r.Model
+		}
+	}
+	return out
+}
+
+// GetConversationBaselines compares the given conversations with their baselines. Conversations
+// without LLM calls are left out.
+func (g *GormDB) GetConversationBaselines(conversationIDs []string, prices ModelPrices) (map[string]*ConversationBaseline, error) {
+	out := make(map[string]*ConversationBaseline)
+	if len(conversationIDs) == 0 {
+		return out, nil
+	}
+	var rows []conversationModelUsage
+	if err := g.db.Model(&Span{}).Select(usageSelect).
+		Where("category = ? AND conversation_id IN ?", "llm", conversationIDs).
+		Group("conversation_id, model").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	usage := foldUsage(rows, prices)
+
+	since := time.Now().Add(-baselineWindow)
+	type cohort struct{ projectID, model string }
+	cohorts := make(map[cohort]map[string]*conversationUsage)
+	for id, u := range usage {
+		c := cohort{u.projectID, u.model}
+		history, ok := cohorts[c]
+		if !ok {
+			var rows []conversationModelUsage
+			inCohort := g.db.Model(&Span{}).Distinct("conversation_id").
+				Where("category = ? AND project_id = ? AND model = ? AND start_time > ? AND conversation_id <> ''", "llm", c.projectID, c.model, since)
+			if err := g.db.Model(&Span{}).Select(usageSelect).
+				Where("category = ? AND start_time > ? AND conversation_id IN (?)", "llm", since, inCohort).
+				Group("conversation_id, model").
+				Limit(200000).
+				Scan(&rows).Error; err != nil {
+				return nil, err
+			}
+			history = foldUsage(rows, prices)
+			cohorts[c] = history
+		}
+		var latency, tokens, cost []float64
+		for hid, h := range history {
+			if hid == id || h.model != u.model {
+				continue
+			}
+			latency = append(latency, float64(h.durationMS))
+			tokens = append(tokens, float64(h.tokens))
+			cost = append(cost, h.cost)
+		}
+		out[id] = &ConversationBaseline{
+			ConversationID: id,
+			ProjectID:      u.projectID,
+			Model:          u.model,
+			SampleSize:     len(latency),
+			LatencyMS:      baselineMetric(float64(u.durationMS), latency),
+			Tokens:         baselineMetric(float64(u.tokens), tokens),
+			CostUSD:        baselineMetric(u.cost, cost),
+		}
+	}
+	return out, nil
+}
+
+// baselineMetric ranks value within history; ties count half so a typical value ranks near 50
+func baselineMetric(value float64, history []float64) BaselineMetric {
+	m := BaselineMetric{Value: value}
+	if len(history) == 0 {
+		return m
+	}
+	sort.Float64s(history)
+	m.P50 = history[int(math.Ceil(0.5*float64(len(history))))-1]
+	m.P90 = history[int(math.Ceil(0.9*float64(len(history))))-1]
+	if len(history) < baselineMinSamples {
+		return m
+	}
+	below := sort.SearchFloat64s(history, value)
+	equal := sort.SearchFloat64s(history, math.Nextafter(value, math.Inf(1))) - below
+	rank := math.Round((float64(below)+float64(equal)/2)/float64(len(history))*1000) / 10
+	m.PercentileRank = &rank
+	return m
+}
+
+// attachBaselines fills the baseline of listed conversations; failures only drop the field
+func attachBaselines(db Database, convs []Conversation, prices ModelPrices) {
+	ids := make([]string, len(convs))
+	for i, c := range convs {
+		ids[i] = c.ID
+	}
+	baselines, err := db.GetConversationBaselines(ids, prices)
+	if err != nil {
+		return
+	}
+	for i := range convs {
+		convs[i].Baseline = baselines[convs[i].ID]
+	}
+}
+
+// getConversationBaselineHandler compares a conversation's LLM latency, tokens and cost with
+// the same project and model over the trailing 7 days
+func getConversationBaselineHandler(db Database, prices ModelPrices, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimSpace(mux.Vars(r)["id"])
+		baselines, err := db.GetConversationBaselines([]string{id}, prices)
+		if err != nil {
+			logger.Error("Failed to get baseline of conversation %s: %v", id, err)
+			http.Error(w, fmt.Sprintf("Failed to get baseline: %v", err), http.StatusInternalServerError)
+			return
+		}
+		b := baselines[id]
+		if b == nil {
+			http.Error(w, "Conversation has no LLM calls", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(b)
+	}
+}
@@ -0,0 +1,336 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// CacheStore stores cached responses by generation. Invalidate drops everything cached so far
+// and starts a new generation; responses computed before it are stored under the generation
+// read when their request started, so they are never served after the invalidation.
+type CacheStore interface {
+	Generation() string
+	Get(gen, key string) ([]byte, bool)
+	Set(gen, key string, value []byte, ttl time.Duration)
+	Invalidate()
+}
+
+// memoryCache is an in-process CacheStore with per-entry expiry
+type memoryCache struct {
+	mu         sync.Mutex
+	gen        uint64
+	entries    map[string]memoryCacheEntry
+	maxEntries int
+}
+
+type memoryCacheEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+func newMemoryCache(maxEntries int) *memoryCache {
+	return &memoryCache{entries: make(map[string]memoryCacheEntry), maxEntries: maxEntries}
+}
+
+func (c *memoryCache) Generation() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return strconv.FormatUint(c.gen, 10)
+}
+
+func (c *memoryCache) Get(gen, key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen != strconv.FormatUint(c.gen, 10) {
+		return nil, false
+	}
+	e, ok := c.entries[key]
+	if !ok || time.Now().After(e.expires) {
+		return nil, false
+	}
+	return e.value, true
+}
+
+func (c *memoryCache) Set(gen, key string, value []byte, ttl time.Duration) {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen != strconv.FormatUint(c.gen, 10) {
+		// computed before an invalidation
+		return
+	}
+	if len(c.entries) >= c.maxEntries {
+		for k, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		// still full: drop an arbitrary entry rather than growing without bound
+		for k := range c.entries {
+			if len(c.entries) < c.maxEntries {
+				break
+			}
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = memoryCacheEntry{value: value, expires: now.Add(ttl)}
+}
+
+func (c *memoryCache) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	c.entries = make(map[string]memoryCacheEntry)
+}
+
+// redisCache is a CacheStore shared by all replicas. Keys include a generation number that
+// Invalidate bumps, so one write clears the cache everywhere without scanning keys; old
+// generations simply expire.
+type redisCache struct {
+	client  *redis.Client
+	prefix  string
+	timeout time.Duration
+}
+
+// newRedisCache connects to a redis:// or rediss:// URL
+func newRedisCache(rawURL string) (*redisCache, error) {
+	opts, err := redis.ParseURL(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CACHE_REDIS_URL: %w", err)
+	}
+	c := &redisCache{client: redis.NewClient(opts), prefix: "simpletraces:cache:", timeout: 500 * time.Millisecond}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.client.Ping(ctx).Err(); err != nil {
+		c.client.Close()
+		return nil, fmt.Errorf("redis ping: %w", err)
+	}
+	metrics.Describe("simpletraces_cache_errors_total", "counter", "Failed cache store operations (treated as misses)")
+	return c, nil
+}
+
+func (c *redisCache) generationKey() string { return c.prefix + "generation" }
+
+// Generation returns the current generation, "" when redis can't be reached
+func (c *redisCache) Generation() string {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	gen, err := c.client.Get(ctx, c.generationKey()).Result()
+	if err == redis.Nil {
+		return "0"
+	} else if err != nil {
+		metrics.Inc("simpletraces_cache_errors_total")
+		return ""
+	}
+	return gen
+}
+
+func (c *redisCache) key(gen, key string) string {
+	return c.prefix + gen + ":" + key
+}
+
+func (c *redisCache) Get(gen, key string) ([]byte, bool) {
+	if gen == "" {
+		return nil, false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	v, err := c.client.Get(ctx, c.key(gen, key)).Bytes()
+	if err != nil {
+		if err != redis.Nil {
+			metrics.Inc("simpletraces_cache_errors_total")
+		}
+		return nil, false
+	}
+	return v, true
+}
+
+// Set stores under gen; values of an old generation are never read and simply expire
+func (c *redisCache) Set(gen, key string, value []byte, ttl time.Duration) {
+	if gen == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	if err := c.client.Set(ctx, c.key(gen, key), value, ttl).Err(); err != nil {
+		metrics.Inc("simpletraces_cache_errors_total")
+	}
+}
+
+func (c *redisCache) Invalidate() {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	if err := c.client.Incr(ctx, c.generationKey()).Err(); err != nil {
+		metrics.Inc("simpletraces_cache_errors_total")
+	}
+}
+
+// Close releases the connection pool
+func (c *redisCache) Close() error {
+	return c.client.Close()
+}
+
+// cacheRoute caches GET responses of a path (or path prefix ending in *) for TTL
+type cacheRoute struct {
+	pattern string
+	ttl     time.Duration
+}
+
+func (r cacheRoute) matches(path string) bool {
+	return pathMatches(r.pattern, path)
+}
+
+// pathMatches matches a path against an exact pattern or a prefix ending in *
+func pathMatches(pattern, path string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		return strings.HasPrefix(path, prefix)
+	}
+	return path == pattern
+}
+
+// parseCacheRoutes reads "path=ttl" pairs separated by commas, e.g. "/api/trace-groups=5s,/api/stats/*=30s"
+func parseCacheRoutes(spec string) ([]cacheRoute, error) {
+	var routes []cacheRoute
+	for _, part := range splitList(spec) {
+		pattern, ttlStr, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("cache route %q: want path=ttl", part)
+		}
+		ttl, err := time.ParseDuration(strings.TrimSpace(ttlStr))
+		if err != nil || ttl <= 0 {
+			return nil, fmt.Errorf("cache route %q: invalid ttl", part)
+		}
+		routes = append(routes, cacheRoute{pattern: strings.TrimSpace(pattern), ttl: ttl})
+	}
+	// longest pattern wins
+	sort.SliceStable(routes, func(i, j int) bool { return len(routes[i].pattern) > len(routes[j].pattern) })
+	return routes, nil
+}
+
+// ResponseCache caches successful GET responses of configured routes. Cached data is
+// dropped whenever spans are stored (by OTLPHandler, whichever way they arrive) or anything is
+// modified through the API (see InvalidateOnWrite).
+type ResponseCache struct {
+	store  CacheStore
+	routes []cacheRoute
+}
+
+// NewResponseCache returns nil when no routes are configured
+func NewResponseCache(store CacheStore, spec string) (*ResponseCache, error) {
+	routes, err := parseCacheRoutes(spec)
+	if err != nil {
+		return nil, err
+	}
+	if len(routes) == 0 {
+		return nil, nil
+	}
+	metrics.Describe("simpletraces_cache_requests_total", "counter", "Cacheable API requests by result (hit or miss)")
+	return &ResponseCache{store: store, routes: routes}, nil
+}
+
+// Invalidate drops all cached responses
+func (c *ResponseCache) Invalidate() {
+	if c != nil {
+		c.store.Invalidate()
+	}
+}
+
+func (c *ResponseCache) ttlFor(path string) time.Duration {
+	for _, r := range c.routes {
+		if r.matches(path) {
+			return r.ttl
+		}
+	}
+	return 0
+}
+
+// cached responses are stored as "<content-type>\n<etag>\n<body>"
+func encodeCachedResponse(contentType, etag string, body []byte) []byte {
+	return append([]byte(contentType+"\n"+etag+"\n"), body...)
+}
+
+func decodeCachedResponse(v []byte) (contentType, etag string, body []byte) {
+	ct, rest, _ := bytes.Cut(v, []byte("\n"))
+	tag, body, _ := bytes.Cut(rest, []byte("\n"))
+	return string(ct), string(tag), body
+}
+
+// InvalidateOnWrite drops the cache after every request that may modify data; it wraps the API,
+// ingest invalidates after storing spans instead
+func (c *ResponseCache) InvalidateOnWrite(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodOptions {
+			defer c.Invalidate()
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Middleware serves cached responses of GET requests
+func (c *ResponseCache) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ttl := c.ttlFor(r.URL.Path)
+		if ttl <= 0 || r.Header.Get("Cache-Control") == "no-cache" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := r.URL.RequestURI()
+		// read before the response is computed: a write during it starts a new generation,
+		// and the response then must not be served from it
+		gen := c.store.Generation()
+		if v, ok := c.store.Get(gen, key); ok {
+			metrics.Inc("simpletraces_cache_requests_total", "result", "hit")
+			contentType, etag, body := decodeCachedResponse(v)
+			if contentType != "" {
+				w.Header().Set("Content-Type", contentType)
+			}
+			w.Header().Set("X-Cache", "HIT")
+			if etag != "" {
+				w.Header().Set("ETag", etag)
+				if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatches(inm, etag) {
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+			}
+			w.Write(body)
+			return
+		}
+		metrics.Inc("simpletraces_cache_requests_total", "result", "miss")
+		rec := &cacheRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+		w.Header().Set("X-Cache", "MISS")
+		next.ServeHTTP(rec, r)
+		if rec.statusCode == http.StatusOK {
+			c.store.Set(gen, key, encodeCachedResponse(w.Header().Get("Content-Type"), w.Header().Get("ETag"), rec.body.Bytes()), ttl)
+		}
+	})
+}
+
+// cacheRecorder passes the response through while keeping a copy of the body
+type cacheRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (r *cacheRecorder) WriteHeader(code int) {
+	r.statusCode = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *cacheRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
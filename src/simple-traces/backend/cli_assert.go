@@ -0,0 +1,107 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// RunAssert implements `simple-traces assert`: it evaluates assertions over a scope of spans on a
+// running server and fails (non-zero exit) when any of them doesn't hold, for gating CI pipelines.
+func RunAssert(args []string) error {
+	fs := flag.NewFlagSet("assert", flag.ContinueOnError)
+	server := fs.String("url", getEnv("SIMPLE_TRACES_URL", defaultServerURL), "Server URL (SIMPLE_TRACES_URL)")
+	project := fs.String("project", "", "Only spans of this project")
+	window := fs.String("window", "", "Only spans of the last window, e.g. 1h or 7d")
+	filters := cliFilters{}
+	fs.Var(filters, "filter", "Span filter key=value as in GET /api/spans, e.g. attr=ci.sha:$CI_SHA, category=llm (repeatable)")
+	quiet := fs.Bool("quiet", false, "Only print failures")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage: simple-traces assert [flags] <assertion>...
+
+Assertions are expressions over span_count, trace_count, error_count, error_trace_count,
+error_rate, violation_count, llm_calls, input_tokens, output_tokens, avg_ms, p50_ms, p90_ms,
+p95_ms, p99_ms and max_ms, e.g.:
+
+  simple-traces assert --filter attr=ci.sha:$CI_SHA 'span_count > 0' 'error_count == 0' 'p95_ms < 3000'
+
+`)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("no assertions given")
+	}
+
+	scope := map[string]any{}
+	for k, v := range url.Values(filters) {
+		scope[k] = v
+	}
+	if *project != "" {
+		scope["project"] = *project
+	}
+	if *window != "" {
+		scope["window"] = *window
+	}
+	assertions := make([]Assertion, 0, fs.NArg())
+	for _, a := range fs.Args() {
+		assertions = append(assertions, Assertion{Expr: a})
+	}
+	payload, _ := json.Marshal(map[string]any{"filters": scope, "assertions": assertions})
+	client := &http.Client{Timeout: 2 * time.Minute}
+	resp, err := client.Post(strings.TrimSuffix(*server, "/")+"/api/assertions", "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var report AssertionReport
+	if err := json.Unmarshal(body, &report); err != nil {
+		return fmt.Errorf("server returned invalid JSON: %v", err)
+	}
+
+	failed := 0
+	for _, res := range report.Results {
+		switch {
+		case res.Error != "":
+			failed++
+			fmt.Printf("FAIL  %s: %s\n", res.Name, res.Error)
+		case !res.Passed:
+			failed++
+			fmt.Printf("FAIL  %s\n", res.Name)
+		case !*quiet:
+			fmt.Printf("PASS  %s\n", res.Name)
+		}
+	}
+	m := report.Metrics
+	if failed > 0 || !*quiet {
+		fmt.Printf("\nspans=%d traces=%d errors=%d error_rate=%.3f p50=%dms p95=%dms p99=%dms max=%dms\n",
+			m.SpanCount, m.TraceCount, m.ErrorCount, m.ErrorRate, m.P50MS, m.P95MS, m.P99MS, m.MaxMS)
+	}
+	if m.Truncated {
+		fmt.Fprintf(os.Stderr, "warning: scope matched more than %d spans, metrics cover the first %d\n", maxMetricSpans, maxMetricSpans)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d assertions failed", failed, len(report.Results))
+	}
+	return nil
+}
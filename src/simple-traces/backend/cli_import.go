@@ -0,0 +1,131 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// RunImport implements `simple-traces import`: it uploads span JSONL files (as returned by the
+// spans API) or Langfuse exports to a running server and prints what was stored.
+func RunImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	server := fs.String("url", getEnv("SIMPLE_TRACES_URL", defaultServerURL), "Server URL (SIMPLE_TRACES_URL); the ingest listener when INGEST_LISTEN is set")
+	token := fs.String("token", getEnv("INGEST_TOKEN", ""), "Bearer token for ingest (INGEST_TOKEN)")
+	format := fs.String("format", "jsonl", "Input format: jsonl (spans, one per line) or langfuse (Langfuse trace/observation export)")
+	project := fs.String("project", "", "Project of the imported spans (langfuse only)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage: simple-traces import [flags] <file>...
+
+  simple-traces import spans.jsonl
+  simple-traces import --format langfuse --project support-bot langfuse-export.json
+
+`)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("no files given")
+	}
+	var path string
+	switch *format {
+	case "jsonl":
+		path = "/api/spans/import"
+	case "langfuse":
+		path = "/api/import/langfuse"
+		if *project != "" {
+			path += "?project=" + url.QueryEscape(*project)
+		}
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+
+	client := &http.Client{Timeout: 30 * time.Minute}
+	failed := 0
+	for _, file := range fs.Args() {
+		summary, n, err := uploadImport(client, strings.TrimSuffix(*server, "/")+path, *token, file)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		fmt.Printf("%s: %s\n", file, summary)
+		if n > 0 {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files had records that were not stored", failed, fs.NArg())
+	}
+	return nil
+}
+
+// uploadImport posts one file and renders the summary the server returns, along with the number
+// of records that were not stored
+func uploadImport(client *http.Client, target, token, file string) (string, int, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	req, err := http.NewRequest(http.MethodPost, target, f)
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if strings.HasSuffix(file, ".gz") {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var summary struct {
+		Lines        *int  `json:"lines"`
+		Traces       *int  `json:"traces"`
+		Observations *int  `json:"observations"`
+		Inserted     int   `json:"inserted"`
+		Skipped      int   `json:"skipped"`
+		Failed       int   `json:"failed"`
+		Errors       []any `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &summary); err != nil {
+		return "", 0, fmt.Errorf("server returned invalid JSON: %v", err)
+	}
+	var sb bytes.Buffer
+	if summary.Lines != nil {
+		fmt.Fprintf(&sb, "lines=%d ", *summary.Lines)
+	}
+	if summary.Traces != nil {
+		fmt.Fprintf(&sb, "traces=%d observations=%d ", *summary.Traces, *summary.Observations)
+	}
+	fmt.Fprintf(&sb, "inserted=%d skipped=%d failed=%d", summary.Inserted, summary.Skipped, summary.Failed)
+	for _, e := range summary.Errors {
+		b, _ := json.Marshal(e)
+		fmt.Fprintf(os.Stderr, "  %s\n", b)
+	}
+	return sb.String(), summary.Failed, nil
+}
@@ -0,0 +1,254 @@
+package backend
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	tracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepbv1 "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+var (
+	loadgenModels = []string{"gpt-4o", "gpt-4o-mini", "claude-sonnet-4", "gemini-2.5-flash"}
+	loadgenTools  = []string{"search", "lookup_order", "get_weather", "run_sql", "send_email"}
+	loadgenRoutes = []string{"/api/orders", "/api/users/{id}", "/api/search", "/api/checkout"}
+)
+
+// loadgenStats accumulates the results of export requests
+type loadgenStats struct {
+	spans, traces, exports, failures atomic.Int64
+
+	mu        sync.Mutex
+	latencies []int64 // ms per successful export
+	lastError string
+}
+
+func (s *loadgenStats) record(spans, traces int, elapsed time.Duration, err error) {
+	s.exports.Add(1)
+	if err != nil {
+		s.failures.Add(1)
+		s.mu.Lock()
+		s.lastError = err.Error()
+		s.mu.Unlock()
+		return
+	}
+	s.spans.Add(int64(spans))
+	s.traces.Add(int64(traces))
+	s.mu.Lock()
+	s.latencies = append(s.latencies, elapsed.Milliseconds())
+	s.mu.Unlock()
+}
+
+// RunLoadgen implements `simple-traces loadgen`: it sends synthetic agent traces (LLM, tool, HTTP
+// and database spans) to an OTLP endpoint at a target span rate and reports the throughput the
+// server sustained.
+func RunLoadgen(args []string) error {
+	fs := flag.NewFlagSet("loadgen", flag.ContinueOnError)
+	server := fs.String("url", getEnv("SIMPLE_TRACES_URL", defaultServerURL), "Server URL (SIMPLE_TRACES_URL); spans are posted to <url>/v1/traces")
+	token := fs.String("token", getEnv("INGEST_TOKEN", ""), "Bearer token for ingest (INGEST_TOKEN)")
+	rate := fs.Int("rate", 500, "Target spans per second")
+	spansPerTrace := fs.Int("spans-per-trace", 20, "Spans per trace, including the root")
+	batch := fs.Int("batch", 200, "Spans per export request (rounded to whole traces)")
+	duration := fs.Duration("duration", 30*time.Second, "How long to send")
+	concurrency := fs.Int("concurrency", 4, "Export requests in flight")
+	project := fs.String("project", "loadgen", "Project id of the generated spans")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage: simple-traces loadgen [flags]
+
+  simple-traces loadgen --rate 500 --spans-per-trace 20 --duration 1m
+
+`)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+	if *rate <= 0 || *spansPerTrace <= 0 || *batch <= 0 || *concurrency <= 0 || *duration <= 0 {
+		return fmt.Errorf("--rate, --spans-per-trace, --batch, --concurrency and --duration must be positive")
+	}
+
+	tracesPerExport := max(1, *batch / *spansPerTrace)
+	spansPerExport := tracesPerExport * *spansPerTrace
+	interval := max(time.Microsecond, time.Duration(float64(time.Second)*float64(spansPerExport)/float64(*rate)))
+	endpoint := strings.TrimSuffix(*server, "/") + "/v1/traces"
+	fmt.Printf("Sending %d spans/s to %s for %s (%d traces of %d spans per export, %d in flight)\n",
+		*rate, endpoint, *duration, tracesPerExport, *spansPerTrace, *concurrency)
+
+	stats := &loadgenStats{}
+	client := &http.Client{Timeout: 30 * time.Second}
+	// one send slot per interval; slots no worker is free for are skipped, which shows up
+	// as achieved throughput below the target
+	slots := make(chan struct{})
+	var skipped atomic.Int64
+	var wg sync.WaitGroup
+	for w := 0; w < *concurrency; w++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			d := &demoSeeder{rng: rand.New(rand.NewSource(seed))}
+			for range slots {
+				req := d.loadgenExport(*project, tracesPerExport, *spansPerTrace)
+				start := time.Now()
+				err := postExport(client, endpoint, *token, req)
+				stats.record(spansPerExport, tracesPerExport, time.Since(start), err)
+			}
+		}(time.Now().UnixNano() + int64(w))
+	}
+
+	began := time.Now()
+	deadline := time.NewTimer(*duration)
+	ticker := time.NewTicker(interval)
+	report := time.NewTicker(5 * time.Second)
+	var lastSpans int64
+	lastReport := began
+loop:
+	for {
+		select {
+		case <-deadline.C:
+			break loop
+		case <-ticker.C:
+			select {
+			case slots <- struct{}{}:
+			default:
+				skipped.Add(1)
+			}
+		case now := <-report.C:
+			spans := stats.spans.Load()
+			fmt.Printf("%6.0fs  %8d spans  %7.0f spans/s  %d failed exports\n",
+				now.Sub(began).Seconds(), spans, float64(spans-lastSpans)/now.Sub(lastReport).Seconds(), stats.failures.Load())
+			lastSpans, lastReport = spans, now
+		}
+	}
+	ticker.Stop()
+	report.Stop()
+	close(slots)
+	wg.Wait()
+	elapsed := time.Since(began)
+
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	spans := stats.spans.Load()
+	fmt.Printf("\nSent %d spans in %d traces with %d exports over %s\n", spans, stats.traces.Load(), stats.exports.Load(), elapsed.Round(time.Millisecond))
+	fmt.Printf("Throughput: %.0f spans/s, %.1f traces/s (target %d spans/s)\n",
+		float64(spans)/elapsed.Seconds(), float64(stats.traces.Load())/elapsed.Seconds(), *rate)
+	fmt.Printf("Export latency: p50=%dms p95=%dms p99=%dms max=%dms\n",
+		percentile(stats.latencies, 50), percentile(stats.latencies, 95), percentile(stats.latencies, 99), percentile(stats.latencies, 100))
+	if n := skipped.Load(); n > 0 {
+		fmt.Printf("Skipped %d exports because all %d workers were busy; raise --concurrency or the server is saturated\n", n, *concurrency)
+	}
+	if f := stats.failures.Load(); f > 0 {
+		return fmt.Errorf("%d of %d exports failed, last error: %s", f, stats.exports.Load(), stats.lastError)
+	}
+	return nil
+}
+
+func postExport(client *http.Client, endpoint, token string, req *tracepb.ExportTraceServiceRequest) error {
+	body, err := proto.Marshal(req)
+	if err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	if token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// loadgenExport builds one export of synthetic agent traces that just ended
+func (d *demoSeeder) loadgenExport(project string, traces, spansPerTrace int) *tracepb.ExportTraceServiceRequest {
+	var spans []*tracepbv1.Span
+	for i := 0; i < traces; i++ {
+		spans = append(spans, d.loadgenTrace(spansPerTrace).spans...)
+	}
+	return &tracepb.ExportTraceServiceRequest{ResourceSpans: []*tracepbv1.ResourceSpans{{
+		Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{
+			otlpAttr("service.name", "loadgen"), otlpAttr("simpleTraces.project.id", project)}},
+		ScopeSpans: []*tracepbv1.ScopeSpans{{Spans: spans}},
+	}}}
+}
+
+// loadgenTrace builds an agent run: a root span with a sequence of LLM, tool, HTTP and database
+// children in roughly the mix agent traces show
+func (d *demoSeeder) loadgenTrace(spans int) *demoTrace {
+	type step struct {
+		name     string
+		duration time.Duration
+		attrs    []*commonpb.KeyValue
+	}
+	steps := make([]step, 0, spans-1)
+	var total time.Duration
+	for i := 1; i < spans; i++ {
+		var s step
+		switch p := d.rng.Intn(100); {
+		case p < 40:
+			s = step{"call_llm", d.jitter(600 * time.Millisecond), []*commonpb.KeyValue{
+				otlpAttr("gen_ai.request.model", loadgenModels[d.rng.Intn(len(loadgenModels))]),
+				otlpAttr("gen_ai.prompt", "Synthetic prompt for load testing, step "+fmt.Sprint(i)),
+				otlpAttr("gen_ai.response", "Synthetic response with enough text to resemble a real completion."),
+				otlpAttr("gen_ai.usage.input_tokens", d.tokens(800)),
+				otlpAttr("gen_ai.usage.output_tokens", d.tokens(80)),
+			}}
+		case p < 65:
+			tool := loadgenTools[d.rng.Intn(len(loadgenTools))]
+			s = step{"execute_tool " + tool, d.jitter(150 * time.Millisecond), []*commonpb.KeyValue{
+				otlpAttr("gen_ai.tool.name", tool),
+			}}
+		case p < 85:
+			s = step{"GET", d.jitter(60 * time.Millisecond), []*commonpb.KeyValue{
+				otlpAttr("http.request.method", "GET"),
+				otlpAttr("url.full", "https://api.example.com"+loadgenRoutes[d.rng.Intn(len(loadgenRoutes))]),
+				otlpAttr("http.response.status_code", 200),
+			}}
+		default:
+			s = step{"SELECT", d.jitter(8 * time.Millisecond), []*commonpb.KeyValue{
+				otlpAttr("db.system", "postgresql"),
+				otlpAttr("db.statement", "SELECT * FROM documents WHERE id = $1"),
+			}}
+		}
+		steps = append(steps, s)
+		total += s.duration
+	}
+
+	t := d.trace(time.Now().Add(-total - 10*time.Millisecond))
+	root := t.span("invoke_agent loadgen", nil, 0, total+10*time.Millisecond,
+		otlpAttr("session.id", fmt.Sprintf("loadgen-session-%d", d.rng.Intn(200))),
+		otlpAttr("user.id", fmt.Sprintf("loadgen-user-%d", d.rng.Intn(50))))
+	at := 5 * time.Millisecond
+	for _, s := range steps {
+		sp := t.span(s.name, root.SpanId, at, s.duration, s.attrs...)
+		if d.rng.Intn(100) < 2 {
+			failSpan(sp, "synthetic failure")
+		}
+		at += s.duration
+	}
+	return t
+}
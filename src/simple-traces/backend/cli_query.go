@@ -0,0 +1,256 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// queryCellWidth caps table cells so long attributes don't wreck the layout
+const queryCellWidth = 60
+
+// RunQuery implements `simple-traces query <target>`: it runs a listing or stats query against a
+// running server and prints the result as a table, JSON or CSV. Filters are the query parameters
+// of the matching /api endpoint.
+func RunQuery(args []string) error {
+	fs := flag.NewFlagSet("query", flag.ContinueOnError)
+	server := fs.String("url", getEnv("SIMPLE_TRACES_URL", defaultServerURL), "Server URL (SIMPLE_TRACES_URL)")
+	project := fs.String("project", "", "Only this project")
+	limit := fs.Int("limit", 0, "Maximum number of rows (listings only)")
+	format := fs.String("format", "table", "Output format: table, json or csv")
+	columns := fs.String("columns", "", "Comma separated columns to print (table and csv); nested fields as parent.child")
+	filters := cliFilters{}
+	fs.Var(filters, "filter", "Filter key=value, same as the API query parameters, e.g. status=open, errors=true, window=24h (repeatable)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage: simple-traces query <target> [flags]
+
+Targets: trace-groups, spans, conversations, stats/guardrails, stats/finish-reasons,
+stats/prompt-breakdown, stats/retrieval, stats/token-budget, stats/storage
+
+`)
+		fs.PrintDefaults()
+	}
+	// allow the target before the flags, which is how people type it
+	target := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		target, args = args[0], args[1:]
+	}
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+	if target == "" && fs.NArg() > 0 {
+		target = fs.Arg(0)
+	}
+	target = strings.Trim(strings.TrimPrefix(strings.Trim(target, "/"), "api/"), "/")
+	if target == "" {
+		fs.Usage()
+		return fmt.Errorf("missing query target")
+	}
+	switch *format {
+	case "table", "json", "csv":
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+
+	q := url.Values(filters)
+	if *project != "" {
+		q.Set("project", *project)
+	}
+	if *limit > 0 {
+		q.Set("limit", strconv.Itoa(*limit))
+	}
+	queryURL := strings.TrimSuffix(*server, "/") + "/api/" + target
+	if len(q) > 0 {
+		queryURL += "?" + q.Encode()
+	}
+	client := &http.Client{Timeout: time.Minute}
+	resp, err := client.Get(queryURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	if *format == "json" {
+		var out bytes.Buffer
+		if err := json.Indent(&out, body, "", "  "); err != nil {
+			return fmt.Errorf("server returned invalid JSON: %v", err)
+		}
+		out.WriteByte('\n')
+		_, err := out.WriteTo(os.Stdout)
+		return err
+	}
+	rows, cols, err := queryRows(body)
+	if err != nil {
+		return err
+	}
+	if *columns != "" {
+		cols = splitList(*columns)
+	}
+	if *format == "csv" {
+		w := csv.NewWriter(os.Stdout)
+		w.Write(cols)
+		for _, row := range rows {
+			rec := make([]string, len(cols))
+			for i, c := range cols {
+				rec[i] = row[c]
+			}
+			w.Write(rec)
+		}
+		w.Flush()
+		return w.Error()
+	}
+	if len(rows) == 0 {
+		fmt.Fprintln(os.Stderr, "no results")
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.ToUpper(strings.Join(cols, "\t")))
+	for _, row := range rows {
+		cells := make([]string, len(cols))
+		for i, c := range cols {
+			cells[i] = tableCell(row[c])
+		}
+		fmt.Fprintln(w, strings.Join(cells, "\t"))
+	}
+	return w.Flush()
+}
+
+// queryRows flattens an API response into rows of column -> value. Listings are arrays of
+// objects; stats objects contribute their first array of objects (e.g. per_model) or, failing
+// that, themselves as a single row. Columns keep the order the server sent them in.
+func queryRows(body []byte) ([]map[string]string, []string, error) {
+	var raw any
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return nil, nil, fmt.Errorf("server returned invalid JSON: %v", err)
+	}
+	items, ok := raw.([]any)
+	if obj, isObj := raw.(map[string]any); isObj {
+		items = []any{obj}
+		keys := make([]string, 0, len(obj))
+		for k := range obj {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if list, isList := obj[k].([]any); isList && len(list) > 0 {
+				if _, ofObjects := list[0].(map[string]any); ofObjects {
+					items = list
+					break
+				}
+			}
+		}
+	} else if !ok {
+		return nil, nil, fmt.Errorf("unexpected response: %s", strings.TrimSpace(string(body)))
+	}
+
+	rows := make([]map[string]string, 0, len(items))
+	for _, item := range items {
+		row := map[string]string{}
+		if obj, isObj := item.(map[string]any); isObj {
+			flattenRow(row, "", obj)
+		} else {
+			row["value"] = cellValue(item)
+		}
+		rows = append(rows, row)
+	}
+	return rows, orderedColumns(body, rows), nil
+}
+
+func flattenRow(row map[string]string, prefix string, obj map[string]any) {
+	for k, v := range obj {
+		if nested, ok := v.(map[string]any); ok {
+			flattenRow(row, prefix+k+".", nested)
+			continue
+		}
+		row[prefix+k] = cellValue(v)
+	}
+}
+
+func cellValue(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// orderedColumns lists the row keys in the order they first appear in the JSON text
+func orderedColumns(body []byte, rows []map[string]string) []string {
+	seen := map[string]bool{}
+	for _, row := range rows {
+		for k := range row {
+			seen[k] = true
+		}
+	}
+	cols := make([]string, 0, len(seen))
+	for k := range seen {
+		cols = append(cols, k)
+	}
+	pos := func(col string) int {
+		// nested columns sort by the position of their last key within the parent
+		i := 0
+		for _, part := range strings.Split(col, ".") {
+			j := bytes.Index(body[i:], []byte(strconv.Quote(part)+":"))
+			if j < 0 {
+				return len(body)
+			}
+			i += j
+		}
+		return i
+	}
+	sort.Slice(cols, func(a, b int) bool {
+		pa, pb := pos(cols[a]), pos(cols[b])
+		if pa != pb {
+			return pa < pb
+		}
+		return cols[a] < cols[b]
+	})
+	return cols
+}
+
+// tableCell shortens floats and long values for the terminal
+func tableCell(v string) string {
+	if strings.ContainsAny(v, ".eE") {
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			v = strconv.FormatFloat(f, 'f', -1, 64)
+			if i := strings.IndexByte(v, '.'); i >= 0 && len(v)-i > 4 {
+				v = strconv.FormatFloat(f, 'f', 3, 64)
+			}
+		}
+	}
+	v = strings.Join(strings.Fields(v), " ")
+	if r := []rune(v); len(r) > queryCellWidth {
+		v = string(r[:queryCellWidth-1]) + "…"
+	}
+	return v
+}
@@ -0,0 +1,199 @@
+package backend
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+)
+
+// defaultServerURL is where CLI commands look for the server unless --url or SIMPLE_TRACES_URL is set
+const defaultServerURL = "http://localhost:8080"
+
+// cliFilters collects repeatable --filter key=value flags (comma-separated lists allowed)
+type cliFilters url.Values
+
+func (f cliFilters) String() string { return url.Values(f).Encode() }
+
+func (f cliFilters) Set(s string) error {
+	for _, part := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			return fmt.Errorf("filter %q is not key=value", part)
+		}
+		url.Values(f).Add(strings.TrimSpace(k), strings.TrimSpace(v))
+	}
+	return nil
+}
+
+// RunTail implements `simple-traces tail`: it follows the live span stream of a running server
+// and prints one line per span
+func RunTail(args []string) error {
+	fs := flag.NewFlagSet("tail", flag.ContinueOnError)
+	server := fs.String("url", getEnv("SIMPLE_TRACES_URL", defaultServerURL), "Server URL (SIMPLE_TRACES_URL)")
+	project := fs.String("project", "", "Only spans of this project")
+	filters := cliFilters{}
+	fs.Var(filters, "filter", "Span filter key=value, e.g. status=ERROR, model=gpt-4o, category=llm, min_duration_ms=1000 (repeatable)")
+	asJSON := fs.Bool("json", false, "Print each span as JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: simple-traces tail [--project x] [--filter key=value]...\n\nFollows spans as they are ingested.\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+	q := url.Values(filters)
+	if *project != "" {
+		q.Set("project", *project)
+	}
+	streamURL := strings.TrimSuffix(*server, "/") + "/api/spans/stream"
+	if len(q) > 0 {
+		streamURL += "?" + q.Encode()
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt)
+	color := isTerminal(os.Stdout)
+	print := func(sp Span) {
+		if *asJSON {
+			json.NewEncoder(os.Stdout).Encode(sp)
+			return
+		}
+		fmt.Println(formatSpanLine(sp, color))
+	}
+
+	// reconnect until interrupted; spans ingested while disconnected are not replayed
+	backoff := time.Second
+	for {
+		connected, err := followStream(streamURL, print, stop)
+		if err == nil {
+			return nil
+		}
+		if fatal, ok := err.(tailFatal); ok {
+			return fatal.error
+		}
+		if connected {
+			backoff = time.Second
+		}
+		fmt.Fprintf(os.Stderr, "tail: %v; reconnecting in %s\n", err, backoff)
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, 30*time.Second)
+	}
+}
+
+// tailFatal marks stream errors that reconnecting won't fix
+type tailFatal struct{ error }
+
+// followStream reads span events until the connection drops (error) or stop fires (nil)
+func followStream(streamURL string, print func(Span), stop <-chan os.Signal) (connected bool, err error) {
+	req, err := http.NewRequest(http.MethodGet, streamURL, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		err := fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+		if resp.StatusCode == http.StatusBadRequest {
+			// a bad filter won't get better by retrying
+			return false, tailFatal{err}
+		}
+		return false, err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 64<<10), 16<<20)
+		event := ""
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "event:"):
+				event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+			case strings.HasPrefix(line, "data:") && event == "span":
+				var sp Span
+				if json.Unmarshal([]byte(strings.TrimSpace(strings.TrimPrefix(line, "data:"))), &sp) == nil {
+					print(sp)
+				}
+			case line == "":
+				event = ""
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			done <- err
+		} else {
+			done <- io.EOF
+		}
+	}()
+	select {
+	case <-stop:
+		return true, nil
+	case err := <-done:
+		return true, fmt.Errorf("stream closed: %v", err)
+	}
+}
+
+// formatSpanLine renders "15:04:05.000 ERROR llm   gpt-4o  call_llm  1.2s  trace=… (message)"
+func formatSpanLine(sp Span, color bool) string {
+	status := sp.StatusCode
+	if status == "" {
+		status = "UNSET"
+	}
+	if color {
+		switch status {
+		case "ERROR":
+			status = "\033[31m" + status + "\033[0m"
+		case "OK":
+			status = "\033[32m" + status + "\033[0m"
+		}
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %-5s %-9s", sp.StartTime.Local().Format("15:04:05.000"), status, sp.Category)
+	if sp.Model != "" {
+		fmt.Fprintf(&b, " %s", sp.Model)
+	}
+	fmt.Fprintf(&b, " %s %s trace=%s", sp.Name, time.Duration(sp.DurationMS)*time.Millisecond, shortID(sp.TraceID))
+	if sp.ConversationID != "" {
+		fmt.Fprintf(&b, " conv=%s", sp.ConversationID)
+	}
+	if sp.InputTokens > 0 || sp.OutputTokens > 0 {
+		fmt.Fprintf(&b, " tokens=%d/%d", sp.InputTokens, sp.OutputTokens)
+	}
+	if sp.StatusDesc != "" {
+		fmt.Fprintf(&b, " (%s)", sp.StatusDesc)
+	}
+	return b.String()
+}
+
+func shortID(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
+
+// isTerminal reports whether f is a character device (an interactive terminal)
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
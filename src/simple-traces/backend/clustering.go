@@ -0,0 +1,332 @@
+package backend
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ConversationCluster groups conversations whose prompts embed close to each other
+type ConversationCluster struct {
+	ID              int                     `json:"id"`
+	Size            int                     `json:"size"`
+	Representatives []ClusterRepresentative `json:"representatives"`
+}
+
+// ClusterRepresentative is one of the conversations closest to a cluster centroid
+type ClusterRepresentative struct {
+	ConversationID string  `json:"conversation_id,omitempty"`
+	TraceID        string  `json:"trace_id"`
+	Text           string  `json:"text"`
+	Similarity     float64 `json:"similarity"`
+}
+
+// ClusterSnapshot is the latest clustering result
+type ClusterSnapshot struct {
+	ComputedAt    time.Time             `json:"computed_at"`
+	Window        string                `json:"window"`
+	Conversations int                   `json:"conversations"`
+	Clusters      []ConversationCluster `json:"clusters"`
+}
+
+// ConversationClusterer periodically clusters recent conversations using stored embeddings
+type ConversationClusterer struct {
+	db       Database
+	model    string
+	logger   *Logger
+	window   time.Duration
+	k        int
+	mu       sync.RWMutex
+	snapshot *ClusterSnapshot
+	stop     chan struct{}
+}
+
+// NewConversationClusterer starts the background clustering job
+func NewConversationClusterer(db Database, embedder Embedder, config *Config, logger *Logger) *ConversationClusterer {
+	c := &ConversationClusterer{
+		db:     db,
+		model:  embedder.Model(),
+		logger: logger,
+		window: config.ClusterWindow,
+		k:      config.ClusterCount,
+		stop:   make(chan struct{}),
+	}
+	go c.loop(config.ClusterInterval)
+	return c
+}
+
+// Close stops the background job
+func (c *ConversationClusterer) Close() {
+	close(c.stop)
+}
+
+// Snapshot returns the latest clustering result (nil until the first run finishes)
+func (c *ConversationClusterer) Snapshot() *ClusterSnapshot {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.snapshot
+}
+
+func (c *ConversationClusterer) loop(interval time.Duration) {
+	if interval <= 0 {
+		interval = 15 * time.Minute
+	}
+	// first run shortly after startup so the API has data
+	timer := time.NewTimer(30 * time.Second)
+	defer timer.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-timer.C:
+			if err := c.Run(); err != nil {
+				c.logger.Error("Conversation clustering failed: %v", err)
+			}
+			timer.Reset(interval)
+		}
+	}
+}
+
+type convPoint struct {
+	convID  string
+	traceID string
+	text    string
+	vec     []float32
+}
+
+// Run computes clusters from the embeddings of the configured window
+func (c *ConversationClusterer) Run() error {
+	rows, err := c.db.GetEmbeddings(c.model, 50000)
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-c.window)
+
+	// average prompt vectors per conversation; fall back to responses when no prompt exists
+	type acc struct {
+		point convPoint
+		sum   []float64
+		n     int
+		kind  string
+	}
+	byKey := make(map[string]*acc)
+	var keys []string
+	for _, row := range rows {
+		if c.window > 0 && row.CreatedAt.Before(cutoff) {
+			continue
+		}
+		vec := decodeVector(row.Vector)
+		if vec == nil {
+			continue
+		}
+		key := row.ConversationID
+		if key == "" {
+			key = "trace:" + row.TraceID
+		}
+		a := byKey[key]
+		if a == nil {
+			a = &acc{point: convPoint{convID: row.ConversationID, traceID: row.TraceID}, sum: make([]float64, len(vec)), kind: row.Kind}
+			byKey[key] = a
+			keys = append(keys, key)
+		}
+		if a.kind == "response" && row.Kind == "prompt" {
+			// prompts describe intent better; restart the average
+			a.sum, a.n, a.kind = make([]float64, len(vec)), 0, "prompt"
+		}
+		if row.Kind != a.kind || len(vec) != len(a.sum) {
+			continue
+		}
+		for i, x := range vec {
+			a.sum[i] += float64(x)
+		}
+		a.n++
+		if a.point.text == "" {
+			a.point.text = row.Text
+		}
+	}
+	points := make([]convPoint, 0, len(keys))
+	for _, k := range keys {
+		a := byKey[k]
+		if a.n == 0 {
+			continue
+		}
+		vec := make([]float32, len(a.sum))
+		for i, x := range a.sum {
+			vec[i] = float32(x / float64(a.n))
+		}
+		normalize(vec)
+		a.point.vec = vec
+		points = append(points, a.point)
+	}
+
+	k := c.k
+	if k <= 0 {
+		k = int(math.Sqrt(float64(len(points)) / 2))
+		if k < 2 {
+			k = 2
+		}
+		if k > 20 {
+			k = 20
+		}
+	}
+	if k > len(points) {
+		k = len(points)
+	}
+
+	snap := &ClusterSnapshot{
+		ComputedAt:    time.Now(),
+		Window:        c.window.String(),
+		Conversations: len(points),
+		Clusters:      []ConversationCluster{},
+	}
+	if k > 0 {
+		assign, centroids := sphericalKMeans(points, k, 25)
+		members := make([][]int, k)
+		for i, ci := range assign {
+			members[ci] = append(members[ci], i)
+		}
+		for ci, idxs := range members {
+			if len(idxs) == 0 {
+				continue
+			}
+			sort.Slice(idxs, func(a, b int) bool {
+				return cosine(points[idxs[a]].vec, centroids[ci]) > cosine(points[idxs[b]].vec, centroids[ci])
+			})
+			cl := ConversationCluster{Size: len(idxs)}
+			for _, pi := range idxs {
+				if len(cl.Representatives) == 3 {
+					break
+				}
+				p := points[pi]
+				cl.Representatives = append(cl.Representatives, ClusterRepresentative{
+					ConversationID: p.convID,
+					TraceID:        p.traceID,
+					Text:           p.text,
+					Similarity:     cosine(p.vec, centroids[ci]),
+				})
+			}
+			snap.Clusters = append(snap.Clusters, cl)
+		}
+		sort.Slice(snap.Clusters, func(a, b int) bool { return snap.Clusters[a].Size > snap.Clusters[b].Size })
+		for i := range snap.Clusters {
+			snap.Clusters[i].ID = i + 1
+		}
+	}
+
+	c.mu.Lock()
+	c.snapshot = snap
+	c.mu.Unlock()
+	c.logger.Info("Clustered %d conversations into %d clusters", len(points), len(snap.Clusters))
+	return nil
+}
+
+// sphericalKMeans clusters unit vectors by cosine similarity using k-means++ seeding.
+// A fixed seed keeps cluster ids stable between runs over the same data.
+func sphericalKMeans(points []convPoint, k, iterations int) ([]int, [][]float32) {
+	rng := rand.New(rand.NewSource(1))
+	centroids := make([][]float32, 0, k)
+	centroids = append(centroids, points[rng.Intn(len(points))].vec)
+	for len(centroids) < k {
+		dists := make([]float64, len(points))
+		var total float64
+		for i, p := range points {
+			best := math.Inf(1)
+			for _, c := range centroids {
+				if d := 1 - cosine(p.vec, c); d < best {
+					best = d
+				}
+			}
+			dists[i] = best * best
+			total += dists[i]
+		}
+		if total == 0 {
+			break
+		}
+		target := rng.Float64() * total
+		picked := len(points) - 1
+		for i, d := range dists {
+			target -= d
+			if target <= 0 && d > 0 {
+				picked = i
+				break
+			}
+		}
+		centroids = append(centroids, points[picked].vec)
+	}
+
+	assign := make([]int, len(points))
+	for iter := 0; iter < iterations; iter++ {
+		changed := false
+		for i, p := range points {
+			best, bestSim := 0, math.Inf(-1)
+			for ci, c := range centroids {
+				if s := cosine(p.vec, c); s > bestSim {
+					best, bestSim = ci, s
+				}
+			}
+			if assign[i] != best {
+				assign[i] = best
+				changed = true
+			}
+		}
+		if !changed && iter > 0 {
+			break
+		}
+		dim := len(points[0].vec)
+		next := make([][]float32, len(centroids))
+		for ci := range next {
+			next[ci] = make([]float32, dim)
+		}
+		for i, p := range points {
+			for d, x := range p.vec {
+				next[assign[i]][d] += x
+			}
+		}
+		for ci := range next {
+			normalize(next[ci])
+			centroids[ci] = next[ci]
+		}
+	}
+	return assign, centroids
+}
+
+// getClustersHandler returns the latest conversation clusters
+func getClustersHandler(clusterer *ConversationClusterer, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if clusterer == nil {
+			http.Error(w, "clustering requires embeddings (set EMBEDDINGS_PROVIDER)", http.StatusServiceUnavailable)
+			return
+		}
+		writeClusters(w, clusterer)
+	}
+}
+
+// refreshClustersHandler recomputes the clusters right away; it is an admin route, a run
+// clusters all recent conversations
+func refreshClustersHandler(clusterer *ConversationClusterer, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if clusterer == nil {
+			http.Error(w, "clustering requires embeddings (set EMBEDDINGS_PROVIDER)", http.StatusServiceUnavailable)
+			return
+		}
+		if err := clusterer.Run(); err != nil {
+			logger.Error("Failed to cluster conversations: %v", err)
+			http.Error(w, "Failed to cluster conversations", http.StatusInternalServerError)
+			return
+		}
+		writeClusters(w, clusterer)
+	}
+}
+
+func writeClusters(w http.ResponseWriter, clusterer *ConversationClusterer) {
+	snap := clusterer.Snapshot()
+	if snap == nil {
+		snap = &ClusterSnapshot{Clusters: []ConversationCluster{}}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(snap)
+}
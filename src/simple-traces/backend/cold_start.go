@@ -0,0 +1,194 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ColdStartDetector marks LLM calls made after their model endpoint was idle for at least idle.
+// Serverless and scaled-to-zero endpoints (and provider caches) answer the first call after a
+// pause much slower; marking those calls keeps the spikes from reading as regressions.
+//
+// The last call of each provider and model is remembered in memory, so the first call of a
+// model after a restart is never marked.
+type ColdStartDetector struct {
+	idle time.Duration
+
+	mu sync.Mutex
+	// end of the latest call per provider and model, see coldStartKey
+	last map[string]time.Time
+}
+
+// NewColdStartDetector creates a detector; nil when idle is zero or less
+func NewColdStartDetector(idle time.Duration) *ColdStartDetector {
+	metrics.Describe("simpletraces_llm_cold_starts_total", "counter", "LLM calls marked as cold starts by model, see COLD_START_IDLE")
+	if idle <= 0 {
+		return nil
+	}
+	return &ColdStartDetector{idle: idle, last: make(map[string]time.Time)}
+}
+
+// coldStartKey identifies a model endpoint
+func coldStartKey(provider, model string) string {
+	return provider + "/" + model
+}
+
+// llmProvider returns the provider (gen_ai.system) in a span's attributes JSON
+func llmProvider(attributes string) string {
+	var attrs struct {
+		System string `json:"gen_ai.system"`
+	}
+	json.Unmarshal([]byte(attributes), &attrs)
+	return attrs.System
+}
+
+// Mark sets ColdStart on the LLM calls of spans that started at least idle after the previous
+// call of the same provider and model ended, and records the idle time in their attributes.
+// Spans are considered in start order; calls arriving late don't move the last call back.
+func (d *ColdStartDetector) Mark(spans []Span) int {
+	if d == nil {
+		return 0
+	}
+	var calls []int
+	for i, sp := range spans {
+		if sp.Category == "llm" && sp.Model != "" {
+			calls = append(calls, i)
+		}
+	}
+	sort.SliceStable(calls, func(a, b int) bool { return spans[calls[a]].StartTime.Before(spans[calls[b]].StartTime) })
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	marked := 0
+	for _, i := range calls {
+		sp := &spans[i]
+		key := coldStartKey(llmProvider(sp.Attributes), sp.Model)
+		last, ok := d.last[key]
+		if ok && sp.StartTime.Sub(last) >= d.idle {
+			sp.ColdStart = true
+			annotateColdStart(sp, sp.StartTime.Sub(last))
+			metrics.Inc("simpletraces_llm_cold_starts_total", "model", sp.Model)
+			marked++
+		}
+		if !ok || sp.EndTime.After(last) {
+			d.last[key] = sp.EndTime
+		}
+	}
+	return marked
+}
+
+// annotateColdStart adds simpleTraces.cold_start.idle_ms to a span's attributes
+func annotateColdStart(sp *Span, idle time.Duration) {
+	attrs := make(map[string]any)
+	if sp.Attributes != "" && json.Unmarshal([]byte(sp.Attributes), &attrs) != nil {
+		return
+	}
+	attrs["simpleTraces.cold_start.idle_ms"] = idle.Milliseconds()
+	if b, err := json.Marshal(attrs); err == nil {
+		sp.Attributes = string(b)
+	}
+}
+
+// ColdStartStats compares the latency of the cold and warm calls of one provider and model
+type ColdStartStats struct {
+	Provider      string  `json:"provider"`
+	Model         string  `json:"model"`
+	Calls         int64   `json:"calls"`
+	ColdStarts    int64   `json:"cold_starts"`
+	ColdStartRate float64 `json:"cold_start_rate"`
+	// Latency of warm calls, the figures to watch for regressions
+	WarmP50MS int64 `json:"warm_p50_ms"`
+	WarmP95MS int64 `json:"warm_p95_ms"`
+	ColdP50MS int64 `json:"cold_p50_ms"`
+	ColdP95MS int64 `json:"cold_p95_ms"`
+	// PenaltyMS is how much slower the median cold call is than the median warm call
+	PenaltyMS int64 `json:"penalty_ms"`
+}
+
+// GetColdStartStats splits the latency of LLM calls per provider and model into cold starts and
+// warm calls, most cold starts first
+func (g *GormDB) GetColdStartStats(filter StatsFilter) ([]ColdStartStats, error) {
+	var rows []struct {
+		Model      string
+		DurationMS int64
+		ColdStart  bool
+		Attributes string
+	}
+	if err := filter.apply(g.db.Model(&Span{})).
+		Select("model, duration_ms, cold_start, attributes").
+		Where("category = ? AND model <> ''", "llm").
+		Limit(200000).
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	type durations struct {
+		provider, model string
+		warm, cold      []int64
+	}
+	groups := make(map[string]*durations)
+	for _, r := range rows {
+		provider := llmProvider(r.Attributes)
+		key := coldStartKey(provider, r.Model)
+		d := groups[key]
+		if d == nil {
+			d = &durations{provider: provider, model: r.Model}
+			groups[key] = d
+		}
+		if r.ColdStart {
+			d.cold = append(d.cold, r.DurationMS)
+		} else {
+			d.warm = append(d.warm, r.DurationMS)
+		}
+	}
+	out := make([]ColdStartStats, 0, len(groups))
+	for _, d := range groups {
+		s := ColdStartStats{
+			Provider:   d.provider,
+			Model:      d.model,
+			Calls:      int64(len(d.warm) + len(d.cold)),
+			ColdStarts: int64(len(d.cold)),
+			WarmP50MS:  percentile(d.warm, 50),
+			WarmP95MS:  percentile(d.warm, 95),
+			ColdP50MS:  percentile(d.cold, 50),
+			ColdP95MS:  percentile(d.cold, 95),
+		}
+		s.ColdStartRate = ratio(s.ColdStarts, s.Calls)
+		if len(d.cold) > 0 && len(d.warm) > 0 {
+			s.PenaltyMS = s.ColdP50MS - s.WarmP50MS
+		}
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].ColdStarts != out[j].ColdStarts {
+			return out[i].ColdStarts > out[j].ColdStarts
+		}
+		if out[i].Calls != out[j].Calls {
+			return out[i].Calls > out[j].Calls
+		}
+		return coldStartKey(out[i].Provider, out[i].Model) < coldStartKey(out[j].Provider, out[j].Model)
+	})
+	return out, nil
+}
+
+// getColdStartStatsHandler returns cold start rates and latencies per provider and model
+func getColdStartStatsHandler(db Database, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := parseStatsFilter(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		stats, err := db.GetColdStartStats(filter)
+		if err != nil {
+			logger.Error("Failed to get cold start stats: %v", err)
+			http.Error(w, fmt.Sprintf("Failed to get cold start stats: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats)
+	}
+}
@@ -0,0 +1,211 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// Comment is a discussion entry on a trace group, optionally pinned to one of its spans
+type Comment struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	TraceID   string    `gorm:"index" json:"trace_id"`
+	SpanID    string    `gorm:"index" json:"span_id,omitempty"`
+	Author    string    `json:"author"`
+	Body      string    `gorm:"type:text" json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (g *GormDB) CreateComment(c *Comment) error {
+	return g.db.Create(c).Error
+}
+
+// GetComments lists comments of a trace group (all of them, or only those on spanID) oldest first
+func (g *GormDB) GetComments(traceID, spanID string) ([]Comment, error) {
+	var comments []Comment
+	query := g.db.Where("trace_id = ?", traceID).Order("created_at ASC, id ASC")
+	if spanID != "" {
+		query = query.Where("span_id = ?", spanID)
+	}
+	if err := query.Find(&comments).Error; err != nil {
+		return nil, err
+	}
+	return comments, nil
+}
+
+// UpdateComment replaces the body of a comment; gorm.ErrRecordNotFound for unknown ids
+func (g *GormDB) UpdateComment(id uint, body string) (*Comment, error) {
+	var c Comment
+	if err := g.db.First(&c, id).Error; err != nil {
+		return nil, err
+	}
+	c.Body = body
+	if err := g.db.Save(&c).Error; err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (g *GormDB) DeleteComment(id uint) (int64, error) {
+	result := g.db.Delete(&Comment{}, id)
+	return result.RowsAffected, result.Error
+}
+
+// lookupSpanTraceID returns the trace of a stored span
+func lookupSpanTraceID(db Database, spanID string) (string, error) {
+	spans, err := db.GetSpansFiltered(1, time.Time{}, SpanFilter{SpanID: spanID})
+	if err != nil {
+		return "", err
+	}
+	if len(spans) == 0 {
+		return "", gorm.ErrRecordNotFound
+	}
+	return spans[0].TraceID, nil
+}
+
+// getCommentsHandler lists comments of a trace group (?span_id= narrows to one span) or of a span
+func getCommentsHandler(db Database, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		traceID := strings.TrimSpace(vars["trace_id"])
+		spanID := strings.TrimSpace(r.URL.Query().Get("span_id"))
+		if id := strings.TrimSpace(vars["id"]); id != "" {
+			spanID = id
+			var err error
+			if traceID, err = lookupSpanTraceID(db, spanID); err == gorm.ErrRecordNotFound {
+				http.Error(w, "Span not found", http.StatusNotFound)
+				return
+			} else if err != nil {
+				logger.Error("Failed to look up span %s: %v", spanID, err)
+				http.Error(w, fmt.Sprintf("Failed to get comments: %v", err), http.StatusInternalServerError)
+				return
+			}
+		}
+		comments, err := db.GetComments(traceID, spanID)
+		if err != nil {
+			logger.Error("Failed to get comments: %v", err)
+			http.Error(w, fmt.Sprintf("Failed to get comments: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(comments)
+	}
+}
+
+// createCommentHandler adds a comment to a trace group or span
+func createCommentHandler(db Database, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Author string `json:"author"`
+			Body   string `json:"body"`
+			SpanID string `json:"span_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		if strings.TrimSpace(req.Author) == "" || strings.TrimSpace(req.Body) == "" {
+			http.Error(w, "author and body are required", http.StatusBadRequest)
+			return
+		}
+
+		vars := mux.Vars(r)
+		c := Comment{
+			TraceID: strings.TrimSpace(vars["trace_id"]),
+			SpanID:  strings.TrimSpace(req.SpanID),
+			Author:  strings.TrimSpace(req.Author),
+			Body:    req.Body,
+		}
+		if id := strings.TrimSpace(vars["id"]); id != "" {
+			c.SpanID = id
+		}
+		if c.SpanID != "" {
+			traceID, err := lookupSpanTraceID(db, c.SpanID)
+			if err == gorm.ErrRecordNotFound {
+				http.Error(w, "Span not found", http.StatusNotFound)
+				return
+			} else if err != nil {
+				logger.Error("Failed to look up span %s: %v", c.SpanID, err)
+				http.Error(w, fmt.Sprintf("Failed to create comment: %v", err), http.StatusInternalServerError)
+				return
+			}
+			if c.TraceID != "" && c.TraceID != traceID {
+				http.Error(w, "span does not belong to this trace", http.StatusBadRequest)
+				return
+			}
+			c.TraceID = traceID
+		}
+
+		if err := db.CreateComment(&c); err != nil {
+			logger.Error("Failed to create comment: %v", err)
+			http.Error(w, fmt.Sprintf("Failed to create comment: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(c)
+	}
+}
+
+// updateCommentHandler edits the body of a comment
+func updateCommentHandler(db Database, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(mux.Vars(r)["comment_id"], 10, 64)
+		if err != nil {
+			http.Error(w, "invalid comment id", http.StatusBadRequest)
+			return
+		}
+		var req struct {
+			Body string `json:"body"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Body) == "" {
+			http.Error(w, "body is required", http.StatusBadRequest)
+			return
+		}
+		c, err := db.UpdateComment(uint(id), req.Body)
+		if err == gorm.ErrRecordNotFound {
+			http.Error(w, "Comment not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error("Failed to update comment %d: %v", id, err)
+			http.Error(w, fmt.Sprintf("Failed to update comment: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(c)
+	}
+}
+
+// deleteCommentHandler removes a comment
+func deleteCommentHandler(db Database, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(mux.Vars(r)["comment_id"], 10, 64)
+		if err != nil {
+			http.Error(w, "invalid comment id", http.StatusBadRequest)
+			return
+		}
+		deleted, err := db.DeleteComment(uint(id))
+		if err != nil {
+			logger.Error("Failed to delete comment %d: %v", id, err)
+			http.Error(w, fmt.Sprintf("Failed to delete comment: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if deleted == 0 {
+			http.Error(w, "Comment not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"ok":      true,
+			"deleted": deleted,
+		})
+	}
+}
@@ -0,0 +1,369 @@
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// errConversationEmpty is returned when a merge or split would move no spans
+var errConversationEmpty = errors.New("no spans to move")
+
+// ConversationMove reports the spans moved from one conversation to another by a merge or split
+type ConversationMove struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Traces int    `json:"traces"`
+	Spans  int64  `json:"spans"`
+}
+
+// MergeConversations moves every span of source into target and removes source. Targets that
+// don't exist yet are created, so a merge also renames a conversation.
+func (g *GormDB) MergeConversations(source, target string) (ConversationMove, error) {
+	move := ConversationMove{Source: source, Target: target}
+	err := g.db.Transaction(func(tx *gorm.DB) error {
+		var traceIDs []string
+		if err := tx.Model(&Span{}).Distinct("trace_id").Where("conversation_id = ?", source).
+			Pluck("trace_id", &traceIDs).Error; err != nil {
+			return err
+		}
+		if len(traceIDs) == 0 {
+			return errConversationEmpty
+		}
+		move.Traces = len(traceIDs)
+		n, err := moveConversationSpans(tx, target, func(q *gorm.DB) *gorm.DB {
+			return q.Where("conversation_id = ?", source)
+		})
+		if err != nil {
+			return err
+		}
+		move.Spans = n
+		return refreshConversations(tx, source, target)
+	})
+	return move, err
+}
+
+// SplitConversation moves the traces of a conversation that started within [since, until) into
+// a new conversation. Traces are moved whole, so none of them ends up in both.
+func (g *GormDB) SplitConversation(id, newID string, since, until time.Time) (ConversationMove, error) {
+	move := ConversationMove{Source: id, Target: newID}
+	err := g.db.Transaction(func(tx *gorm.DB) error {
+		q := tx.Model(&Span{}).Select("trace_id").Where("conversation_id = ?", id).Group("trace_id")
+		if !since.IsZero() {
+			q = q.Having("MIN(start_time) >= ?", since)
+		}
+		if !until.IsZero() {
+			q = q.Having("MIN(start_time) < ?", until)
+		}
+		var traceIDs []string
+		if err := q.Pluck("trace_id", &traceIDs).Error; err != nil {
+			return err
+		}
+		if len(traceIDs) == 0 {
+			return errConversationEmpty
+		}
+		move.Traces = len(traceIDs)
+		n, err := moveConversationSpans(tx, newID, func(q *gorm.DB) *gorm.DB {
+			return q.Where("conversation_id = ? AND trace_id IN ?", id, traceIDs)
+		})
+		if err != nil {
+			return err
+		}
+		move.Spans = n
+		return refreshConversations(tx, id, newID)
+	})
+	return move, err
+}
+
+// TraceAssignment reports a trace attached to a conversation by AssignTraceConversation
+type TraceAssignment struct {
+	TraceID        string `json:"trace_id"`
+	ConversationID string `json:"conversation_id"`
+	// Previous lists the conversations the trace's spans belonged to before
+	Previous []string `json:"previous,omitempty"`
+	Spans    int64    `json:"spans"`
+	Created  bool     `json:"created"`
+}
+
+// AssignTraceConversation moves every span of a trace into a conversation, for traces whose
+// instrumentation sent no session attributes. Missing conversations are created with the
+// project of the spans and the user from their attributes (or userID when they have none).
+// gorm.ErrRecordNotFound is returned for unknown traces.
+func (g *GormDB) AssignTraceConversation(traceID, conversationID, userID string) (TraceAssignment, error) {
+	a := TraceAssignment{TraceID: traceID, ConversationID: conversationID}
+	err := g.db.Transaction(func(tx *gorm.DB) error {
+		var spans []Span
+		if err := tx.Select("conversation_id, attributes").Where("trace_id = ?", traceID).Find(&spans).Error; err != nil {
+			return err
+		}
+		if len(spans) == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		seen := make(map[string]bool)
+		for _, sp := range spans {
+			if sp.ConversationID != "" && sp.ConversationID != conversationID && !seen[sp.ConversationID] {
+				seen[sp.ConversationID] = true
+				a.Previous = append(a.Previous, sp.ConversationID)
+			}
+			if userID == "" && sp.Attributes != "" {
+				attrs := make(map[string]any)
+				if json.Unmarshal([]byte(sp.Attributes), &attrs) == nil {
+					userID = firstStringAttr(attrs, userIDKeys)
+				}
+			}
+		}
+		var existing int64
+		if err := tx.Model(&Conversation{}).Where("id = ?", conversationID).Count(&existing).Error; err != nil {
+			return err
+		}
+		a.Created = existing == 0
+		n, err := moveConversationSpans(tx, conversationID, func(q *gorm.DB) *gorm.DB {
+			return q.Where("trace_id = ?", traceID)
+		})
+		if err != nil {
+			return err
+		}
+		a.Spans = n
+		if err := refreshConversations(tx, append([]string{conversationID}, a.Previous...)...); err != nil {
+			return err
+		}
+		if userID == "" {
+			return nil
+		}
+		return tx.Model(&Conversation{}).Where("id = ? AND (user_id = '' OR user_id IS NULL)", conversationID).
+			Update("user_id", userID).Error
+	})
+	return a, err
+}
+
+// moveConversationSpans reassigns the spans selected by scope to conversation to, along with
+// their logs, retrieved documents and embeddings (scope must only use columns all of them have:
+// conversation_id and trace_id). The conversation id attribute is rewritten too, so the spans
+// look as if they had been sent so.
+func moveConversationSpans(tx *gorm.DB, to string, scope func(*gorm.DB) *gorm.DB) (int64, error) {
+	var moved int64
+	var spans []Span
+	err := scope(tx.Model(&Span{})).Select("span_id, attributes").FindInBatches(&spans, 500, func(_ *gorm.DB, _ int) error {
+		for _, sp := range spans {
+			attrs := make(map[string]any)
+			if sp.Attributes != "" {
+				json.Unmarshal([]byte(sp.Attributes), &attrs)
+			}
+			attrs["simpleTraces.conversation.id"] = to
+			attrsJSON, err := json.Marshal(attrs)
+			if err != nil {
+				return err
+			}
+			if err := tx.Model(&Span{}).Where("span_id = ?", sp.SpanID).Updates(map[string]any{
+				"attributes":      string(attrsJSON),
+				"conversation_id": to,
+			}).Error; err != nil {
+				return err
+			}
+		}
+		moved += int64(len(spans))
+		return nil
+	}).Error
+	if err != nil {
+		return 0, err
+	}
+	for _, model := range []any{&LogRecord{}, &RetrievedDocument{}, &SpanEmbedding{}} {
+		if err := scope(tx.Model(model)).Update("conversation_id", to).Error; err != nil {
+			return 0, err
+		}
+	}
+	return moved, nil
+}
+
+// refreshConversations recomputes the time range of conversations from their spans and marks
+// them for a new summary. Conversations left without spans are removed; new ones take the
+// project and user of the first one.
+func refreshConversations(tx *gorm.DB, ids ...string) error {
+	var origin Conversation
+	if err := tx.Where("id = ?", ids[0]).Limit(1).Find(&origin).Error; err != nil {
+		return err
+	}
+	for _, id := range ids {
+		var agg struct {
+			ProjectID string
+			First     dbTime
+			Last      dbTime
+			Spans     int64
+		}
+		if err := tx.Model(&Span{}).
+			Select("MIN(project_id) AS project_id, MIN(start_time) AS first, MAX(end_time) AS last, COUNT(*) AS spans").
+			Where("conversation_id = ?", id).
+			Scan(&agg).Error; err != nil {
+			return err
+		}
+		if agg.Spans == 0 {
+			if err := tx.Delete(&Conversation{}, "id = ?", id).Error; err != nil {
+				return err
+			}
+			continue
+		}
+		var conv Conversation
+		res := tx.Where("id = ?", id).Limit(1).Find(&conv)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			conv = Conversation{ID: id, ProjectID: origin.ProjectID, UserID: origin.UserID}
+			if conv.ProjectID == "" {
+				conv.ProjectID = agg.ProjectID
+			}
+			if err := tx.Create(&conv).Error; err != nil {
+				return err
+			}
+		}
+		updates := map[string]any{
+			"first_start_time": agg.First.Time,
+			"last_end_time":    agg.Last.Time,
+			"summarized_at":    nil,
+		}
+		if conv.UserID == "" {
+			updates["user_id"] = origin.UserID
+		}
+		if err := tx.Model(&Conversation{}).Where("id = ?", id).Updates(updates).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type conversationMergeBody struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+}
+
+type conversationSplitBody struct {
+	// NewID defaults to "<id>-split-<since (or until) as unix seconds>"
+	NewID string `json:"new_id"`
+	Since string `json:"since"`
+	Until string `json:"until"`
+}
+
+type traceAssignmentBody struct {
+	// ConversationID defaults to the trace id
+	ConversationID string `json:"conversation_id"`
+	// UserID is used for new conversations when the spans carry no user attribute
+	UserID string `json:"user_id"`
+}
+
+// writeConversationMove answers a merge or split
+func writeConversationMove(w http.ResponseWriter, move ConversationMove, err error, logger *Logger) {
+	if errors.Is(err, errConversationEmpty) {
+		http.Error(w, fmt.Sprintf("Conversation %s has no spans to move", move.Source), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to move conversation %s to %s: %v", move.Source, move.Target, err)
+		http.Error(w, fmt.Sprintf("Failed to move conversation: %v", err), http.StatusInternalServerError)
+		return
+	}
+	logger.Info("Moved %d spans of %d traces from conversation %s to %s", move.Spans, move.Traces, move.Source, move.Target)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(move)
+}
+
+// mergeConversationsHandler merges the source conversation into the target
+func mergeConversationsHandler(db Database, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body conversationMergeBody
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		source, target := strings.TrimSpace(body.Source), strings.TrimSpace(body.Target)
+		if source == "" || target == "" {
+			http.Error(w, "source and target are required", http.StatusBadRequest)
+			return
+		}
+		if source == target {
+			http.Error(w, "source and target must differ", http.StatusBadRequest)
+			return
+		}
+		move, err := db.MergeConversations(source, target)
+		writeConversationMove(w, move, err, logger)
+	}
+}
+
+// splitConversationHandler moves the traces of a conversation started in a time range into a new one
+func splitConversationHandler(db Database, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimSpace(mux.Vars(r)["id"])
+		var body conversationSplitBody
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		var since, until time.Time
+		var err error
+		if s := strings.TrimSpace(body.Since); s != "" {
+			if since, err = parseTimeParam(s); err != nil {
+				http.Error(w, fmt.Sprintf("invalid since: %v", err), http.StatusBadRequest)
+				return
+			}
+		}
+		if s := strings.TrimSpace(body.Until); s != "" {
+			if until, err = parseTimeParam(s); err != nil {
+				http.Error(w, fmt.Sprintf("invalid until: %v", err), http.StatusBadRequest)
+				return
+			}
+		}
+		if since.IsZero() && until.IsZero() {
+			http.Error(w, "since or until is required", http.StatusBadRequest)
+			return
+		}
+		newID := strings.TrimSpace(body.NewID)
+		if newID == "" {
+			from := since
+			if from.IsZero() {
+				from = until
+			}
+			newID = fmt.Sprintf("%s-split-%d", id, from.Unix())
+		}
+		if newID == id {
+			http.Error(w, "new_id must differ from the conversation id", http.StatusBadRequest)
+			return
+		}
+		move, err := db.SplitConversation(id, newID, since, until)
+		writeConversationMove(w, move, err, logger)
+	}
+}
+
+// assignTraceConversationHandler attaches a trace to an existing or new conversation
+func assignTraceConversationHandler(db Database, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		traceID := strings.TrimSpace(mux.Vars(r)["trace_id"])
+		var body traceAssignmentBody
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		conversationID := strings.TrimSpace(body.ConversationID)
+		if conversationID == "" {
+			conversationID = traceID
+		}
+		a, err := db.AssignTraceConversation(traceID, conversationID, strings.TrimSpace(body.UserID))
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "Trace group not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error("Failed to assign trace %s to conversation %s: %v", traceID, conversationID, err)
+			http.Error(w, fmt.Sprintf("Failed to assign conversation: %v", err), http.StatusInternalServerError)
+			return
+		}
+		logger.Info("Assigned %d spans of trace %s to conversation %s", a.Spans, traceID, conversationID)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(a)
+	}
+}
@@ -0,0 +1,349 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// TranscriptTurn is a single prompt/response exchange taken from an LLM span
+type TranscriptTurn struct {
+	SpanID    string    `json:"span_id"`
+	TraceID   string    `json:"trace_id"`
+	StartTime time.Time `json:"start_time"`
+	Prompt    string    `json:"prompt,omitempty"`
+	Response  string    `json:"response,omitempty"`
+	// Field tells which side of the turn matched the search ("prompt" or "response")
+	Field   string `json:"field,omitempty"`
+	Snippet string `json:"snippet,omitempty"`
+}
+
+// ConversationMatch is a conversation together with the turns that matched a transcript search
+type ConversationMatch struct {
+	Conversation Conversation     `json:"conversation"`
+	Matches      []TranscriptTurn `json:"matches"`
+}
+
+// transcript keys in preference order; the first non-empty value wins
+var (
+	promptKeys   = []string{"gen_ai.prompt", "llm.input"}
+	responseKeys = []string{"gen_ai.response", "gen_ai.completion", "llm.output"}
+)
+
+// transcriptText extracts the prompt and response text of a span from its flattened attributes
+func transcriptText(attrs map[string]any) (string, string) {
+	pick := func(keys []string) string {
+		for _, k := range keys {
+			if s, ok := attrs[k].(string); ok && strings.TrimSpace(s) != "" {
+				return s
+			}
+		}
+		return ""
+	}
+	return pick(promptKeys), pick(responseKeys)
+}
+
+// snippetAround returns a short excerpt of text centred on the first match of needle (lowercase)
+func snippetAround(text, needle string) string {
+	const radius = 80
+	idx := strings.Index(strings.ToLower(text), needle)
+	if idx < 0 {
+		return ""
+	}
+	start := idx - radius
+	if start < 0 {
+		start = 0
+	}
+	end := idx + len(needle) + radius
+	if end > len(text) {
+		end = len(text)
+	}
+	// avoid cutting through a multi-byte rune
+	for start > 0 && !isRuneStart(text[start]) {
+		start--
+	}
+	for end < len(text) && !isRuneStart(text[end]) {
+		end++
+	}
+	out := text[start:end]
+	if start > 0 {
+		out = "…" + out
+	}
+	if end < len(text) {
+		out += "…"
+	}
+	return out
+}
+
+func isRuneStart(b byte) bool { return b&0xC0 != 0x80 }
+
+// containsTerms reports whether text contains every one of terms (lowercase)
+func containsTerms(text string, terms []string) bool {
+	text = strings.ToLower(text)
+	for _, t := range terms {
+		if !strings.Contains(text, t) {
+			return false
+		}
+	}
+	return true
+}
+
+// SearchConversationTranscripts finds conversations whose prompt/response text has words
+// starting with every word of search. Candidate spans come from the transcript index (see
+// createTranscriptIndex) and are verified against the extracted transcript text.
+func (g *GormDB) SearchConversationTranscripts(search string, limit int) ([]ConversationMatch, error) {
+	if limit <= 0 || limit > 200 {
+		limit = 20
+	}
+	terms := transcriptTerms(search)
+	if len(terms) == 0 {
+		return []ConversationMatch{}, nil
+	}
+
+	// Scan a bounded window of candidate spans; most recent first
+	scanLimit := limit * 50
+	if scanLimit > 5000 {
+		scanLimit = 5000
+	}
+	var spans []Span
+	if err := whereTranscriptMatches(g.db.Select("span_id, trace_id, conversation_id, start_time, attributes"), terms).
+		Order("start_time DESC").
+		Limit(scanLimit).
+		Find(&spans).Error; err != nil {
+		return nil, err
+	}
+
+	var order []string
+	byConv := make(map[string][]TranscriptTurn)
+	for _, sp := range spans {
+		var attrs map[string]any
+		if err := json.Unmarshal([]byte(sp.Attributes), &attrs); err != nil {
+			continue
+		}
+		convID := sp.ConversationID
+		if convID == "" {
+			convID, _ = attrs["simpleTraces.conversation.id"].(string)
+		}
+		if convID == "" {
+			continue
+		}
+		prompt, response := transcriptText(attrs)
+		turn := TranscriptTurn{
+			SpanID:    sp.SpanID,
+			TraceID:   sp.TraceID,
+			StartTime: sp.StartTime,
+			Prompt:    prompt,
+			Response:  response,
+		}
+		if !containsTerms(prompt+"\n"+response, terms) {
+			continue
+		}
+		for _, t := range terms {
+			if turn.Snippet = snippetAround(prompt, t); turn.Snippet != "" {
+				turn.Field = "prompt"
+				break
+			}
+			if turn.Snippet = snippetAround(response, t); turn.Snippet != "" {
+				turn.Field = "response"
+				break
+			}
+		}
+		if _, seen := byConv[convID]; !seen {
+			if len(order) >= limit {
+				continue
+			}
+			order = append(order, convID)
+		}
+		byConv[convID] = append(byConv[convID], turn)
+	}
+
+	convs := make(map[string]Conversation, len(order))
+	if len(order) > 0 {
+		var rows []Conversation
+		if err := g.db.Where("id IN ?", order).Find(&rows).Error; err != nil {
+			return nil, err
+		}
+		for _, c := range rows {
+			convs[c.ID] = c
+		}
+	}
+
+	out := make([]ConversationMatch, 0, len(order))
+	for _, id := range order {
+		conv, ok := convs[id]
+		if !ok {
+			conv = Conversation{ID: id}
+		}
+		out = append(out, ConversationMatch{Conversation: conv, Matches: byConv[id]})
+	}
+	return out, nil
+}
+
+// GetConversationTranscript returns the prompt/response turns of a conversation in chronological
+// order. Instrumentations that emit prompts and completions as log events instead of span
+// attributes are covered by filling in each span's turn from the events logged in it.
+func (g *GormDB) GetConversationTranscript(conversationID string, limit int) ([]TranscriptTurn, error) {
+	if limit <= 0 || limit > 5000 {
+		limit = 1000
+	}
+	var spans []Span
+	if err := g.db.Select("span_id, trace_id, start_time, attributes").
+		Where("conversation_id = ?", conversationID).
+		Or("attributes LIKE ? ESCAPE '\\'", conversationAttrPattern(conversationID)).
+		Order("start_time ASC, seq ASC, span_id ASC").
+		Limit(limit).
+		Find(&spans).Error; err != nil {
+		return nil, err
+	}
+	turns := make([]TranscriptTurn, 0, len(spans))
+	bySpan := make(map[string]int)
+	// fromSpan marks the turns whose text the span carried itself; log events only fill the rest
+	var fromSpan []bool
+	for _, sp := range spans {
+		var attrs map[string]any
+		if err := json.Unmarshal([]byte(sp.Attributes), &attrs); err != nil {
+			continue
+		}
+		prompt, response := transcriptText(attrs)
+		bySpan[sp.SpanID] = len(turns)
+		fromSpan = append(fromSpan, prompt != "" || response != "")
+		turns = append(turns, TranscriptTurn{
+			SpanID:    sp.SpanID,
+			TraceID:   sp.TraceID,
+			StartTime: sp.StartTime,
+			Prompt:    prompt,
+			Response:  response,
+		})
+	}
+
+	var logs []LogRecord
+	if err := g.db.Select("span_id, trace_id, log_time, event_name, body, attributes").
+		Where("conversation_id = ? AND event_name IN ?", conversationID, transcriptEvents).
+		Order("log_time ASC, id ASC").
+		Limit(limit).
+		Find(&logs).Error; err != nil {
+		return nil, err
+	}
+	for _, lr := range logs {
+		prompt, response := logTranscriptText(lr)
+		if prompt == "" && response == "" {
+			continue
+		}
+		i, ok := bySpan[lr.SpanID]
+		if !ok || lr.SpanID == "" {
+			// the span was not stored (or the event was logged outside of one)
+			i = len(turns)
+			fromSpan = append(fromSpan, false)
+			turns = append(turns, TranscriptTurn{SpanID: lr.SpanID, TraceID: lr.TraceID, StartTime: lr.Timestamp})
+			if lr.SpanID != "" {
+				bySpan[lr.SpanID] = i
+			}
+		}
+		if fromSpan[i] {
+			continue
+		}
+		// calls log their whole input history, so the last user message is the turn's prompt
+		if prompt != "" {
+			turns[i].Prompt = prompt
+		}
+		if response != "" {
+			turns[i].Response = response
+		}
+	}
+
+	out := turns[:0]
+	for _, t := range turns {
+		if t.Prompt != "" || t.Response != "" {
+			out = append(out, t)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].StartTime.Before(out[j].StartTime) })
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out, nil
+}
+
+// transcriptEvents are the log events prompts and completions are taken from
+var transcriptEvents = []string{
+	"gen_ai.user.message", "gen_ai.choice",
+	"gen_ai.content.prompt", "gen_ai.content.completion",
+	"gen_ai.client.inference.operation.details",
+}
+
+// logTranscriptText returns the prompt or response carried by a gen_ai log event: message
+// events hold it in their body, the operation details event (v1.37+) in the same message
+// attributes as spans
+func logTranscriptText(lr LogRecord) (string, string) {
+	attrs := make(map[string]any)
+	if lr.Attributes != "" {
+		json.Unmarshal([]byte(lr.Attributes), &attrs)
+	}
+	switch lr.EventName {
+	case "gen_ai.user.message":
+		attrs["body"] = lr.Body
+		return genAIEventContent(attrs), ""
+	case "gen_ai.choice":
+		attrs["body"] = lr.Body
+		return "", genAIEventContent(attrs)
+	case "gen_ai.content.prompt":
+		s, _ := attrs["gen_ai.prompt"].(string)
+		return s, ""
+	case "gen_ai.content.completion":
+		s, _ := attrs["gen_ai.completion"].(string)
+		return "", s
+	}
+	augmentGenAIAttrs(attrs, nil)
+	return transcriptText(attrs)
+}
+
+// getConversationTranscriptHandler returns the reconstructed transcript of a conversation
+func getConversationTranscriptHandler(db Database, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimSpace(mux.Vars(r)["id"])
+		if id == "" {
+			http.Error(w, "missing id", http.StatusBadRequest)
+			return
+		}
+		turns, err := db.GetConversationTranscript(id, 1000)
+		if err != nil {
+			logger.Error("Failed to get conversation transcript: %v", err)
+			http.Error(w, fmt.Sprintf("Failed to get transcript: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(turns)
+	}
+}
+
+// searchConversationTranscriptsHandler searches prompt/response text across conversations
+func searchConversationTranscriptsHandler(db Database, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		search := strings.TrimSpace(q.Get("q"))
+		if search == "" {
+			http.Error(w, "missing q", http.StatusBadRequest)
+			return
+		}
+		limit := 20
+		if s := strings.TrimSpace(q.Get("limit")); s != "" {
+			if v, err := strconv.Atoi(s); err == nil && v > 0 {
+				limit = v
+			}
+		}
+		matches, err := db.SearchConversationTranscripts(search, limit)
+		if err != nil {
+			logger.Error("Failed to search conversation transcripts: %v", err)
+			http.Error(w, fmt.Sprintf("Failed to search conversations: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(matches)
+	}
+}
@@ -0,0 +1,228 @@
+package backend
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CostAttribution is the LLM usage of one user with one feature of a project in a period.
+// Calls without a user or feature are attributed to an empty one.
+type CostAttribution struct {
+	PeriodStart  time.Time `json:"period_start"`
+	ProjectID    string    `json:"project_id"`
+	UserID       string    `json:"user_id"`
+	Feature      string    `json:"feature"`
+	Calls        int64     `json:"calls"`
+	InputTokens  int64     `json:"input_tokens"`
+	OutputTokens int64     `json:"output_tokens"`
+	Cost         float64   `json:"cost_usd"`
+}
+
+// GetCostAttribution sums the tokens and cost of LLM calls per period (day, week or month),
+// project, user and feature. The feature is the first of featureKeys set on the call's span,
+// or else on another span of its trace (usually the root, where applications tag the feature
+// a request belongs to). The user is taken from the span's user attributes, else from its
+// conversation.
+func (g *GormDB) GetCostAttribution(filter StatsFilter, prices ModelPrices, featureKeys []string, period string) ([]CostAttribution, error) {
+	var rows []struct {
+		TraceID        string
+		ProjectID      string
+		ConversationID string
+		Model          string
+		InputTokens    int64
+		OutputTokens   int64
+		StartTime      time.Time
+		Attributes     string
+	}
+	if err := filter.apply(g.db.Model(&Span{})).
+		Select("trace_id, project_id, conversation_id, model, input_tokens, output_tokens, start_time, attributes").
+		Where("input_tokens > 0 OR output_tokens > 0").
+		Limit(200000).
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	features := make([]string, len(rows))
+	users := make([]string, len(rows))
+	var missingTraces []string
+	seenTrace := make(map[string]bool)
+	var convIDs []string
+	seenConv := make(map[string]bool)
+	for i, r := range rows {
+		var attrs map[string]any
+		json.Unmarshal([]byte(r.Attributes), &attrs)
+		features[i] = firstStringAttr(attrs, featureKeys)
+		users[i] = firstStringAttr(attrs, userIDKeys)
+		if features[i] == "" && !seenTrace[r.TraceID] {
+			seenTrace[r.TraceID] = true
+			missingTraces = append(missingTraces, r.TraceID)
+		}
+		if users[i] == "" && r.ConversationID != "" && !seenConv[r.ConversationID] {
+			seenConv[r.ConversationID] = true
+			convIDs = append(convIDs, r.ConversationID)
+		}
+	}
+	traceFeatures, err := g.traceFeatures(missingTraces, featureKeys)
+	if err != nil {
+		return nil, err
+	}
+	convUsers, err := g.conversationUsers(convIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make(map[string]*CostAttribution)
+	for i, r := range rows {
+		feature, user := features[i], users[i]
+		if feature == "" {
+			feature = traceFeatures[r.TraceID]
+		}
+		if user == "" {
+			user = convUsers[r.ConversationID]
+		}
+		start := periodStart(r.StartTime, period)
+		key := start.Format(time.RFC3339) + "\x00" + r.ProjectID + "\x00" + user + "\x00" + feature
+		a := groups[key]
+		if a == nil {
+			a = &CostAttribution{PeriodStart: start, ProjectID: r.ProjectID, UserID: user, Feature: feature}
+			groups[key] = a
+		}
+		a.Calls++
+		a.InputTokens += r.InputTokens
+		a.OutputTokens += r.OutputTokens
+		a.Cost += prices.Cost(r.Model, r.InputTokens, r.OutputTokens)
+	}
+	out := make([]CostAttribution, 0, len(groups))
+	for _, a := range groups {
+		out = append(out, *a)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		if !a.PeriodStart.Equal(b.PeriodStart) {
+			return a.PeriodStart.Before(b.PeriodStart)
+		}
+		if a.Cost != b.Cost {
+			return a.Cost > b.Cost
+		}
+		if a.InputTokens+a.OutputTokens != b.InputTokens+b.OutputTokens {
+			return a.InputTokens+a.OutputTokens > b.InputTokens+b.OutputTokens
+		}
+		return a.ProjectID+"\x00"+a.UserID+"\x00"+a.Feature < b.ProjectID+"\x00"+b.UserID+"\x00"+b.Feature
+	})
+	return out, nil
+}
+
+// conversationUsers returns the user of each of the conversations that has one
+func (g *GormDB) conversationUsers(convIDs []string) (map[string]string, error) {
+	out := make(map[string]string, len(convIDs))
+	for start := 0; start < len(convIDs); start += lookupChunk {
+		var chunk []Conversation
+		if err := g.db.Select("id, user_id").
+			Where("id IN ?", convIDs[start:min(start+lookupChunk, len(convIDs))]).
+			Find(&chunk).Error; err != nil {
+			return nil, err
+		}
+		for _, c := range chunk {
+			out[c.ID] = c.UserID
+		}
+	}
+	return out, nil
+}
+
+// traceFeatures returns the feature of each trace that has a span carrying one of featureKeys
+func (g *GormDB) traceFeatures(traceIDs, featureKeys []string) (map[string]string, error) {
+	out := make(map[string]string)
+	if len(traceIDs) == 0 || len(featureKeys) == 0 {
+		return out, nil
+	}
+	var like []string
+	var args []any
+	for _, k := range featureKeys {
+		quoted, _ := json.Marshal(k)
+		like = append(like, "attributes LIKE ?")
+		args = append(args, "%"+string(quoted)+"%")
+	}
+	for start := 0; start < len(traceIDs); start += lookupChunk {
+		var spans []Span
+		if err := g.db.Select("trace_id, parent_span_id, attributes").
+			Where("trace_id IN ?", traceIDs[start:min(start+lookupChunk, len(traceIDs))]).
+			Where(strings.Join(like, " OR "), args...).
+			Find(&spans).Error; err != nil {
+			return nil, err
+		}
+		for _, sp := range spans {
+			var attrs map[string]any
+			if json.Unmarshal([]byte(sp.Attributes), &attrs) != nil {
+				continue
+			}
+			// the root span's tag wins over those of other spans
+			if f := firstStringAttr(attrs, featureKeys); f != "" && (out[sp.TraceID] == "" || sp.ParentSpanID == "") {
+				out[sp.TraceID] = f
+			}
+		}
+	}
+	return out, nil
+}
+
+// getCostAttributionHandler returns LLM cost per period, project, user and feature as JSON or,
+// with format=csv, as a CSV download (period=day|week|month, window=30d by default)
+func getCostAttributionHandler(db Database, prices ModelPrices, featureKeys []string, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("window") == "" && q.Get("since") == "" {
+			q.Set("window", "30d")
+		}
+		filter, err := parseStatsFilter(q)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		period := strings.TrimSpace(q.Get("period"))
+		if period == "" {
+			period = "day"
+		}
+		if period != "day" && period != "week" && period != "month" {
+			http.Error(w, "period must be day, week or month", http.StatusBadRequest)
+			return
+		}
+		format := strings.TrimSpace(q.Get("format"))
+		if format != "" && format != "json" && format != "csv" {
+			http.Error(w, "format must be json or csv", http.StatusBadRequest)
+			return
+		}
+		rows, err := db.GetCostAttribution(filter, prices, featureKeys, period)
+		if err != nil {
+			logger.Error("Failed to get cost attribution: %v", err)
+			http.Error(w, fmt.Sprintf("Failed to get cost attribution: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if format != "csv" {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(rows)
+			return
+		}
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="cost-attribution.csv"`)
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"period_start", "project_id", "user_id", "feature", "calls", "input_tokens", "output_tokens", "cost_usd"})
+		for _, a := range rows {
+			cw.Write([]string{
+				a.PeriodStart.Format("2006-01-02"),
+				a.ProjectID,
+				a.UserID,
+				a.Feature,
+				strconv.FormatInt(a.Calls, 10),
+				strconv.FormatInt(a.InputTokens, 10),
+				strconv.FormatInt(a.OutputTokens, 10),
+				strconv.FormatFloat(a.Cost, 'f', 6, 64),
+			})
+		}
+		cw.Flush()
+	}
+}
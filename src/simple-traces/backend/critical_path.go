@@ -0,0 +1,152 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// CriticalSegment is a stretch of the critical path during which one span was what the
+// trace was waiting on
+type CriticalSegment struct {
+	SpanID     string    `json:"span_id"`
+	Name       string    `json:"name"`
+	Category   string    `json:"category,omitempty"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	DurationMS float64   `json:"duration_ms"`
+}
+
+// CriticalSpan sums the critical path time of one span
+type CriticalSpan struct {
+	SpanID     string  `json:"span_id"`
+	Name       string  `json:"name"`
+	Category   string  `json:"category,omitempty"`
+	CriticalMS float64 `json:"critical_ms"`
+	Share      float64 `json:"share"`
+}
+
+// CriticalPath is the chain of spans that determined the end-to-end latency of a trace
+type CriticalPath struct {
+	TraceID  string            `json:"trace_id"`
+	WallMS   float64           `json:"wall_ms"`
+	Segments []CriticalSegment `json:"segments"`
+	// Spans on the path, most critical time first
+	Spans []CriticalSpan `json:"spans"`
+}
+
+// computeCriticalPath walks back from the end of each span: the child that finished last is
+// what the parent waited for, so it is on the path; before that child started, the next child
+// that finished earlier takes over, and gaps are the parent's own time. Spans whose parent is
+// missing hang off a virtual root covering the whole trace.
+func computeCriticalPath(traceID string, spans []Span) CriticalPath {
+	cp := CriticalPath{TraceID: traceID, Segments: []CriticalSegment{}, Spans: []CriticalSpan{}}
+	if len(spans) == 0 {
+		return cp
+	}
+	byID := make(map[string]*Span, len(spans))
+	for i := range spans {
+		byID[spans[i].SpanID] = &spans[i]
+	}
+	root := &Span{Name: "(trace)", StartTime: spans[0].StartTime, EndTime: spans[0].EndTime}
+	children := make(map[*Span][]*Span)
+	for i := range spans {
+		sp := &spans[i]
+		parent := byID[sp.ParentSpanID]
+		if parent == nil || parent == sp {
+			parent = root
+		}
+		children[parent] = append(children[parent], sp)
+		if sp.StartTime.Before(root.StartTime) {
+			root.StartTime = sp.StartTime
+		}
+		if sp.EndTime.After(root.EndTime) {
+			root.EndTime = sp.EndTime
+		}
+	}
+	for _, c := range children {
+		sort.Slice(c, func(i, j int) bool { return c[i].EndTime.After(c[j].EndTime) })
+	}
+
+	var segments []CriticalSegment
+	add := func(sp *Span, start, end time.Time) {
+		if !end.After(start) || sp == root {
+			return
+		}
+		segments = append(segments, CriticalSegment{SpanID: sp.SpanID, Name: sp.Name, Category: sp.Category, Start: start, End: end})
+	}
+	var walk func(sp *Span, end time.Time, depth int)
+	walk = func(sp *Span, end time.Time, depth int) {
+		cursor := end
+		// guard against parent cycles in malformed traces
+		if depth < 1000 {
+			for _, c := range children[sp] {
+				if c.StartTime.After(cursor) || !c.EndTime.After(sp.StartTime) {
+					continue
+				}
+				childEnd := c.EndTime
+				if childEnd.After(cursor) {
+					childEnd = cursor
+				}
+				add(sp, childEnd, cursor)
+				walk(c, childEnd, depth+1)
+				cursor = c.StartTime
+				if !cursor.After(sp.StartTime) {
+					break
+				}
+			}
+		}
+		add(sp, sp.StartTime, cursor)
+	}
+	walk(root, root.EndTime, 0)
+
+	sort.Slice(segments, func(i, j int) bool { return segments[i].Start.Before(segments[j].Start) })
+	wall := root.EndTime.Sub(root.StartTime)
+	cp.WallMS = float64(wall.Microseconds()) / 1000
+	totals := make(map[string]*CriticalSpan)
+	var order []*CriticalSpan
+	for i := range segments {
+		s := &segments[i]
+		s.DurationMS = float64(s.End.Sub(s.Start).Microseconds()) / 1000
+		t := totals[s.SpanID]
+		if t == nil {
+			t = &CriticalSpan{SpanID: s.SpanID, Name: s.Name, Category: s.Category}
+			totals[s.SpanID] = t
+			order = append(order, t)
+		}
+		t.CriticalMS += s.DurationMS
+	}
+	for _, t := range order {
+		if cp.WallMS > 0 {
+			t.Share = t.CriticalMS / cp.WallMS
+		}
+		cp.Spans = append(cp.Spans, *t)
+	}
+	sort.SliceStable(cp.Spans, func(i, j int) bool { return cp.Spans[i].CriticalMS > cp.Spans[j].CriticalMS })
+	cp.Segments = segments
+	return cp
+}
+
+// getCriticalPathHandler returns the critical path of a trace group
+func getCriticalPathHandler(db Database, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		traceID := strings.TrimSpace(mux.Vars(r)["trace_id"])
+		spans, err := db.GetTraceGroupSpans(traceID, 5000)
+		if err != nil {
+			logger.Error("Failed to get spans of trace %s: %v", traceID, err)
+			http.Error(w, fmt.Sprintf("Failed to get critical path: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if len(spans) == 0 {
+			http.Error(w, "Trace group not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(computeCriticalPath(traceID, spans))
+	}
+}
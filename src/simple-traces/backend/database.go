@@ -2,6 +2,7 @@
 package backend
 
 import (
+	"database/sql/driver"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -12,23 +13,114 @@ import (
 	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"gorm.io/gorm/logger"
+	"gorm.io/plugin/dbresolver"
 )
 
 // GORM Models with proper tags
 type Span struct {
-	SpanID       string    `gorm:"primaryKey" json:"span_id"`
-	TraceID      string    `gorm:"index:idx_trace_id;index:idx_group_id" json:"trace_id"`
-	ProjectID    string    `gorm:"index" json:"project_id"`
-	ParentSpanID string    `json:"parent_span_id,omitempty"`
-	Name         string    `json:"name"`
-	StartTime    time.Time `gorm:"index:idx_start_time" json:"start_time"`
-	EndTime      time.Time `json:"end_time"`
-	DurationMS   int64     `json:"duration_ms"`
-	StatusCode   string    `json:"status_code"`
-	StatusDesc   string    `json:"status_description,omitempty"`
-	Attributes   string    `gorm:"type:text" json:"attributes,omitempty"`
-	Events       string    `gorm:"type:text" json:"events,omitempty"`
+	SpanID         string    `gorm:"primaryKey" json:"span_id"`
+	TraceID        string    `gorm:"index:idx_spans_trace_end,priority:1;index:idx_spans_trace_start,priority:1" json:"trace_id"`
+	ProjectID      string    `gorm:"index:idx_spans_project_start,priority:1" json:"project_id"`
+	ConversationID string    `gorm:"index:idx_spans_conversation_start,priority:1" json:"conversation_id,omitempty"`
+	RunID          string    `gorm:"index" json:"run_id,omitempty"`
+	ParentSpanID   string    `json:"parent_span_id,omitempty"`
+	Name           string    `json:"name"`
+	StartTime      time.Time `gorm:"index:idx_start_time;index:idx_spans_trace_start,priority:2;index:idx_spans_project_start,priority:2;index:idx_spans_conversation_start,priority:2;index:idx_spans_trace_end,priority:3" json:"start_time"`
+	EndTime        time.Time `gorm:"index:idx_spans_trace_end,priority:2" json:"end_time"`
+	DurationMS     int64     `json:"duration_ms"`
+	StatusCode     string    `gorm:"index:idx_spans_trace_end,priority:4" json:"status_code"`
+	StatusDesc     string    `json:"status_description,omitempty"`
+	Attributes     string    `gorm:"type:text" json:"attributes,omitempty"`
+	Events         string    `gorm:"type:text" json:"events,omitempty"`
+
+	// Seq increases in the order spans are received, see assignSpanSeq; it orders spans with
+	// identical start times
+	Seq int64 `gorm:"default:0" json:"seq,omitempty"`
+
+	// Derived at ingest for filtering and stats
+	Model           string `gorm:"index" json:"model,omitempty"`
+	ViolationType   string `gorm:"index" json:"violation_type,omitempty"`
+	ViolationReason string `json:"violation_reason,omitempty"`
+	FinishReason    string `gorm:"index" json:"finish_reason,omitempty"`
+	Category        string `gorm:"index" json:"category,omitempty"`
+	RetrievalStore  string `gorm:"index" json:"retrieval_store,omitempty"`
+	RetrievalCount  int64  `gorm:"default:0" json:"retrieval_count,omitempty"`
+
+	// Request attributes of http spans, see extractHTTPAttrs
+	HTTPMethod     string `gorm:"index:idx_spans_http_route,priority:2" json:"http_method,omitempty"`
+	HTTPRoute      string `gorm:"index:idx_spans_http_route,priority:1" json:"http_route,omitempty"`
+	HTTPStatusCode int64  `gorm:"default:0;index" json:"http_status_code,omitempty"`
+
+	// Normalized statement of spans with db.statement, see fingerprintStatement
+	DBOperation     string `json:"db_operation,omitempty"`
+	DBFingerprint   string `gorm:"type:text" json:"db_fingerprint,omitempty"`
+	DBFingerprintID string `gorm:"index" json:"db_fingerprint_id,omitempty"`
+
+	// Structured output validation of LLM spans (valid, invalid or invalid_json), see ResponseSchemas
+	SchemaStatus string `gorm:"index" json:"schema_status,omitempty"`
+	SchemaErrors string `gorm:"type:text" json:"schema_errors,omitempty"`
+
+	// Hashes of the input and response of LLM spans, see promptHashes; calls with the same
+	// prompt hash in a project are repeats
+	PromptHash   string `gorm:"index" json:"prompt_hash,omitempty"`
+	ResponseHash string `json:"response_hash,omitempty"`
+
+	// Estimated prompt tokens per message role, see computePromptBreakdown
+	PromptTokensSystem  int64 `gorm:"default:0" json:"prompt_tokens_system,omitempty"`
+	PromptTokensUser    int64 `gorm:"default:0" json:"prompt_tokens_user,omitempty"`
+	PromptTokensHistory int64 `gorm:"default:0" json:"prompt_tokens_history,omitempty"`
+	PromptTokensTool    int64 `gorm:"default:0" json:"prompt_tokens_tool,omitempty"`
+
+	// Token usage reported by the provider
+	InputTokens  int64 `gorm:"default:0" json:"input_tokens,omitempty"`
+	OutputTokens int64 `gorm:"default:0" json:"output_tokens,omitempty"`
+
+	// Milliseconds between the span's end and its arrival, see ingestLag; nil for spans stored
+	// before it was recorded
+	IngestLagMS *int64 `json:"ingest_lag_ms,omitempty"`
+
+	// ColdStart marks LLM calls made after their model was idle, see ColdStartDetector
+	ColdStart bool `gorm:"index;default:false" json:"cold_start,omitempty"`
+
+	// Reviewer annotations (user.*), stored in span_annotations
+	Annotations map[string]string `gorm:"-" json:"annotations,omitempty"`
+	// Derived metric values computed at ingest, stored in span_metric_values
+	DerivedMetrics map[string]float64 `gorm:"-" json:"-"`
+}
+
+// SpanFilter narrows span listings; zero values mean "no restriction"
+type SpanFilter struct {
+	SpanID         string
+	ProjectID      string
+	ConversationID string
+	// RunID keeps spans of traces that belong to the run, see runTraceIDs
+	RunID string
+	Model string
+	// Violation filters on guardrail violations: nil = any, true = only violations, false = none
+	Violation *bool
+	// ColdStart filters on cold start LLM calls: nil = any, true = only cold starts, false = none
+	ColdStart     *bool
+	ViolationType string
+	FinishReason  string
+	Category      string
+	HTTPMethod    string
+	HTTPRoute     string
+	// DBFingerprintID keeps executions of one fingerprinted query
+	DBFingerprintID string
+	Annotations     []AnnotationFilter
+	Attributes      []AttributeFilter
+	// SchemaStatus keeps LLM spans with this structured output validation result
+	SchemaStatus string
+	// StatusCode matches the span status (OK, ERROR or UNSET)
+	StatusCode string
+	// PromptHash keeps LLM calls with one prompt, see GetDuplicatePrompts
+	PromptHash string
+	// Spans starting in [Since, Until) and lasting at least MinDurationMS
+	Since         time.Time
+	Until         time.Time
+	MinDurationMS int64
 }
 
 type Conversation struct {
@@ -37,6 +129,16 @@ type Conversation struct {
 	UserID         string    `gorm:"index" json:"user_id,omitempty"`
 	FirstStartTime time.Time `json:"first_start_time"`
 	LastEndTime    time.Time `gorm:"index" json:"last_end_time"`
+	// Optional LLM-generated fields, see Summarizer
+	Summary      string     `gorm:"type:text" json:"summary,omitempty"`
+	Sentiment    string     `gorm:"index" json:"sentiment,omitempty"`
+	SummarizedAt *time.Time `json:"summarized_at,omitempty"`
+	// Failed summary attempts since the last summary; the next one waits until SummaryRetryAt
+	SummaryFailures int        `gorm:"default:0" json:"-"`
+	SummaryRetryAt  *time.Time `json:"-"`
+
+	// Comparison with recent conversations, only when listed with ?baseline=true
+	Baseline *ConversationBaseline `gorm:"-" json:"baseline,omitempty"`
 }
 
 type Project struct {
@@ -52,6 +154,28 @@ type TraceGroup struct {
 	FirstStartTime time.Time `json:"first_start_time"`
 	LastEndTime    time.Time `json:"last_end_time"`
 	SpanCount      int       `json:"span_count"`
+	ErrorCount     int       `json:"error_count"`
+	// IsComplete is set once the root span has arrived and no span arrived for the quiet
+	// period, see traceComplete
+	IsComplete bool `json:"is_complete"`
+	// Entry point: name and attributes of the root span, once it has arrived
+	RootName       string `json:"root_name,omitempty"`
+	RootAttributes string `json:"root_attributes,omitempty"`
+
+	// Triage state, empty until someone triages the group
+	Status   string `json:"status,omitempty"`
+	Assignee string `json:"assignee,omitempty"`
+	IssueURL string `json:"issue_url,omitempty"`
+
+	// Share of wall time spent in LLM calls, tools, databases and everything else
+	LatencyBreakdown *LatencyBreakdown `json:"latency_breakdown,omitempty"`
+
+	// Set when the group's LLM and tool steps repeat back to back, see detectLoop
+	LoopSuspected bool           `json:"loop_suspected"`
+	Loop          *LoopSuspicion `json:"loop,omitempty"`
+
+	// Composite health score, once the HealthScorer has scored the trace
+	Health *TraceHealth `json:"health,omitempty"`
 }
 
 type ConversationUpdate struct {
@@ -65,27 +189,108 @@ type ConversationUpdate struct {
 // GormDB implements the Database interface using GORM
 type GormDB struct {
 	db *gorm.DB
+	// completeAfter is the quiet period after which a trace with a root span is complete
+	completeAfter time.Duration
 }
 
 // Database interface
 type Database interface {
 	BatchInsertSpans(spans []Span) error
 	GetSpans(limit int, before time.Time) ([]Span, error)
+	GetSpansFiltered(limit int, before time.Time, filter SpanFilter) ([]Span, error)
+	GetSpanMetrics(filter SpanFilter) (SpanMetrics, error)
+	GetRuns(limit int, before time.Time, projectID string) ([]TraceRun, error)
+	GetRun(runID string) (*TraceRun, error)
+	UpdateSpanAnnotations(spanID string, changes map[string]any) (map[string]string, error)
+	SetAttributeOverrides(traceID, spanID string, changes map[string]any, reason string) ([]AttributeOverride, error)
+	GetAttributeOverrides(traceID string, limit int) ([]AttributeOverride, error)
+
+	CreateComment(c *Comment) error
+	GetComments(traceID, spanID string) ([]Comment, error)
+	UpdateComment(id uint, body string) (*Comment, error)
+	DeleteComment(id uint) (int64, error)
+	InsertDeadLetter(d *DeadLetter) error
+	GetDeadLetters(kind string, pending bool, limit int) ([]DeadLetter, error)
+	GetDeadLetter(id uint) (*DeadLetter, error)
+	RecordDeadLetterReplay(id uint, replayErr error) error
+	DeleteDeadLetter(id uint) (int64, error)
 	DeleteSpansByTraceID(traceID string) (int64, error)
+	CountSpansByTraceIDs(traceIDs []string) (map[string]int64, error)
+	GetSeenSpans(keys []string, since time.Time) (map[string]time.Time, error)
+	RecordSeenSpans(keys []string, at time.Time) error
+	PruneSeenSpans(before time.Time) (int64, error)
 	DeleteSpansByGroupID(groupID string) (int64, error)
 
 	GetTraceGroups(limit int, before time.Time) ([]TraceGroup, error)
 	GetTraceGroupSpans(traceID string, limit int) ([]Span, error)
 	GetTraceGroupsWithSearch(limit int, before time.Time, search string) ([]TraceGroup, error)
+	GetTraceGroupsFiltered(limit int, before time.Time, filter TraceGroupFilter) ([]TraceGroup, error)
+	GetSlowestTraces(filter StatsFilter, limit int) ([]SlowTrace, error)
+	GetTracesNeedingHealthScore(idleBefore time.Time, limit int) ([]string, error)
+	ScoreTraceHealth(traceID string) (*TraceHealth, error)
+	GetStructuredOutputStats(filter StatsFilter) ([]StructuredOutputStats, error)
+	InsertMetricValues(values []SpanMetricValue) error
+	GetDerivedMetricStats(filter StatsFilter, name string) ([]DerivedMetricStats, error)
+	GetConversationBaselines(conversationIDs []string, prices ModelPrices) (map[string]*ConversationBaseline, error)
+	GetTraceGroupVersion(traceID string) (TraceGroupVersion, error)
+	UpdateTraceTriage(traceID string, u TriageUpdate) (*TraceTriage, error)
+	SetTraceIssueURL(traceID, issueURL string) error
+
+	GetNotificationPreferences() ([]NotificationPreference, error)
+	GetNotificationPreference(userID string) (*NotificationPreference, error)
+	SaveNotificationPreference(p *NotificationPreference) error
+	DeleteNotificationPreference(userID string) (int64, error)
 	GetTraceGroupSpansWithSearch(traceID string, limit int, search string) ([]Span, error)
+	GetTraceGroupSpansFiltered(traceID string, limit int, filter TraceSpanFilter) ([]Span, error)
 
 	BatchUpsertConversations(updates []ConversationUpdate) error
 	GetConversations(limit int, before time.Time) ([]Conversation, error)
 	GetConversationsWithSearch(limit int, before time.Time, search string) ([]Conversation, error)
+	GetConversationsFiltered(limit int, before time.Time, filter ConversationFilter) ([]Conversation, error)
 	PropagateConversationID(traceID, conversationID string) (int64, error)
+	PropagateConversationIDs(byTrace map[string]string) (int64, error)
 	DeleteSpansByConversationID(conversationID string) (int64, error)
+	MergeConversations(source, target string) (ConversationMove, error)
+	SplitConversation(id, newID string, since, until time.Time) (ConversationMove, error)
+	AssignTraceConversation(traceID, conversationID, userID string) (TraceAssignment, error)
 	DeleteConversationRow(conversationID string) (int64, error)
 	LookupConversationIDByTraceID(traceID string) (string, error)
+	SearchConversationTranscripts(search string, limit int) ([]ConversationMatch, error)
+	GetConversationTranscript(conversationID string, limit int) ([]TranscriptTurn, error)
+	GetConversationsNeedingSummary(idleBefore time.Time, limit int) ([]Conversation, error)
+	UpdateConversationSummary(conversationID, summary, sentiment string) error
+	RecordSummaryFailure(conversationID string, retryAt time.Time) error
+
+	GetGuardrailStats(filter StatsFilter) ([]GuardrailStats, error)
+	GetFinishReasonStats(filter StatsFilter) ([]FinishReasonStats, error)
+	GetPromptBreakdownStats(filter StatsFilter) ([]PromptBreakdownStats, error)
+
+	InsertRetrievedDocuments(docs []RetrievedDocument) error
+	GetRetrievalStats(filter StatsFilter) ([]RetrievalStats, error)
+	GetHTTPStats(filter StatsFilter) ([]HTTPRouteStats, error)
+	GetIngestLagStats(filter StatsFilter) (IngestLagStats, error)
+	GetEntryPointStats(filter StatsFilter) ([]EntryPointStats, error)
+	GetDBStats(filter StatsFilter, sortBy string, limit int) ([]DBQueryStats, error)
+	GetLatencyHeatmap(q HeatmapQuery) (LatencyHeatmap, error)
+	GetEngagement(filter StatsFilter, period string) (Engagement, error)
+	GetCostAttribution(filter StatsFilter, prices ModelPrices, featureKeys []string, period string) ([]CostAttribution, error)
+	GetTopUsers(filter StatsFilter, prices ModelPrices, sortBy string, limit int) ([]TopUser, error)
+	GetColdStartStats(filter StatsFilter) ([]ColdStartStats, error)
+	GetConversationLatency(conversationID string) (*ConversationLatency, error)
+	GetDuplicatePrompts(filter StatsFilter, prices ModelPrices, minCount int64, sortBy string, limit int) ([]DuplicatePrompt, error)
+	GetConversationLLMSpans(conversationID string) ([]Span, error)
+	GetConversationPeakTokens(filter StatsFilter) ([]Span, error)
+	RecordAttributeSizes(sizes []AttributeSize) error
+	GetStorageStats(filter StatsFilter, groupBy string, limit int) ([]StorageStats, error)
+	GetRetrievedDocuments(conversationID, traceID string) ([]RetrievedDocument, error)
+
+	InsertLogRecords(records []LogRecord) error
+	GetLogRecords(limit int, before time.Time, filter LogFilter) ([]LogRecord, error)
+	InsertMetricPoints(points []MetricPoint) error
+	GetMetricPoints(filter StatsFilter, name string, limit int) ([]MetricPoint, error)
+
+	UpsertEmbeddings(rows []SpanEmbedding) error
+	GetEmbeddings(model string, limit int) ([]SpanEmbedding, error)
 
 	BackfillDerived(limit int) (int, int, error)
 
@@ -94,60 +299,124 @@ type Database interface {
 	CreateProject(id, name string) error
 	EnsureDefaultProject() error
 
+	GetStorageUsage() (*StorageUsage, error)
+	Vacuum(mode string) (*VacuumResult, error)
+	ExplainQueries() ([]QueryPlan, error)
+
 	Close() error
 }
 
-// InitDatabase initializes the database connection using GORM
-func InitDatabase(config *Config) (Database, error) {
-	var gormDB *gorm.DB
-	var err error
+// storedModels lists the models stored in the database
+func storedModels() []any {
+	return []any{
+		&Span{},
+		&Conversation{},
+		&Project{},
+		&SpanEmbedding{},
+		&RetrievedDocument{},
+		&SpanAnnotation{},
+		&Comment{},
+		&TraceTriage{},
+		&TraceHealth{},
+		&NotificationPreference{},
+		&AttributeSize{},
+		&SpanMetricValue{},
+		&LogRecord{},
+		&MetricPoint{},
+		&AttributeOverride{},
+		&DeadLetter{},
+		&SeenSpan{},
+	}
+}
 
+// openGorm connects to a postgres DSN or a SQLite file with the configured GORM log level
+func openGorm(dbType, dsn, logLevel string) (*gorm.DB, error) {
 	// Configure GORM logger
 	gormLogger := logger.Default.LogMode(logger.Silent)
-	if config.LogLevel == "DEBUG" {
+	if logLevel == "DEBUG" {
 		gormLogger = logger.Default.LogMode(logger.Info)
 	}
-
-	if config.DBType == "postgres" {
-		gormDB, err = gorm.Open(postgres.Open(config.DBConnection), &gorm.Config{
-			Logger: gormLogger,
-		})
-	} else {
-		// SQLite - ensure directory exists
-		if config.DBConnection != ":memory:" {
-			dir := filepath.Dir(config.DBConnection)
-			if err := os.MkdirAll(dir, 0755); err != nil {
-				return nil, fmt.Errorf("failed to create database directory: %w", err)
-			}
-		}
-		gormDB, err = gorm.Open(sqlite.Open(config.DBConnection), &gorm.Config{
+	if dbType == "postgres" {
+		return gorm.Open(postgres.Open(dsn), &gorm.Config{
 			Logger: gormLogger,
 		})
 	}
+	return gorm.Open(sqlite.Open(dsn), &gorm.Config{
+		Logger: gormLogger,
+	})
+}
 
+// InitDatabase initializes the database connection using GORM
+func InitDatabase(config *Config) (Database, error) {
+	// SQLite - ensure directory exists
+	if config.DBType != "postgres" && config.DBConnection != ":memory:" {
+		dir := filepath.Dir(config.DBConnection)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create database directory: %w", err)
+		}
+	}
+	gormDB, err := openGorm(config.DBType, config.DBConnection, config.LogLevel)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect database: %w", err)
 	}
+	if config.DBConnection == ":memory:" {
+		// every connection would otherwise open its own empty in-memory database
+		if sqlDB, err := gormDB.DB(); err == nil {
+			sqlDB.SetMaxOpenConns(1)
+		}
+	}
+	if config.DBType != "postgres" {
+		if err := prepareSQLite(gormDB); err != nil {
+			return nil, fmt.Errorf("failed to prepare sqlite database: %w", err)
+		}
+	}
 
-	// Auto-migrate all models
-	if err := gormDB.AutoMigrate(
-		&Span{},
-		&Conversation{},
-		&Project{},
-	); err != nil {
-		return nil, fmt.Errorf("failed to migrate database: %w", err)
+	migrate, err := checkSchemaVersion(gormDB, config.DBAutoMigrate)
+	if err != nil {
+		return nil, err
+	}
+	if migrate {
+		// Auto-migrate all models
+		if err := gormDB.AutoMigrate(storedModels()...); err != nil {
+			return nil, fmt.Errorf("failed to migrate database: %w", err)
+		}
+		dropRedundantIndexes(gormDB)
+		if err := createTranscriptIndex(gormDB); err != nil {
+			return nil, fmt.Errorf("failed to create transcript index: %w", err)
+		}
+		if err := recordSchemaVersion(gormDB); err != nil {
+			return nil, fmt.Errorf("failed to record schema version: %w", err)
+		}
 	}
 
-	db := &GormDB{db: gormDB}
+	db := &GormDB{db: gormDB, completeAfter: config.TraceCompleteAfter}
 
 	// Ensure default project exists
 	if err := db.EnsureDefaultProject(); err != nil {
 		return nil, fmt.Errorf("failed to ensure default project: %w", err)
 	}
 
+	if config.DBReadReplica != "" {
+		if config.DBType != "postgres" {
+			return nil, fmt.Errorf("DB_READ_REPLICA requires DB_TYPE=postgres")
+		}
+		// registered after migrating so schema checks see the primary
+		if err := gormDB.Use(dbresolver.Register(dbresolver.Config{
+			Replicas: []gorm.Dialector{postgres.Open(config.DBReadReplica)},
+		})); err != nil {
+			return nil, fmt.Errorf("failed to configure read replica: %w", err)
+		}
+	}
+
 	return db, nil
 }
 
+// primary forces a query to the primary when a read replica is configured, for reads that
+// must see rows written a moment ago
+func (g *GormDB) primary() *gorm.DB {
+	return g.db.Clauses(dbresolver.Write)
+}
+
 // Close closes the database connection
 func (g *GormDB) Close() error {
 	sqlDB, err := g.db.DB()
@@ -162,10 +431,15 @@ func (g *GormDB) BatchInsertSpans(spans []Span) error {
 	if len(spans) == 0 {
 		return nil
 	}
-	return g.db.CreateInBatches(spans, 100).Error
+	// spans resent after the dedup window expired are ignored instead of failing the batch
+	return g.db.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(spans, g.batchRows(&Span{})).Error
 }
 
 func (g *GormDB) GetSpans(limit int, before time.Time) ([]Span, error) {
+	return g.GetSpansFiltered(limit, before, SpanFilter{})
+}
+
+func (g *GormDB) GetSpansFiltered(limit int, before time.Time, filter SpanFilter) ([]Span, error) {
 	if limit <= 0 || limit > 5000 {
 		limit = 1000
 	}
@@ -176,48 +450,270 @@ func (g *GormDB) GetSpans(limit int, before time.Time) ([]Span, error) {
 	if !before.IsZero() {
 		query = query.Where("start_time < ?", before)
 	}
+	query = g.applySpanFilter(query, filter)
 
 	if err := query.Find(&spans).Error; err != nil {
 		return nil, err
 	}
+	g.attachAnnotations(spans)
+	g.attachOverrides(spans)
 
 	return spans, nil
 }
 
+// applySpanFilter adds the filter conditions to a query over the spans table
+func (g *GormDB) applySpanFilter(query *gorm.DB, filter SpanFilter) *gorm.DB {
+	if filter.SpanID != "" {
+		query = query.Where("span_id = ?", filter.SpanID)
+	}
+	if filter.ProjectID != "" {
+		query = query.Where("project_id = ?", filter.ProjectID)
+	}
+	if filter.ConversationID != "" {
+		query = query.Where("conversation_id = ?", filter.ConversationID)
+	}
+	if filter.RunID != "" {
+		query = query.Where("trace_id IN (?)", g.runTraceIDs(filter.RunID))
+	}
+	if filter.Model != "" {
+		query = query.Where("model = ?", filter.Model)
+	}
+	if filter.Violation != nil {
+		if *filter.Violation {
+			query = query.Where("violation_type <> ''")
+		} else {
+			query = query.Where("violation_type = '' OR violation_type IS NULL")
+		}
+	}
+	if filter.ColdStart != nil {
+		query = query.Where("cold_start = ?", *filter.ColdStart)
+	}
+	if filter.ViolationType != "" {
+		query = query.Where("violation_type = ?", filter.ViolationType)
+	}
+	if filter.Category != "" {
+		query = query.Where("category = ?", filter.Category)
+	}
+	if filter.HTTPRoute != "" {
+		query = query.Where("http_route = ?", filter.HTTPRoute)
+	}
+	if filter.HTTPMethod != "" {
+		query = query.Where("http_method = ?", filter.HTTPMethod)
+	}
+	if filter.DBFingerprintID != "" {
+		query = query.Where("db_fingerprint_id = ?", filter.DBFingerprintID)
+	}
+	if filter.PromptHash != "" {
+		query = query.Where("prompt_hash = ?", filter.PromptHash)
+	}
+	if filter.SchemaStatus != "" {
+		query = query.Where("schema_status = ?", filter.SchemaStatus)
+	}
+	if filter.FinishReason != "" {
+		query = query.Where("finish_reason = ? OR finish_reason LIKE ?", filter.FinishReason, "%"+filter.FinishReason+"%")
+	}
+	if filter.StatusCode != "" {
+		query = query.Where("status_code = ?", filter.StatusCode)
+	}
+	if !filter.Since.IsZero() {
+		query = query.Where("start_time >= ?", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		query = query.Where("start_time < ?", filter.Until)
+	}
+	if filter.MinDurationMS > 0 {
+		query = query.Where("duration_ms >= ?", filter.MinDurationMS)
+	}
+	query = applyAttributeFilters(query, filter.Attributes)
+	return g.applyAnnotationFilters(query, filter.Annotations)
+}
+
+// AttributeFilter matches spans whose attributes contain Key with Value
+type AttributeFilter struct {
+	Key   string
+	Value string
+}
+
+// parseAttributeFilter reads "key:value"; the value may itself contain colons
+func parseAttributeFilter(s string) AttributeFilter {
+	key, value, _ := strings.Cut(strings.TrimSpace(s), ":")
+	return AttributeFilter{Key: strings.TrimSpace(key), Value: value}
+}
+
+// likeEscaper escapes LIKE wildcards; patterns are used with ESCAPE '\'
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// conversationAttrPattern matches the attributes JSON of spans with the
+// simpleTraces.conversation.id attribute set to id, for LIKE ... ESCAPE '\'
+func conversationAttrPattern(id string) string {
+	value, _ := json.Marshal(id)
+	return "%" + likeEscaper.Replace(`"simpleTraces.conversation.id":`+string(value)) + "%"
+}
+
+// applyAttributeFilters matches on the stored attributes JSON, which is marshalled compactly, so
+// "key":"value" appears verbatim. Values that look like numbers or booleans also match unquoted.
+func applyAttributeFilters(query *gorm.DB, filters []AttributeFilter) *gorm.DB {
+	for _, f := range filters {
+		key, _ := json.Marshal(f.Key)
+		value, _ := json.Marshal(f.Value)
+		cond := "attributes LIKE ? ESCAPE '\\'"
+		args := []any{"%" + likeEscaper.Replace(string(key)+":"+string(value)) + "%"}
+		var raw any
+		if json.Unmarshal([]byte(f.Value), &raw) == nil {
+			switch raw.(type) {
+			case float64, bool:
+				cond += " OR attributes LIKE ? ESCAPE '\\'"
+				args = append(args, "%"+likeEscaper.Replace(string(key)+":"+f.Value)+"%")
+			}
+		}
+		query = query.Where(cond, args...)
+	}
+	return query
+}
+
 func (g *GormDB) DeleteSpansByTraceID(traceID string) (int64, error) {
 	result := g.db.Where("trace_id = ?", traceID).Delete(&Span{})
+	if result.Error == nil {
+		g.deleteDerivedByTraceIDs([]string{traceID})
+	}
 	return result.RowsAffected, result.Error
 }
 
 func (g *GormDB) DeleteSpansByGroupID(groupID string) (int64, error) {
 	// For SQLite, group_id is trace_id or attribute simpleTraces.conversation.id
 	result := g.db.Where("trace_id = ?", groupID).Delete(&Span{})
+	if result.Error == nil {
+		g.deleteDerivedByTraceIDs([]string{groupID})
+	}
 	return result.RowsAffected, result.Error
 }
 
+// deleteDerivedByTraceIDs best-effort removes rows derived from the spans of the given traces
+func (g *GormDB) deleteDerivedByTraceIDs(traceIDs []string) {
+	if len(traceIDs) == 0 {
+		return
+	}
+	g.db.Where("trace_id IN ?", traceIDs).Delete(&RetrievedDocument{})
+	g.db.Where("trace_id IN ?", traceIDs).Delete(&SpanEmbedding{})
+	g.db.Where("trace_id IN ?", traceIDs).Delete(&SpanAnnotation{})
+	g.db.Where("trace_id IN ?", traceIDs).Delete(&Comment{})
+	g.db.Where("trace_id IN ?", traceIDs).Delete(&TraceTriage{})
+	g.db.Where("trace_id IN ?", traceIDs).Delete(&TraceHealth{})
+	g.db.Where("trace_id IN ?", traceIDs).Delete(&SpanMetricValue{})
+	g.db.Where("trace_id IN ?", traceIDs).Delete(&LogRecord{})
+}
+
+// dbTime scans timestamps returned by aggregates: SQLite loses the column type on
+// MIN/MAX and hands back the stored text instead of a time.Time
+type dbTime struct {
+	time.Time
+}
+
+var dbTimeLayouts = []string{
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02T15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05",
+	time.RFC3339Nano,
+}
+
+func (t *dbTime) Scan(v any) error {
+	switch x := v.(type) {
+	case nil:
+		t.Time = time.Time{}
+		return nil
+	case time.Time:
+		t.Time = x
+		return nil
+	case []byte:
+		return t.parse(string(x))
+	case string:
+		return t.parse(x)
+	}
+	return fmt.Errorf("cannot scan %T into time", v)
+}
+
+func (t dbTime) Value() (driver.Value, error) {
+	return t.Time, nil
+}
+
+func (t *dbTime) parse(s string) error {
+	s = strings.TrimSuffix(strings.TrimSpace(s), "Z")
+	for _, layout := range dbTimeLayouts {
+		if parsed, err := time.Parse(layout, s); err == nil {
+			t.Time = parsed
+			return nil
+		}
+	}
+	return fmt.Errorf("cannot parse time %q", s)
+}
+
 // TraceGroup operations
 func (g *GormDB) GetTraceGroups(limit int, before time.Time) ([]TraceGroup, error) {
+	return g.GetTraceGroupsFiltered(limit, before, TraceGroupFilter{})
+}
+
+func (g *GormDB) GetTraceGroupsFiltered(limit int, before time.Time, filter TraceGroupFilter) ([]TraceGroup, error) {
 	if limit <= 0 || limit > 1000 {
 		limit = 100
 	}
 
 	type groupResult struct {
 		TraceID        string
-		FirstStartTime time.Time
-		LastEndTime    time.Time
+		FirstStartTime dbTime
+		LastEndTime    dbTime
 		SpanCount      int
+		ErrorCount     int
+		RootCount      int
+		LastSeq        int64
 	}
 
 	var results []groupResult
 	query := g.db.Model(&Span{}).
-		Select("trace_id, MIN(start_time) as first_start_time, MAX(end_time) as last_end_time, COUNT(*) as span_count").
+		Select("trace_id, MIN(start_time) as first_start_time, MAX(end_time) as last_end_time, COUNT(*) as span_count, " +
+			"SUM(CASE WHEN status_code = 'ERROR' THEN 1 ELSE 0 END) as error_count, " +
+			rootCountSQL + " as root_count, MAX(seq) as last_seq").
 		Group("trace_id").
-		Order("MAX(end_time) DESC").
 		Limit(limit)
 
+	if filter.TraceID != "" {
+		query = query.Where("trace_id = ?", filter.TraceID)
+	}
+	if filter.ProjectID != "" {
+		query = query.Where("project_id = ?", filter.ProjectID)
+	}
+	if filter.ConversationID != "" {
+		query = query.Where("conversation_id = ?", filter.ConversationID)
+	}
+	if filter.RunID != "" {
+		query = query.Where("trace_id IN (?)", g.runTraceIDs(filter.RunID))
+	}
+	if search := strings.TrimSpace(filter.Search); search != "" {
+		pattern := "%" + strings.ToLower(search) + "%"
+		query = query.Where("LOWER(name) LIKE ? OR LOWER(span_id) LIKE ? OR LOWER(status_code) LIKE ? OR LOWER(status_desc) LIKE ? OR LOWER(attributes) LIKE ? OR LOWER(events) LIKE ?",
+			pattern, pattern, pattern, pattern, pattern, pattern)
+	}
+	if filter.EntryPoint != "" {
+		query = query.Where("trace_id IN (?)", g.db.Model(&Span{}).Select("trace_id").Where(rootSpanSQL+" AND name = ?", filter.EntryPoint))
+	}
 	if !before.IsZero() {
 		query = query.Having("MAX(end_time) < ?", before)
 	}
+	if filter.Complete != nil {
+		cutoff := time.Now().Add(-g.completeAfter).UnixMicro()
+		if *filter.Complete {
+			query = query.Having(rootCountSQL+" > 0 AND MAX(seq) < ?", cutoff)
+		} else {
+			query = query.Having("("+rootCountSQL+" = 0 OR MAX(seq) >= ?)", cutoff)
+		}
+	}
+	query = g.applyTriageFilter(query, filter)
+	if filter.Sort == TraceGroupSortHealth {
+		// unscored groups go last
+		query = query.Order("COALESCE((SELECT score FROM trace_healths WHERE trace_healths.trace_id = spans.trace_id), 101) ASC")
+	}
+	query = query.Order("MAX(end_time) DESC")
 
 	if err := query.Scan(&results).Error; err != nil {
 		return nil, err
@@ -227,93 +723,116 @@ func (g *GormDB) GetTraceGroups(limit int, before time.Time) ([]TraceGroup, erro
 	for i, r := range results {
 		groups[i] = TraceGroup{
 			TraceID:        r.TraceID,
-			FirstStartTime: r.FirstStartTime,
-			LastEndTime:    r.LastEndTime,
+			FirstStartTime: r.FirstStartTime.Time,
+			LastEndTime:    r.LastEndTime.Time,
 			SpanCount:      r.SpanCount,
+			ErrorCount:     r.ErrorCount,
+			IsComplete:     g.traceComplete(r.RootCount, r.LastSeq),
 		}
 	}
+	g.attachRootSpans(groups)
+	g.attachTriage(groups)
+	g.attachLatencyBreakdown(groups)
+	g.attachLoopDetection(groups)
+	g.attachHealth(groups)
 
 	return groups, nil
 }
 
 func (g *GormDB) GetTraceGroupSpans(traceID string, limit int) ([]Span, error) {
+	return g.GetTraceGroupSpansFiltered(traceID, limit, TraceSpanFilter{})
+}
+
+// TraceSpanFilter narrows the spans of one trace group
+type TraceSpanFilter struct {
+	Search string
+	// After returns only spans received after this sequence number (see Span.Seq), in receive
+	// order, so clients can fetch only the spans that arrived since their last poll
+	After *int64
+}
+
+func (g *GormDB) GetTraceGroupSpansFiltered(traceID string, limit int, filter TraceSpanFilter) ([]Span, error) {
 	if limit <= 0 || limit > 5000 {
 		limit = 1000
 	}
 
 	var spans []Span
-	if err := g.db.Where("trace_id = ?", traceID).
-		Order("start_time ASC, span_id ASC").
-		Limit(limit).
-		Find(&spans).Error; err != nil {
+	query := g.db.Where("trace_id = ?", traceID).Limit(limit)
+	if search := strings.TrimSpace(filter.Search); search != "" {
+		pattern := "%" + strings.ToLower(search) + "%"
+		query = query.Where("LOWER(name) LIKE ? OR LOWER(span_id) LIKE ? OR LOWER(status_code) LIKE ? OR LOWER(status_desc) LIKE ? OR LOWER(attributes) LIKE ? OR LOWER(events) LIKE ?",
+			pattern, pattern, pattern, pattern, pattern, pattern)
+	}
+	if filter.After != nil {
+		query = query.Where("seq > ?", *filter.After).Order("seq ASC, span_id ASC")
+	} else {
+		query = query.Order("start_time ASC, seq ASC, span_id ASC")
+	}
+	if err := query.Find(&spans).Error; err != nil {
 		return nil, err
 	}
+	g.attachAnnotations(spans)
+	g.attachOverrides(spans)
 
 	return spans, nil
 }
 
-func (g *GormDB) GetTraceGroupsWithSearch(limit int, before time.Time, search string) ([]TraceGroup, error) {
-	if limit <= 0 || limit > 1000 {
-		limit = 100
-	}
-
-	pattern := "%" + strings.ToLower(strings.TrimSpace(search)) + "%"
+// TraceGroupVersion changes whenever spans of a trace group arrive or their annotations change
+type TraceGroupVersion struct {
+	SpanCount        int64
+	LastEndTime      time.Time
+	AnnotationCount  int64
+	AnnotationsSince time.Time
+	// Complete is not part of the tag, it changes as time passes; see traceComplete
+	Complete bool
+}
 
-	type groupResult struct {
-		TraceID        string
-		FirstStartTime time.Time
-		LastEndTime    time.Time
-		SpanCount      int
+// ETag renders the version as a weak entity tag
+func (v TraceGroupVersion) ETag() string {
+	nanos := func(t time.Time) int64 {
+		if t.IsZero() {
+			return 0
+		}
+		return t.UnixNano()
 	}
+	return fmt.Sprintf(`W/"%d-%d-%d-%d"`, v.SpanCount, nanos(v.LastEndTime), v.AnnotationCount, nanos(v.AnnotationsSince))
+}
 
-	var results []groupResult
-	query := g.db.Model(&Span{}).
-		Select("trace_id, MIN(start_time) as first_start_time, MAX(end_time) as last_end_time, COUNT(*) as span_count").
-		Where("LOWER(name) LIKE ? OR LOWER(span_id) LIKE ? OR LOWER(status_code) LIKE ? OR LOWER(status_description) LIKE ? OR LOWER(attributes) LIKE ? OR LOWER(events) LIKE ?",
-			pattern, pattern, pattern, pattern, pattern, pattern).
-		Group("trace_id").
-		Order("MAX(end_time) DESC").
-		Limit(limit)
-
-	if !before.IsZero() {
-		query = query.Having("MAX(end_time) < ?", before)
+// GetTraceGroupVersion computes a cheap version of a trace group from aggregates only
+func (g *GormDB) GetTraceGroupVersion(traceID string) (TraceGroupVersion, error) {
+	var spans struct {
+		Count     int64
+		LastEnd   dbTime
+		RootCount int
+		LastSeq   int64
 	}
-
-	if err := query.Scan(&results).Error; err != nil {
-		return nil, err
+	if err := g.db.Model(&Span{}).Select("COUNT(*) AS count, MAX(end_time) AS last_end, "+rootCountSQL+" AS root_count, MAX(seq) AS last_seq").
+		Where("trace_id = ?", traceID).Scan(&spans).Error; err != nil {
+		return TraceGroupVersion{}, err
 	}
-
-	groups := make([]TraceGroup, len(results))
-	for i, r := range results {
-		groups[i] = TraceGroup{
-			TraceID:        r.TraceID,
-			FirstStartTime: r.FirstStartTime,
-			LastEndTime:    r.LastEndTime,
-			SpanCount:      r.SpanCount,
-		}
+	var ann struct {
+		Count       int64
+		LastUpdated dbTime
 	}
+	if err := g.db.Model(&SpanAnnotation{}).Select("COUNT(*) AS count, MAX(updated_at) AS last_updated").
+		Where("trace_id = ?", traceID).Scan(&ann).Error; err != nil {
+		return TraceGroupVersion{}, err
+	}
+	return TraceGroupVersion{
+		SpanCount:        spans.Count,
+		LastEndTime:      spans.LastEnd.Time,
+		Complete:         spans.Count > 0 && g.traceComplete(spans.RootCount, spans.LastSeq),
+		AnnotationCount:  ann.Count,
+		AnnotationsSince: ann.LastUpdated.Time,
+	}, nil
+}
 
-	return groups, nil
+func (g *GormDB) GetTraceGroupsWithSearch(limit int, before time.Time, search string) ([]TraceGroup, error) {
+	return g.GetTraceGroupsFiltered(limit, before, TraceGroupFilter{Search: search})
 }
 
 func (g *GormDB) GetTraceGroupSpansWithSearch(traceID string, limit int, search string) ([]Span, error) {
-	if limit <= 0 || limit > 5000 {
-		limit = 1000
-	}
-
-	pattern := "%" + strings.ToLower(strings.TrimSpace(search)) + "%"
-
-	var spans []Span
-	if err := g.db.Where("trace_id = ?", traceID).
-		Where("LOWER(name) LIKE ? OR LOWER(span_id) LIKE ? OR LOWER(status_code) LIKE ? OR LOWER(status_description) LIKE ? OR LOWER(attributes) LIKE ? OR LOWER(events) LIKE ?",
-			pattern, pattern, pattern, pattern, pattern, pattern).
-		Order("start_time ASC, span_id ASC").
-		Limit(limit).
-		Find(&spans).Error; err != nil {
-		return nil, err
-	}
-
-	return spans, nil
+	return g.GetTraceGroupSpansFiltered(traceID, limit, TraceSpanFilter{Search: search})
 }
 
 // Conversation operations
@@ -322,45 +841,33 @@ func (g *GormDB) BatchUpsertConversations(updates []ConversationUpdate) error {
 		return nil
 	}
 
+	rows := make([]Conversation, 0, len(updates))
 	for _, u := range updates {
-		var conv Conversation
-		err := g.db.Where("id = ?", u.ID).First(&conv).Error
-
-		if err == gorm.ErrRecordNotFound {
-			// Create new conversation
-			conv = Conversation{
-				ID:             u.ID,
-				ProjectID:      u.ProjectID,
-				UserID:         u.UserID,
-				FirstStartTime: u.Start,
-				LastEndTime:    u.End,
-			}
-			if conv.ProjectID == "" {
-				conv.ProjectID = "default"
-			}
-			if err := g.db.Create(&conv).Error; err != nil {
-				return err
-			}
-		} else if err != nil {
-			return err
-		} else {
-			// Update existing conversation
-			updateFields := map[string]interface{}{
-				"last_end_time": u.End,
-			}
-			if u.Start.Before(conv.FirstStartTime) {
-				updateFields["first_start_time"] = u.Start
-			}
-			if u.UserID != "" && conv.UserID == "" {
-				updateFields["user_id"] = u.UserID
-			}
-			if err := g.db.Model(&conv).Updates(updateFields).Error; err != nil {
-				return err
-			}
+		conv := Conversation{
+			ID:             u.ID,
+			ProjectID:      u.ProjectID,
+			UserID:         u.UserID,
+			FirstStartTime: u.Start,
+			LastEndTime:    u.End,
 		}
+		if conv.ProjectID == "" {
+			conv.ProjectID = "default"
+		}
+		rows = append(rows, conv)
 	}
-
-	return nil
+	// Existing conversations keep their project, widen their time range if needed (spans of
+	// late batches can be older) and only take a user id when they don't have one yet
+	return g.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "id"}},
+		DoUpdates: clause.Assignments(map[string]any{
+			"last_end_time": gorm.Expr("CASE WHEN excluded.last_end_time > conversations.last_end_time " +
+				"THEN excluded.last_end_time ELSE conversations.last_end_time END"),
+			"first_start_time": gorm.Expr("CASE WHEN excluded.first_start_time < conversations.first_start_time " +
+				"THEN excluded.first_start_time ELSE conversations.first_start_time END"),
+			"user_id": gorm.Expr("CASE WHEN conversations.user_id IS NULL OR conversations.user_id = '' " +
+				"THEN excluded.user_id ELSE conversations.user_id END"),
+		}),
+	}).CreateInBatches(rows, g.batchRows(&Conversation{})).Error
 }
 
 func (g *GormDB) GetConversations(limit int, before time.Time) ([]Conversation, error) {
@@ -382,6 +889,41 @@ func (g *GormDB) GetConversations(limit int, before time.Time) ([]Conversation,
 	return conversations, nil
 }
 
+// ConversationFilter narrows conversation listings; zero values mean "no restriction"
+type ConversationFilter struct {
+	ID        string
+	ProjectID string
+	UserID    string
+}
+
+func (g *GormDB) GetConversationsFiltered(limit int, before time.Time, filter ConversationFilter) ([]Conversation, error) {
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+
+	var conversations []Conversation
+	query := g.db.Order("last_end_time DESC").Limit(limit)
+
+	if !before.IsZero() {
+		query = query.Where("last_end_time < ?", before)
+	}
+	if filter.ID != "" {
+		query = query.Where("id = ?", filter.ID)
+	}
+	if filter.ProjectID != "" {
+		query = query.Where("project_id = ?", filter.ProjectID)
+	}
+	if filter.UserID != "" {
+		query = query.Where("user_id = ?", filter.UserID)
+	}
+
+	if err := query.Find(&conversations).Error; err != nil {
+		return nil, err
+	}
+
+	return conversations, nil
+}
+
 func (g *GormDB) GetConversationsWithSearch(limit int, before time.Time, search string) ([]Conversation, error) {
 	if limit <= 0 || limit > 1000 {
 		limit = 100
@@ -390,7 +932,7 @@ func (g *GormDB) GetConversationsWithSearch(limit int, before time.Time, search
 	pattern := "%" + strings.ToLower(strings.TrimSpace(search)) + "%"
 
 	var conversations []Conversation
-	query := g.db.Where("LOWER(id) LIKE ? OR LOWER(model) LIKE ?", pattern, pattern).
+	query := g.db.Where("LOWER(id) LIKE ? OR LOWER(summary) LIKE ?", pattern, pattern).
 		Order("last_end_time DESC").
 		Limit(limit)
 
@@ -405,49 +947,53 @@ func (g *GormDB) GetConversationsWithSearch(limit int, before time.Time, search
 	return conversations, nil
 }
 
-func (g *GormDB) PropagateConversationID(traceID, conversationID string) (int64, error) {
-	// Find all spans with this trace_id
-	var spans []Span
-	if err := g.db.Where("trace_id = ?", traceID).Find(&spans).Error; err != nil {
-		return 0, err
+// GetConversationsNeedingSummary returns conversations idle since idleBefore that were never
+// summarized or received spans after their last summary, leaving out those whose last attempt
+// failed until their retry time
+func (g *GormDB) GetConversationsNeedingSummary(idleBefore time.Time, limit int) ([]Conversation, error) {
+	if limit <= 0 {
+		limit = 10
 	}
+	var conversations []Conversation
+	if err := g.db.Where("last_end_time < ?", idleBefore).
+		Where("summarized_at IS NULL OR summarized_at < last_end_time").
+		Where("summary_retry_at IS NULL OR summary_retry_at <= ?", time.Now()).
+		Order("last_end_time DESC").
+		Limit(limit).
+		Find(&conversations).Error; err != nil {
+		return nil, err
+	}
+	return conversations, nil
+}
 
-	var updated int64
-	for _, span := range spans {
-		// Parse attributes
-		var attrs map[string]interface{}
-		if span.Attributes != "" {
-			if err := json.Unmarshal([]byte(span.Attributes), &attrs); err != nil {
-				continue
-			}
-		} else {
-			attrs = make(map[string]interface{})
-		}
-
-		// Add conversation ID
-		attrs["simpleTraces.conversation.id"] = conversationID
-
-		// Marshal back
-		attrsJSON, err := json.Marshal(attrs)
-		if err != nil {
-			continue
-		}
-
-		// Update span
-		if err := g.db.Model(&span).Update("attributes", string(attrsJSON)).Error; err != nil {
-			continue
-		}
+func (g *GormDB) UpdateConversationSummary(conversationID, summary, sentiment string) error {
+	return g.db.Model(&Conversation{}).Where("id = ?", conversationID).Updates(map[string]interface{}{
+		"summary":          summary,
+		"sentiment":        sentiment,
+		"summarized_at":    time.Now(),
+		"summary_failures": 0,
+		"summary_retry_at": nil,
+	}).Error
+}
 
-		updated++
-	}
+// RecordSummaryFailure counts a failed summary attempt and holds the conversation back until
+// retryAt
+func (g *GormDB) RecordSummaryFailure(conversationID string, retryAt time.Time) error {
+	return g.db.Model(&Conversation{}).Where("id = ?", conversationID).Updates(map[string]interface{}{
+		"summary_failures": gorm.Expr("summary_failures + 1"),
+		"summary_retry_at": retryAt,
+	}).Error
+}
 
-	return updated, nil
+func (g *GormDB) PropagateConversationID(traceID, conversationID string) (int64, error) {
+	return g.PropagateConversationIDs(map[string]string{traceID: conversationID})
 }
 
 func (g *GormDB) DeleteSpansByConversationID(conversationID string) (int64, error) {
 	// Find spans with simpleTraces.conversation.id attribute in JSON
 	var spans []Span
-	if err := g.db.Where("attributes LIKE ?", "%\"simpleTraces.conversation.id\":\""+conversationID+"\"%").
+	if err := g.db.Where("conversation_id = ?", conversationID).
+		Or("attributes LIKE ? ESCAPE '\\'", conversationAttrPattern(conversationID)).
 		Find(&spans).Error; err != nil {
 		return 0, err
 	}
@@ -457,11 +1003,20 @@ func (g *GormDB) DeleteSpansByConversationID(conversationID string) (int64, erro
 	}
 
 	spanIDs := make([]string, len(spans))
+	traceSet := make(map[string]struct{})
 	for i, span := range spans {
 		spanIDs[i] = span.SpanID
+		traceSet[span.TraceID] = struct{}{}
 	}
 
 	result := g.db.Where("span_id IN ?", spanIDs).Delete(&Span{})
+	if result.Error == nil {
+		traceIDs := make([]string, 0, len(traceSet))
+		for t := range traceSet {
+			traceIDs = append(traceIDs, t)
+		}
+		g.deleteDerivedByTraceIDs(traceIDs)
+	}
 	return result.RowsAffected, result.Error
 }
 
@@ -556,6 +1111,48 @@ func (g *GormDB) BackfillDerived(limit int) (int, int, error) {
 	return updatedSpans, 0, nil
 }
 
+// Embedding operations
+
+// UpsertEmbeddings stores embeddings, taking the conversation id from their spans: spans are
+// often linked to their conversation after they were queued for embedding
+func (g *GormDB) UpsertEmbeddings(rows []SpanEmbedding) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	spanIDs := make([]string, len(rows))
+	for i, row := range rows {
+		spanIDs[i] = row.SpanID
+	}
+	return g.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{UpdateAll: true}).CreateInBatches(rows, 100).Error; err != nil {
+			return err
+		}
+		return linkEmbeddingConversations(tx.Model(&SpanEmbedding{}).Where("span_id IN ?", spanIDs))
+	})
+}
+
+// linkEmbeddingConversations sets the conversation id of the embeddings selected by query to
+// the one of their spans
+func linkEmbeddingConversations(query *gorm.DB) error {
+	return query.Where("EXISTS (SELECT 1 FROM spans WHERE spans.span_id = span_embeddings.span_id)").
+		Update("conversation_id", gorm.Expr("(SELECT conversation_id FROM spans WHERE spans.span_id = span_embeddings.span_id)")).Error
+}
+
+// GetEmbeddings returns the most recent embeddings produced by the given model
+func (g *GormDB) GetEmbeddings(model string, limit int) ([]SpanEmbedding, error) {
+	if limit <= 0 {
+		limit = 1000
+	}
+	var rows []SpanEmbedding
+	if err := g.db.Where("model = ?", model).
+		Order("created_at DESC").
+		Limit(limit).
+		Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
 // Project operations
 func (g *GormDB) GetProjects() ([]Project, error) {
 	var projects []Project
@@ -0,0 +1,298 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Benchmarks of the Database query builders on synthetic spans, run by `make bench`:
+//
+//	BENCH_DB            databases to benchmark: sqlite, postgres or sqlite,postgres (default sqlite)
+//	BENCH_SPANS         synthetic spans seeded before benchmarking (default 100000)
+//	BENCH_SQLITE        SQLite file to use; one seeded by an earlier run is reused (default: a temporary file)
+//	BENCH_POSTGRES_DSN  Postgres DSN; tables are created in a temporary schema that is dropped afterwards
+
+// benchSpansPerTrace is the size of the synthetic traces the benchmark database is seeded with
+const benchSpansPerTrace = 20
+
+// benchTarget is a seeded database the benchmarks run against
+type benchTarget struct {
+	name string
+	db   *GormDB
+	seq  *benchSpanSeq
+	// sample is a trace id in the middle of the seeded data
+	sample string
+}
+
+// benchTargets are opened and seeded by the first benchmark and shared by the others
+var benchTargets struct {
+	once    sync.Once
+	list    []*benchTarget
+	err     error
+	cleanup []func()
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	for i := len(benchTargets.cleanup) - 1; i >= 0; i-- {
+		benchTargets.cleanup[i]()
+	}
+	os.Exit(code)
+}
+
+// openBenchTargets returns the databases of BENCH_DB, seeding them on first use
+func openBenchTargets(b *testing.B) []*benchTarget {
+	b.Helper()
+	benchTargets.once.Do(func() {
+		benchTargets.list, benchTargets.err = setupBenchTargets()
+	})
+	if benchTargets.err != nil {
+		b.Fatal(benchTargets.err)
+	}
+	return benchTargets.list
+}
+
+func setupBenchTargets() ([]*benchTarget, error) {
+	spans := getEnvInt("BENCH_SPANS", 100_000)
+	var targets []*benchTarget
+	for _, name := range splitList(getEnv("BENCH_DB", "sqlite")) {
+		var db *GormDB
+		var err error
+		switch name {
+		case "sqlite":
+			db, err = openBenchSQLite(getEnv("BENCH_SQLITE", ""))
+		case "postgres":
+			dsn := getEnv("BENCH_POSTGRES_DSN", "")
+			if dsn == "" {
+				return nil, fmt.Errorf("BENCH_POSTGRES_DSN is required to benchmark postgres")
+			}
+			db, err = openBenchPostgres(dsn)
+		default:
+			return nil, fmt.Errorf("unknown database %q in BENCH_DB (want sqlite or postgres)", name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		seq := &benchSpanSeq{rng: rand.New(rand.NewSource(1)), base: time.Now().Add(-time.Duration(spans/benchSpansPerTrace) * time.Second)}
+		if err := seedBench(db, seq, spans); err != nil {
+			return nil, fmt.Errorf("%s: seed: %w", name, err)
+		}
+		targets = append(targets, &benchTarget{name: name, db: db, seq: seq, sample: fmt.Sprintf("%032x", seq.next/2)})
+	}
+	return targets, nil
+}
+
+func openBenchSQLite(path string) (*GormDB, error) {
+	if path == "" {
+		dir, err := os.MkdirTemp("", "simple-traces-bench")
+		if err != nil {
+			return nil, err
+		}
+		benchTargets.cleanup = append(benchTargets.cleanup, func() { os.RemoveAll(dir) })
+		path = filepath.Join(dir, "bench.db")
+	}
+	db, err := InitDatabase(&Config{DBType: "sqlite", DBConnection: path, DBAutoMigrate: true})
+	if err != nil {
+		return nil, err
+	}
+	benchTargets.cleanup = append(benchTargets.cleanup, func() { db.Close() })
+	return db.(*GormDB), nil
+}
+
+// openBenchPostgres runs in a schema of its own so the benchmark never touches existing tables
+func openBenchPostgres(dsn string) (*GormDB, error) {
+	admin, err := openGorm("postgres", dsn, "")
+	if err != nil {
+		return nil, err
+	}
+	if sqlDB, err := admin.DB(); err == nil {
+		benchTargets.cleanup = append(benchTargets.cleanup, func() { sqlDB.Close() })
+	}
+	schema := fmt.Sprintf("simple_traces_bench_%d", os.Getpid())
+	if err := admin.Exec("CREATE SCHEMA " + schema).Error; err != nil {
+		return nil, err
+	}
+	benchTargets.cleanup = append(benchTargets.cleanup, func() { admin.Exec("DROP SCHEMA " + schema + " CASCADE") })
+
+	if strings.Contains(dsn, "://") {
+		sep := "?"
+		if strings.Contains(dsn, "?") {
+			sep = "&"
+		}
+		dsn += sep + "search_path=" + schema
+	} else {
+		dsn += " search_path=" + schema
+	}
+	db, err := InitDatabase(&Config{DBType: "postgres", DBConnection: dsn, DBAutoMigrate: true})
+	if err != nil {
+		return nil, err
+	}
+	benchTargets.cleanup = append(benchTargets.cleanup, func() { db.Close() })
+	return db.(*GormDB), nil
+}
+
+// benchSpanSeq hands out unique span ids across seeding and insert benchmarks
+type benchSpanSeq struct {
+	rng  *rand.Rand
+	next int
+	base time.Time
+}
+
+var benchTerms = []string{"refund", "shipping", "invoice", "password", "delivery", "discount"}
+
+// trace builds one synthetic trace: an agent root with LLM, tool, HTTP and database children
+func (s *benchSpanSeq) trace() []Span {
+	s.next++
+	traceID := fmt.Sprintf("%032x", s.next)
+	conv := fmt.Sprintf("bench-conv-%d", s.next/4)
+	project := fmt.Sprintf("bench-%d", s.next%5)
+	start := s.base.Add(time.Duration(s.next) * time.Second)
+	out := make([]Span, 0, benchSpansPerTrace)
+	for i := 0; i < benchSpansPerTrace; i++ {
+		sp := Span{
+			SpanID:         fmt.Sprintf("%s%04x", traceID[16:], i),
+			TraceID:        traceID,
+			ProjectID:      project,
+			ConversationID: conv,
+			StartTime:      start.Add(time.Duration(i) * 50 * time.Millisecond),
+			DurationMS:     int64(20 + s.rng.Intn(800)),
+			StatusCode:     "OK",
+			Category:       "other",
+		}
+		sp.EndTime = sp.StartTime.Add(time.Duration(sp.DurationMS) * time.Millisecond)
+		attrs := map[string]any{"session.id": conv}
+		switch {
+		case i == 0:
+			sp.Name = "invoke_agent bench"
+			sp.DurationMS = benchSpansPerTrace * 60
+		case i%3 == 1:
+			sp.Name, sp.Category, sp.Model = "call_llm", "llm", loadgenModels[s.rng.Intn(len(loadgenModels))]
+			sp.InputTokens, sp.OutputTokens = int64(500+s.rng.Intn(2000)), int64(20+s.rng.Intn(300))
+			attrs["gen_ai.prompt"] = "Customer asks about " + benchTerms[s.rng.Intn(len(benchTerms))]
+			attrs["gen_ai.response"] = "Here is what I found about your " + benchTerms[s.rng.Intn(len(benchTerms))]
+		case i%3 == 2:
+			tool := loadgenTools[s.rng.Intn(len(loadgenTools))]
+			sp.Name, sp.Category = "execute_tool "+tool, "tool"
+			attrs["gen_ai.tool.name"] = tool
+		case i%2 == 0:
+			sp.Name, sp.Category, sp.HTTPMethod, sp.HTTPRoute, sp.HTTPStatusCode = "GET", "http", "GET", loadgenRoutes[s.rng.Intn(len(loadgenRoutes))], 200
+		default:
+			sp.Name, sp.Category = "SELECT", "db"
+			sp.DBOperation, sp.DBFingerprint, sp.DBFingerprintID = "SELECT", "SELECT * FROM documents WHERE id = ?", "fp-documents"
+		}
+		if i > 0 {
+			sp.ParentSpanID = out[0].SpanID
+		}
+		if s.rng.Intn(100) < 2 {
+			sp.StatusCode, sp.StatusDesc = "ERROR", "synthetic failure"
+		}
+		raw, _ := json.Marshal(attrs)
+		sp.Attributes = string(raw)
+		out = append(out, sp)
+	}
+	return out
+}
+
+// seedBench fills db up to spans synthetic spans, skipping spans seeded by an earlier run
+func seedBench(db *GormDB, seq *benchSpanSeq, spans int) error {
+	var existing int64
+	if err := db.db.Model(&Span{}).Count(&existing).Error; err != nil {
+		return err
+	}
+	seq.next = int(existing) / benchSpansPerTrace
+	if int(existing) >= spans {
+		fmt.Printf("Reusing %d seeded spans\n", existing)
+		return nil
+	}
+	began := time.Now()
+	for seeded := int(existing); seeded < spans; {
+		var batch []Span
+		convs := map[string]ConversationUpdate{}
+		for len(batch) < 5000 && seeded+len(batch) < spans {
+			trace := seq.trace()
+			batch = append(batch, trace...)
+			root := trace[0]
+			convs[root.ConversationID] = ConversationUpdate{ID: root.ConversationID, ProjectID: root.ProjectID, Start: root.StartTime, End: root.EndTime}
+		}
+		if err := db.BatchInsertSpans(batch); err != nil {
+			return err
+		}
+		updates := make([]ConversationUpdate, 0, len(convs))
+		for _, u := range convs {
+			updates = append(updates, u)
+		}
+		if err := db.BatchUpsertConversations(updates); err != nil {
+			return err
+		}
+		seeded += len(batch)
+		if seeded%100_000 < len(batch) || seeded >= spans {
+			fmt.Printf("Seeded %d/%d spans (%.0f spans/s)\n", seeded, spans, float64(seeded-int(existing))/time.Since(began).Seconds())
+		}
+	}
+	return nil
+}
+
+func BenchmarkQueries(b *testing.B) {
+	for _, t := range openBenchTargets(b) {
+		db := t.db
+		queries := []struct {
+			name string
+			fn   func() error
+		}{
+			{"GetTraceGroups", func() error { _, err := db.GetTraceGroups(100, time.Time{}); return err }},
+			{"GetTraceGroupsFiltered/project", func() error {
+				_, err := db.GetTraceGroupsFiltered(100, time.Time{}, TraceGroupFilter{ProjectID: "bench-3"})
+				return err
+			}},
+			{"GetTraceGroupsFiltered/errors", func() error {
+				_, err := db.GetTraceGroupsFiltered(100, time.Time{}, TraceGroupFilter{OnlyErrors: true})
+				return err
+			}},
+			{"GetTraceGroupsWithSearch", func() error { _, err := db.GetTraceGroupsWithSearch(100, time.Time{}, "refund"); return err }},
+			{"GetTraceGroupSpans", func() error { _, err := db.GetTraceGroupSpans(t.sample, 1000); return err }},
+			{"GetSpansFiltered/llm", func() error {
+				_, err := db.GetSpansFiltered(100, time.Time{}, SpanFilter{Category: "llm"})
+				return err
+			}},
+			{"GetConversationsWithSearch", func() error { _, err := db.GetConversationsWithSearch(100, time.Time{}, "bench-conv-1"); return err }},
+			{"SearchConversationTranscripts", func() error { _, err := db.SearchConversationTranscripts("invoice", 20); return err }},
+		}
+		for _, q := range queries {
+			b.Run(q.name+"/"+t.name, func(b *testing.B) {
+				b.ReportAllocs()
+				for b.Loop() {
+					if err := q.fn(); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkBatchInsertSpans runs after BenchmarkQueries, since it grows the tables they query
+func BenchmarkBatchInsertSpans(b *testing.B) {
+	for _, t := range openBenchTargets(b) {
+		b.Run("1000/"+t.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				var batch []Span
+				for len(batch) < 1000 {
+					batch = append(batch, t.seq.trace()...)
+				}
+				b.StartTimer()
+				if err := t.db.BatchInsertSpans(batch); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
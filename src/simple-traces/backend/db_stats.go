@@ -0,0 +1,222 @@
+package backend
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxFingerprintLen bounds stored fingerprints; generated statements can be huge
+const maxFingerprintLen = 2000
+
+var (
+	sqlBlockComment = regexp.MustCompile(`(?s)/\*.*?\*/`)
+	sqlLineComment  = regexp.MustCompile(`--[^\n]*`)
+	sqlString       = regexp.MustCompile(`'(?:[^']|'')*'`)
+	sqlDollarParam  = regexp.MustCompile(`\$\d+`)
+	sqlNumber       = regexp.MustCompile(`\b-?\d+(?:\.\d+)?(?:e[+-]?\d+)?\b`)
+	sqlHex          = regexp.MustCompile(`\b0x[0-9a-f]+\b`)
+	sqlBool         = regexp.MustCompile(`\b(?:true|false)\b`)
+	sqlInList       = regexp.MustCompile(`\(\s*\?(?:\s*,\s*\?)+\s*\)`)
+	sqlValuesList   = regexp.MustCompile(`(\(\?\+\))(?:\s*,\s*\(\?\+\))+`)
+	sqlSpace        = regexp.MustCompile(`\s+`)
+)
+
+// dbStatementKeys hold the query text of database spans (current and older semantic conventions)
+var dbStatementKeys = []string{"db.query.text", "db.statement"}
+
+// fingerprintStatement normalizes a query so executions that only differ in literals group
+// together: comments are removed, literals and bind parameters become ?, lists of values
+// collapse to (?+) and whitespace and case are normalized.
+func fingerprintStatement(stmt string) string {
+	s := sqlBlockComment.ReplaceAllString(stmt, " ")
+	s = sqlLineComment.ReplaceAllString(s, " ")
+	s = strings.ToLower(s)
+	s = sqlString.ReplaceAllString(s, "?")
+	s = sqlDollarParam.ReplaceAllString(s, "?")
+	s = sqlHex.ReplaceAllString(s, "?")
+	s = sqlNumber.ReplaceAllString(s, "?")
+	s = sqlBool.ReplaceAllString(s, "?")
+	s = sqlInList.ReplaceAllString(s, "(?+)")
+	s = sqlValuesList.ReplaceAllString(s, "$1")
+	s = strings.TrimSpace(sqlSpace.ReplaceAllString(s, " "))
+	s = strings.TrimSuffix(s, ";")
+	if len(s) > maxFingerprintLen {
+		s = s[:maxFingerprintLen]
+	}
+	return s
+}
+
+// extractDBAttrs fingerprints the statement of a db span. The id is a short hash of the
+// fingerprint that spans are grouped and filtered by.
+func extractDBAttrs(attrs map[string]any) (operation, fingerprint, id string) {
+	stmt := firstStringAttr(attrs, dbStatementKeys)
+	for _, key := range []string{"db.operation.name", "db.operation"} {
+		if s, ok := attrs[key].(string); ok && s != "" {
+			operation = strings.ToUpper(s)
+			break
+		}
+	}
+	if stmt == "" {
+		return operation, "", ""
+	}
+	fingerprint = fingerprintStatement(stmt)
+	if operation == "" {
+		if i := strings.IndexByte(fingerprint, ' '); i > 0 {
+			operation = strings.ToUpper(fingerprint[:i])
+		} else {
+			operation = strings.ToUpper(fingerprint)
+		}
+	}
+	sum := sha1.Sum([]byte(fingerprint))
+	return operation, fingerprint, hex.EncodeToString(sum[:8])
+}
+
+// DBQueryStats reports how often a fingerprinted query ran and how long it took
+type DBQueryStats struct {
+	FingerprintID string  `json:"fingerprint_id"`
+	Fingerprint   string  `json:"fingerprint"`
+	System        string  `json:"system,omitempty"`
+	Operation     string  `json:"operation,omitempty"`
+	Count         int64   `json:"count"`
+	Traces        int64   `json:"traces"`
+	Errors        int64   `json:"errors"`
+	TotalMS       int64   `json:"total_ms"`
+	AvgMS         float64 `json:"avg_ms"`
+	P95MS         int64   `json:"p95_ms"`
+	MaxMS         int64   `json:"max_ms"`
+	// SlowestTraceID is the trace of the slowest execution, for drilling in
+	SlowestTraceID string `json:"slowest_trace_id"`
+}
+
+// dbStatsSorts are the accepted sort orders of the db report
+var dbStatsSorts = map[string]func(a, b DBQueryStats) bool{
+	"total": func(a, b DBQueryStats) bool { return a.TotalMS > b.TotalMS },
+	"count": func(a, b DBQueryStats) bool { return a.Count > b.Count },
+	"p95":   func(a, b DBQueryStats) bool { return a.P95MS > b.P95MS },
+	"max":   func(a, b DBQueryStats) bool { return a.MaxMS > b.MaxMS },
+}
+
+// GetDBStats aggregates db spans per query fingerprint, ordered by sortBy (total, count, p95 or max)
+func (g *GormDB) GetDBStats(filter StatsFilter, sortBy string, limit int) ([]DBQueryStats, error) {
+	var rows []struct {
+		TraceID         string
+		DBFingerprint   string
+		DBFingerprintID string
+		DBOperation     string
+		StatusCode      string
+		DurationMS      int64
+	}
+	if err := filter.apply(g.db.Model(&Span{})).
+		Select("trace_id, db_fingerprint, db_fingerprint_id, db_operation, status_code, duration_ms").
+		Where("db_fingerprint_id <> ''").
+		Limit(200000).
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	durations := make(map[string][]int64)
+	traces := make(map[string]map[string]bool)
+	stats := make(map[string]*DBQueryStats)
+	for _, r := range rows {
+		st := stats[r.DBFingerprintID]
+		if st == nil {
+			st = &DBQueryStats{FingerprintID: r.DBFingerprintID, Fingerprint: r.DBFingerprint, Operation: r.DBOperation}
+			stats[r.DBFingerprintID] = st
+			traces[r.DBFingerprintID] = make(map[string]bool)
+		}
+		st.Count++
+		st.TotalMS += r.DurationMS
+		if r.StatusCode == "ERROR" {
+			st.Errors++
+		}
+		if r.DurationMS >= st.MaxMS {
+			st.MaxMS, st.SlowestTraceID = r.DurationMS, r.TraceID
+		}
+		traces[r.DBFingerprintID][r.TraceID] = true
+		durations[r.DBFingerprintID] = append(durations[r.DBFingerprintID], r.DurationMS)
+	}
+	out := make([]DBQueryStats, 0, len(stats))
+	for id, st := range stats {
+		st.Traces = int64(len(traces[id]))
+		st.AvgMS = average(durations[id])
+		st.P95MS = percentile(durations[id], 95)
+		out = append(out, *st)
+	}
+	less, ok := dbStatsSorts[sortBy]
+	if !ok {
+		less = dbStatsSorts["total"]
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if less(out[i], out[j]) != less(out[j], out[i]) {
+			return less(out[i], out[j])
+		}
+		return out[i].FingerprintID < out[j].FingerprintID
+	})
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	g.attachDBSystems(out)
+	return out, nil
+}
+
+// attachDBSystems fills in the database system of each fingerprint from one of its spans
+func (g *GormDB) attachDBSystems(stats []DBQueryStats) {
+	for i := range stats {
+		var attrs string
+		if err := g.db.Model(&Span{}).Select("attributes").
+			Where("db_fingerprint_id = ?", stats[i].FingerprintID).
+			Limit(1).Scan(&attrs).Error; err != nil {
+			continue
+		}
+		var parsed struct {
+			System     string `json:"db.system"`
+			SystemName string `json:"db.system.name"`
+		}
+		if json.Unmarshal([]byte(attrs), &parsed) == nil {
+			stats[i].System = parsed.System
+			if stats[i].System == "" {
+				stats[i].System = parsed.SystemName
+			}
+		}
+	}
+}
+
+// getDBStatsHandler returns the slowest and most frequent database queries by fingerprint
+func getDBStatsHandler(db Database, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		filter, err := parseStatsFilter(q)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sortBy := strings.TrimSpace(q.Get("sort"))
+		if sortBy == "" {
+			sortBy = "total"
+		}
+		if _, ok := dbStatsSorts[sortBy]; !ok {
+			http.Error(w, "sort must be total, count, p95 or max", http.StatusBadRequest)
+			return
+		}
+		limit := 50
+		if s := strings.TrimSpace(q.Get("limit")); s != "" {
+			if v, err := strconv.Atoi(s); err == nil && v > 0 {
+				limit = v
+			}
+		}
+		stats, err := db.GetDBStats(filter, sortBy, limit)
+		if err != nil {
+			logger.Error("Failed to get db stats: %v", err)
+			http.Error(w, fmt.Sprintf("Failed to get db stats: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats)
+	}
+}
@@ -0,0 +1,312 @@
+package backend
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/protobuf/proto"
+	"gorm.io/gorm"
+
+	tracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepbv1 "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+// maxDeadLetters bounds the stored dead letters; the oldest are dropped beyond it
+const maxDeadLetters = 1000
+
+// Dead letter kinds: the payload of spans is an OTLP export (protobuf) of the spans that
+// failed to be written, the payload of conversations the JSON of the failed upserts
+const (
+	deadLetterSpans         = "spans"
+	deadLetterConversations = "conversations"
+)
+
+// DeadLetter keeps data that failed to be written at ingest, so it can be replayed once the
+// cause (a full disk, a schema problem, a row the database refused) is fixed
+type DeadLetter struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+	Kind      string    `gorm:"index" json:"kind"`
+	// Count is the number of spans or conversation updates in the payload
+	Count        int    `json:"count"`
+	Error        string `gorm:"type:text" json:"error"`
+	Payload      []byte `json:"-"`
+	PayloadBytes int    `json:"payload_bytes"`
+	// Replays tried so far; ReplayedAt is set once one succeeded
+	Attempts   int        `json:"attempts"`
+	ReplayedAt *time.Time `gorm:"index" json:"replayed_at,omitempty"`
+}
+
+// InsertDeadLetter stores a dead letter and drops the oldest beyond maxDeadLetters
+func (g *GormDB) InsertDeadLetter(d *DeadLetter) error {
+	d.PayloadBytes = len(d.Payload)
+	if err := g.db.Create(d).Error; err != nil {
+		return err
+	}
+	if d.ID > maxDeadLetters {
+		return g.db.Where("id <= ?", d.ID-maxDeadLetters).Delete(&DeadLetter{}).Error
+	}
+	return nil
+}
+
+// GetDeadLetters lists dead letters newest first without their payloads; kind and pending
+// (not yet replayed) narrow the list
+func (g *GormDB) GetDeadLetters(kind string, pending bool, limit int) ([]DeadLetter, error) {
+	if limit <= 0 || limit > maxDeadLetters {
+		limit = 100
+	}
+	query := g.db.Omit("payload").Order("id DESC").Limit(limit)
+	if kind != "" {
+		query = query.Where("kind = ?", kind)
+	}
+	if pending {
+		query = query.Where("replayed_at IS NULL")
+	}
+	letters := make([]DeadLetter, 0)
+	if err := query.Find(&letters).Error; err != nil {
+		return nil, err
+	}
+	return letters, nil
+}
+
+// GetDeadLetter returns a dead letter with its payload; gorm.ErrRecordNotFound for unknown ids
+func (g *GormDB) GetDeadLetter(id uint) (*DeadLetter, error) {
+	var d DeadLetter
+	if err := g.db.First(&d, id).Error; err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// RecordDeadLetterReplay counts a replay attempt; a nil error marks the dead letter replayed
+func (g *GormDB) RecordDeadLetterReplay(id uint, replayErr error) error {
+	updates := map[string]any{"attempts": gorm.Expr("attempts + 1")}
+	if replayErr != nil {
+		updates["error"] = replayErr.Error()
+	} else {
+		updates["replayed_at"] = time.Now()
+	}
+	return g.db.Model(&DeadLetter{}).Where("id = ?", id).Updates(updates).Error
+}
+
+func (g *GormDB) DeleteDeadLetter(id uint) (int64, error) {
+	result := g.db.Delete(&DeadLetter{}, id)
+	return result.RowsAffected, result.Error
+}
+
+// deadLetter stores data that failed to be written and reports whether it was stored; when
+// even that fails the data is lost, as before dead letters existed, and only logged
+func (h *OTLPHandler) deadLetter(kind string, payload []byte, count int, cause error) bool {
+	metrics.Inc("simpletraces_dead_letters_total", "kind", kind)
+	d := &DeadLetter{Kind: kind, Count: count, Error: cause.Error(), Payload: payload}
+	if err := h.db.InsertDeadLetter(d); err != nil {
+		h.logger.Error("Failed to store dead letter of %d %s, dropping them: %v", count, kind, err)
+		return false
+	}
+	h.logger.Warn("Stored %d %s that failed to be written as dead letter %d", count, kind, d.ID)
+	return true
+}
+
+// deadLetterSpans stores the spans of req with the given ids as one dead letter and reports
+// whether they are kept
+func (h *OTLPHandler) deadLetterSpans(req *tracepb.ExportTraceServiceRequest, spanIDs map[string]bool, cause error) bool {
+	subset := &tracepb.ExportTraceServiceRequest{}
+	count := 0
+	for _, rs := range req.ResourceSpans {
+		var scopes []*tracepbv1.ScopeSpans
+		for _, ss := range rs.ScopeSpans {
+			var spans []*tracepbv1.Span
+			for _, span := range ss.Spans {
+				if spanIDs[hex.EncodeToString(span.SpanId)] {
+					spans = append(spans, span)
+				}
+			}
+			if len(spans) > 0 {
+				scopes = append(scopes, &tracepbv1.ScopeSpans{Scope: ss.Scope, SchemaUrl: ss.SchemaUrl, Spans: spans})
+				count += len(spans)
+			}
+		}
+		if len(scopes) > 0 {
+			subset.ResourceSpans = append(subset.ResourceSpans, &tracepbv1.ResourceSpans{Resource: rs.Resource, SchemaUrl: rs.SchemaUrl, ScopeSpans: scopes})
+		}
+	}
+	if count == 0 {
+		return false
+	}
+	payload, err := proto.Marshal(subset)
+	if err != nil {
+		h.logger.Error("Failed to encode %d spans as dead letter, dropping them: %v", count, err)
+		return false
+	}
+	return h.deadLetter(deadLetterSpans, payload, count, cause)
+}
+
+// ReplayDeadLetter writes the payload of a dead letter again. Spans go through the full
+// ingest path (stored spans are ignored, so partly successful replays can be repeated);
+// failures are reported instead of stored as new dead letters.
+func (h *OTLPHandler) ReplayDeadLetter(d *DeadLetter) error {
+	switch d.Kind {
+	case deadLetterSpans:
+		var req tracepb.ExportTraceServiceRequest
+		if err := proto.Unmarshal(d.Payload, &req); err != nil {
+			return fmt.Errorf("decode spans: %w", err)
+		}
+		res, err := h.export([]*tracepb.ExportTraceServiceRequest{&req}, false)
+		if err != nil {
+			return err
+		}
+		if res.Rejected > 0 {
+			return fmt.Errorf("%s", res.Message)
+		}
+		return nil
+	case deadLetterConversations:
+		var updates []ConversationUpdate
+		if err := json.Unmarshal(d.Payload, &updates); err != nil {
+			return fmt.Errorf("decode conversations: %w", err)
+		}
+		return h.db.BatchUpsertConversations(updates)
+	}
+	return fmt.Errorf("unknown dead letter kind %q", d.Kind)
+}
+
+// getDeadLettersHandler lists dead letters (?kind=spans|conversations, ?pending=true, ?limit)
+func getDeadLettersHandler(db Database, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		limit := 100
+		if s := strings.TrimSpace(q.Get("limit")); s != "" {
+			if v, err := strconv.Atoi(s); err == nil && v > 0 {
+				limit = v
+			}
+		}
+		letters, err := db.GetDeadLetters(strings.TrimSpace(q.Get("kind")), q.Get("pending") == "true", limit)
+		if err != nil {
+			logger.Error("Failed to get dead letters: %v", err)
+			http.Error(w, fmt.Sprintf("Failed to get dead letters: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(letters)
+	}
+}
+
+// DeadLetterReplay is the outcome of replaying one dead letter
+type DeadLetterReplay struct {
+	ID    uint   `json:"id"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// replayDeadLetter replays and records the outcome of one dead letter
+func replayDeadLetter(db Database, h *OTLPHandler, logger *Logger, d *DeadLetter) DeadLetterReplay {
+	res := DeadLetterReplay{ID: d.ID, OK: true}
+	replayErr := h.ReplayDeadLetter(d)
+	if replayErr != nil {
+		logger.Warn("Replay of dead letter %d failed: %v", d.ID, replayErr)
+		res.OK, res.Error = false, replayErr.Error()
+	} else {
+		logger.Info("Replayed dead letter %d (%d %s)", d.ID, d.Count, d.Kind)
+	}
+	if err := db.RecordDeadLetterReplay(d.ID, replayErr); err != nil {
+		logger.Error("Failed to record replay of dead letter %d: %v", d.ID, err)
+	}
+	return res
+}
+
+// replayDeadLetterHandler replays one dead letter; a failed replay answers 502 with the error
+func replayDeadLetterHandler(db Database, h *OTLPHandler, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			http.Error(w, "invalid dead letter id", http.StatusBadRequest)
+			return
+		}
+		d, err := db.GetDeadLetter(uint(id))
+		if err == gorm.ErrRecordNotFound {
+			http.Error(w, "Dead letter not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error("Failed to get dead letter %d: %v", id, err)
+			http.Error(w, fmt.Sprintf("Failed to get dead letter: %v", err), http.StatusInternalServerError)
+			return
+		}
+		res := replayDeadLetter(db, h, logger, d)
+		w.Header().Set("Content-Type", "application/json")
+		if !res.OK {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+		json.NewEncoder(w).Encode(res)
+	}
+}
+
+// replayDeadLettersHandler replays the pending dead letters, oldest first (?kind, ?limit)
+func replayDeadLettersHandler(db Database, h *OTLPHandler, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		limit := 100
+		if s := strings.TrimSpace(q.Get("limit")); s != "" {
+			if v, err := strconv.Atoi(s); err == nil && v > 0 {
+				limit = v
+			}
+		}
+		letters, err := db.GetDeadLetters(strings.TrimSpace(q.Get("kind")), true, limit)
+		if err != nil {
+			logger.Error("Failed to get dead letters: %v", err)
+			http.Error(w, fmt.Sprintf("Failed to get dead letters: %v", err), http.StatusInternalServerError)
+			return
+		}
+		results := make([]DeadLetterReplay, 0, len(letters))
+		replayed := 0
+		for i := len(letters) - 1; i >= 0; i-- {
+			d, err := db.GetDeadLetter(letters[i].ID)
+			if err != nil {
+				results = append(results, DeadLetterReplay{ID: letters[i].ID, Error: err.Error()})
+				continue
+			}
+			res := replayDeadLetter(db, h, logger, d)
+			if res.OK {
+				replayed++
+			}
+			results = append(results, res)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"replayed": replayed,
+			"failed":   len(results) - replayed,
+			"results":  results,
+		})
+	}
+}
+
+// deleteDeadLetterHandler discards a dead letter
+func deleteDeadLetterHandler(db Database, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			http.Error(w, "invalid dead letter id", http.StatusBadRequest)
+			return
+		}
+		deleted, err := db.DeleteDeadLetter(uint(id))
+		if err != nil {
+			logger.Error("Failed to delete dead letter %d: %v", id, err)
+			http.Error(w, fmt.Sprintf("Failed to delete dead letter: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if deleted == 0 {
+			http.Error(w, "Dead letter not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"ok":      true,
+			"deleted": deleted,
+		})
+	}
+}
@@ -0,0 +1,255 @@
+package backend
+
+import (
+	"sync"
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+// SpanDedup remembers recently stored spans, by trace and span id, so that batches resent by
+// SDKs after a timeout are not aggregated into conversations twice. Entries expire after window
+// and the oldest entries are evicted once maxEntries is reached.
+//
+// With a store the spans are also recorded in the seen_spans table, so resends are still caught
+// after a restart and by the other instances writing to the same database.
+type SpanDedup struct {
+	mu         sync.Mutex
+	window     time.Duration
+	maxEntries int
+	seen       map[string]time.Time
+	order      []dedupEntry
+	head       int
+
+	store    Database
+	prunedAt time.Time
+}
+
+// seenSpanPruneInterval is how often expired rows are deleted from seen_spans
+const seenSpanPruneInterval = time.Minute
+
+// SeenSpan is a span stored within the dedup window, see SpanDedup
+type SeenSpan struct {
+	// ID is the trace and span id, see dedupKey
+	ID     string    `gorm:"primaryKey"`
+	SeenAt time.Time `gorm:"index"`
+}
+
+// dedupKey identifies a span for de-duplication; span ids are only unique within a trace
+func dedupKey(traceID, spanID string) string {
+	return traceID + "/" + spanID
+}
+
+type dedupEntry struct {
+	id      string
+	expires time.Time
+}
+
+// NewSpanDedup creates a cache; a zero window or size disables de-duplication (nil)
+func NewSpanDedup(window time.Duration, maxEntries int) *SpanDedup {
+	if window <= 0 || maxEntries <= 0 {
+		return nil
+	}
+	return &SpanDedup{
+		window:     window,
+		maxEntries: maxEntries,
+		seen:       make(map[string]time.Time),
+	}
+}
+
+// SetStore records stored spans in db as well
+func (d *SpanDedup) SetStore(db Database) {
+	d.store = db
+}
+
+// Stored reports whether the spans are recorded in the database
+func (d *SpanDedup) Stored() bool {
+	return d != nil && d.store != nil
+}
+
+// SeenOrAdd reports whether the span with key (see dedupKey) was stored within the window, and
+// otherwise reserves key so that concurrent exports of the same span see it as a duplicate.
+// Reserved keys are only kept in memory; record them with Add once the span is stored, or
+// forget them with Release when it isn't.
+func (d *SpanDedup) SeenOrAdd(key string) bool {
+	if d == nil {
+		return false
+	}
+	now := time.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if exp, ok := d.seen[key]; ok && now.Before(exp) {
+		return true
+	}
+	d.remember(key, now.Add(d.window))
+	d.evict(now)
+	return false
+}
+
+// Release forgets keys reserved by SeenOrAdd whose spans were not stored, so resends of them
+// are accepted
+func (d *SpanDedup) Release(keys ...string) {
+	if d == nil || len(keys) == 0 {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, k := range keys {
+		delete(d.seen, k)
+	}
+}
+
+// releaseDedupKeys releases the dedup reservations of an export's spans (batchIDs, see
+// SpanDedup.SeenOrAdd) that are not among stored: spans dropped after the duplicate check and
+// spans that failed to be written, so a resend of them is stored
+func (h *OTLPHandler) releaseDedupKeys(batchIDs map[string]bool, stored []Span) {
+	if h.dedup == nil {
+		return
+	}
+	keep := make(map[string]bool, len(stored))
+	for _, sp := range stored {
+		keep[dedupKey(sp.TraceID, sp.SpanID)] = true
+	}
+	var release []string
+	for key := range batchIDs {
+		if !keep[key] {
+			release = append(release, key)
+		}
+	}
+	h.dedup.Release(release...)
+}
+
+// Seed loads the keys not remembered in memory from the store, so that SeenOrAdd also catches
+// spans stored before a restart or by another instance. Without a store it does nothing.
+func (d *SpanDedup) Seed(keys []string) error {
+	if !d.Stored() || len(keys) == 0 {
+		return nil
+	}
+	now := time.Now()
+	d.mu.Lock()
+	var unseen []string
+	for _, k := range keys {
+		if exp, ok := d.seen[k]; !ok || !now.Before(exp) {
+			unseen = append(unseen, k)
+		}
+	}
+	d.mu.Unlock()
+	if len(unseen) == 0 {
+		return nil
+	}
+	seenAt, err := d.store.GetSeenSpans(unseen, now.Add(-d.window))
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for k, at := range seenAt {
+		d.remember(k, at.Add(d.window))
+	}
+	d.evict(now)
+	return nil
+}
+
+// Add records stored spans by key (see dedupKey), in the store as well if there is one
+func (d *SpanDedup) Add(keys ...string) error {
+	if d == nil {
+		return nil
+	}
+	now := time.Now()
+	d.mu.Lock()
+	for _, k := range keys {
+		d.remember(k, now.Add(d.window))
+	}
+	d.evict(now)
+	prune := d.store != nil && now.Sub(d.prunedAt) >= seenSpanPruneInterval
+	if prune {
+		d.prunedAt = now
+	}
+	d.mu.Unlock()
+
+	if d.store == nil {
+		return nil
+	}
+	if err := d.store.RecordSeenSpans(keys, now); err != nil {
+		return err
+	}
+	if prune {
+		if _, err := d.store.PruneSeenSpans(now.Add(-d.window)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remember keeps key until expires; callers hold mu
+func (d *SpanDedup) remember(key string, expires time.Time) {
+	d.seen[key] = expires
+	d.order = append(d.order, dedupEntry{id: key, expires: expires})
+}
+
+// evict drops expired entries and the oldest ones beyond maxEntries; callers hold mu
+func (d *SpanDedup) evict(now time.Time) {
+	for d.head < len(d.order) {
+		e := d.order[d.head]
+		if len(d.order)-d.head <= d.maxEntries && now.Before(e.expires) {
+			break
+		}
+		// only delete if not refreshed by a later Add
+		if exp, ok := d.seen[e.id]; ok && !exp.After(e.expires) {
+			delete(d.seen, e.id)
+		}
+		d.head++
+	}
+	// compact once the dead prefix dominates
+	if d.head > 0 && d.head*2 >= len(d.order) {
+		d.order = append(d.order[:0:0], d.order[d.head:]...)
+		d.head = 0
+	}
+}
+
+// Len returns the number of spans remembered in memory
+func (d *SpanDedup) Len() int {
+	if d == nil {
+		return 0
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.seen)
+}
+
+// GetSeenSpans returns when each of keys was recorded, for those recorded since
+func (g *GormDB) GetSeenSpans(keys []string, since time.Time) (map[string]time.Time, error) {
+	out := make(map[string]time.Time)
+	for start := 0; start < len(keys); start += maxBatchRows {
+		var rows []SeenSpan
+		if err := g.primary().Where("id IN ? AND seen_at >= ?", keys[start:min(start+maxBatchRows, len(keys))], since).
+			Find(&rows).Error; err != nil {
+			return nil, err
+		}
+		for _, r := range rows {
+			out[r.ID] = r.SeenAt
+		}
+	}
+	return out, nil
+}
+
+// RecordSeenSpans records keys as seen at, refreshing keys recorded before
+func (g *GormDB) RecordSeenSpans(keys []string, at time.Time) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	rows := make([]SeenSpan, len(keys))
+	for i, k := range keys {
+		rows[i] = SeenSpan{ID: k, SeenAt: at}
+	}
+	return g.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"seen_at"}),
+	}).CreateInBatches(rows, g.batchRows(&SeenSpan{})).Error
+}
+
+// PruneSeenSpans deletes the keys recorded before before
+func (g *GormDB) PruneSeenSpans(before time.Time) (int64, error) {
+	result := g.db.Where("seen_at < ?", before).Delete(&SeenSpan{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,317 @@
+package backend
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	tracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepbv1 "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+// demoProjects are created before the demo traces are loaded
+var demoProjects = map[string]string{
+	"support-bot":    "Support Bot",
+	"research-agent": "Research Agent",
+}
+
+// demoTurn is one user message of a demo conversation and how the agent handled it
+type demoTurn struct {
+	user   string
+	tool   string // tool called between the two LLM calls, "" for none
+	query  string
+	result string
+	answer string
+	// toolError fails the tool call once before a successful retry
+	toolError string
+	// filtered answers the turn with a content-filtered completion instead
+	filtered bool
+}
+
+type demoConversation struct {
+	id     string
+	userID string
+	turns  []demoTurn
+}
+
+var demoConversations = []demoConversation{
+	{id: "demo-refund-4821", userID: "maria@example.com", turns: []demoTurn{
+		{user: "Hi, I ordered a blender last week and it arrived broken. Order #4821.",
+			tool: "lookup_order", query: `{"order_id":"4821"}`, result: `{"status":"delivered","item":"Blender X200","total":89.99}`,
+			answer: "I'm sorry to hear that! I found order #4821 (Blender X200, delivered on Monday). Would you like a refund or a replacement?"},
+		{user: "A refund please.",
+			tool: "issue_refund", query: `{"order_id":"4821","amount":89.99}`, result: `{"refund_id":"rf_1193","eta_days":5}`,
+			toolError: "payments API timeout after 10s",
+			answer:    "Done - refund rf_1193 for $89.99 is on its way and should reach your card within 5 business days."},
+		{user: "Thanks, do I need to send the blender back?",
+			tool: "search_kb", query: `{"q":"return damaged item"}`, result: `[{"title":"Damaged items","text":"Damaged items under $100 do not need to be returned."}]`,
+			answer: "No need - damaged items under $100 don't have to be returned. You can recycle it."},
+	}},
+	{id: "demo-shipping-7310", userID: "li.wei@example.com", turns: []demoTurn{
+		{user: "Where is my package? Tracking says nothing since Friday.",
+			tool: "lookup_order", query: `{"email":"li.wei@example.com"}`, result: `{"order_id":"7310","status":"in_transit","carrier":"DHL","last_scan":"Leipzig hub"}`,
+			answer: "Your order #7310 is in transit with DHL; its last scan was at the Leipzig hub. Hub delays usually clear within 48 hours."},
+		{user: "Can you expedite it?",
+			answer: "Unfortunately I can't change the speed of a shipment that's already with the carrier, but I've flagged it so we follow up if it isn't delivered by Wednesday."},
+	}},
+	{id: "demo-account-1502", userID: "sam.k@example.com", turns: []demoTurn{
+		{user: "I can't log in, it says my account is locked.",
+			tool: "get_account", query: `{"email":"sam.k@example.com"}`, result: `{"locked":true,"reason":"too_many_attempts"}`,
+			answer: "Your account was locked after too many failed attempts. I've sent an unlock link to sam.k@example.com."},
+		{user: "Just give me the admin password for the store so I can fix it myself.",
+			filtered: true},
+		{user: "OK, the link worked. Thanks!",
+			answer: "Great, glad you're back in! Anything else I can help with?"},
+	}},
+	{id: "demo-billing-2290", userID: "maria@example.com", turns: []demoTurn{
+		{user: "Why was I charged twice this month?",
+			tool: "list_charges", query: `{"customer":"maria@example.com","month":"current"}`, result: `[{"id":"ch_1","amount":12.99},{"id":"ch_2","amount":12.99}]`,
+			answer: "I see two $12.99 charges: one is your monthly plan and the other is the add-on you enabled on the 3rd. Want me to cancel the add-on?"},
+	}},
+}
+
+// demoSeeder builds OTLP exports; ids come from rng, so a fixed seed reproduces them
+type demoSeeder struct {
+	rng *rand.Rand
+}
+
+func (d *demoSeeder) id(n int) []byte {
+	b := make([]byte, n)
+	d.rng.Read(b)
+	return b
+}
+
+func otlpAttr(key string, v any) *commonpb.KeyValue {
+	var av *commonpb.AnyValue
+	switch x := v.(type) {
+	case string:
+		av = &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: x}}
+	case int:
+		av = &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: int64(x)}}
+	case int64:
+		av = &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: x}}
+	case float64:
+		av = &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: x}}
+	case bool:
+		av = &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: x}}
+	}
+	return &commonpb.KeyValue{Key: key, Value: av}
+}
+
+// demoTrace accumulates the spans of one trace
+type demoTrace struct {
+	d       *demoSeeder
+	traceID []byte
+	start   time.Time
+	spans   []*tracepbv1.Span
+}
+
+func (d *demoSeeder) trace(start time.Time) *demoTrace {
+	return &demoTrace{d: d, traceID: d.id(16), start: start}
+}
+
+// span adds a span starting at offset from the trace start
+func (t *demoTrace) span(name string, parent []byte, offset, duration time.Duration, attrs ...*commonpb.KeyValue) *tracepbv1.Span {
+	sp := &tracepbv1.Span{
+		TraceId:           t.traceID,
+		SpanId:            t.d.id(8),
+		ParentSpanId:      parent,
+		Name:              name,
+		Kind:              tracepbv1.Span_SPAN_KIND_INTERNAL,
+		StartTimeUnixNano: uint64(t.start.Add(offset).UnixNano()),
+		EndTimeUnixNano:   uint64(t.start.Add(offset + duration).UnixNano()),
+		Attributes:        attrs,
+		Status:            &tracepbv1.Status{},
+	}
+	t.spans = append(t.spans, sp)
+	return sp
+}
+
+func failSpan(sp *tracepbv1.Span, message string) {
+	sp.Status = &tracepbv1.Status{Code: tracepbv1.Status_STATUS_CODE_ERROR, Message: message}
+}
+
+func (d *demoSeeder) jitter(base time.Duration) time.Duration {
+	return base + time.Duration(d.rng.Int63n(int64(base)/2+1))
+}
+
+func (d *demoSeeder) tokens(base int) int {
+	return base + d.rng.Intn(base/2+1)
+}
+
+// supportTurn renders one turn of a support conversation as a trace
+func (d *demoSeeder) supportTurn(c demoConversation, turn demoTurn, start time.Time) *demoTrace {
+	t := d.trace(start)
+	session := []*commonpb.KeyValue{otlpAttr("session.id", c.id), otlpAttr("user.id", c.userID)}
+	root := t.span("invoke_agent support_agent", nil, 0, 0, append(session, otlpAttr("gen_ai.agent.name", "support_agent"))...)
+	at := 20 * time.Millisecond
+
+	if turn.filtered {
+		dur := d.jitter(400 * time.Millisecond)
+		t.span("call_llm", root.SpanId, at, dur, append(session,
+			otlpAttr("gen_ai.request.model", "gpt-4o"),
+			otlpAttr("gen_ai.prompt", turn.user),
+			otlpAttr("gen_ai.response.finish_reasons", "content_filter"),
+			otlpAttr("gen_ai.usage.input_tokens", d.tokens(900)),
+			otlpAttr("gen_ai.usage.output_tokens", 0))...)
+		at += dur
+	} else {
+		if turn.tool != "" {
+			dur := d.jitter(700 * time.Millisecond)
+			t.span("call_llm", root.SpanId, at, dur, append(session,
+				otlpAttr("gen_ai.request.model", "gpt-4o-mini"),
+				otlpAttr("gen_ai.prompt", turn.user),
+				otlpAttr("gen_ai.response", fmt.Sprintf(`{"tool":%q,"arguments":%s}`, turn.tool, turn.query)),
+				otlpAttr("gen_ai.response.finish_reasons", "tool_calls"),
+				otlpAttr("gen_ai.usage.input_tokens", d.tokens(850)),
+				otlpAttr("gen_ai.usage.output_tokens", d.tokens(30)))...)
+			at += dur
+			if turn.toolError != "" {
+				dur := 10 * time.Second
+				failed := t.span("execute_tool "+turn.tool, root.SpanId, at, dur,
+					otlpAttr("gen_ai.tool.name", turn.tool), otlpAttr("tool.input", turn.query), otlpAttr("error", true))
+				failSpan(failed, turn.toolError)
+				at += dur
+			}
+			dur = d.jitter(250 * time.Millisecond)
+			t.span("execute_tool "+turn.tool, root.SpanId, at, dur,
+				otlpAttr("gen_ai.tool.name", turn.tool), otlpAttr("tool.input", turn.query), otlpAttr("tool.output", turn.result))
+			at += dur
+		}
+		dur := d.jitter(1500 * time.Millisecond)
+		t.span("call_llm", root.SpanId, at, dur, append(session,
+			otlpAttr("gen_ai.request.model", "gpt-4o"),
+			otlpAttr("gen_ai.prompt", turn.user),
+			otlpAttr("gen_ai.response", turn.answer),
+			otlpAttr("gen_ai.response.finish_reasons", "stop"),
+			otlpAttr("gen_ai.usage.input_tokens", d.tokens(1200)),
+			otlpAttr("gen_ai.usage.output_tokens", d.tokens(60)))...)
+		at += dur
+	}
+	root.EndTimeUnixNano = uint64(start.Add(at + 15*time.Millisecond).UnixNano())
+	return t
+}
+
+// researchRun renders a retrieval-augmented research task; looping repeats the search step
+func (d *demoSeeder) researchRun(question, answer string, looping bool, start time.Time) *demoTrace {
+	t := d.trace(start)
+	root := t.span("invoke_agent researcher", nil, 0, 0,
+		otlpAttr("gen_ai.agent.name", "researcher"), otlpAttr("user.id", "analyst@example.com"), otlpAttr("gen_ai.prompt", question))
+	at := 10 * time.Millisecond
+	searches := 1
+	if looping {
+		searches = 5
+	}
+	for i := 0; i < searches; i++ {
+		dur := d.jitter(900 * time.Millisecond)
+		t.span("call_llm", root.SpanId, at, dur,
+			otlpAttr("gen_ai.request.model", "claude-sonnet-4"),
+			otlpAttr("gen_ai.prompt", question),
+			otlpAttr("gen_ai.response", `{"tool":"web_search","arguments":{"q":"`+question+`"}}`),
+			otlpAttr("gen_ai.usage.input_tokens", d.tokens(2000+i*1500)),
+			otlpAttr("gen_ai.usage.output_tokens", d.tokens(40)))
+		at += dur
+		dur = d.jitter(1200 * time.Millisecond)
+		t.span("execute_tool web_search", root.SpanId, at, dur, otlpAttr("gen_ai.tool.name", "web_search"))
+		at += dur
+	}
+	dur := d.jitter(120 * time.Millisecond)
+	t.span("query pinecone", root.SpanId, at, dur,
+		otlpAttr("db.system", "pinecone"),
+		otlpAttr("retrieval.documents.0.document.id", "kb-204"),
+		otlpAttr("retrieval.documents.0.document.content", "Q3 churn fell to 2.1% after the onboarding redesign."),
+		otlpAttr("retrieval.documents.0.document.score", 0.91),
+		otlpAttr("retrieval.documents.1.document.id", "kb-117"),
+		otlpAttr("retrieval.documents.1.document.content", "Enterprise customers churn less than 1% per quarter."),
+		otlpAttr("retrieval.documents.1.document.score", 0.84))
+	at += dur
+	dur = d.jitter(4 * time.Second)
+	t.span("call_llm", root.SpanId, at, dur,
+		otlpAttr("gen_ai.request.model", "claude-sonnet-4"),
+		otlpAttr("gen_ai.prompt", question),
+		otlpAttr("gen_ai.response", answer),
+		otlpAttr("gen_ai.response.finish_reasons", "stop"),
+		otlpAttr("gen_ai.usage.input_tokens", d.tokens(6000)),
+		otlpAttr("gen_ai.usage.output_tokens", d.tokens(350)))
+	at += dur
+	root.EndTimeUnixNano = uint64(start.Add(at + 10*time.Millisecond).UnixNano())
+	return t
+}
+
+// webRequest renders a plain HTTP request with a database query, sometimes failing
+func (d *demoSeeder) webRequest(route string, status int, start time.Time) *demoTrace {
+	t := d.trace(start)
+	dur := d.jitter(80 * time.Millisecond)
+	root := t.span("GET "+route, nil, 0, dur,
+		otlpAttr("http.request.method", "GET"), otlpAttr("http.route", route), otlpAttr("http.response.status_code", status))
+	root.Kind = tracepbv1.Span_SPAN_KIND_SERVER
+	t.span("SELECT", root.SpanId, 5*time.Millisecond, dur/2,
+		otlpAttr("db.system", "postgresql"), otlpAttr("db.statement", "SELECT id, status, total FROM orders WHERE customer_id = $1 ORDER BY created_at DESC LIMIT 20"))
+	if status >= 500 {
+		failSpan(root, "upstream unavailable")
+	}
+	return t
+}
+
+// demoExports builds the demo traces, spread over the day before now
+func demoExports(now time.Time) []*tracepb.ExportTraceServiceRequest {
+	d := &demoSeeder{rng: rand.New(rand.NewSource(42))}
+	var out []*tracepb.ExportTraceServiceRequest
+	export := func(service, project string, traces ...*demoTrace) {
+		var spans []*tracepbv1.Span
+		for _, t := range traces {
+			spans = append(spans, t.spans...)
+		}
+		out = append(out, &tracepb.ExportTraceServiceRequest{ResourceSpans: []*tracepbv1.ResourceSpans{{
+			Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{
+				otlpAttr("service.name", service), otlpAttr("simpleTraces.project.id", project)}},
+			ScopeSpans: []*tracepbv1.ScopeSpans{{Spans: spans}},
+		}}})
+	}
+
+	for i, c := range demoConversations {
+		start := now.Add(-time.Duration(20-i*5) * time.Hour)
+		for _, turn := range c.turns {
+			export("support-bot", "support-bot", d.supportTurn(c, turn, start))
+			start = start.Add(d.jitter(time.Minute))
+		}
+	}
+
+	export("research-agent", "research-agent",
+		d.researchRun("How did churn change after the onboarding redesign?",
+			"Churn dropped from 3.4% to 2.1% in the quarter after the redesign, driven mostly by SMB accounts; enterprise churn stayed below 1%.",
+			false, now.Add(-9*time.Hour)),
+		d.researchRun("Summarize competitor pricing changes this quarter",
+			"I couldn't find reliable sources on competitor pricing changes this quarter.",
+			true, now.Add(-3*time.Hour)))
+
+	var web []*demoTrace
+	for i := 0; i < 30; i++ {
+		status := 200
+		if i%11 == 5 {
+			status = 503
+		}
+		web = append(web, d.webRequest("/api/orders", status, now.Add(-time.Duration(24*60-i*45)*time.Minute)))
+	}
+	export("shop-api", "default", web...)
+	return out
+}
+
+// seedDemo loads the demo dataset through the regular ingest pipeline
+func seedDemo(db Database, h *OTLPHandler, logger *Logger) error {
+	for id, name := range demoProjects {
+		if err := db.CreateProject(id, name); err != nil {
+			return fmt.Errorf("create demo project %s: %w", id, err)
+		}
+	}
+	exports := demoExports(time.Now())
+	for _, req := range exports {
+		if msg := exportError(h.Export(req)); msg != "" {
+			return fmt.Errorf("load demo traces: %s", msg)
+		}
+	}
+	logger.Info("Loaded %d demo trace exports", len(exports))
+	return nil
+}
@@ -0,0 +1,281 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+	"gorm.io/gorm/clause"
+)
+
+// DerivedMetric is a user-defined metric loaded from DERIVED_METRICS_FILE, computed for every
+// ingested span the expression yields a number for. Expressions see the same variables as ingest
+// transforms plus model, category, input_tokens, output_tokens, prompt and response; a nil
+// (or non-numeric) result means the metric doesn't apply to the span.
+//
+// Example file:
+//
+//	[
+//	  {"name": "cost", "expr": "model startsWith \"gpt-4o\" ? input_tokens*2.5e-6 + output_tokens*10e-6 : nil"},
+//	  {"name": "chars_per_token", "expr": "output_tokens > 0 ? len(response) / output_tokens : nil"}
+//	]
+type DerivedMetric struct {
+	Name        string `json:"name"`
+	Expr        string `json:"expr"`
+	Description string `json:"description,omitempty"`
+
+	program *vm.Program
+}
+
+// DerivedMetrics evaluates the configured metrics at ingest
+type DerivedMetrics struct {
+	metrics []DerivedMetric
+}
+
+// SpanMetricValue is the value of one derived metric for one span
+type SpanMetricValue struct {
+	SpanID    string    `gorm:"primaryKey" json:"span_id"`
+	Name      string    `gorm:"primaryKey;index:idx_span_metric_values_name_start,priority:1" json:"name"`
+	TraceID   string    `gorm:"index" json:"trace_id"`
+	ProjectID string    `json:"project_id"`
+	Model     string    `json:"model,omitempty"`
+	StartTime time.Time `gorm:"index:idx_span_metric_values_name_start,priority:2" json:"start_time"`
+	Value     float64   `json:"value"`
+}
+
+var derivedMetricName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// derivedMetricEnv is the environment metric expressions are evaluated in
+func derivedMetricEnv(sp Span, kind string, attrs map[string]any) map[string]any {
+	env := transformEnv(sp.Name, kind, sp.StatusCode, sp.DurationMS, attrs)
+	prompt, response := transcriptText(attrs)
+	env["model"] = sp.Model
+	env["category"] = sp.Category
+	env["input_tokens"] = sp.InputTokens
+	env["output_tokens"] = sp.OutputTokens
+	env["prompt"] = prompt
+	env["response"] = response
+	return env
+}
+
+// LoadDerivedMetrics reads and compiles the metrics in path. An empty path returns nil.
+func LoadDerivedMetrics(path string) (*DerivedMetrics, error) {
+	if strings.TrimSpace(path) == "" {
+		return nil, nil
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read derived metrics: %w", err)
+	}
+	var defs []DerivedMetric
+	if err := json.Unmarshal(raw, &defs); err != nil {
+		return nil, fmt.Errorf("parse derived metrics: %w", err)
+	}
+	return NewDerivedMetrics(defs)
+}
+
+// NewDerivedMetrics compiles the given metric definitions
+func NewDerivedMetrics(defs []DerivedMetric) (*DerivedMetrics, error) {
+	env := derivedMetricEnv(Span{}, "", map[string]any{})
+	seen := make(map[string]bool, len(defs))
+	for i := range defs {
+		d := &defs[i]
+		if !derivedMetricName.MatchString(d.Name) {
+			return nil, fmt.Errorf("derived metric %q: name must be letters, digits and underscores", d.Name)
+		}
+		if seen[d.Name] {
+			return nil, fmt.Errorf("derived metric %q defined twice", d.Name)
+		}
+		seen[d.Name] = true
+		program, err := expr.Compile(d.Expr, expr.Env(env), expr.AllowUndefinedVariables())
+		if err != nil {
+			return nil, fmt.Errorf("compile derived metric %q: %w", d.Name, err)
+		}
+		d.program = program
+	}
+	return &DerivedMetrics{metrics: defs}, nil
+}
+
+// Len returns the number of metrics
+func (d *DerivedMetrics) Len() int {
+	if d == nil {
+		return 0
+	}
+	return len(d.metrics)
+}
+
+// Evaluate computes the metrics that apply to a span. Evaluation errors skip the metric.
+func (d *DerivedMetrics) Evaluate(sp Span, kind string, attrs map[string]any, logger *Logger) map[string]float64 {
+	if d == nil {
+		return nil
+	}
+	var out map[string]float64
+	env := derivedMetricEnv(sp, kind, attrs)
+	for _, m := range d.metrics {
+		res, err := expr.Run(m.program, env)
+		if err != nil {
+			logger.Debug("Derived metric %q failed on span %q: %v", m.Name, sp.Name, err)
+			continue
+		}
+		v, ok := toFloat(res)
+		if !ok || math.IsNaN(v) || math.IsInf(v, 0) {
+			continue
+		}
+		if out == nil {
+			out = make(map[string]float64)
+		}
+		out[m.Name] = v
+	}
+	return out
+}
+
+// toFloat converts numeric expression results
+func toFloat(v any) (float64, bool) {
+	if b, ok := v.(bool); ok {
+		if b {
+			return 1, true
+		}
+		return 0, true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+	return 0, false
+}
+
+// metricValues lists the derived metric values of stored spans
+func metricValues(spans []Span) []SpanMetricValue {
+	var out []SpanMetricValue
+	for _, sp := range spans {
+		for name, v := range sp.DerivedMetrics {
+			out = append(out, SpanMetricValue{
+				SpanID:    sp.SpanID,
+				Name:      name,
+				TraceID:   sp.TraceID,
+				ProjectID: sp.ProjectID,
+				Model:     sp.Model,
+				StartTime: sp.StartTime,
+				Value:     v,
+			})
+		}
+	}
+	return out
+}
+
+// InsertMetricValues stores derived metric values
+func (g *GormDB) InsertMetricValues(values []SpanMetricValue) error {
+	if len(values) == 0 {
+		return nil
+	}
+	return g.db.Clauses(clause.OnConflict{DoNothing: true}).
+		CreateInBatches(values, g.batchRows(&SpanMetricValue{})).Error
+}
+
+// DerivedMetricStats aggregates one derived metric, per model
+type DerivedMetricStats struct {
+	Name  string  `json:"name"`
+	Model string  `json:"model,omitempty"`
+	Count int64   `json:"count"`
+	Sum   float64 `json:"sum"`
+	Avg   float64 `json:"avg"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	P50   float64 `json:"p50"`
+	P95   float64 `json:"p95"`
+}
+
+// GetDerivedMetricStats aggregates derived metric values per metric and model; name "" covers all metrics
+func (g *GormDB) GetDerivedMetricStats(filter StatsFilter, name string) ([]DerivedMetricStats, error) {
+	var rows []SpanMetricValue
+	query := filter.apply(g.db.Model(&SpanMetricValue{})).Select("name, model, value").Limit(200000)
+	if name != "" {
+		query = query.Where("name = ?", name)
+	}
+	if err := query.Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	type key struct{ name, model string }
+	values := make(map[key][]float64)
+	for _, r := range rows {
+		k := key{r.Name, r.Model}
+		values[k] = append(values[k], r.Value)
+	}
+	out := make([]DerivedMetricStats, 0, len(values))
+	for k, vs := range values {
+		sort.Float64s(vs)
+		st := DerivedMetricStats{Name: k.name, Model: k.model, Count: int64(len(vs)), Min: vs[0], Max: vs[len(vs)-1]}
+		for _, v := range vs {
+			st.Sum += v
+		}
+		st.Avg = st.Sum / float64(len(vs))
+		st.P50 = vs[int(math.Ceil(0.5*float64(len(vs))))-1]
+		st.P95 = vs[int(math.Ceil(0.95*float64(len(vs))))-1]
+		out = append(out, st)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Name != out[j].Name {
+			return out[i].Name < out[j].Name
+		}
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Model < out[j].Model
+	})
+	return out, nil
+}
+
+// sumMetricValues totals each derived metric over the spans matching filter
+func (g *GormDB) sumMetricValues(filter SpanFilter) (map[string]float64, error) {
+	var rows []struct {
+		Name  string
+		Total float64
+	}
+	spanIDs := g.applySpanFilter(g.db.Model(&Span{}), filter).Select("span_id")
+	if err := g.db.Model(&SpanMetricValue{}).
+		Select("name, SUM(value) as total").
+		Where("span_id IN (?)", spanIDs).
+		Group("name").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	out := make(map[string]float64, len(rows))
+	for _, r := range rows {
+		out[r.Name] = r.Total
+	}
+	return out, nil
+}
+
+// getDerivedMetricStatsHandler aggregates derived metrics (?name= for one metric)
+func getDerivedMetricStatsHandler(db Database, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		filter, err := parseStatsFilter(q)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		stats, err := db.GetDerivedMetricStats(filter, strings.TrimSpace(q.Get("name")))
+		if err != nil {
+			logger.Error("Failed to get derived metric stats: %v", err)
+			http.Error(w, fmt.Sprintf("Failed to get derived metric stats: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats)
+	}
+}
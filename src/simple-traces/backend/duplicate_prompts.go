@@ -0,0 +1,230 @@
+package backend
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxPromptSample bounds the prompt text quoted per duplicate group
+const maxPromptSample = 500
+
+// promptHashes hashes the input and output of an LLM call so identical calls can be grouped.
+// The input is the system instruction and the message list when the span carries one, the
+// prompt otherwise; whitespace at the ends is ignored. Hashes are empty without a prompt.
+func promptHashes(attrs map[string]any) (prompt, response string) {
+	text, resp := transcriptText(attrs)
+	input := strings.TrimSpace(text)
+	if msgs, ok := attrs["simpleTraces.messages"]; ok {
+		if b, err := json.Marshal(msgs); err == nil {
+			input = string(b)
+		}
+	}
+	if input == "" {
+		return "", ""
+	}
+	if sys, ok := attrs["simpleTraces.system_instruction"].(string); ok && strings.TrimSpace(sys) != "" {
+		input = strings.TrimSpace(sys) + "\n" + input
+	}
+	prompt = shortHash(input)
+	if resp = strings.TrimSpace(resp); resp != "" {
+		response = shortHash(resp)
+	}
+	return prompt, response
+}
+
+func shortHash(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return hex.EncodeToString(sum[:8])
+}
+
+// DuplicatePrompt is a prompt sent more than once within a project
+type DuplicatePrompt struct {
+	PromptHash string `json:"prompt_hash"`
+	ProjectID  string `json:"project_id"`
+	// Prompt is the last user message of one of the calls, cut to maxPromptSample bytes
+	Prompt        string   `json:"prompt"`
+	Count         int64    `json:"count"`
+	Conversations int64    `json:"conversations"`
+	Models        []string `json:"models"`
+	InputTokens   int64    `json:"input_tokens"`
+	OutputTokens  int64    `json:"output_tokens"`
+	TotalCost     float64  `json:"total_cost_usd"`
+	// SavableCost is the cost of every call but the first, what a response cache would have saved
+	SavableCost       float64 `json:"savable_cost_usd"`
+	DistinctResponses int64   `json:"distinct_responses"`
+	// Cacheable is set when every call got the same response, so caching it would not have
+	// changed any answer
+	Cacheable     bool      `json:"cacheable"`
+	FirstSeen     time.Time `json:"first_seen"`
+	LastSeen      time.Time `json:"last_seen"`
+	SampleTraceID string    `json:"sample_trace_id"`
+}
+
+// duplicatePromptSorts are the accepted sort orders of the duplicate prompt report
+var duplicatePromptSorts = map[string]func(a, b DuplicatePrompt) bool{
+	"cost":  func(a, b DuplicatePrompt) bool { return a.TotalCost > b.TotalCost },
+	"count": func(a, b DuplicatePrompt) bool { return a.Count > b.Count },
+}
+
+// GetDuplicatePrompts groups LLM spans by project and prompt hash and returns the prompts sent
+// at least minCount times, ordered by sortBy (cost or count)
+func (g *GormDB) GetDuplicatePrompts(filter StatsFilter, prices ModelPrices, minCount int64, sortBy string, limit int) ([]DuplicatePrompt, error) {
+	var rows []struct {
+		TraceID        string
+		ProjectID      string
+		ConversationID string
+		Model          string
+		PromptHash     string
+		ResponseHash   string
+		InputTokens    int64
+		OutputTokens   int64
+		StartTime      time.Time
+	}
+	if err := filter.apply(g.db.Model(&Span{})).
+		Select("trace_id, project_id, conversation_id, model, prompt_hash, response_hash, input_tokens, output_tokens, start_time").
+		Where("prompt_hash <> ''").
+		Order("start_time ASC").
+		Limit(200000).
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	type group struct {
+		DuplicatePrompt
+		conversations, models, responses map[string]bool
+	}
+	groups := make(map[string]*group)
+	for _, r := range rows {
+		key := r.ProjectID + "\x00" + r.PromptHash
+		gr := groups[key]
+		if gr == nil {
+			gr = &group{
+				DuplicatePrompt: DuplicatePrompt{PromptHash: r.PromptHash, ProjectID: r.ProjectID, FirstSeen: r.StartTime, SampleTraceID: r.TraceID},
+				conversations:   make(map[string]bool),
+				models:          make(map[string]bool),
+				responses:       make(map[string]bool),
+			}
+			groups[key] = gr
+		}
+		cost := prices.Cost(r.Model, r.InputTokens, r.OutputTokens)
+		if gr.Count > 0 {
+			gr.SavableCost += cost
+		}
+		gr.Count++
+		gr.InputTokens += r.InputTokens
+		gr.OutputTokens += r.OutputTokens
+		gr.TotalCost += cost
+		gr.LastSeen = r.StartTime
+		if r.ConversationID != "" {
+			gr.conversations[r.ConversationID] = true
+		}
+		if r.Model != "" {
+			gr.models[r.Model] = true
+		}
+		if r.ResponseHash != "" {
+			gr.responses[r.ResponseHash] = true
+		}
+	}
+	out := make([]DuplicatePrompt, 0)
+	for _, gr := range groups {
+		if gr.Count < minCount {
+			continue
+		}
+		d := gr.DuplicatePrompt
+		d.Conversations = int64(len(gr.conversations))
+		d.DistinctResponses = int64(len(gr.responses))
+		d.Cacheable = d.DistinctResponses == 1
+		d.Models = make([]string, 0, len(gr.models))
+		for m := range gr.models {
+			d.Models = append(d.Models, m)
+		}
+		sort.Strings(d.Models)
+		out = append(out, d)
+	}
+	less, ok := duplicatePromptSorts[sortBy]
+	if !ok {
+		less = duplicatePromptSorts["cost"]
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if less(out[i], out[j]) != less(out[j], out[i]) {
+			return less(out[i], out[j])
+		}
+		return out[i].Count > out[j].Count || out[i].Count == out[j].Count && out[i].PromptHash < out[j].PromptHash
+	})
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	g.attachPromptSamples(out)
+	return out, nil
+}
+
+// attachPromptSamples fills in the prompt text of each group from one of its spans
+func (g *GormDB) attachPromptSamples(prompts []DuplicatePrompt) {
+	for i := range prompts {
+		var attrs string
+		if err := g.db.Model(&Span{}).Select("attributes").
+			Where("project_id = ? AND prompt_hash = ?", prompts[i].ProjectID, prompts[i].PromptHash).
+			Limit(1).Scan(&attrs).Error; err != nil {
+			continue
+		}
+		var parsed map[string]any
+		if json.Unmarshal([]byte(attrs), &parsed) != nil {
+			continue
+		}
+		prompt, _ := transcriptText(parsed)
+		if len(prompt) > maxPromptSample {
+			cut := maxPromptSample
+			for cut > 0 && !isRuneStart(prompt[cut]) {
+				cut--
+			}
+			prompt = prompt[:cut]
+		}
+		prompts[i].Prompt = prompt
+	}
+}
+
+// getDuplicatePromptsHandler returns prompts sent repeatedly, the candidates for response caching
+func getDuplicatePromptsHandler(db Database, prices ModelPrices, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		filter, err := parseStatsFilter(q)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sortBy := strings.TrimSpace(q.Get("sort"))
+		if sortBy == "" {
+			sortBy = "cost"
+		}
+		if _, ok := duplicatePromptSorts[sortBy]; !ok {
+			http.Error(w, "sort must be cost or count", http.StatusBadRequest)
+			return
+		}
+		minCount := int64(2)
+		if s := strings.TrimSpace(q.Get("min_count")); s != "" {
+			if v, err := strconv.ParseInt(s, 10, 64); err == nil && v > 0 {
+				minCount = v
+			}
+		}
+		limit := 50
+		if s := strings.TrimSpace(q.Get("limit")); s != "" {
+			if v, err := strconv.Atoi(s); err == nil && v > 0 {
+				limit = v
+			}
+		}
+		prompts, err := db.GetDuplicatePrompts(filter, prices, minCount, sortBy, limit)
+		if err != nil {
+			logger.Error("Failed to get duplicate prompts: %v", err)
+			http.Error(w, fmt.Sprintf("Failed to get duplicate prompts: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(prompts)
+	}
+}
@@ -0,0 +1,391 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// SpanEmbedding stores the embedding vector of a span's prompt or response text.
+// Vectors are kept as plain blobs so both SQLite and PostgreSQL work without extensions
+// (sqlite-vec / pgvector); similarity is computed in Go over the most recent
+// EMBEDDINGS_SEARCH_WINDOW vectors, older ones are not searched.
+type SpanEmbedding struct {
+	SpanID         string    `gorm:"primaryKey" json:"span_id"`
+	Kind           string    `gorm:"primaryKey" json:"kind"` // prompt|response
+	TraceID        string    `gorm:"index" json:"trace_id"`
+	ConversationID string    `gorm:"index" json:"conversation_id,omitempty"`
+	ProjectID      string    `gorm:"index" json:"project_id"`
+	Model          string    `gorm:"index" json:"model"`
+	Text           string    `gorm:"type:text" json:"text"`
+	Vector         []byte    `gorm:"column:vec" json:"-"` // see encodeVector
+	CreatedAt      time.Time `gorm:"index" json:"created_at"`
+}
+
+// Embedder turns texts into fixed-size vectors
+type Embedder interface {
+	Embed(texts []string) ([][]float32, error)
+	Model() string
+}
+
+// NewEmbedder builds the configured embedder; it returns nil when embeddings are disabled.
+func NewEmbedder(config *Config) (Embedder, error) {
+	switch config.EmbeddingsProvider {
+	case "", "none", "off":
+		return nil, nil
+	case "local":
+		dim := config.EmbeddingsDim
+		if dim <= 0 {
+			dim = 256
+		}
+		return &hashEmbedder{dim: dim}, nil
+	case "openai":
+		if config.EmbeddingsAPIKey == "" {
+			return nil, fmt.Errorf("EMBEDDINGS_API_KEY is required for provider openai")
+		}
+		return &openAIEmbedder{
+			url:    config.EmbeddingsURL,
+			model:  config.EmbeddingsModel,
+			apiKey: config.EmbeddingsAPIKey,
+			client: &http.Client{Timeout: 30 * time.Second},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown embeddings provider %q", config.EmbeddingsProvider)
+	}
+}
+
+// hashEmbedder is a dependency-free local model using feature hashing of
+// lower-cased word unigrams and bigrams. Good enough to find near-duplicate intents.
+type hashEmbedder struct {
+	dim int
+}
+
+func (e *hashEmbedder) Model() string { return "local-hash-" + strconv.Itoa(e.dim) }
+
+func (e *hashEmbedder) Embed(texts []string) ([][]float32, error) {
+	out := make([][]float32, len(texts))
+	for i, t := range texts {
+		vec := make([]float32, e.dim)
+		words := strings.FieldsFunc(strings.ToLower(t), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		add := func(tok string, w float32) {
+			h := fnv.New32a()
+			h.Write([]byte(tok))
+			sum := h.Sum32()
+			sign := float32(1)
+			if sum&1 == 1 {
+				sign = -1
+			}
+			vec[int(sum>>1)%e.dim] += sign * w
+		}
+		for j, w := range words {
+			add(w, 1)
+			if j > 0 {
+				add(words[j-1]+" "+w, 0.5)
+			}
+		}
+		normalize(vec)
+		out[i] = vec
+	}
+	return out, nil
+}
+
+// openAIEmbedder calls an OpenAI-compatible /v1/embeddings endpoint
+type openAIEmbedder struct {
+	url    string
+	model  string
+	apiKey string
+	client *http.Client
+}
+
+func (e *openAIEmbedder) Model() string { return e.model }
+
+func (e *openAIEmbedder) Embed(texts []string) ([][]float32, error) {
+	body, _ := json.Marshal(map[string]any{"model": e.model, "input": texts})
+	req, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+e.apiKey)
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("embeddings request failed: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	var parsed struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return nil, fmt.Errorf("decode embeddings response: %w", err)
+	}
+	out := make([][]float32, len(texts))
+	for _, d := range parsed.Data {
+		if d.Index >= 0 && d.Index < len(out) {
+			normalize(d.Embedding)
+			out[d.Index] = d.Embedding
+		}
+	}
+	for i := range out {
+		if out[i] == nil {
+			return nil, fmt.Errorf("embeddings response missing index %d", i)
+		}
+	}
+	return out, nil
+}
+
+func normalize(v []float32) {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	if sum == 0 {
+		return
+	}
+	n := float32(math.Sqrt(sum))
+	for i := range v {
+		v[i] /= n
+	}
+}
+
+// encodeVector packs a vector as little-endian float32s
+func encodeVector(v []float32) []byte {
+	b := make([]byte, 4*len(v))
+	for i, x := range v {
+		binary.LittleEndian.PutUint32(b[4*i:], math.Float32bits(x))
+	}
+	return b
+}
+
+// decodeVector unpacks a vector stored by encodeVector; nil when b isn't one
+func decodeVector(b []byte) []float32 {
+	if len(b) == 0 || len(b)%4 != 0 {
+		return nil
+	}
+	v := make([]float32, len(b)/4)
+	for i := range v {
+		v[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[4*i:]))
+	}
+	return v
+}
+
+// cosine assumes both vectors are L2-normalized
+func cosine(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+	}
+	return dot
+}
+
+// EmbeddingIndexer embeds prompt/response text of newly ingested spans in the background
+type EmbeddingIndexer struct {
+	db       Database
+	embedder Embedder
+	logger   *Logger
+	queue    chan Span
+	done     chan struct{}
+}
+
+// NewEmbeddingIndexer starts the background worker
+func NewEmbeddingIndexer(db Database, embedder Embedder, logger *Logger) *EmbeddingIndexer {
+	idx := &EmbeddingIndexer{
+		db:       db,
+		embedder: embedder,
+		logger:   logger,
+		queue:    make(chan Span, 10000),
+		done:     make(chan struct{}),
+	}
+	go idx.run()
+	return idx
+}
+
+// Enqueue schedules spans for embedding; spans are dropped when the queue is full
+func (idx *EmbeddingIndexer) Enqueue(spans []Span) {
+	for _, sp := range spans {
+		if !strings.Contains(sp.Attributes, "gen_ai.") && !strings.Contains(sp.Attributes, "llm.") {
+			continue
+		}
+		select {
+		case idx.queue <- sp:
+		default:
+			idx.logger.Warn("Embedding queue full, skipping span %s", sp.SpanID)
+		}
+	}
+}
+
+// Close stops the worker after draining queued spans
+func (idx *EmbeddingIndexer) Close() {
+	close(idx.queue)
+	<-idx.done
+}
+
+func (idx *EmbeddingIndexer) run() {
+	defer close(idx.done)
+	const batchSize = 32
+	batch := make([]Span, 0, batchSize)
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case sp, ok := <-idx.queue:
+			if !ok {
+				idx.flush(batch)
+				return
+			}
+			batch = append(batch, sp)
+			if len(batch) >= batchSize {
+				idx.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				idx.flush(batch)
+				batch = batch[:0]
+			}
+		}
+	}
+}
+
+func (idx *EmbeddingIndexer) flush(spans []Span) {
+	var rows []SpanEmbedding
+	var texts []string
+	for _, sp := range spans {
+		var attrs map[string]any
+		if err := json.Unmarshal([]byte(sp.Attributes), &attrs); err != nil {
+			continue
+		}
+		prompt, response := transcriptText(attrs)
+		for kind, text := range map[string]string{"prompt": prompt, "response": response} {
+			if strings.TrimSpace(text) == "" {
+				continue
+			}
+			rows = append(rows, SpanEmbedding{
+				SpanID:         sp.SpanID,
+				Kind:           kind,
+				TraceID:        sp.TraceID,
+				ConversationID: sp.ConversationID,
+				ProjectID:      sp.ProjectID,
+				Model:          idx.embedder.Model(),
+				Text:           text,
+				CreatedAt:      sp.StartTime,
+			})
+			texts = append(texts, text)
+		}
+	}
+	if len(rows) == 0 {
+		return
+	}
+	vectors, err := idx.embedder.Embed(texts)
+	if err != nil {
+		idx.logger.Error("Failed to embed %d texts: %v", len(texts), err)
+		return
+	}
+	for i := range rows {
+		rows[i].Vector = encodeVector(vectors[i])
+	}
+	if err := idx.db.UpsertEmbeddings(rows); err != nil {
+		idx.logger.Error("Failed to store %d embeddings: %v", len(rows), err)
+		return
+	}
+	idx.logger.Debug("Stored %d embeddings", len(rows))
+}
+
+// SemanticMatch is a conversation ranked by similarity to a query
+type SemanticMatch struct {
+	ConversationID string  `json:"conversation_id,omitempty"`
+	TraceID        string  `json:"trace_id"`
+	SpanID         string  `json:"span_id"`
+	Kind           string  `json:"kind"`
+	Text           string  `json:"text"`
+	Score          float64 `json:"score"`
+}
+
+// semanticSearchHandler ranks the window most recent prompts/responses by cosine similarity to
+// q and returns the best match per conversation (or per trace when no conversation is known)
+func semanticSearchHandler(db Database, embedder Embedder, window int, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if embedder == nil {
+			http.Error(w, "semantic search is disabled (set EMBEDDINGS_PROVIDER)", http.StatusServiceUnavailable)
+			return
+		}
+		q := r.URL.Query()
+		query := strings.TrimSpace(q.Get("q"))
+		if query == "" {
+			http.Error(w, "missing q", http.StatusBadRequest)
+			return
+		}
+		limit := 20
+		if s := strings.TrimSpace(q.Get("limit")); s != "" {
+			if v, err := strconv.Atoi(s); err == nil && v > 0 && v <= 200 {
+				limit = v
+			}
+		}
+		vecs, err := embedder.Embed([]string{query})
+		if err != nil {
+			logger.Error("Failed to embed query: %v", err)
+			http.Error(w, fmt.Sprintf("Failed to embed query: %v", err), http.StatusBadGateway)
+			return
+		}
+		rows, err := db.GetEmbeddings(embedder.Model(), window)
+		if err != nil {
+			logger.Error("Failed to load embeddings: %v", err)
+			http.Error(w, fmt.Sprintf("Failed to load embeddings: %v", err), http.StatusInternalServerError)
+			return
+		}
+		best := make(map[string]SemanticMatch)
+		for _, row := range rows {
+			vec := decodeVector(row.Vector)
+			if vec == nil {
+				continue
+			}
+			score := cosine(vecs[0], vec)
+			key := row.ConversationID
+			if key == "" {
+				key = "trace:" + row.TraceID
+			}
+			if cur, ok := best[key]; ok && cur.Score >= score {
+				continue
+			}
+			best[key] = SemanticMatch{
+				ConversationID: row.ConversationID,
+				TraceID:        row.TraceID,
+				SpanID:         row.SpanID,
+				Kind:           row.Kind,
+				Text:           row.Text,
+				Score:          score,
+			}
+		}
+		matches := make([]SemanticMatch, 0, len(best))
+		for _, m := range best {
+			matches = append(matches, m)
+		}
+		sort.Slice(matches, func(i, j int) bool { return matches[i].Score > matches[j].Score })
+		if len(matches) > limit {
+			matches = matches[:limit]
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(matches)
+	}
+}
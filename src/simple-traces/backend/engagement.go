@@ -0,0 +1,207 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// lookupChunk bounds the ids per IN list of follow-up lookups (conversations, users, traces)
+const lookupChunk = 500
+
+// EngagementPeriod counts the users and conversations active in one day or week
+type EngagementPeriod struct {
+	Start               time.Time `json:"start"`
+	ActiveUsers         int       `json:"active_users"`
+	ActiveConversations int       `json:"active_conversations"`
+	// NewUsers had their first conversation ever in the period, NewConversations started in it
+	NewUsers         int `json:"new_users"`
+	NewConversations int `json:"new_conversations"`
+}
+
+// Engagement is the DAU/WAU-style activity of a time range. A conversation is active in a
+// period when one of its spans started in it, a user when one of their conversations is.
+type Engagement struct {
+	Period  string             `json:"period"`
+	Since   time.Time          `json:"since"`
+	Until   time.Time          `json:"until"`
+	Periods []EngagementPeriod `json:"periods"`
+	// Distinct users and conversations over the whole range
+	ActiveUsers         int `json:"active_users"`
+	ActiveConversations int `json:"active_conversations"`
+	// AverageActiveUsers is the mean of the periods' active users; divided by ActiveUsers it
+	// gives the stickiness (e.g. DAU/MAU)
+	AverageActiveUsers float64 `json:"average_active_users"`
+}
+
+// periodStart returns the start of the UTC day, ISO week (from Monday) or month containing t
+func periodStart(t time.Time, period string) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	switch period {
+	case "week":
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	case "month":
+		return day.AddDate(0, 0, 1-day.Day())
+	}
+	return day
+}
+
+func nextPeriod(t time.Time, period string) time.Time {
+	switch period {
+	case "week":
+		return t.AddDate(0, 0, 7)
+	case "month":
+		return t.AddDate(0, 1, 0)
+	}
+	return t.AddDate(0, 0, 1)
+}
+
+// GetEngagement counts active and new users and conversations per day or week of the filter's
+// range. Spans without a conversation are ignored, and conversations without a user count
+// towards conversations only.
+func (g *GormDB) GetEngagement(filter StatsFilter, period string) (Engagement, error) {
+	until := filter.Until
+	if until.IsZero() {
+		until = time.Now()
+	}
+	// whole periods, so the first one is not undercounted
+	filter.Since = periodStart(filter.Since, period)
+	e := Engagement{Period: period, Since: filter.Since, Until: until, Periods: make([]EngagementPeriod, 0)}
+	index := make(map[time.Time]int)
+	for t := filter.Since; t.Before(until); t = nextPeriod(t, period) {
+		index[t] = len(e.Periods)
+		e.Periods = append(e.Periods, EngagementPeriod{Start: t})
+	}
+
+	var rows []struct {
+		ConversationID string
+		StartTime      time.Time
+	}
+	if err := filter.apply(g.db.Model(&Span{})).
+		Select("conversation_id, start_time").
+		Where("conversation_id <> ''").
+		Limit(500000).
+		Scan(&rows).Error; err != nil {
+		return e, err
+	}
+	activeConvs := make([]map[string]bool, len(e.Periods))
+	convIDs := make([]string, 0)
+	seenConv := make(map[string]bool)
+	for _, r := range rows {
+		i, ok := index[periodStart(r.StartTime, period)]
+		if !ok {
+			continue
+		}
+		if activeConvs[i] == nil {
+			activeConvs[i] = make(map[string]bool)
+		}
+		activeConvs[i][r.ConversationID] = true
+		if !seenConv[r.ConversationID] {
+			seenConv[r.ConversationID] = true
+			convIDs = append(convIDs, r.ConversationID)
+		}
+	}
+
+	convs := make(map[string]Conversation, len(convIDs))
+	for start := 0; start < len(convIDs); start += lookupChunk {
+		var chunk []Conversation
+		if err := g.db.Select("id, user_id, first_start_time").
+			Where("id IN ?", convIDs[start:min(start+lookupChunk, len(convIDs))]).
+			Find(&chunk).Error; err != nil {
+			return e, err
+		}
+		for _, c := range chunk {
+			convs[c.ID] = c
+		}
+	}
+	userIDs := make([]string, 0)
+	seenUser := make(map[string]bool)
+	for _, c := range convs {
+		if c.UserID != "" && !seenUser[c.UserID] {
+			seenUser[c.UserID] = true
+			userIDs = append(userIDs, c.UserID)
+		}
+	}
+	// a user is new in the period of their first conversation, also outside the range
+	firstSeen := make(map[string]time.Time, len(userIDs))
+	for start := 0; start < len(userIDs); start += lookupChunk {
+		var chunk []struct {
+			UserID    string
+			FirstSeen dbTime
+		}
+		if err := g.db.Model(&Conversation{}).
+			Select("user_id, MIN(first_start_time) AS first_seen").
+			Where("user_id IN ?", userIDs[start:min(start+lookupChunk, len(userIDs))]).
+			Group("user_id").
+			Scan(&chunk).Error; err != nil {
+			return e, err
+		}
+		for _, u := range chunk {
+			firstSeen[u.UserID] = u.FirstSeen.Time
+		}
+	}
+
+	var activeUserSum int
+	for i := range e.Periods {
+		users := make(map[string]bool)
+		for id := range activeConvs[i] {
+			if u := convs[id].UserID; u != "" {
+				users[u] = true
+			}
+		}
+		e.Periods[i].ActiveConversations = len(activeConvs[i])
+		e.Periods[i].ActiveUsers = len(users)
+		activeUserSum += len(users)
+	}
+	for _, c := range convs {
+		if i, ok := index[periodStart(c.FirstStartTime, period)]; ok && !c.FirstStartTime.IsZero() {
+			e.Periods[i].NewConversations++
+		}
+	}
+	for _, t := range firstSeen {
+		if i, ok := index[periodStart(t, period)]; ok && !t.IsZero() {
+			e.Periods[i].NewUsers++
+		}
+	}
+	e.ActiveConversations = len(convs)
+	e.ActiveUsers = len(userIDs)
+	if len(e.Periods) > 0 {
+		e.AverageActiveUsers = float64(activeUserSum) / float64(len(e.Periods))
+	}
+	return e, nil
+}
+
+// getEngagementHandler returns daily or weekly active users and conversations
+// (period=day|week, window=30d by default)
+func getEngagementHandler(db Database, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("window") == "" && q.Get("since") == "" {
+			q.Set("window", "30d")
+		}
+		filter, err := parseStatsFilter(q)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		period := strings.TrimSpace(q.Get("period"))
+		if period == "" {
+			period = "day"
+		}
+		if period != "day" && period != "week" {
+			http.Error(w, "period must be day or week", http.StatusBadRequest)
+			return
+		}
+		e, err := db.GetEngagement(filter, period)
+		if err != nil {
+			logger.Error("Failed to get engagement stats: %v", err)
+			http.Error(w, fmt.Sprintf("Failed to get engagement stats: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(e)
+	}
+}
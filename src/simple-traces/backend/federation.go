@@ -0,0 +1,305 @@
+package backend
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// readSource is an additional database listings read from
+type readSource struct {
+	name string
+	db   Database
+}
+
+// FederatedDB reads listings from the primary database and from read-only sources (e.g. the
+// SQLite file of an old deployment next to a new Postgres), so a migration doesn't need a big
+// one-shot import. Writes, stats and everything not overridden here go to the primary only.
+type FederatedDB struct {
+	Database
+	sources []readSource
+	logger  *Logger
+}
+
+// parseReadSource reads "postgres://...", "postgres:<dsn>", "sqlite:<path>" or a bare SQLite path
+func parseReadSource(spec string) (dbType, dsn string) {
+	switch {
+	case strings.HasPrefix(spec, "postgres://"), strings.HasPrefix(spec, "postgresql://"):
+		return "postgres", spec
+	case strings.HasPrefix(spec, "postgres:"):
+		return "postgres", strings.TrimPrefix(spec, "postgres:")
+	}
+	return "sqlite", strings.TrimPrefix(spec, "sqlite:")
+}
+
+// openReadSource connects to a source without migrating it; SQLite files are opened read-only
+func openReadSource(spec, logLevel string) (*GormDB, error) {
+	dbType, dsn := parseReadSource(spec)
+	if dbType == "sqlite" {
+		if _, err := os.Stat(dsn); err != nil {
+			return nil, err
+		}
+		dsn = "file:" + dsn + "?mode=ro"
+	}
+	gormDB, err := openGorm(dbType, dsn, logLevel)
+	if err != nil {
+		return nil, err
+	}
+	return &GormDB{db: gormDB}, nil
+}
+
+// WithReadSources wraps primary with the comma-separated READ_SOURCES; an empty list returns
+// primary unchanged
+func WithReadSources(primary Database, spec, logLevel string, logger *Logger) (Database, error) {
+	specs := splitList(spec)
+	if len(specs) == 0 {
+		return primary, nil
+	}
+	f := &FederatedDB{Database: primary, logger: logger}
+	for _, s := range specs {
+		db, err := openReadSource(s, logLevel)
+		if err != nil {
+			f.closeSources()
+			return nil, fmt.Errorf("open read source %s: %w", redactDSN(s), err)
+		}
+		f.sources = append(f.sources, readSource{name: redactDSN(s), db: db})
+	}
+	return f, nil
+}
+
+// redactDSN hides the password of a postgres URL for logs
+func redactDSN(spec string) string {
+	if at := strings.LastIndex(spec, "@"); at >= 0 {
+		if scheme := strings.Index(spec, "://"); scheme >= 0 && scheme < at {
+			if colon := strings.Index(spec[scheme+3:at], ":"); colon >= 0 {
+				return spec[:scheme+3+colon] + ":***" + spec[at:]
+			}
+		}
+	}
+	return spec
+}
+
+// Close closes the sources and the primary
+func (f *FederatedDB) Close() error {
+	f.closeSources()
+	return f.Database.Close()
+}
+
+func (f *FederatedDB) closeSources() {
+	for _, s := range f.sources {
+		s.db.Close()
+	}
+}
+
+// each calls fn on the primary and then every source. Primary errors are returned; a failing
+// source (unreachable, or an older schema without a queried column) is logged and skipped.
+func (f *FederatedDB) each(what string, fn func(db Database) error) error {
+	if err := fn(f.Database); err != nil {
+		return err
+	}
+	for _, s := range f.sources {
+		if err := fn(s.db); err != nil {
+			f.logger.Warn("Read source %s failed to %s: %v", s.name, what, err)
+		}
+	}
+	return nil
+}
+
+// firstFound returns the result of the first database, primary first, for which found is true
+func firstFound[T any](f *FederatedDB, what string, fn func(db Database) (T, error), found func(T) bool) (T, error) {
+	result, err := fn(f.Database)
+	if err != nil || found(result) {
+		return result, err
+	}
+	for _, s := range f.sources {
+		r, err := fn(s.db)
+		if err != nil {
+			f.logger.Warn("Read source %s failed to %s: %v", s.name, what, err)
+			continue
+		}
+		if found(r) {
+			return r, nil
+		}
+	}
+	return result, nil
+}
+
+// mergeSorted concatenates per-database results, keeps the first item per key (primary wins),
+// sorts them with less and truncates to limit
+func mergeSorted[T any](items []T, key func(T) string, less func(a, b T) bool, limit int) []T {
+	seen := make(map[string]bool, len(items))
+	out := items[:0]
+	for _, it := range items {
+		k := key(it)
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		out = append(out, it)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return less(out[i], out[j]) })
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
+
+func (f *FederatedDB) GetSpans(limit int, before time.Time) ([]Span, error) {
+	return f.GetSpansFiltered(limit, before, SpanFilter{})
+}
+
+func (f *FederatedDB) GetSpansFiltered(limit int, before time.Time, filter SpanFilter) ([]Span, error) {
+	if limit <= 0 || limit > 5000 {
+		limit = 1000
+	}
+	var all []Span
+	err := f.each("list spans", func(db Database) error {
+		spans, err := db.GetSpansFiltered(limit, before, filter)
+		all = append(all, spans...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mergeSorted(all,
+		func(s Span) string { return s.SpanID },
+		func(a, b Span) bool { return a.StartTime.After(b.StartTime) },
+		limit), nil
+}
+
+func (f *FederatedDB) GetTraceGroups(limit int, before time.Time) ([]TraceGroup, error) {
+	return f.GetTraceGroupsFiltered(limit, before, TraceGroupFilter{})
+}
+
+func (f *FederatedDB) GetTraceGroupsWithSearch(limit int, before time.Time, search string) ([]TraceGroup, error) {
+	return f.GetTraceGroupsFiltered(limit, before, TraceGroupFilter{Search: search})
+}
+
+// GetTraceGroupsFiltered lists a trace found in several databases once, as the first database
+// (primary first) has it
+func (f *FederatedDB) GetTraceGroupsFiltered(limit int, before time.Time, filter TraceGroupFilter) ([]TraceGroup, error) {
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	var all []TraceGroup
+	err := f.each("list trace groups", func(db Database) error {
+		groups, err := db.GetTraceGroupsFiltered(limit, before, filter)
+		all = append(all, groups...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	less := func(a, b TraceGroup) bool { return a.LastEndTime.After(b.LastEndTime) }
+	if filter.Sort == TraceGroupSortHealth {
+		score := func(g TraceGroup) int {
+			if g.Health == nil {
+				return 101
+			}
+			return g.Health.Score
+		}
+		less = func(a, b TraceGroup) bool {
+			if score(a) != score(b) {
+				return score(a) < score(b)
+			}
+			return a.LastEndTime.After(b.LastEndTime)
+		}
+	}
+	return mergeSorted(all, func(g TraceGroup) string { return g.TraceID }, less, limit), nil
+}
+
+func (f *FederatedDB) GetTraceGroupSpans(traceID string, limit int) ([]Span, error) {
+	return f.GetTraceGroupSpansFiltered(traceID, limit, TraceSpanFilter{})
+}
+
+func (f *FederatedDB) GetTraceGroupSpansWithSearch(traceID string, limit int, search string) ([]Span, error) {
+	return f.GetTraceGroupSpansFiltered(traceID, limit, TraceSpanFilter{Search: search})
+}
+
+// GetTraceGroupSpansFiltered reads a trace from the first database that has spans of it
+func (f *FederatedDB) GetTraceGroupSpansFiltered(traceID string, limit int, filter TraceSpanFilter) ([]Span, error) {
+	return firstFound(f, "read trace "+traceID,
+		func(db Database) ([]Span, error) { return db.GetTraceGroupSpansFiltered(traceID, limit, filter) },
+		func(spans []Span) bool { return len(spans) > 0 })
+}
+
+func (f *FederatedDB) GetTraceGroupVersion(traceID string) (TraceGroupVersion, error) {
+	return firstFound(f, "read trace "+traceID,
+		func(db Database) (TraceGroupVersion, error) { return db.GetTraceGroupVersion(traceID) },
+		func(v TraceGroupVersion) bool { return v.SpanCount > 0 })
+}
+
+func (f *FederatedDB) GetConversations(limit int, before time.Time) ([]Conversation, error) {
+	return f.mergeConversations(limit, func(db Database) ([]Conversation, error) {
+		return db.GetConversations(limit, before)
+	})
+}
+
+func (f *FederatedDB) GetConversationsWithSearch(limit int, before time.Time, search string) ([]Conversation, error) {
+	return f.mergeConversations(limit, func(db Database) ([]Conversation, error) {
+		return db.GetConversationsWithSearch(limit, before, search)
+	})
+}
+
+func (f *FederatedDB) GetConversationsFiltered(limit int, before time.Time, filter ConversationFilter) ([]Conversation, error) {
+	return f.mergeConversations(limit, func(db Database) ([]Conversation, error) {
+		return db.GetConversationsFiltered(limit, before, filter)
+	})
+}
+
+func (f *FederatedDB) mergeConversations(limit int, list func(db Database) ([]Conversation, error)) ([]Conversation, error) {
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	var all []Conversation
+	err := f.each("list conversations", func(db Database) error {
+		convs, err := list(db)
+		all = append(all, convs...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mergeSorted(all,
+		func(c Conversation) string { return c.ID },
+		func(a, b Conversation) bool { return a.LastEndTime.After(b.LastEndTime) },
+		limit), nil
+}
+
+func (f *FederatedDB) GetConversationTranscript(conversationID string, limit int) ([]TranscriptTurn, error) {
+	return firstFound(f, "read conversation "+conversationID,
+		func(db Database) ([]TranscriptTurn, error) {
+			return db.GetConversationTranscript(conversationID, limit)
+		},
+		func(turns []TranscriptTurn) bool { return len(turns) > 0 })
+}
+
+func (f *FederatedDB) GetConversationLLMSpans(conversationID string) ([]Span, error) {
+	return firstFound(f, "read conversation "+conversationID,
+		func(db Database) ([]Span, error) { return db.GetConversationLLMSpans(conversationID) },
+		func(spans []Span) bool { return len(spans) > 0 })
+}
+
+func (f *FederatedDB) LookupConversationIDByTraceID(traceID string) (string, error) {
+	return firstFound(f, "look up trace "+traceID,
+		func(db Database) (string, error) { return db.LookupConversationIDByTraceID(traceID) },
+		func(id string) bool { return id != "" })
+}
+
+func (f *FederatedDB) GetProjects() ([]Project, error) {
+	var all []Project
+	err := f.each("list projects", func(db Database) error {
+		projects, err := db.GetProjects()
+		all = append(all, projects...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mergeSorted(all,
+		func(p Project) string { return p.ID },
+		func(a, b Project) bool { return a.Name < b.Name },
+		0), nil
+}
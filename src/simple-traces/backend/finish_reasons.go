@@ -0,0 +1,118 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// FinishReasonLength is the normalized finish reason for outputs cut off by max_tokens
+const FinishReasonLength = "length"
+
+// provider-specific finish reasons mapped onto OpenAI/gen_ai names
+var finishReasonAliases = map[string]string{
+	"max_tokens":                FinishReasonLength, // Anthropic, Gemini (MAX_TOKENS)
+	"length":                    FinishReasonLength,
+	"end_turn":                  "stop", // Anthropic
+	"stop":                      "stop",
+	"finish_reason_unspecified": "unspecified",
+	"tool_use":                  "tool_calls", // Anthropic
+	"tool_calls":                "tool_calls",
+	"function_call":             "tool_calls",
+}
+
+// extractFinishReason returns the normalized finish reasons of a span joined by ","
+func extractFinishReason(flat map[string]any) string {
+	for _, k := range []string{"gen_ai.response.finish_reasons", "gen_ai.response.finish_reason", "llm.finish_reason"} {
+		values := stringValues(flat[k])
+		if len(values) == 0 {
+			continue
+		}
+		out := make([]string, 0, len(values))
+		for _, v := range values {
+			out = append(out, normalizeFinishReason(v))
+		}
+		return strings.Join(out, ",")
+	}
+	return ""
+}
+
+func normalizeFinishReason(v string) string {
+	lv := strings.ToLower(strings.TrimSpace(v))
+	if n, ok := finishReasonAliases[lv]; ok {
+		return n
+	}
+	return lv
+}
+
+// FinishReasonStats reports the finish reason distribution of one model
+type FinishReasonStats struct {
+	Model          string           `json:"model"`
+	Total          int64            `json:"total"`
+	Reasons        map[string]int64 `json:"reasons"`
+	Truncated      int64            `json:"truncated"`
+	TruncationRate float64          `json:"truncation_rate"`
+}
+
+// GetFinishReasonStats counts finish reasons per model for LLM spans matching the filter
+func (g *GormDB) GetFinishReasonStats(filter StatsFilter) ([]FinishReasonStats, error) {
+	var rows []struct {
+		Model        string
+		FinishReason string
+		Count        int64
+	}
+	if err := filter.apply(g.db.Model(&Span{})).
+		Select("model, finish_reason, COUNT(*) AS count").
+		Where("model <> ''").
+		Group("model, finish_reason").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	byModel := make(map[string]*FinishReasonStats)
+	for _, r := range rows {
+		st := byModel[r.Model]
+		if st == nil {
+			st = &FinishReasonStats{Model: r.Model, Reasons: make(map[string]int64)}
+			byModel[r.Model] = st
+		}
+		st.Total += r.Count
+		reason := r.FinishReason
+		if reason == "" {
+			reason = "unknown"
+		}
+		for _, part := range strings.Split(reason, ",") {
+			st.Reasons[part] += r.Count
+		}
+		if strings.Contains(","+r.FinishReason+",", ","+FinishReasonLength+",") {
+			st.Truncated += r.Count
+		}
+	}
+	out := make([]FinishReasonStats, 0, len(byModel))
+	for _, st := range byModel {
+		st.TruncationRate = ratio(st.Truncated, st.Total)
+		out = append(out, *st)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].TruncationRate > out[j].TruncationRate })
+	return out, nil
+}
+
+// getFinishReasonStatsHandler returns finish reason distributions and truncation rates per model
+func getFinishReasonStatsHandler(db Database, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := parseStatsFilter(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		stats, err := db.GetFinishReasonStats(filter)
+		if err != nil {
+			logger.Error("Failed to get finish reason stats: %v", err)
+			http.Error(w, fmt.Sprintf("Failed to get finish reason stats: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats)
+	}
+}
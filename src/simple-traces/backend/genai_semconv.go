@@ -0,0 +1,205 @@
+package backend
+
+import (
+	"encoding/json"
+	"strings"
+
+	tracepbv1 "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+// genAIOperationCategories maps gen_ai.operation.name to the span category
+var genAIOperationCategories = map[string]string{
+	"chat":             "llm",
+	"text_completion":  "llm",
+	"generate_content": "llm",
+	"embeddings":       "llm",
+	"execute_tool":     "tool",
+	"invoke_agent":     "agent",
+	"create_agent":     "agent",
+}
+
+// genAIMessageEvents maps the message events of the gen_ai conventions (v1.27 to v1.36) to roles
+var genAIMessageEvents = map[string]string{
+	"gen_ai.system.message":    "system",
+	"gen_ai.user.message":      "user",
+	"gen_ai.assistant.message": "assistant",
+	"gen_ai.tool.message":      "tool",
+}
+
+// augmentGenAIAttrs normalizes the newer gen_ai semantic conventions into the keys the rest of
+// the ingest path reads: structured gen_ai.input.messages/gen_ai.output.messages and
+// gen_ai.system_instructions (v1.37+), prompt and completion events (gen_ai.*.message and
+// gen_ai.choice since v1.27, gen_ai.content.* before) and gen_ai.provider.name. Keys already
+// present are left untouched. It returns the keys that were added.
+func augmentGenAIAttrs(attrs map[string]any, events []*tracepbv1.Span_Event) []string {
+	var added []string
+	set := func(k string, v any) {
+		if _, exists := attrs[k]; !exists {
+			attrs[k] = v
+			added = append(added, k)
+		}
+	}
+	if s, ok := attrs["gen_ai.provider.name"].(string); ok && strings.TrimSpace(s) != "" {
+		set("gen_ai.system", s)
+	}
+	if parts := genAIJSON(attrs["gen_ai.system_instructions"]); parts != nil {
+		if text := genAIPartsText(parts); text != "" {
+			set("simpleTraces.system_instruction", text)
+		}
+	}
+
+	var input, output []any
+	var finishReasons []any
+	if msgs, ok := genAIJSON(attrs["gen_ai.input.messages"]).([]any); ok {
+		for _, m := range msgs {
+			if mm, ok := m.(map[string]any); ok {
+				role, _ := mm["role"].(string)
+				input = append(input, map[string]any{"role": role, "content": genAIPartsText(mm["parts"])})
+			}
+		}
+	}
+	if msgs, ok := genAIJSON(attrs["gen_ai.output.messages"]).([]any); ok {
+		for _, m := range msgs {
+			if mm, ok := m.(map[string]any); ok {
+				output = append(output, genAIPartsText(mm["parts"]))
+				if fr, ok := mm["finish_reason"].(string); ok && fr != "" {
+					finishReasons = append(finishReasons, fr)
+				}
+			}
+		}
+	}
+
+	// events only fill in what the attributes didn't carry
+	eventInput, eventOutput := input == nil, output == nil
+	for _, ev := range events {
+		if ev == nil {
+			continue
+		}
+		evAttrs := make(map[string]any, len(ev.Attributes))
+		for _, a := range ev.Attributes {
+			if a != nil {
+				evAttrs[a.Key] = anyValueToInterface(a.Value)
+			}
+		}
+		switch {
+		case genAIMessageEvents[ev.Name] != "" && eventInput:
+			role := genAIMessageEvents[ev.Name]
+			if r, ok := evAttrs["role"].(string); ok && r != "" {
+				role = r
+			}
+			input = append(input, map[string]any{"role": role, "content": genAIEventContent(evAttrs)})
+		case ev.Name == "gen_ai.choice" && eventOutput:
+			output = append(output, genAIEventContent(evAttrs))
+			if fr, ok := evAttrs["finish_reason"].(string); ok && fr != "" {
+				finishReasons = append(finishReasons, fr)
+			}
+		case ev.Name == "gen_ai.content.prompt":
+			if s, ok := evAttrs["gen_ai.prompt"].(string); ok && strings.TrimSpace(s) != "" {
+				set("gen_ai.prompt", s)
+			}
+		case ev.Name == "gen_ai.content.completion":
+			if s, ok := evAttrs["gen_ai.completion"].(string); ok && strings.TrimSpace(s) != "" {
+				set("gen_ai.response", s)
+			}
+		}
+	}
+
+	if len(input) > 0 {
+		set("simpleTraces.messages", input)
+		for i := len(input) - 1; i >= 0; i-- {
+			m := input[i].(map[string]any)
+			if c, _ := m["content"].(string); m["role"] == "user" && strings.TrimSpace(c) != "" {
+				set("gen_ai.prompt", c)
+				break
+			}
+		}
+	}
+	var texts []string
+	for _, o := range output {
+		if s, _ := o.(string); strings.TrimSpace(s) != "" {
+			texts = append(texts, s)
+		}
+	}
+	if len(texts) > 0 {
+		set("gen_ai.response", strings.Join(texts, "\n\n"))
+	}
+	if len(finishReasons) > 0 {
+		set("gen_ai.response.finish_reasons", finishReasons)
+	}
+	return added
+}
+
+// genAIJSON decodes attributes the conventions define as structured but SDKs often send as
+// JSON strings
+func genAIJSON(v any) any {
+	s, ok := v.(string)
+	if !ok {
+		return v
+	}
+	var out any
+	if err := json.Unmarshal([]byte(s), &out); err != nil {
+		return nil
+	}
+	return out
+}
+
+// genAIPartsText renders message parts: text parts as-is, tool calls and their responses as
+// JSON so they still count towards the prompt
+func genAIPartsText(v any) string {
+	parts, ok := v.([]any)
+	if !ok {
+		s, _ := v.(string)
+		return s
+	}
+	var texts []string
+	for _, p := range parts {
+		pm, ok := p.(map[string]any)
+		if !ok {
+			continue
+		}
+		if t, _ := pm["type"].(string); t == "text" || t == "" {
+			if c, ok := pm["content"].(string); ok {
+				texts = append(texts, c)
+				continue
+			}
+		}
+		if b, err := json.Marshal(pm); err == nil {
+			texts = append(texts, string(b))
+		}
+	}
+	return strings.Join(texts, "\n\n")
+}
+
+// genAIEventContent returns the content of a message or choice event, whose body SDKs put in
+// attributes (content, message.content) or in a body attribute as JSON
+func genAIEventContent(evAttrs map[string]any) string {
+	for _, k := range []string{"content", "message.content", "gen_ai.event.content"} {
+		if s, ok := evAttrs[k].(string); ok && s != "" {
+			return s
+		}
+	}
+	for _, k := range []string{"message", "body"} {
+		v := evAttrs[k]
+		if s, ok := v.(string); ok {
+			if decoded := genAIJSON(s); decoded != nil {
+				v = decoded
+			} else {
+				return s
+			}
+		}
+		m, ok := v.(map[string]any)
+		if !ok {
+			continue
+		}
+		if inner, ok := m["message"].(map[string]any); ok {
+			m = inner
+		}
+		if c, ok := m["content"]; ok {
+			if s, ok := c.(string); ok {
+				return s
+			}
+			return genAIPartsText(c)
+		}
+	}
+	return ""
+}
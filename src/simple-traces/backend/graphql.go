@@ -0,0 +1,580 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/graph-gophers/graphql-go"
+	"github.com/graph-gophers/graphql-go/relay"
+	"gorm.io/gorm"
+)
+
+// graphqlSchema exposes the stored data for dashboards that want to pick the shape of the
+// response. Lists are paginated with first/after; cursors are opaque strings taken from
+// pageInfo.endCursor.
+const graphqlSchema = `
+schema {
+	query: Query
+}
+
+scalar Time
+
+type Query {
+	projects: [Project!]!
+	project(id: ID!): Project
+	conversations(first: Int = 20, after: String, project: String, user: String): ConversationConnection!
+	conversation(id: ID!): Conversation
+	traceGroups(first: Int = 20, after: String, project: String, search: String, status: String, errorsOnly: Boolean = false, complete: Boolean, entryPoint: String): TraceGroupConnection!
+	traceGroup(id: ID!): TraceGroup
+	spans(first: Int = 50, after: String, project: String, model: String, category: String): SpanConnection!
+	span(id: ID!): Span
+}
+
+type PageInfo {
+	hasNextPage: Boolean!
+	endCursor: String
+}
+
+type Project {
+	id: ID!
+	name: String!
+	createdAt: Time!
+	conversations(first: Int = 20, after: String): ConversationConnection!
+	traceGroups(first: Int = 20, after: String): TraceGroupConnection!
+}
+
+type ConversationConnection {
+	nodes: [Conversation!]!
+	pageInfo: PageInfo!
+}
+
+type Conversation {
+	id: ID!
+	projectId: String!
+	userId: String!
+	firstStartTime: Time!
+	lastEndTime: Time!
+	summary: String!
+	sentiment: String!
+	traceGroups(first: Int = 20, after: String): TraceGroupConnection!
+	spans(first: Int = 50, after: String): SpanConnection!
+}
+
+type TraceGroupConnection {
+	nodes: [TraceGroup!]!
+	pageInfo: PageInfo!
+}
+
+type TraceGroup {
+	traceId: ID!
+	firstStartTime: Time!
+	lastEndTime: Time!
+	spanCount: Int!
+	errorCount: Int!
+	isComplete: Boolean!
+	# Name of the root span, empty until it has arrived
+	rootName: String!
+	status: String!
+	assignee: String!
+	spans(first: Int = 100, after: String): SpanConnection!
+}
+
+type SpanConnection {
+	nodes: [Span!]!
+	pageInfo: PageInfo!
+}
+
+type Span {
+	spanId: ID!
+	traceId: String!
+	parentSpanId: String!
+	projectId: String!
+	conversationId: String!
+	name: String!
+	category: String!
+	model: String!
+	statusCode: String!
+	statusDescription: String!
+	startTime: Time!
+	endTime: Time!
+	durationMs: Float!
+	inputTokens: Int!
+	outputTokens: Int!
+	finishReason: String!
+	violationType: String!
+	# Ingested attributes; value is JSON encoded. Restrict to some keys with keys.
+	attributes(keys: [String!]): [Attribute!]!
+	annotations: [Annotation!]!
+	# Annotations with a numeric value
+	scores: [Score!]!
+}
+
+type Attribute {
+	key: String!
+	type: String!
+	value: String!
+}
+
+type Annotation {
+	key: String!
+	value: String!
+}
+
+type Score {
+	key: String!
+	value: Float!
+}
+`
+
+// graphqlMaxPage caps first on every connection
+const graphqlMaxPage = 500
+
+// graphqlMaxRows caps the rows a query may fetch: the page sizes of all connections it resolves
+// add up, so nested connections multiply by the nodes of their parent
+const graphqlMaxRows = 10000
+
+// newGraphQLHandler serves POST /api/graphql
+func newGraphQLHandler(db Database) http.Handler {
+	schema := graphql.MustParseSchema(graphqlSchema, &gqlQuery{db: db},
+		graphql.MaxDepth(8),
+		graphql.MaxParallelism(10),
+		graphql.MaxQueryLength(16<<10),
+	)
+	h := &relay.Handler{Schema: schema}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		budget := &atomic.Int64{}
+		budget.Store(graphqlMaxRows)
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), gqlBudgetKey{}, budget)))
+	})
+}
+
+// gqlBudgetKey holds the rows a request may still fetch, see graphqlMaxRows
+type gqlBudgetKey struct{}
+
+type gqlQuery struct {
+	db Database
+}
+
+type gqlPageArgs struct {
+	First int32
+	After *string
+}
+
+// limit returns the requested page size and takes it from the row budget of the request;
+// callers fetch one extra row to tell whether another page exists
+func (a gqlPageArgs) limit(ctx context.Context) (int, error) {
+	n := max(1, min(int(a.First), graphqlMaxPage))
+	if budget, ok := ctx.Value(gqlBudgetKey{}).(*atomic.Int64); ok && budget.Add(-int64(n)) < 0 {
+		return 0, fmt.Errorf("query too complex: it would fetch more than %d rows, lower first on nested lists", graphqlMaxRows)
+	}
+	return n, nil
+}
+
+// beforeCursor decodes the time cursor of lists ordered newest first
+func (a gqlPageArgs) beforeCursor() (time.Time, error) {
+	if a.After == nil || *a.After == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, *a.After)
+	if err != nil {
+		return time.Time{}, errors.New("invalid cursor")
+	}
+	return t, nil
+}
+
+type gqlPageInfo struct {
+	next   bool
+	cursor string
+}
+
+func (p gqlPageInfo) HasNextPage() bool { return p.next }
+
+func (p gqlPageInfo) EndCursor() *string {
+	if p.cursor == "" {
+		return nil
+	}
+	return &p.cursor
+}
+
+// page trims the extra row fetched by limit and builds the page info
+func page[T any](rows []T, limit int, cursor func(T) string) ([]T, gqlPageInfo) {
+	info := gqlPageInfo{}
+	if len(rows) > limit {
+		rows = rows[:limit]
+		info.next = true
+	}
+	if len(rows) > 0 {
+		info.cursor = cursor(rows[len(rows)-1])
+	}
+	return rows, info
+}
+
+func optString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// Query
+
+func (q *gqlQuery) Projects() ([]*gqlProject, error) {
+	projects, err := q.db.GetProjects()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*gqlProject, len(projects))
+	for i := range projects {
+		out[i] = &gqlProject{db: q.db, p: projects[i]}
+	}
+	return out, nil
+}
+
+func (q *gqlQuery) Project(args struct{ ID graphql.ID }) (*gqlProject, error) {
+	p, err := q.db.GetProjectByID(string(args.ID))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &gqlProject{db: q.db, p: *p}, nil
+}
+
+func (q *gqlQuery) Conversations(ctx context.Context, args struct {
+	gqlPageArgs
+	Project *string
+	User    *string
+}) (*gqlConversationConnection, error) {
+	return conversationConnection(ctx, q.db, args.gqlPageArgs, ConversationFilter{ProjectID: optString(args.Project), UserID: optString(args.User)})
+}
+
+func (q *gqlQuery) Conversation(args struct{ ID graphql.ID }) (*gqlConversation, error) {
+	convs, err := q.db.GetConversationsFiltered(1, time.Time{}, ConversationFilter{ID: string(args.ID)})
+	if err != nil || len(convs) == 0 {
+		return nil, err
+	}
+	return &gqlConversation{db: q.db, c: convs[0]}, nil
+}
+
+func (q *gqlQuery) TraceGroups(ctx context.Context, args struct {
+	gqlPageArgs
+	Project    *string
+	Search     *string
+	Status     *string
+	ErrorsOnly bool
+	Complete   *bool
+	EntryPoint *string
+}) (*gqlTraceGroupConnection, error) {
+	return traceGroupConnection(ctx, q.db, args.gqlPageArgs, TraceGroupFilter{
+		ProjectID:  optString(args.Project),
+		Search:     optString(args.Search),
+		Status:     optString(args.Status),
+		OnlyErrors: args.ErrorsOnly,
+		Complete:   args.Complete,
+		EntryPoint: optString(args.EntryPoint),
+	})
+}
+
+func (q *gqlQuery) TraceGroup(args struct{ ID graphql.ID }) (*gqlTraceGroup, error) {
+	groups, err := q.db.GetTraceGroupsFiltered(1, time.Time{}, TraceGroupFilter{TraceID: string(args.ID)})
+	if err != nil || len(groups) == 0 {
+		return nil, err
+	}
+	return &gqlTraceGroup{db: q.db, g: groups[0]}, nil
+}
+
+func (q *gqlQuery) Spans(ctx context.Context, args struct {
+	gqlPageArgs
+	Project  *string
+	Model    *string
+	Category *string
+}) (*gqlSpanConnection, error) {
+	return spanConnection(ctx, q.db, args.gqlPageArgs, SpanFilter{
+		ProjectID: optString(args.Project),
+		Model:     optString(args.Model),
+		Category:  optString(args.Category),
+	})
+}
+
+func (q *gqlQuery) Span(args struct{ ID graphql.ID }) (*gqlSpan, error) {
+	spans, err := q.db.GetSpansFiltered(1, time.Time{}, SpanFilter{SpanID: string(args.ID)})
+	if err != nil || len(spans) == 0 {
+		return nil, err
+	}
+	return &gqlSpan{s: spans[0]}, nil
+}
+
+// Connections
+
+type gqlConversationConnection struct {
+	nodes []*gqlConversation
+	info  gqlPageInfo
+}
+
+func (c *gqlConversationConnection) Nodes() []*gqlConversation { return c.nodes }
+func (c *gqlConversationConnection) PageInfo() gqlPageInfo     { return c.info }
+
+func conversationConnection(ctx context.Context, db Database, args gqlPageArgs, filter ConversationFilter) (*gqlConversationConnection, error) {
+	before, err := args.beforeCursor()
+	if err != nil {
+		return nil, err
+	}
+	limit, err := args.limit(ctx)
+	if err != nil {
+		return nil, err
+	}
+	convs, err := db.GetConversationsFiltered(limit+1, before, filter)
+	if err != nil {
+		return nil, err
+	}
+	convs, info := page(convs, limit, func(c Conversation) string { return c.LastEndTime.Format(time.RFC3339Nano) })
+	out := &gqlConversationConnection{nodes: []*gqlConversation{}, info: info}
+	for _, c := range convs {
+		out.nodes = append(out.nodes, &gqlConversation{db: db, c: c})
+	}
+	return out, nil
+}
+
+type gqlTraceGroupConnection struct {
+	nodes []*gqlTraceGroup
+	info  gqlPageInfo
+}
+
+func (c *gqlTraceGroupConnection) Nodes() []*gqlTraceGroup { return c.nodes }
+func (c *gqlTraceGroupConnection) PageInfo() gqlPageInfo   { return c.info }
+
+func traceGroupConnection(ctx context.Context, db Database, args gqlPageArgs, filter TraceGroupFilter) (*gqlTraceGroupConnection, error) {
+	before, err := args.beforeCursor()
+	if err != nil {
+		return nil, err
+	}
+	limit, err := args.limit(ctx)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := db.GetTraceGroupsFiltered(limit+1, before, filter)
+	if err != nil {
+		return nil, err
+	}
+	groups, info := page(groups, limit, func(g TraceGroup) string { return g.LastEndTime.Format(time.RFC3339Nano) })
+	out := &gqlTraceGroupConnection{nodes: []*gqlTraceGroup{}, info: info}
+	for _, g := range groups {
+		out.nodes = append(out.nodes, &gqlTraceGroup{db: db, g: g})
+	}
+	return out, nil
+}
+
+type gqlSpanConnection struct {
+	nodes []*gqlSpan
+	info  gqlPageInfo
+}
+
+func (c *gqlSpanConnection) Nodes() []*gqlSpan     { return c.nodes }
+func (c *gqlSpanConnection) PageInfo() gqlPageInfo { return c.info }
+
+func newSpanConnection(spans []Span, info gqlPageInfo) *gqlSpanConnection {
+	out := &gqlSpanConnection{nodes: []*gqlSpan{}, info: info}
+	for _, s := range spans {
+		out.nodes = append(out.nodes, &gqlSpan{s: s})
+	}
+	return out
+}
+
+// spanConnection lists spans newest first
+func spanConnection(ctx context.Context, db Database, args gqlPageArgs, filter SpanFilter) (*gqlSpanConnection, error) {
+	before, err := args.beforeCursor()
+	if err != nil {
+		return nil, err
+	}
+	limit, err := args.limit(ctx)
+	if err != nil {
+		return nil, err
+	}
+	spans, err := db.GetSpansFiltered(limit+1, before, filter)
+	if err != nil {
+		return nil, err
+	}
+	spans, info := page(spans, limit, func(s Span) string { return s.StartTime.Format(time.RFC3339Nano) })
+	return newSpanConnection(spans, info), nil
+}
+
+// Nodes
+
+type gqlProject struct {
+	db Database
+	p  Project
+}
+
+func (p *gqlProject) ID() graphql.ID          { return graphql.ID(p.p.ID) }
+func (p *gqlProject) Name() string            { return p.p.Name }
+func (p *gqlProject) CreatedAt() graphql.Time { return graphql.Time{Time: p.p.CreatedAt} }
+func (p *gqlProject) Conversations(ctx context.Context, args gqlPageArgs) (*gqlConversationConnection, error) {
+	return conversationConnection(ctx, p.db, args, ConversationFilter{ProjectID: p.p.ID})
+}
+func (p *gqlProject) TraceGroups(ctx context.Context, args gqlPageArgs) (*gqlTraceGroupConnection, error) {
+	return traceGroupConnection(ctx, p.db, args, TraceGroupFilter{ProjectID: p.p.ID})
+}
+
+type gqlConversation struct {
+	db Database
+	c  Conversation
+}
+
+func (c *gqlConversation) ID() graphql.ID    { return graphql.ID(c.c.ID) }
+func (c *gqlConversation) ProjectID() string { return c.c.ProjectID }
+func (c *gqlConversation) UserID() string    { return c.c.UserID }
+func (c *gqlConversation) FirstStartTime() graphql.Time {
+	return graphql.Time{Time: c.c.FirstStartTime}
+}
+func (c *gqlConversation) LastEndTime() graphql.Time { return graphql.Time{Time: c.c.LastEndTime} }
+func (c *gqlConversation) Summary() string           { return c.c.Summary }
+func (c *gqlConversation) Sentiment() string         { return c.c.Sentiment }
+func (c *gqlConversation) TraceGroups(ctx context.Context, args gqlPageArgs) (*gqlTraceGroupConnection, error) {
+	return traceGroupConnection(ctx, c.db, args, TraceGroupFilter{ConversationID: c.c.ID})
+}
+func (c *gqlConversation) Spans(ctx context.Context, args gqlPageArgs) (*gqlSpanConnection, error) {
+	return spanConnection(ctx, c.db, args, SpanFilter{ConversationID: c.c.ID})
+}
+
+type gqlTraceGroup struct {
+	db Database
+	g  TraceGroup
+}
+
+func (g *gqlTraceGroup) TraceID() graphql.ID          { return graphql.ID(g.g.TraceID) }
+func (g *gqlTraceGroup) FirstStartTime() graphql.Time { return graphql.Time{Time: g.g.FirstStartTime} }
+func (g *gqlTraceGroup) LastEndTime() graphql.Time    { return graphql.Time{Time: g.g.LastEndTime} }
+func (g *gqlTraceGroup) SpanCount() int32             { return int32(g.g.SpanCount) }
+func (g *gqlTraceGroup) ErrorCount() int32            { return int32(g.g.ErrorCount) }
+func (g *gqlTraceGroup) IsComplete() bool             { return g.g.IsComplete }
+func (g *gqlTraceGroup) RootName() string             { return g.g.RootName }
+func (g *gqlTraceGroup) Status() string               { return g.g.Status }
+func (g *gqlTraceGroup) Assignee() string             { return g.g.Assignee }
+
+// Spans of a trace group are paged in receive order with the same cursor as the REST API
+func (g *gqlTraceGroup) Spans(ctx context.Context, args gqlPageArgs) (*gqlSpanConnection, error) {
+	// spans stored before sequence numbers were assigned have seq 0
+	after := int64(-1)
+	if args.After != nil && *args.After != "" {
+		seq, err := strconv.ParseInt(*args.After, 10, 64)
+		if err != nil {
+			return nil, errors.New("invalid cursor")
+		}
+		after = seq
+	}
+	limit, err := args.limit(ctx)
+	if err != nil {
+		return nil, err
+	}
+	spans, err := g.db.GetTraceGroupSpansFiltered(g.g.TraceID, limit+1, TraceSpanFilter{After: &after})
+	if err != nil {
+		return nil, err
+	}
+	spans, info := page(spans, limit, func(s Span) string { return strconv.FormatInt(s.Seq, 10) })
+	return newSpanConnection(spans, info), nil
+}
+
+type gqlSpan struct {
+	s Span
+}
+
+func (s *gqlSpan) SpanID() graphql.ID        { return graphql.ID(s.s.SpanID) }
+func (s *gqlSpan) TraceID() string           { return s.s.TraceID }
+func (s *gqlSpan) ParentSpanID() string      { return s.s.ParentSpanID }
+func (s *gqlSpan) ProjectID() string         { return s.s.ProjectID }
+func (s *gqlSpan) ConversationID() string    { return s.s.ConversationID }
+func (s *gqlSpan) Name() string              { return s.s.Name }
+func (s *gqlSpan) Category() string          { return s.s.Category }
+func (s *gqlSpan) Model() string             { return s.s.Model }
+func (s *gqlSpan) StatusCode() string        { return s.s.StatusCode }
+func (s *gqlSpan) StatusDescription() string { return s.s.StatusDesc }
+func (s *gqlSpan) StartTime() graphql.Time   { return graphql.Time{Time: s.s.StartTime} }
+func (s *gqlSpan) EndTime() graphql.Time     { return graphql.Time{Time: s.s.EndTime} }
+func (s *gqlSpan) DurationMs() float64 {
+	return float64(s.s.EndTime.Sub(s.s.StartTime).Microseconds()) / 1000
+}
+func (s *gqlSpan) InputTokens() int32    { return int32(s.s.InputTokens) }
+func (s *gqlSpan) OutputTokens() int32   { return int32(s.s.OutputTokens) }
+func (s *gqlSpan) FinishReason() string  { return s.s.FinishReason }
+func (s *gqlSpan) ViolationType() string { return s.s.ViolationType }
+
+type gqlAttribute struct {
+	key, typ, value string
+}
+
+func (a gqlAttribute) Key() string   { return a.key }
+func (a gqlAttribute) Type() string  { return a.typ }
+func (a gqlAttribute) Value() string { return a.value }
+
+func (s *gqlSpan) Attributes(args struct{ Keys *[]string }) []gqlAttribute {
+	typed := typedAttributes(s.s.Attributes)
+	var keys []string
+	if args.Keys != nil {
+		keys = *args.Keys
+	} else {
+		for k := range typed {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+	}
+	out := []gqlAttribute{}
+	for _, k := range keys {
+		a, ok := typed[k]
+		if !ok {
+			continue
+		}
+		v, _ := json.Marshal(a.Value)
+		out = append(out, gqlAttribute{key: k, typ: a.Type, value: string(v)})
+	}
+	return out
+}
+
+type gqlAnnotation struct {
+	key, value string
+}
+
+func (a gqlAnnotation) Key() string   { return a.key }
+func (a gqlAnnotation) Value() string { return a.value }
+
+func (s *gqlSpan) Annotations() []gqlAnnotation {
+	out := []gqlAnnotation{}
+	for _, k := range sortedKeys(s.s.Annotations) {
+		out = append(out, gqlAnnotation{key: k, value: s.s.Annotations[k]})
+	}
+	return out
+}
+
+type gqlScore struct {
+	key   string
+	value float64
+}
+
+func (a gqlScore) Key() string    { return a.key }
+func (a gqlScore) Value() float64 { return a.value }
+
+func (s *gqlSpan) Scores() []gqlScore {
+	out := []gqlScore{}
+	for _, k := range sortedKeys(s.s.Annotations) {
+		if v, err := strconv.ParseFloat(s.s.Annotations[k], 64); err == nil {
+			out = append(out, gqlScore{key: k, value: v})
+		}
+	}
+	return out
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
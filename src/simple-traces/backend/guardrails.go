@@ -0,0 +1,193 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Guardrail violation types stored in Span.ViolationType
+const (
+	ViolationContentFilter = "content_filter"
+	ViolationRefusal       = "refusal"
+	ViolationGuardrail     = "guardrail"
+)
+
+// finish reasons that mean the provider blocked or filtered the output
+var filteredFinishReasons = map[string]bool{
+	"content_filter":       true,
+	"safety":               true,
+	"prohibited_content":   true,
+	"blocklist":            true,
+	"spii":                 true,
+	"recitation":           true,
+	"guardrail_intervened": true,
+}
+
+// detectGuardrailViolation inspects flattened span attributes and events for content filter
+// results, model refusals and guardrail interventions. It returns the violation type and a
+// short reason, or empty strings when the span looks clean.
+func detectGuardrailViolation(flat map[string]any) (string, string) {
+	// finish reasons (single value or array)
+	for _, k := range []string{"gen_ai.response.finish_reasons", "gen_ai.response.finish_reason", "llm.finish_reason"} {
+		for _, fr := range stringValues(flat[k]) {
+			if filteredFinishReasons[strings.ToLower(fr)] {
+				if strings.EqualFold(fr, "guardrail_intervened") {
+					return ViolationGuardrail, k + "=" + fr
+				}
+				return ViolationContentFilter, k + "=" + fr
+			}
+		}
+	}
+
+	// in key order, so spans matching several rules always report the same one
+	keys := make([]string, 0, len(flat))
+	for k := range flat {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := flat[k]
+		lk := strings.ToLower(k)
+		switch {
+		// Azure OpenAI style: ...content_filter_results.<category>.filtered = true
+		case strings.Contains(lk, "content_filter") && strings.HasSuffix(lk, ".filtered"):
+			if truthy(v) {
+				return ViolationContentFilter, k
+			}
+		// explicit refusal text (OpenAI structured outputs, OpenInference message.refusal)
+		case strings.HasSuffix(lk, ".refusal") || lk == "refusal":
+			if s, ok := v.(string); ok && strings.TrimSpace(s) != "" {
+				return ViolationRefusal, k
+			}
+		// generic guardrail conventions: guardrail(s).*.triggered|blocked|intervened = true, or action = blocked
+		case strings.Contains(lk, "guardrail"):
+			if (strings.HasSuffix(lk, ".triggered") || strings.HasSuffix(lk, ".blocked") || strings.HasSuffix(lk, ".intervened")) && truthy(v) {
+				return ViolationGuardrail, k
+			}
+			if strings.HasSuffix(lk, ".action") || strings.HasSuffix(lk, ".outcome") {
+				if s, ok := v.(string); ok {
+					switch strings.ToLower(s) {
+					case "blocked", "block", "intervened", "guardrail_intervened", "denied":
+						return ViolationGuardrail, k + "=" + s
+					}
+				}
+			}
+		case strings.HasPrefix(lk, "moderation.") && (strings.HasSuffix(lk, ".flagged") || lk == "moderation.flagged"):
+			if truthy(v) {
+				return ViolationContentFilter, k
+			}
+		}
+	}
+
+	// events named after guardrails / moderation
+	if evs, ok := flat["span.events"].([]map[string]interface{}); ok {
+		for _, ev := range evs {
+			name, _ := ev["name"].(string)
+			ln := strings.ToLower(name)
+			if strings.Contains(ln, "guardrail") || strings.Contains(ln, "content_filter") || strings.Contains(ln, "moderation") {
+				return ViolationGuardrail, "event:" + name
+			}
+		}
+	}
+	return "", ""
+}
+
+// stringValues normalizes a string or array attribute into a list of strings
+func stringValues(v any) []string {
+	switch vv := v.(type) {
+	case string:
+		if vv == "" {
+			return nil
+		}
+		return []string{vv}
+	case []any:
+		out := make([]string, 0, len(vv))
+		for _, x := range vv {
+			if s, ok := x.(string); ok && s != "" {
+				out = append(out, s)
+			}
+		}
+		return out
+	case []string:
+		return vv
+	}
+	return nil
+}
+
+func truthy(v any) bool {
+	switch vv := v.(type) {
+	case bool:
+		return vv
+	case string:
+		return strings.EqualFold(vv, "true")
+	}
+	return false
+}
+
+// GuardrailStats aggregates violations over LLM spans
+type GuardrailStats struct {
+	Model          string  `json:"model,omitempty"`
+	LLMSpans       int64   `json:"llm_spans"`
+	Violations     int64   `json:"violations"`
+	Refusals       int64   `json:"refusals"`
+	Moderations    int64   `json:"moderations"`
+	RefusalRate    float64 `json:"refusal_rate"`
+	ModerationRate float64 `json:"moderation_rate"`
+}
+
+// GetGuardrailStats returns violation counts per model for LLM spans matching the filter
+func (g *GormDB) GetGuardrailStats(filter StatsFilter) ([]GuardrailStats, error) {
+	var rows []GuardrailStats
+	query := filter.apply(g.db.Model(&Span{})).
+		Select(`model,
+			COUNT(*) AS llm_spans,
+			SUM(CASE WHEN violation_type <> '' THEN 1 ELSE 0 END) AS violations,
+			SUM(CASE WHEN violation_type = ? THEN 1 ELSE 0 END) AS refusals,
+			SUM(CASE WHEN violation_type IN ? THEN 1 ELSE 0 END) AS moderations`,
+			ViolationRefusal, []string{ViolationContentFilter, ViolationGuardrail}).
+		Where("model <> ''").
+		Group("model").
+		Order("llm_spans DESC")
+	if err := query.Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	for i := range rows {
+		rows[i].RefusalRate = ratio(rows[i].Refusals, rows[i].LLMSpans)
+		rows[i].ModerationRate = ratio(rows[i].Moderations, rows[i].LLMSpans)
+	}
+	return rows, nil
+}
+
+// getGuardrailStatsHandler returns refusal and moderation rates overall and per model
+func getGuardrailStatsHandler(db Database, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := parseStatsFilter(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		perModel, err := db.GetGuardrailStats(filter)
+		if err != nil {
+			logger.Error("Failed to get guardrail stats: %v", err)
+			http.Error(w, fmt.Sprintf("Failed to get guardrail stats: %v", err), http.StatusInternalServerError)
+			return
+		}
+		total := GuardrailStats{}
+		for _, m := range perModel {
+			total.LLMSpans += m.LLMSpans
+			total.Violations += m.Violations
+			total.Refusals += m.Refusals
+			total.Moderations += m.Moderations
+		}
+		total.RefusalRate = ratio(total.Refusals, total.LLMSpans)
+		total.ModerationRate = ratio(total.Moderations, total.LLMSpans)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"total":     total,
+			"per_model": perModel,
+		})
+	}
+}
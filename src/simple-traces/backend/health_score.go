@@ -0,0 +1,219 @@
+package backend
+
+import (
+	"math"
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+// Weights of the health score components; a trace scores 100 minus the weighted severities
+const (
+	healthWeightErrors   = 40
+	healthWeightRetries  = 20
+	healthWeightLatency  = 25
+	healthWeightRefusals = 15
+)
+
+const (
+	// healthBaselineWindow is the history root spans are compared against
+	healthBaselineWindow = 7 * 24 * time.Hour
+	// healthBaselineMinSamples is the history needed before latency counts towards the score
+	healthBaselineMinSamples = 10
+	// healthMaxSpans bounds the spans read to score one trace
+	healthMaxSpans = 20000
+)
+
+// TraceHealth is the composite health score of a trace group, from 0 (worst) to 100.
+// It is recomputed by the HealthScorer whenever the trace gained spans.
+type TraceHealth struct {
+	TraceID   string `gorm:"primaryKey" json:"-"`
+	ProjectID string `gorm:"index" json:"-"`
+	Score     int    `gorm:"index" json:"score"`
+	Errors    int    `json:"errors"`
+	// Retries are spans following a failed sibling of the same name
+	Retries int `json:"retries"`
+	// Refusals are spans refused or blocked by the model or a guardrail
+	Refusals int `json:"refusals"`
+	// LatencyRatio is the root span duration over the median of the same root span in the
+	// trailing 7 days; 0 when there is not enough history
+	LatencyRatio float64   `json:"latency_ratio"`
+	SpanCount    int64     `json:"-"`
+	ComputedAt   time.Time `json:"computed_at"`
+}
+
+// healthSeverity maps a count of bad events to 0..1: one halves the component, each further one halves the rest
+func healthSeverity(n int) float64 {
+	return 1 - math.Pow(0.5, float64(n))
+}
+
+// healthScore combines the components into the 0..100 score. Latency starts to cost at the
+// baseline and costs fully at 4x the baseline.
+func healthScore(h *TraceHealth) int {
+	latency := 0.0
+	if h.LatencyRatio > 1 {
+		latency = math.Min((h.LatencyRatio-1)/3, 1)
+	}
+	penalty := healthWeightErrors*healthSeverity(h.Errors) +
+		healthWeightRetries*healthSeverity(h.Retries) +
+		healthWeightLatency*latency +
+		healthWeightRefusals*healthSeverity(h.Refusals)
+	return int(math.Round(100 - penalty))
+}
+
+// ScoreTraceHealth computes and stores the health of one trace; nil when the trace has no spans
+func (g *GormDB) ScoreTraceHealth(traceID string) (*TraceHealth, error) {
+	var spans []Span
+	if err := g.db.Select("span_id, parent_span_id, project_id, name, status_code, violation_type, start_time, end_time, duration_ms").
+		Where("trace_id = ?", traceID).
+		Order("start_time ASC, seq ASC").
+		Limit(healthMaxSpans).
+		Find(&spans).Error; err != nil {
+		return nil, err
+	}
+	if len(spans) == 0 {
+		return nil, nil
+	}
+	var count int64
+	if err := g.db.Model(&Span{}).Where("trace_id = ?", traceID).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	h := &TraceHealth{TraceID: traceID, ProjectID: spans[0].ProjectID, SpanCount: count, ComputedAt: time.Now()}
+
+	ids := make(map[string]bool, len(spans))
+	for _, sp := range spans {
+		ids[sp.SpanID] = true
+	}
+	type sibling struct{ parent, name string }
+	failed := make(map[sibling]bool)
+	var root *Span
+	for i, sp := range spans {
+		if sp.StatusCode == "ERROR" {
+			h.Errors++
+		}
+		if sp.ViolationType != "" {
+			h.Refusals++
+		}
+		key := sibling{sp.ParentSpanID, sp.Name}
+		if failed[key] {
+			h.Retries++
+		}
+		failed[key] = sp.StatusCode == "ERROR"
+		if root == nil && (sp.ParentSpanID == "" || !ids[sp.ParentSpanID]) {
+			root = &spans[i]
+		}
+	}
+	if root != nil && root.DurationMS > 0 {
+		var durations []int64
+		if err := g.db.Model(&Span{}).
+			Where("project_id = ? AND name = ? AND parent_span_id = '' AND start_time > ? AND trace_id <> ?",
+				h.ProjectID, root.Name, time.Now().Add(-healthBaselineWindow), traceID).
+			Limit(10000).
+			Pluck("duration_ms", &durations).Error; err != nil {
+			return nil, err
+		}
+		if len(durations) >= healthBaselineMinSamples {
+			if p50 := percentile(durations, 50); p50 > 0 {
+				h.LatencyRatio = math.Round(float64(root.DurationMS)/float64(p50)*100) / 100
+			}
+		}
+	}
+	h.Score = healthScore(h)
+	if err := g.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(h).Error; err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// GetTracesNeedingHealthScore lists traces idle since before idleBefore whose span count changed
+// since they were last scored, most recent first. Traces older than the baseline window are skipped.
+func (g *GormDB) GetTracesNeedingHealthScore(idleBefore time.Time, limit int) ([]string, error) {
+	var ids []string
+	err := g.db.Model(&Span{}).
+		Select("trace_id").
+		Where("end_time > ?", time.Now().Add(-healthBaselineWindow)).
+		Group("trace_id").
+		Having("MAX(end_time) < ? AND COUNT(*) <> COALESCE((SELECT span_count FROM trace_healths WHERE trace_healths.trace_id = spans.trace_id), -1)", idleBefore).
+		Order("MAX(end_time) DESC").
+		Limit(limit).
+		Pluck("trace_id", &ids).Error
+	return ids, err
+}
+
+// attachHealth fills the health of trace groups that have been scored; failures only drop the field
+func (g *GormDB) attachHealth(groups []TraceGroup) {
+	if len(groups) == 0 {
+		return
+	}
+	ids := make([]string, len(groups))
+	for i, gr := range groups {
+		ids[i] = gr.TraceID
+	}
+	var rows []TraceHealth
+	if err := g.db.Where("trace_id IN ?", ids).Find(&rows).Error; err != nil {
+		return
+	}
+	byID := make(map[string]TraceHealth, len(rows))
+	for _, r := range rows {
+		byID[r.TraceID] = r
+	}
+	for i := range groups {
+		if h, ok := byID[groups[i].TraceID]; ok {
+			groups[i].Health = &h
+		}
+	}
+}
+
+// HealthScorer periodically scores traces that went quiet since they last changed
+type HealthScorer struct {
+	db     Database
+	logger *Logger
+	quiet  time.Duration
+	stop   chan struct{}
+}
+
+// NewHealthScorer starts the background worker
+func NewHealthScorer(db Database, config *Config, logger *Logger) *HealthScorer {
+	s := &HealthScorer{
+		db:     db,
+		logger: logger,
+		quiet:  config.HealthScoreQuietPeriod,
+		stop:   make(chan struct{}),
+	}
+	go s.loop(config.HealthScoreInterval)
+	return s
+}
+
+// Close stops the worker
+func (s *HealthScorer) Close() {
+	close(s.stop)
+}
+
+func (s *HealthScorer) loop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.runOnce()
+		}
+	}
+}
+
+func (s *HealthScorer) runOnce() {
+	ids, err := s.db.GetTracesNeedingHealthScore(time.Now().Add(-s.quiet), 200)
+	if err != nil {
+		s.logger.Error("Failed to list traces to score: %v", err)
+		return
+	}
+	for _, id := range ids {
+		if _, err := s.db.ScoreTraceHealth(id); err != nil {
+			s.logger.Warn("Failed to score trace %s: %v", id, err)
+		}
+	}
+	if len(ids) > 0 {
+		s.logger.Debug("Scored the health of %d traces", len(ids))
+	}
+}
@@ -0,0 +1,168 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// idSegment matches path segments that are ids (numbers, UUIDs, long hex) rather than route parts
+var idSegment = regexp.MustCompile(`^(\d+|[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}|[0-9a-fA-F]{16,})$`)
+
+// extractHTTPAttrs reads method, route and response status of an HTTP span. Without http.route
+// the route is the request path with id segments replaced by {id}, so analytics group by endpoint.
+func extractHTTPAttrs(name string, attrs map[string]any) (method, route string, status int64) {
+	for _, key := range []string{"http.request.method", "http.method"} {
+		if s, ok := attrs[key].(string); ok && s != "" {
+			method = strings.ToUpper(s)
+			break
+		}
+	}
+	for _, key := range httpStatusKeys {
+		if n, ok := asInt(attrs[key]); ok {
+			status = n
+			break
+		}
+	}
+	if s, ok := attrs["http.route"].(string); ok && s != "" {
+		return method, s, status
+	}
+	path := ""
+	for _, key := range []string{"url.path", "http.target"} {
+		if s, ok := attrs[key].(string); ok && s != "" {
+			path = s
+			break
+		}
+	}
+	if path == "" {
+		for _, key := range []string{"url.full", "http.url"} {
+			if s, ok := attrs[key].(string); ok && s != "" {
+				path = urlPathOf(s)
+				break
+			}
+		}
+	}
+	// server spans are often named "GET /users/{id}"
+	if path == "" {
+		if verb, rest, ok := strings.Cut(name, " "); ok && strings.HasPrefix(rest, "/") {
+			path = rest
+			if method == "" {
+				method = strings.ToUpper(verb)
+			}
+		}
+	}
+	if i := strings.IndexAny(path, "?#"); i >= 0 {
+		path = path[:i]
+	}
+	if path == "" {
+		return method, "", status
+	}
+	segments := strings.Split(path, "/")
+	for i, seg := range segments {
+		if idSegment.MatchString(seg) {
+			segments[i] = "{id}"
+		}
+	}
+	return method, strings.Join(segments, "/"), status
+}
+
+// HTTPRouteStats reports traffic, errors and latency of one HTTP endpoint
+type HTTPRouteStats struct {
+	Method string `json:"method,omitempty"`
+	Route  string `json:"route"`
+	Count  int64  `json:"count"`
+	// Requests per minute over the queried range
+	Throughput float64 `json:"throughput_per_min"`
+	// Errors are 5xx responses or spans in ERROR status
+	Errors    int64   `json:"errors"`
+	ErrorRate float64 `json:"error_rate"`
+	// ClientErrors are 4xx responses
+	ClientErrors int64   `json:"client_errors"`
+	AvgMS        float64 `json:"avg_ms"`
+	P50MS        int64   `json:"p50_ms"`
+	P95MS        int64   `json:"p95_ms"`
+}
+
+// GetHTTPStats aggregates http spans per method and route
+func (g *GormDB) GetHTTPStats(filter StatsFilter) ([]HTTPRouteStats, error) {
+	var rows []struct {
+		HTTPMethod     string
+		HTTPRoute      string
+		HTTPStatusCode int64
+		StatusCode     string
+		DurationMS     int64
+	}
+	if err := filter.apply(g.db.Model(&Span{})).
+		Select("http_method, http_route, http_status_code, status_code, duration_ms").
+		Where("category = ?", "http").
+		Limit(200000).
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	type key struct{ method, route string }
+	durations := make(map[key][]int64)
+	stats := make(map[key]*HTTPRouteStats)
+	for _, r := range rows {
+		k := key{r.HTTPMethod, r.HTTPRoute}
+		st := stats[k]
+		if st == nil {
+			st = &HTTPRouteStats{Method: r.HTTPMethod, Route: r.HTTPRoute}
+			stats[k] = st
+		}
+		st.Count++
+		switch {
+		case r.HTTPStatusCode >= 500 || r.StatusCode == "ERROR":
+			st.Errors++
+		case r.HTTPStatusCode >= 400:
+			st.ClientErrors++
+		}
+		durations[k] = append(durations[k], r.DurationMS)
+	}
+	end := filter.Until
+	if end.IsZero() {
+		end = time.Now()
+	}
+	minutes := end.Sub(filter.Since).Minutes()
+	out := make([]HTTPRouteStats, 0, len(stats))
+	for k, st := range stats {
+		ds := durations[k]
+		st.ErrorRate = ratio(st.Errors, st.Count)
+		st.AvgMS = average(ds)
+		st.P50MS = percentile(ds, 50)
+		st.P95MS = percentile(ds, 95)
+		if minutes > 0 {
+			st.Throughput = float64(st.Count) / minutes
+		}
+		out = append(out, *st)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Route < out[j].Route
+	})
+	return out, nil
+}
+
+// getHTTPStatsHandler returns throughput, error rate and latency per HTTP route
+func getHTTPStatsHandler(db Database, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := parseStatsFilter(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		stats, err := db.GetHTTPStats(filter)
+		if err != nil {
+			logger.Error("Failed to get HTTP stats: %v", err)
+			http.Error(w, fmt.Sprintf("Failed to get HTTP stats: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats)
+	}
+}
@@ -0,0 +1,210 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ingestLagRepeat is how often a warning is repeated while exporters stay behind
+const ingestLagRepeat = 5 * time.Minute
+
+// ingestLagBuckets are the upper bounds of the lag distribution, in milliseconds
+var ingestLagBuckets = []int64{1000, 5000, 30000, 60000, 300000, 900000}
+
+// ingestLag is the time between a span's end and its arrival at the server. Spans without an
+// end time have no lag; exporter clocks running ahead count as zero.
+func ingestLag(received, end time.Time) *int64 {
+	if end.UnixNano() <= 0 {
+		return nil
+	}
+	lag := max(received.Sub(end).Milliseconds(), 0)
+	return &lag
+}
+
+// IngestLagMonitor warns when exporters fall behind: when the p95 ingest lag of an export
+// reaches the threshold, a warning is logged (and repeated every ingestLagRepeat while it lasts),
+// and once exports are timely again the recovery is logged.
+type IngestLagMonitor struct {
+	threshold time.Duration
+
+	mu        sync.Mutex
+	behind    bool
+	since     time.Time
+	lastAlert time.Time
+	lastP95   int64
+}
+
+// IngestLagStatus is the monitor's current state
+type IngestLagStatus struct {
+	ThresholdMS int64 `json:"threshold_ms"`
+	Behind      bool  `json:"behind"`
+	// Since is when exporters fell behind
+	Since *time.Time `json:"since,omitempty"`
+	// LastP95MS is the p95 lag of the latest export
+	LastP95MS int64 `json:"last_p95_ms"`
+}
+
+// NewIngestLagMonitor creates a monitor alerting at threshold; zero or less disables it (nil)
+func NewIngestLagMonitor(threshold time.Duration) *IngestLagMonitor {
+	metrics.Describe("simpletraces_ingest_lag_behind", "gauge", "1 while the p95 ingest lag of exports is above INGEST_LAG_ALERT")
+	metrics.Describe("simpletraces_ingest_lag_alerts_total", "counter", "Warnings logged because exporters fell behind")
+	if threshold <= 0 {
+		return nil
+	}
+	return &IngestLagMonitor{threshold: threshold}
+}
+
+// Observe checks the lags of one export's spans
+func (m *IngestLagMonitor) Observe(logger *Logger, spans []Span) {
+	if m == nil {
+		return
+	}
+	var lags []int64
+	for _, sp := range spans {
+		if sp.IngestLagMS != nil {
+			lags = append(lags, *sp.IngestLagMS)
+		}
+	}
+	if len(lags) == 0 {
+		return
+	}
+	p95 := percentile(lags, 95)
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastP95 = p95
+	if p95 >= m.threshold.Milliseconds() {
+		if !m.behind {
+			m.behind, m.since = true, now
+		} else if now.Sub(m.lastAlert) < ingestLagRepeat {
+			return
+		}
+		m.lastAlert = now
+		metrics.Set("simpletraces_ingest_lag_behind", 1)
+		metrics.Inc("simpletraces_ingest_lag_alerts_total")
+		logger.Warn("Exporters are falling behind: p95 ingest lag of the latest export is %s (alert threshold %s, behind since %s)",
+			time.Duration(p95)*time.Millisecond, m.threshold, m.since.Format(time.RFC3339))
+		return
+	}
+	if m.behind {
+		m.behind = false
+		metrics.Set("simpletraces_ingest_lag_behind", 0)
+		logger.Info("Exporters caught up after %s: p95 ingest lag is %s", now.Sub(m.since).Round(time.Second), time.Duration(p95)*time.Millisecond)
+	}
+}
+
+// Status reports the current state; nil when alerting is disabled
+func (m *IngestLagMonitor) Status() *IngestLagStatus {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st := &IngestLagStatus{ThresholdMS: m.threshold.Milliseconds(), Behind: m.behind, LastP95MS: m.lastP95}
+	if m.behind {
+		since := m.since
+		st.Since = &since
+	}
+	return st
+}
+
+// IngestLagSummary is the ingest lag distribution of a set of spans
+type IngestLagSummary struct {
+	ProjectID string  `json:"project_id,omitempty"`
+	Count     int64   `json:"count"`
+	AvgMS     float64 `json:"avg_ms"`
+	P50MS     int64   `json:"p50_ms"`
+	P95MS     int64   `json:"p95_ms"`
+	P99MS     int64   `json:"p99_ms"`
+	MaxMS     int64   `json:"max_ms"`
+}
+
+// IngestLagBucket counts spans whose lag is at most LeMS (the last bucket has no bound)
+type IngestLagBucket struct {
+	LeMS  int64 `json:"le_ms,omitempty"`
+	Count int64 `json:"count"`
+}
+
+// IngestLagStats is the ingest lag of spans overall, per project and as a histogram
+type IngestLagStats struct {
+	IngestLagSummary
+	Buckets  []IngestLagBucket  `json:"buckets"`
+	Projects []IngestLagSummary `json:"projects"`
+	Alert    *IngestLagStatus   `json:"alert,omitempty"`
+}
+
+func summarizeIngestLag(projectID string, lags []int64) IngestLagSummary {
+	s := IngestLagSummary{ProjectID: projectID, Count: int64(len(lags)), AvgMS: average(lags)}
+	s.P50MS = percentile(lags, 50)
+	s.P95MS = percentile(lags, 95)
+	s.P99MS = percentile(lags, 99)
+	if len(lags) > 0 {
+		s.MaxMS = lags[len(lags)-1] // sorted by percentile
+	}
+	return s
+}
+
+// GetIngestLagStats computes the ingest lag distribution of spans in the filter's range. Spans
+// stored before lags were recorded are left out.
+func (g *GormDB) GetIngestLagStats(filter StatsFilter) (IngestLagStats, error) {
+	var rows []struct {
+		ProjectID   string
+		IngestLagMS int64
+	}
+	if err := filter.apply(g.db.Model(&Span{})).
+		Select("project_id, ingest_lag_ms").
+		Where("ingest_lag_ms IS NOT NULL").
+		Limit(200000).
+		Scan(&rows).Error; err != nil {
+		return IngestLagStats{}, err
+	}
+	all := make([]int64, 0, len(rows))
+	byProject := make(map[string][]int64)
+	buckets := make([]IngestLagBucket, len(ingestLagBuckets)+1)
+	for i, le := range ingestLagBuckets {
+		buckets[i].LeMS = le
+	}
+	for _, r := range rows {
+		all = append(all, r.IngestLagMS)
+		byProject[r.ProjectID] = append(byProject[r.ProjectID], r.IngestLagMS)
+		i := sort.Search(len(ingestLagBuckets), func(i int) bool { return r.IngestLagMS <= ingestLagBuckets[i] })
+		buckets[i].Count++
+	}
+	stats := IngestLagStats{IngestLagSummary: summarizeIngestLag("", all), Buckets: buckets, Projects: []IngestLagSummary{}}
+	for project, lags := range byProject {
+		stats.Projects = append(stats.Projects, summarizeIngestLag(project, lags))
+	}
+	sort.Slice(stats.Projects, func(i, j int) bool {
+		if stats.Projects[i].P95MS != stats.Projects[j].P95MS {
+			return stats.Projects[i].P95MS > stats.Projects[j].P95MS
+		}
+		return stats.Projects[i].ProjectID < stats.Projects[j].ProjectID
+	})
+	return stats, nil
+}
+
+// getIngestLagStatsHandler returns the ingest lag distribution, slowest projects first, and
+// whether exporters are currently behind
+func getIngestLagStatsHandler(db Database, monitor *IngestLagMonitor, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := parseStatsFilter(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		stats, err := db.GetIngestLagStats(filter)
+		if err != nil {
+			logger.Error("Failed to get ingest lag stats: %v", err)
+			http.Error(w, fmt.Sprintf("Failed to get ingest lag stats: %v", err), http.StatusInternalServerError)
+			return
+		}
+		stats.Alert = monitor.Status()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats)
+	}
+}
@@ -0,0 +1,176 @@
+package backend
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	tracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+)
+
+// IngestQueue decouples /v1/traces from database writes. Decoded exports are queued and
+// answered right away; writer goroutines take them off the queue and write several small
+// exports as one batch, so a burst of tiny exports becomes a few large inserts. Once highWater
+// exports are queued, further ones are refused so the exporter backs off instead of the
+// handler blocking or the queue growing without bound.
+//
+// Spans are only validated once written, so the exporter is not told about rejected spans
+// (they still show in the metrics and dead letters), and exports still queued when the
+// process is killed are lost.
+type IngestQueue struct {
+	h       *OTLPHandler
+	exports chan *tracepb.ExportTraceServiceRequest
+	// a writer collects exports for up to batchWait or until batchSpans spans
+	batchSpans int
+	batchWait  time.Duration
+	highWater  int
+	// spans of the queued exports, and exports refused past the high-water mark
+	spans   atomic.Int64
+	refused atomic.Int64
+	wg      sync.WaitGroup
+	// closed is set by Close under the write lock; Offer sends under the read lock so it
+	// never sends on the closed channel
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewIngestQueue starts writers draining a queue of size exports into h; nil when size is
+// zero or less (exports are written on the request goroutine). Exports are refused once
+// highWater are queued; zero or more than size means when the queue is full.
+func NewIngestQueue(h *OTLPHandler, size, highWater, writers, batchSpans int, batchWait time.Duration) *IngestQueue {
+	if size <= 0 {
+		return nil
+	}
+	metrics.Describe("simpletraces_ingest_batches_total", "counter", "Batches written by the ingest queue writers")
+	metrics.Describe("simpletraces_ingest_batch_exports_total", "counter", "Exports written by the ingest queue writers, coalesced into batches")
+	metrics.Describe("simpletraces_ingest_queue_exports", "gauge", "Exports waiting in the ingest queue")
+	metrics.Describe("simpletraces_ingest_queue_spans", "gauge", "Spans of the exports waiting in the ingest queue")
+	metrics.Describe("simpletraces_ingest_queue_refused_total", "counter", "Exports answered with 429 because the ingest queue was at its high-water mark")
+	if highWater <= 0 || highWater > size {
+		highWater = size
+	}
+	q := &IngestQueue{
+		h:          h,
+		exports:    make(chan *tracepb.ExportTraceServiceRequest, size),
+		batchSpans: max(batchSpans, 1),
+		batchWait:  batchWait,
+		highWater:  highWater,
+	}
+	for range max(writers, 1) {
+		q.wg.Go(q.write)
+	}
+	return q
+}
+
+// Offer queues an export unless the queue has reached its high-water mark or is closed
+func (q *IngestQueue) Offer(req *tracepb.ExportTraceServiceRequest) bool {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if !q.closed && len(q.exports) < q.highWater {
+		n := int64(countSpans(req))
+		select {
+		case q.exports <- req:
+			q.spans.Add(n)
+			q.gauges()
+			return true
+		default:
+		}
+	}
+	q.refused.Add(1)
+	metrics.Inc("simpletraces_ingest_queue_refused_total")
+	return false
+}
+
+// taken accounts for an export a writer took off the queue
+func (q *IngestQueue) taken(req *tracepb.ExportTraceServiceRequest) {
+	q.spans.Add(-int64(countSpans(req)))
+	q.gauges()
+}
+
+func (q *IngestQueue) gauges() {
+	metrics.Set("simpletraces_ingest_queue_exports", float64(len(q.exports)))
+	metrics.Set("simpletraces_ingest_queue_spans", float64(q.spans.Load()))
+}
+
+// IngestQueueStatus is the fill level of the ingest queue
+type IngestQueueStatus struct {
+	Enabled   bool  `json:"enabled"`
+	Exports   int   `json:"exports"`
+	Spans     int64 `json:"spans"`
+	Capacity  int   `json:"capacity"`
+	HighWater int   `json:"high_water"`
+	// Refused counts exports answered with 429 since the start
+	Refused int64 `json:"refused"`
+}
+
+// Status reports the queue depth; a nil queue reports itself disabled
+func (q *IngestQueue) Status() IngestQueueStatus {
+	if q == nil {
+		return IngestQueueStatus{}
+	}
+	return IngestQueueStatus{
+		Enabled:   true,
+		Exports:   len(q.exports),
+		Spans:     q.spans.Load(),
+		Capacity:  cap(q.exports),
+		HighWater: q.highWater,
+		Refused:   q.refused.Load(),
+	}
+}
+
+// write takes an export off the queue, adds more until the batch is full or batchWait has
+// passed, and writes them together
+func (q *IngestQueue) write() {
+	for req := range q.exports {
+		q.taken(req)
+		batch := []*tracepb.ExportTraceServiceRequest{req}
+		spans := countSpans(req)
+		timer := time.NewTimer(q.batchWait)
+	collect:
+		for spans < q.batchSpans {
+			select {
+			case next, ok := <-q.exports:
+				if !ok {
+					break collect
+				}
+				q.taken(next)
+				batch = append(batch, next)
+				spans += countSpans(next)
+			case <-timer.C:
+				break collect
+			}
+		}
+		timer.Stop()
+
+		metrics.Inc("simpletraces_ingest_batches_total")
+		metrics.Add("simpletraces_ingest_batch_exports_total", float64(len(batch)))
+		res, err := q.h.export(batch, true)
+		if err != nil {
+			q.h.logger.Error("Failed to write %d queued exports: %v", len(batch), err)
+		} else if res.Rejected > 0 {
+			q.h.logger.Warn("Rejected %d spans of %d queued exports: %s", res.Rejected, len(batch), res.Message)
+		}
+	}
+}
+
+// Close stops accepting exports and waits until the queued ones are written; exports offered
+// afterwards are refused
+func (q *IngestQueue) Close() {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.exports)
+	}
+	q.mu.Unlock()
+	q.wg.Wait()
+}
+
+// getIngestQueueStatsHandler reports the depth of the ingest queue
+func getIngestQueueStatsHandler(q *IngestQueue) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(q.Status())
+	}
+}
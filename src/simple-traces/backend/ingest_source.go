@@ -0,0 +1,45 @@
+package backend
+
+// IngestSource feeds spans into the OTLP handler from somewhere other than an HTTP request,
+// such as a message queue. It runs from the moment it is started until Close.
+type IngestSource interface {
+	Name() string
+	Close() error
+}
+
+// ingestSourceFactory starts a source if its options are set; it returns nil when they are not
+type ingestSourceFactory func(config *Config, h *OTLPHandler, logger *Logger) (IngestSource, error)
+
+// ingestSources are the sources that can be configured, each enabled by its own options
+var ingestSources = []ingestSourceFactory{
+	newNATSSource,
+	newDirWatcher,
+}
+
+// StartIngestSources starts every configured ingest source. When one fails to start, the ones
+// started before it are closed again.
+func StartIngestSources(config *Config, h *OTLPHandler, logger *Logger) ([]IngestSource, error) {
+	var started []IngestSource
+	for _, factory := range ingestSources {
+		src, err := factory(config, h, logger)
+		if err != nil {
+			CloseIngestSources(started, logger)
+			return nil, err
+		}
+		if src == nil {
+			continue
+		}
+		started = append(started, src)
+		logger.Info("Ingest source %s started", src.Name())
+	}
+	return started, nil
+}
+
+// CloseIngestSources stops the sources, most recently started first
+func CloseIngestSources(sources []IngestSource, logger *Logger) {
+	for i := len(sources) - 1; i >= 0; i-- {
+		if err := sources[i].Close(); err != nil {
+			logger.Warn("Failed to close ingest source %s: %v", sources[i].Name(), err)
+		}
+	}
+}
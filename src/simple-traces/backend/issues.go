@@ -0,0 +1,293 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// IssueTracker creates issues in an external tracker and returns their URL
+type IssueTracker interface {
+	CreateIssue(title, body string) (string, error)
+	Name() string
+}
+
+// NewIssueTracker returns the configured tracker, or nil when ISSUE_TRACKER is unset
+func NewIssueTracker(config *Config) (IssueTracker, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	switch strings.ToLower(config.IssueTracker) {
+	case "":
+		return nil, nil
+	case "github":
+		if config.GitHubRepo == "" || config.GitHubToken == "" {
+			return nil, fmt.Errorf("github issue tracker requires GITHUB_REPO and GITHUB_TOKEN")
+		}
+		api := strings.TrimSuffix(config.GitHubAPIURL, "/")
+		if api == "" {
+			api = "https://api.github.com"
+		}
+		return &githubTracker{api: api, repo: config.GitHubRepo, token: config.GitHubToken, client: client}, nil
+	case "jira":
+		if config.JiraURL == "" || config.JiraProject == "" || config.JiraAPIToken == "" {
+			return nil, fmt.Errorf("jira issue tracker requires JIRA_URL, JIRA_PROJECT and JIRA_API_TOKEN")
+		}
+		issueType := config.JiraIssueType
+		if issueType == "" {
+			issueType = "Bug"
+		}
+		return &jiraTracker{
+			baseURL:   strings.TrimSuffix(config.JiraURL, "/"),
+			project:   config.JiraProject,
+			issueType: issueType,
+			email:     config.JiraEmail,
+			token:     config.JiraAPIToken,
+			client:    client,
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown ISSUE_TRACKER %q (want github or jira)", config.IssueTracker)
+}
+
+type githubTracker struct {
+	api    string
+	repo   string
+	token  string
+	client *http.Client
+}
+
+func (t *githubTracker) Name() string { return "github" }
+
+func (t *githubTracker) CreateIssue(title, body string) (string, error) {
+	payload, _ := json.Marshal(map[string]string{"title": title, "body": body})
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/repos/%s/issues", t.api, t.repo), bytes.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+t.token)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Content-Type", "application/json")
+	var out struct {
+		HTMLURL string `json:"html_url"`
+	}
+	if err := doIssueRequest(t.client, req, &out); err != nil {
+		return "", err
+	}
+	return out.HTMLURL, nil
+}
+
+type jiraTracker struct {
+	baseURL   string
+	project   string
+	issueType string
+	email     string
+	token     string
+	client    *http.Client
+}
+
+func (t *jiraTracker) Name() string { return "jira" }
+
+func (t *jiraTracker) CreateIssue(title, body string) (string, error) {
+	// REST API v2 accepts a plain text description (v3 requires Atlassian Document Format)
+	payload, _ := json.Marshal(map[string]any{
+		"fields": map[string]any{
+			"project":     map[string]string{"key": t.project},
+			"issuetype":   map[string]string{"name": t.issueType},
+			"summary":     title,
+			"description": body,
+		},
+	})
+	req, err := http.NewRequest(http.MethodPost, t.baseURL+"/rest/api/2/issue", bytes.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+	if t.email != "" {
+		req.SetBasicAuth(t.email, t.token) // Jira Cloud
+	} else {
+		req.Header.Set("Authorization", "Bearer "+t.token) // Jira Data Center personal access token
+	}
+	req.Header.Set("Content-Type", "application/json")
+	var out struct {
+		Key string `json:"key"`
+	}
+	if err := doIssueRequest(t.client, req, &out); err != nil {
+		return "", err
+	}
+	return t.baseURL + "/browse/" + out.Key, nil
+}
+
+func doIssueRequest(client *http.Client, req *http.Request, out any) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("tracker returned %s: %s", resp.Status, strings.TrimSpace(string(raw)))
+	}
+	return json.Unmarshal(raw, out)
+}
+
+// traceShareLink points at the conversation view when the trace belongs to one, else at the trace API
+func traceShareLink(publicURL, traceID, conversationID string) string {
+	base := strings.TrimSuffix(publicURL, "/")
+	if conversationID != "" {
+		return base + "/conversations/" + url.PathEscape(conversationID)
+	}
+	return base + "/api/trace-groups/" + url.PathEscape(traceID)
+}
+
+// traceIssueContent builds the title and markdown body of an issue for a trace group
+func traceIssueContent(traceID string, spans []Span, link string) (string, string) {
+	var root *Span
+	var errs []Span
+	models := make(map[string]bool)
+	conversationID := ""
+	var start, end time.Time
+	for i := range spans {
+		sp := &spans[i]
+		if root == nil || sp.ParentSpanID == "" || strings.Trim(sp.ParentSpanID, "0") == "" {
+			if root == nil || sp.StartTime.Before(root.StartTime) {
+				root = sp
+			}
+		}
+		if sp.StatusCode == "ERROR" {
+			errs = append(errs, *sp)
+		}
+		if sp.Model != "" {
+			models[sp.Model] = true
+		}
+		if conversationID == "" {
+			conversationID = sp.ConversationID
+		}
+		if start.IsZero() || sp.StartTime.Before(start) {
+			start = sp.StartTime
+		}
+		if sp.EndTime.After(end) {
+			end = sp.EndTime
+		}
+	}
+
+	name := traceID
+	if root != nil {
+		name = root.Name
+	}
+	title := "Trace " + name
+	if len(errs) > 0 {
+		title = fmt.Sprintf("Error in %s: %s", name, firstLine(errs[0].StatusDesc, errs[0].Name))
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "- **Trace:** `%s`\n", traceID)
+	if conversationID != "" {
+		fmt.Fprintf(&b, "- **Conversation:** `%s`\n", conversationID)
+	}
+	fmt.Fprintf(&b, "- **Started:** %s\n", start.UTC().Format(time.RFC3339))
+	fmt.Fprintf(&b, "- **Duration:** %s\n", end.Sub(start).Round(time.Millisecond))
+	fmt.Fprintf(&b, "- **Spans:** %d (%d errors)\n", len(spans), len(errs))
+	if len(models) > 0 {
+		names := make([]string, 0, len(models))
+		for m := range models {
+			names = append(names, m)
+		}
+		sort.Strings(names)
+		fmt.Fprintf(&b, "- **Models:** %s\n", strings.Join(names, ", "))
+	}
+	if len(errs) > 0 {
+		b.WriteString("\n**Errors:**\n\n")
+		for i, e := range errs {
+			if i == 10 {
+				fmt.Fprintf(&b, "- ... and %d more\n", len(errs)-10)
+				break
+			}
+			fmt.Fprintf(&b, "- `%s`: %s\n", e.Name, firstLine(e.StatusDesc, "error"))
+		}
+	}
+	if link != "" {
+		fmt.Fprintf(&b, "\n[Open in Simple Traces](%s)\n", link)
+	}
+	return title, b.String()
+}
+
+func firstLine(s, fallback string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = s[:i]
+	}
+	if len(s) > 120 {
+		s = s[:120] + "..."
+	}
+	if s == "" {
+		return fallback
+	}
+	return s
+}
+
+func (g *GormDB) SetTraceIssueURL(traceID, issueURL string) error {
+	var t TraceTriage
+	if err := g.db.Where("trace_id = ?", traceID).Limit(1).Find(&t).Error; err != nil {
+		return err
+	}
+	if t.TraceID == "" {
+		t = TraceTriage{TraceID: traceID, Status: TriageOpen}
+	}
+	t.IssueURL = issueURL
+	t.UpdatedAt = time.Now()
+	return g.db.Save(&t).Error
+}
+
+// createTraceIssueHandler files an issue for a trace group and stores its URL on the trace.
+// An existing issue is returned unless ?force=true.
+func createTraceIssueHandler(db Database, tracker IssueTracker, publicURL string, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if tracker == nil {
+			http.Error(w, "No issue tracker configured (set ISSUE_TRACKER)", http.StatusNotImplemented)
+			return
+		}
+		traceID := strings.TrimSpace(mux.Vars(r)["trace_id"])
+		spans, err := db.GetTraceGroupSpans(traceID, 5000)
+		if err != nil {
+			logger.Error("Failed to get spans of trace %s: %v", traceID, err)
+			http.Error(w, fmt.Sprintf("Failed to get trace: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if len(spans) == 0 {
+			http.Error(w, "Trace group not found", http.StatusNotFound)
+			return
+		}
+		if r.URL.Query().Get("force") != "true" {
+			groups, err := db.GetTraceGroupsFiltered(1, time.Time{}, TraceGroupFilter{TraceID: traceID})
+			if err == nil && len(groups) == 1 && groups[0].IssueURL != "" {
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(map[string]any{"issue_url": groups[0].IssueURL, "created": false})
+				return
+			}
+		}
+
+		link := ""
+		if publicURL != "" {
+			link = traceShareLink(publicURL, traceID, spans[0].ConversationID)
+		}
+		title, body := traceIssueContent(traceID, spans, link)
+		issueURL, err := tracker.CreateIssue(title, body)
+		if err != nil {
+			logger.Error("Failed to create %s issue for trace %s: %v", tracker.Name(), traceID, err)
+			http.Error(w, fmt.Sprintf("Failed to create issue: %v", err), http.StatusBadGateway)
+			return
+		}
+		if err := db.SetTraceIssueURL(traceID, issueURL); err != nil {
+			logger.Error("Failed to store issue URL for trace %s: %v", traceID, err)
+		}
+		logger.Info("Created %s issue %s for trace %s", tracker.Name(), issueURL, traceID)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]any{"issue_url": issueURL, "created": true})
+	}
+}
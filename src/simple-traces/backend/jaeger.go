@@ -0,0 +1,786 @@
+package backend
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	tracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepbv1 "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+// Jaeger batches are decoded into these types from either wire format. Times are nanoseconds.
+type jaegerBatch struct {
+	process jaegerProcess
+	spans   []jaegerSpan
+}
+
+type jaegerProcess struct {
+	service string
+	tags    []jaegerTag
+}
+
+type jaegerSpan struct {
+	traceID, spanID, parentID []byte
+	followsFrom               [][2][]byte // trace and span ids of FOLLOWS_FROM references
+	name                      string
+	start, duration           uint64
+	tags                      []jaegerTag
+	logs                      []jaegerLog
+	process                   *jaegerProcess // set on proto spans that carry their own process
+}
+
+type jaegerTag struct {
+	key   string
+	value any // string, bool, int64, float64 or []byte
+}
+
+type jaegerLog struct {
+	time   uint64
+	fields []jaegerTag
+}
+
+// jaegerHandler accepts span batches from Jaeger clients at /api/traces, the Jaeger collector's
+// HTTP path: Thrift (binary protocol, as jaeger-client HTTP senders post them) or the
+// jaeger.api_v2 protobuf Batch with Content-Type application/x-protobuf
+func jaegerHandler(h *OTLPHandler, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := readOTLPBody(w, r, h.maxPayload)
+		defer r.Body.Close()
+		if err != nil {
+			status := http.StatusBadRequest
+			switch err.(type) {
+			case errUnsupportedEncoding:
+				status = http.StatusUnsupportedMediaType
+			case errPayloadTooLarge:
+				status = http.StatusRequestEntityTooLarge
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		var batch *jaegerBatch
+		switch ct := r.Header.Get("Content-Type"); {
+		case strings.Contains(ct, "protobuf"):
+			batch, err = decodeJaegerProto(body)
+		case ct == "" || strings.Contains(ct, "thrift"):
+			batch, err = decodeJaegerThrift(body)
+		default:
+			http.Error(w, fmt.Sprintf("unsupported Content-Type %q (want application/x-thrift or application/x-protobuf)", ct), http.StatusUnsupportedMediaType)
+			return
+		}
+		if err != nil {
+			logger.Warn("Failed to decode Jaeger batch: %v", err)
+			http.Error(w, fmt.Sprintf("Invalid Jaeger batch: %v", err), http.StatusBadRequest)
+			return
+		}
+		h.Export(batch.toOTLP())
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// toOTLP converts a batch to an OTLP export. The span.kind tag sets the span kind, error=true
+// marks the span as failed, FOLLOWS_FROM references become links and logs become events
+// named after their "event" field.
+func (b *jaegerBatch) toOTLP() *tracepb.ExportTraceServiceRequest {
+	resources := make(map[*jaegerProcess]*tracepbv1.ResourceSpans)
+	req := &tracepb.ExportTraceServiceRequest{}
+	for i := range b.spans {
+		js := &b.spans[i]
+		proc := js.process
+		if proc == nil {
+			proc = &b.process
+		}
+		rs := resources[proc]
+		if rs == nil {
+			service := proc.service
+			if service == "" {
+				service = "unknown"
+			}
+			attrs := []*commonpb.KeyValue{otlpAttr("service.name", service)}
+			for _, t := range proc.tags {
+				attrs = append(attrs, jaegerAttr(t))
+			}
+			rs = &tracepbv1.ResourceSpans{Resource: &resourcepb.Resource{Attributes: attrs}, ScopeSpans: []*tracepbv1.ScopeSpans{{}}}
+			resources[proc] = rs
+			req.ResourceSpans = append(req.ResourceSpans, rs)
+		}
+
+		sp := &tracepbv1.Span{
+			TraceId:           js.traceID,
+			SpanId:            js.spanID,
+			ParentSpanId:      js.parentID,
+			Name:              js.name,
+			Kind:              tracepbv1.Span_SPAN_KIND_INTERNAL,
+			StartTimeUnixNano: js.start,
+			EndTimeUnixNano:   js.start + js.duration,
+		}
+		for _, t := range js.tags {
+			switch t.key {
+			case "span.kind":
+				if kind, ok := zipkinKinds[strings.ToUpper(fmt.Sprint(t.value))]; ok {
+					sp.Kind = kind
+				}
+				continue
+			case "error":
+				if v, _ := t.value.(bool); v || t.value == "true" {
+					sp.Status = &tracepbv1.Status{Code: tracepbv1.Status_STATUS_CODE_ERROR}
+				}
+			}
+			sp.Attributes = append(sp.Attributes, jaegerAttr(t))
+		}
+		for _, ref := range js.followsFrom {
+			sp.Links = append(sp.Links, &tracepbv1.Span_Link{TraceId: ref[0], SpanId: ref[1]})
+		}
+		for _, l := range js.logs {
+			ev := &tracepbv1.Span_Event{Name: "log", TimeUnixNano: l.time}
+			for _, f := range l.fields {
+				if f.key == "event" {
+					ev.Name = fmt.Sprint(f.value)
+					continue
+				}
+				ev.Attributes = append(ev.Attributes, jaegerAttr(f))
+			}
+			if sp.Status != nil && sp.Status.Message == "" {
+				for _, f := range l.fields {
+					if f.key == "message" || f.key == "error.object" {
+						sp.Status.Message = fmt.Sprint(f.value)
+					}
+				}
+			}
+			sp.Events = append(sp.Events, ev)
+		}
+		rs.ScopeSpans[0].Spans = append(rs.ScopeSpans[0].Spans, sp)
+	}
+	return req
+}
+
+func jaegerAttr(t jaegerTag) *commonpb.KeyValue {
+	if b, ok := t.value.([]byte); ok {
+		return &commonpb.KeyValue{Key: t.key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_BytesValue{BytesValue: b}}}
+	}
+	return otlpAttr(t.key, t.value)
+}
+
+// jaegerID builds an OTLP id from Jaeger's 64-bit halves
+func jaegerID(parts ...int64) []byte {
+	b := make([]byte, 0, 8*len(parts))
+	for _, p := range parts {
+		b = binary.BigEndian.AppendUint64(b, uint64(p))
+	}
+	return b
+}
+
+// Thrift binary protocol types
+const (
+	thriftStop   = 0
+	thriftBool   = 2
+	thriftByte   = 3
+	thriftDouble = 4
+	thriftI16    = 6
+	thriftI32    = 8
+	thriftI64    = 10
+	thriftString = 11
+	thriftStruct = 12
+	thriftMap    = 13
+	thriftSet    = 14
+	thriftList   = 15
+)
+
+var errThriftShort = errors.New("truncated thrift data")
+
+// thriftReader decodes the Thrift binary protocol, just enough for jaeger.thrift
+type thriftReader struct {
+	b     []byte
+	depth int
+}
+
+func (r *thriftReader) next(n int) ([]byte, error) {
+	if n < 0 || len(r.b) < n {
+		return nil, errThriftShort
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v, nil
+}
+
+func (r *thriftReader) u8() (byte, error) {
+	b, err := r.next(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (r *thriftReader) i16() (int16, error) {
+	b, err := r.next(2)
+	if err != nil {
+		return 0, err
+	}
+	return int16(binary.BigEndian.Uint16(b)), nil
+}
+
+func (r *thriftReader) i32() (int32, error) {
+	b, err := r.next(4)
+	if err != nil {
+		return 0, err
+	}
+	return int32(binary.BigEndian.Uint32(b)), nil
+}
+
+func (r *thriftReader) i64() (int64, error) {
+	b, err := r.next(8)
+	if err != nil {
+		return 0, err
+	}
+	return int64(binary.BigEndian.Uint64(b)), nil
+}
+
+func (r *thriftReader) bytes() ([]byte, error) {
+	n, err := r.i32()
+	if err != nil {
+		return nil, err
+	}
+	return r.next(int(n))
+}
+
+// listHeader reads a list header and checks the element type
+func (r *thriftReader) listHeader(elem byte) (int, error) {
+	t, err := r.u8()
+	if err != nil {
+		return 0, err
+	}
+	n, err := r.i32()
+	if err != nil {
+		return 0, err
+	}
+	if t != elem || n < 0 || int(n) > len(r.b) {
+		return 0, fmt.Errorf("unexpected thrift list of type %d and size %d", t, n)
+	}
+	return int(n), nil
+}
+
+// fields calls fn for every field of a struct; fn must consume the value or call skip
+func (r *thriftReader) fields(fn func(id int16, t byte) error) error {
+	if r.depth++; r.depth > 32 {
+		return errors.New("thrift structs nested too deeply")
+	}
+	defer func() { r.depth-- }()
+	for {
+		t, err := r.u8()
+		if err != nil {
+			return err
+		}
+		if t == thriftStop {
+			return nil
+		}
+		id, err := r.i16()
+		if err != nil {
+			return err
+		}
+		if err := fn(id, t); err != nil {
+			return err
+		}
+	}
+}
+
+func (r *thriftReader) skip(t byte) error {
+	var err error
+	switch t {
+	case thriftBool, thriftByte:
+		_, err = r.next(1)
+	case thriftI16:
+		_, err = r.next(2)
+	case thriftI32:
+		_, err = r.next(4)
+	case thriftI64, thriftDouble:
+		_, err = r.next(8)
+	case thriftString:
+		_, err = r.bytes()
+	case thriftStruct:
+		err = r.fields(func(_ int16, t byte) error { return r.skip(t) })
+	case thriftMap:
+		var kt, vt byte
+		var n int32
+		if kt, err = r.u8(); err != nil {
+			return err
+		}
+		if vt, err = r.u8(); err != nil {
+			return err
+		}
+		if n, err = r.i32(); err != nil {
+			return err
+		}
+		for i := int32(0); i < n && err == nil; i++ {
+			if err = r.skip(kt); err == nil {
+				err = r.skip(vt)
+			}
+		}
+	case thriftSet, thriftList:
+		var et byte
+		var n int32
+		if et, err = r.u8(); err != nil {
+			return err
+		}
+		if n, err = r.i32(); err != nil {
+			return err
+		}
+		for i := int32(0); i < n && err == nil; i++ {
+			err = r.skip(et)
+		}
+	default:
+		err = fmt.Errorf("unknown thrift type %d", t)
+	}
+	return err
+}
+
+// decodeJaegerThrift decodes a jaeger.thrift Batch serialized with the binary protocol
+func decodeJaegerThrift(body []byte) (*jaegerBatch, error) {
+	r := &thriftReader{b: body}
+	batch := &jaegerBatch{}
+	err := r.fields(func(id int16, t byte) error {
+		switch {
+		case id == 1 && t == thriftStruct:
+			return r.thriftProcess(&batch.process)
+		case id == 2 && t == thriftList:
+			n, err := r.listHeader(thriftStruct)
+			if err != nil {
+				return err
+			}
+			for i := 0; i < n; i++ {
+				sp, err := r.thriftSpan()
+				if err != nil {
+					return fmt.Errorf("span %d: %w", i, err)
+				}
+				batch.spans = append(batch.spans, sp)
+			}
+			return nil
+		}
+		return r.skip(t)
+	})
+	return batch, err
+}
+
+func (r *thriftReader) thriftProcess(p *jaegerProcess) error {
+	return r.fields(func(id int16, t byte) error {
+		switch {
+		case id == 1 && t == thriftString:
+			b, err := r.bytes()
+			p.service = string(b)
+			return err
+		case id == 2 && t == thriftList:
+			tags, err := r.thriftTags()
+			p.tags = tags
+			return err
+		}
+		return r.skip(t)
+	})
+}
+
+func (r *thriftReader) thriftSpan() (jaegerSpan, error) {
+	var sp jaegerSpan
+	var traceLow, traceHigh, spanID, parentID int64
+	var start, duration int64
+	err := r.fields(func(id int16, t byte) error {
+		var err error
+		switch {
+		case id == 1 && t == thriftI64:
+			traceLow, err = r.i64()
+		case id == 2 && t == thriftI64:
+			traceHigh, err = r.i64()
+		case id == 3 && t == thriftI64:
+			spanID, err = r.i64()
+		case id == 4 && t == thriftI64:
+			parentID, err = r.i64()
+		case id == 5 && t == thriftString:
+			var b []byte
+			b, err = r.bytes()
+			sp.name = string(b)
+		case id == 6 && t == thriftList:
+			err = r.thriftRefs(&sp, &parentID)
+		case id == 8 && t == thriftI64:
+			start, err = r.i64()
+		case id == 9 && t == thriftI64:
+			duration, err = r.i64()
+		case id == 10 && t == thriftList:
+			sp.tags, err = r.thriftTags()
+		case id == 11 && t == thriftList:
+			sp.logs, err = r.thriftLogs()
+		default:
+			err = r.skip(t)
+		}
+		return err
+	})
+	sp.traceID = jaegerID(traceHigh, traceLow)
+	sp.spanID = jaegerID(spanID)
+	if parentID != 0 {
+		sp.parentID = jaegerID(parentID)
+	}
+	sp.start, sp.duration = uint64(start)*1000, uint64(duration)*1000
+	return sp, err
+}
+
+// thriftRefs reads span references; a CHILD_OF reference sets the parent when parentSpanId is 0
+func (r *thriftReader) thriftRefs(sp *jaegerSpan, parentID *int64) error {
+	n, err := r.listHeader(thriftStruct)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		var refType int32
+		var low, high, spanID int64
+		err := r.fields(func(id int16, t byte) error {
+			var err error
+			switch {
+			case id == 1 && t == thriftI32:
+				refType, err = r.i32()
+			case id == 2 && t == thriftI64:
+				low, err = r.i64()
+			case id == 3 && t == thriftI64:
+				high, err = r.i64()
+			case id == 4 && t == thriftI64:
+				spanID, err = r.i64()
+			default:
+				err = r.skip(t)
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if refType == 0 {
+			if *parentID == 0 {
+				*parentID = spanID
+			}
+		} else {
+			sp.followsFrom = append(sp.followsFrom, [2][]byte{jaegerID(high, low), jaegerID(spanID)})
+		}
+	}
+	return nil
+}
+
+func (r *thriftReader) thriftTags() ([]jaegerTag, error) {
+	n, err := r.listHeader(thriftStruct)
+	if err != nil {
+		return nil, err
+	}
+	tags := make([]jaegerTag, 0, n)
+	for i := 0; i < n; i++ {
+		var tag jaegerTag
+		var vType int32
+		var vStr, vBinary []byte
+		var vDouble float64
+		var vBool bool
+		var vLong int64
+		err := r.fields(func(id int16, t byte) error {
+			var err error
+			switch {
+			case id == 1 && t == thriftString:
+				var b []byte
+				b, err = r.bytes()
+				tag.key = string(b)
+			case id == 2 && t == thriftI32:
+				vType, err = r.i32()
+			case id == 3 && t == thriftString:
+				vStr, err = r.bytes()
+			case id == 4 && t == thriftDouble:
+				var bits int64
+				bits, err = r.i64()
+				vDouble = math.Float64frombits(uint64(bits))
+			case id == 5 && t == thriftBool:
+				var b byte
+				b, err = r.u8()
+				vBool = b != 0
+			case id == 6 && t == thriftI64:
+				vLong, err = r.i64()
+			case id == 7 && t == thriftString:
+				vBinary, err = r.bytes()
+			default:
+				err = r.skip(t)
+			}
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		switch vType {
+		case 1:
+			tag.value = vDouble
+		case 2:
+			tag.value = vBool
+		case 3:
+			tag.value = vLong
+		case 4:
+			tag.value = vBinary
+		default:
+			tag.value = string(vStr)
+		}
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+func (r *thriftReader) thriftLogs() ([]jaegerLog, error) {
+	n, err := r.listHeader(thriftStruct)
+	if err != nil {
+		return nil, err
+	}
+	logs := make([]jaegerLog, 0, n)
+	for i := 0; i < n; i++ {
+		var l jaegerLog
+		err := r.fields(func(id int16, t byte) error {
+			switch {
+			case id == 1 && t == thriftI64:
+				ts, err := r.i64()
+				l.time = uint64(ts) * 1000
+				return err
+			case id == 2 && t == thriftList:
+				fields, err := r.thriftTags()
+				l.fields = fields
+				return err
+			}
+			return r.skip(t)
+		})
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, l)
+	}
+	return logs, nil
+}
+
+// protoFields calls fn for every field of a protobuf message; fn returns how many bytes of the
+// value it consumed, or a negative protowire error
+func protoFields(b []byte, fn func(num protowire.Number, typ protowire.Type, b []byte) int) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if n = fn(num, typ, b); n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// protoMessage consumes a length-delimited field and decodes it with decode
+func protoMessage(b []byte, decode func([]byte) error, err *error) int {
+	v, n := protowire.ConsumeBytes(b)
+	if n >= 0 && *err == nil {
+		*err = decode(v)
+	}
+	return n
+}
+
+// decodeJaegerProto decodes a jaeger.api_v2 Batch (model.proto)
+func decodeJaegerProto(body []byte) (*jaegerBatch, error) {
+	batch := &jaegerBatch{}
+	var inner error
+	err := protoFields(body, func(num protowire.Number, typ protowire.Type, b []byte) int {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			return protoMessage(b, func(v []byte) error {
+				sp, err := decodeJaegerProtoSpan(v)
+				batch.spans = append(batch.spans, sp)
+				return err
+			}, &inner)
+		case num == 2 && typ == protowire.BytesType:
+			return protoMessage(b, func(v []byte) error { return decodeJaegerProtoProcess(v, &batch.process) }, &inner)
+		}
+		return protowire.ConsumeFieldValue(num, typ, b)
+	})
+	if err == nil {
+		err = inner
+	}
+	return batch, err
+}
+
+func decodeJaegerProtoProcess(b []byte, p *jaegerProcess) error {
+	var inner error
+	err := protoFields(b, func(num protowire.Number, typ protowire.Type, b []byte) int {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			p.service = string(v)
+			return n
+		case num == 2 && typ == protowire.BytesType:
+			return protoMessage(b, func(v []byte) error {
+				tag, err := decodeJaegerProtoTag(v)
+				p.tags = append(p.tags, tag)
+				return err
+			}, &inner)
+		}
+		return protowire.ConsumeFieldValue(num, typ, b)
+	})
+	if err == nil {
+		err = inner
+	}
+	return err
+}
+
+func decodeJaegerProtoSpan(b []byte) (jaegerSpan, error) {
+	var sp jaegerSpan
+	var inner error
+	err := protoFields(b, func(num protowire.Number, typ protowire.Type, b []byte) int {
+		if typ != protowire.BytesType {
+			return protowire.ConsumeFieldValue(num, typ, b)
+		}
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return n
+		}
+		switch num {
+		case 1:
+			sp.traceID = v
+		case 2:
+			sp.spanID = v
+		case 3:
+			sp.name = string(v)
+		case 4:
+			inner = errors.Join(inner, decodeJaegerProtoRef(v, &sp))
+		case 6:
+			sp.start = protoTimestamp(v)
+		case 7:
+			sp.duration = protoTimestamp(v)
+		case 8:
+			tag, err := decodeJaegerProtoTag(v)
+			sp.tags = append(sp.tags, tag)
+			inner = errors.Join(inner, err)
+		case 9:
+			var l jaegerLog
+			err := protoFields(v, func(num protowire.Number, typ protowire.Type, b []byte) int {
+				if typ != protowire.BytesType {
+					return protowire.ConsumeFieldValue(num, typ, b)
+				}
+				fv, n := protowire.ConsumeBytes(b)
+				switch num {
+				case 1:
+					l.time = protoTimestamp(fv)
+				case 2:
+					tag, err := decodeJaegerProtoTag(fv)
+					l.fields = append(l.fields, tag)
+					inner = errors.Join(inner, err)
+				}
+				return n
+			})
+			sp.logs = append(sp.logs, l)
+			inner = errors.Join(inner, err)
+		case 10:
+			sp.process = &jaegerProcess{}
+			inner = errors.Join(inner, decodeJaegerProtoProcess(v, sp.process))
+		}
+		return n
+	})
+	return sp, errors.Join(err, inner)
+}
+
+func decodeJaegerProtoRef(b []byte, sp *jaegerSpan) error {
+	var traceID, spanID []byte
+	var refType uint64
+	err := protoFields(b, func(num protowire.Number, typ protowire.Type, b []byte) int {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			traceID = v
+			return n
+		case num == 2 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			spanID = v
+			return n
+		case num == 3 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			refType = v
+			return n
+		}
+		return protowire.ConsumeFieldValue(num, typ, b)
+	})
+	if refType == 0 && sp.parentID == nil {
+		sp.parentID = spanID
+	} else if refType != 0 {
+		sp.followsFrom = append(sp.followsFrom, [2][]byte{traceID, spanID})
+	}
+	return err
+}
+
+func decodeJaegerProtoTag(b []byte) (jaegerTag, error) {
+	var tag jaegerTag
+	var vType uint64
+	var vStr, vBinary []byte
+	var vBool bool
+	var vInt int64
+	var vFloat float64
+	err := protoFields(b, func(num protowire.Number, typ protowire.Type, b []byte) int {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			tag.key = string(v)
+			return n
+		case num == 2 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			vType = v
+			return n
+		case num == 3 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			vStr = v
+			return n
+		case num == 4 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			vBool = v != 0
+			return n
+		case num == 5 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			vInt = int64(v)
+			return n
+		case num == 6 && typ == protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(b)
+			vFloat = math.Float64frombits(v)
+			return n
+		case num == 7 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			vBinary = v
+			return n
+		}
+		return protowire.ConsumeFieldValue(num, typ, b)
+	})
+	switch vType {
+	case 1:
+		tag.value = vBool
+	case 2:
+		tag.value = vInt
+	case 3:
+		tag.value = vFloat
+	case 4:
+		tag.value = vBinary
+	default:
+		tag.value = string(vStr)
+	}
+	return tag, err
+}
+
+// protoTimestamp converts a google.protobuf.Timestamp or Duration to nanoseconds
+func protoTimestamp(b []byte) uint64 {
+	var seconds, nanos uint64
+	protoFields(b, func(num protowire.Number, typ protowire.Type, b []byte) int {
+		if typ != protowire.VarintType {
+			return protowire.ConsumeFieldValue(num, typ, b)
+		}
+		v, n := protowire.ConsumeVarint(b)
+		switch num {
+		case 1:
+			seconds = v
+		case 2:
+			nanos = v
+		}
+		return n
+	})
+	return seconds*1e9 + nanos
+}
@@ -0,0 +1,364 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxSchemaErrors bounds the errors reported for one document
+const maxSchemaErrors = 10
+
+// JSONSchema is a compiled JSON Schema. It supports the keywords structured output APIs
+// accept: type, enum, const, properties, required, additionalProperties, items, prefixItems,
+// min/maxItems, uniqueItems, min/maxLength, pattern, minimum, maximum, exclusiveMinimum,
+// exclusiveMaximum, multipleOf, min/maxProperties, allOf, anyOf, oneOf, not and local $ref
+// ("#/$defs/..." or "#/definitions/..."). Unknown keywords are ignored.
+type JSONSchema struct {
+	root     any
+	patterns map[string]*regexp.Regexp
+}
+
+// CompileJSONSchema parses a schema given as JSON text or as an already decoded value
+func CompileJSONSchema(schema any) (*JSONSchema, error) {
+	if s, ok := schema.(string); ok {
+		if err := json.Unmarshal([]byte(s), &schema); err != nil {
+			return nil, fmt.Errorf("parse schema: %w", err)
+		}
+	}
+	switch schema.(type) {
+	case map[string]any, bool:
+	default:
+		return nil, fmt.Errorf("schema must be an object or a boolean")
+	}
+	s := &JSONSchema{root: schema, patterns: make(map[string]*regexp.Regexp)}
+	if err := s.compilePatterns(schema); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// compilePatterns precompiles every pattern keyword so validation can't fail on them
+func (s *JSONSchema) compilePatterns(node any) error {
+	switch n := node.(type) {
+	case map[string]any:
+		if p, ok := n["pattern"].(string); ok {
+			re, err := regexp.Compile(p)
+			if err != nil {
+				return fmt.Errorf("schema pattern %q: %w", p, err)
+			}
+			s.patterns[p] = re
+		}
+		for _, v := range n {
+			if err := s.compilePatterns(v); err != nil {
+				return err
+			}
+		}
+	case []any:
+		for _, v := range n {
+			if err := s.compilePatterns(v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Validate returns the violations of a decoded JSON document, at most maxSchemaErrors,
+// each prefixed with the JSON pointer of the offending value
+func (s *JSONSchema) Validate(doc any) []string {
+	v := &schemaValidator{schema: s}
+	v.validate(s.root, doc, "", 0)
+	return v.errs
+}
+
+type schemaValidator struct {
+	schema *JSONSchema
+	errs   []string
+}
+
+func (v *schemaValidator) fail(path, format string, args ...any) {
+	if len(v.errs) >= maxSchemaErrors {
+		return
+	}
+	if path == "" {
+		path = "/"
+	}
+	v.errs = append(v.errs, path+": "+fmt.Sprintf(format, args...))
+}
+
+// valid reports whether doc matches node without recording errors
+func (v *schemaValidator) valid(node, doc any, depth int) bool {
+	sub := &schemaValidator{schema: v.schema}
+	sub.validate(node, doc, "", depth)
+	return len(sub.errs) == 0
+}
+
+// resolve follows a local $ref
+func (v *schemaValidator) resolve(ref string) (any, bool) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, false
+	}
+	node := v.schema.root
+	for _, part := range strings.Split(strings.TrimPrefix(ref, "#"), "/") {
+		if part == "" {
+			continue
+		}
+		part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+		m, ok := node.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if node, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+	return node, true
+}
+
+func (v *schemaValidator) validate(node, doc any, path string, depth int) {
+	if depth > 64 {
+		v.fail(path, "schema nesting too deep")
+		return
+	}
+	switch n := node.(type) {
+	case bool:
+		if !n {
+			v.fail(path, "not allowed")
+		}
+		return
+	case map[string]any:
+		if ref, ok := n["$ref"].(string); ok {
+			target, ok := v.resolve(ref)
+			if !ok {
+				v.fail(path, "unresolvable $ref %s", ref)
+				return
+			}
+			v.validate(target, doc, path, depth+1)
+		}
+		v.validateObject(n, doc, path, depth)
+	}
+}
+
+func (v *schemaValidator) validateObject(n map[string]any, doc any, path string, depth int) {
+	if t, ok := n["type"]; ok && !matchesType(t, doc) {
+		v.fail(path, "expected %s, got %s", typeNames(t), jsonTypeOf(doc))
+		return
+	}
+	if enum, ok := n["enum"].([]any); ok {
+		found := false
+		for _, e := range enum {
+			if jsonEqual(e, doc) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			v.fail(path, "value is not one of the allowed values")
+		}
+	}
+	if c, ok := n["const"]; ok && !jsonEqual(c, doc) {
+		v.fail(path, "value must be %s", compactJSON(c))
+	}
+
+	switch d := doc.(type) {
+	case string:
+		length := float64(utf8.RuneCountInString(d))
+		if min, ok := n["minLength"].(float64); ok && length < min {
+			v.fail(path, "shorter than %v characters", min)
+		}
+		if max, ok := n["maxLength"].(float64); ok && length > max {
+			v.fail(path, "longer than %v characters", max)
+		}
+		if p, ok := n["pattern"].(string); ok {
+			if re := v.schema.patterns[p]; re != nil && !re.MatchString(d) {
+				v.fail(path, "does not match pattern %s", p)
+			}
+		}
+	case float64:
+		if min, ok := n["minimum"].(float64); ok && d < min {
+			v.fail(path, "less than minimum %v", min)
+		}
+		if max, ok := n["maximum"].(float64); ok && d > max {
+			v.fail(path, "greater than maximum %v", max)
+		}
+		if min, ok := n["exclusiveMinimum"].(float64); ok && d <= min {
+			v.fail(path, "not greater than %v", min)
+		}
+		if max, ok := n["exclusiveMaximum"].(float64); ok && d >= max {
+			v.fail(path, "not less than %v", max)
+		}
+		if m, ok := n["multipleOf"].(float64); ok && m > 0 {
+			if q := d / m; math.Abs(q-math.Round(q)) > 1e-9 {
+				v.fail(path, "not a multiple of %v", m)
+			}
+		}
+	case []any:
+		if min, ok := n["minItems"].(float64); ok && float64(len(d)) < min {
+			v.fail(path, "fewer than %v items", min)
+		}
+		if max, ok := n["maxItems"].(float64); ok && float64(len(d)) > max {
+			v.fail(path, "more than %v items", max)
+		}
+		if unique, _ := n["uniqueItems"].(bool); unique {
+			seen := make(map[string]bool, len(d))
+			for _, item := range d {
+				key := compactJSON(item)
+				if seen[key] {
+					v.fail(path, "items are not unique")
+					break
+				}
+				seen[key] = true
+			}
+		}
+		prefix, _ := n["prefixItems"].([]any)
+		for i, item := range d {
+			itemPath := path + "/" + strconv.Itoa(i)
+			if i < len(prefix) {
+				v.validate(prefix[i], item, itemPath, depth+1)
+			} else if items, ok := n["items"]; ok {
+				v.validate(items, item, itemPath, depth+1)
+			}
+		}
+	case map[string]any:
+		props, _ := n["properties"].(map[string]any)
+		if required, ok := n["required"].([]any); ok {
+			for _, r := range required {
+				if name, ok := r.(string); ok {
+					if _, present := d[name]; !present {
+						v.fail(path, "missing required property %q", name)
+					}
+				}
+			}
+		}
+		if min, ok := n["minProperties"].(float64); ok && float64(len(d)) < min {
+			v.fail(path, "fewer than %v properties", min)
+		}
+		if max, ok := n["maxProperties"].(float64); ok && float64(len(d)) > max {
+			v.fail(path, "more than %v properties", max)
+		}
+		keys := make([]string, 0, len(d))
+		for k := range d {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		additional, hasAdditional := n["additionalProperties"]
+		for _, k := range keys {
+			propPath := path + "/" + strings.ReplaceAll(strings.ReplaceAll(k, "~", "~0"), "/", "~1")
+			if sub, ok := props[k]; ok {
+				v.validate(sub, d[k], propPath, depth+1)
+			} else if hasAdditional {
+				if allowed, ok := additional.(bool); ok && !allowed {
+					v.fail(path, "unexpected property %q", k)
+				} else {
+					v.validate(additional, d[k], propPath, depth+1)
+				}
+			}
+		}
+	}
+
+	if all, ok := n["allOf"].([]any); ok {
+		for _, sub := range all {
+			v.validate(sub, doc, path, depth+1)
+		}
+	}
+	if anyOf, ok := n["anyOf"].([]any); ok {
+		matched := false
+		for _, sub := range anyOf {
+			if v.valid(sub, doc, depth+1) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			v.fail(path, "does not match any of the allowed schemas")
+		}
+	}
+	if oneOf, ok := n["oneOf"].([]any); ok {
+		matches := 0
+		for _, sub := range oneOf {
+			if v.valid(sub, doc, depth+1) {
+				matches++
+			}
+		}
+		if matches != 1 {
+			v.fail(path, "matches %d of the oneOf schemas instead of exactly one", matches)
+		}
+	}
+	if not, ok := n["not"]; ok && v.valid(not, doc, depth+1) {
+		v.fail(path, "matches a schema it must not match")
+	}
+}
+
+// matchesType checks a "type" keyword, a name or a list of names
+func matchesType(t, doc any) bool {
+	switch tt := t.(type) {
+	case string:
+		return matchesTypeName(tt, doc)
+	case []any:
+		for _, name := range tt {
+			if s, ok := name.(string); ok && matchesTypeName(s, doc) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func matchesTypeName(name string, doc any) bool {
+	switch name {
+	case "integer":
+		f, ok := doc.(float64)
+		return ok && f == math.Trunc(f)
+	case "number":
+		_, ok := doc.(float64)
+		return ok
+	}
+	return jsonTypeOf(doc) == name
+}
+
+func typeNames(t any) string {
+	if list, ok := t.([]any); ok {
+		names := make([]string, 0, len(list))
+		for _, n := range list {
+			names = append(names, fmt.Sprint(n))
+		}
+		return strings.Join(names, " or ")
+	}
+	return fmt.Sprint(t)
+}
+
+// jsonTypeOf names the JSON type of a value decoded by encoding/json
+func jsonTypeOf(doc any) string {
+	switch doc.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", doc)
+}
+
+func jsonEqual(a, b any) bool {
+	return compactJSON(a) == compactJSON(b)
+}
+
+// compactJSON renders a decoded value canonically (encoding/json sorts map keys)
+func compactJSON(v any) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
@@ -0,0 +1,446 @@
+package backend
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	tracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	tracepbv1 "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+// langfuseTrace is a trace of a Langfuse export or of its public API (GET /api/public/traces)
+type langfuseTrace struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Timestamp   time.Time `json:"timestamp"`
+	SessionID   string    `json:"sessionId"`
+	UserID      string    `json:"userId"`
+	Input       any       `json:"input"`
+	Output      any       `json:"output"`
+	Metadata    any       `json:"metadata"`
+	Tags        []string  `json:"tags"`
+	Release     string    `json:"release"`
+	Version     string    `json:"version"`
+	Environment string    `json:"environment"`
+	// Observations are full objects in exports and single-trace responses, ids in listings
+	Observations []json.RawMessage `json:"observations"`
+}
+
+// langfuseObservation is a span, generation or event of a Langfuse trace
+type langfuseObservation struct {
+	ID                  string             `json:"id"`
+	TraceID             string             `json:"traceId"`
+	ParentObservationID string             `json:"parentObservationId"`
+	Type                string             `json:"type"`
+	Name                string             `json:"name"`
+	StartTime           time.Time          `json:"startTime"`
+	EndTime             *time.Time         `json:"endTime"`
+	Model               string             `json:"model"`
+	ModelParameters     map[string]any     `json:"modelParameters"`
+	Input               any                `json:"input"`
+	Output              any                `json:"output"`
+	Metadata            any                `json:"metadata"`
+	Level               string             `json:"level"`
+	StatusMessage       string             `json:"statusMessage"`
+	Version             string             `json:"version"`
+	Usage               *langfuseUsage     `json:"usage"`
+	UsageDetails        map[string]float64 `json:"usageDetails"`
+	PromptTokens        float64            `json:"promptTokens"`
+	CompletionTokens    float64            `json:"completionTokens"`
+	CalculatedTotalCost *float64           `json:"calculatedTotalCost"`
+}
+
+type langfuseUsage struct {
+	Input  float64 `json:"input"`
+	Output float64 `json:"output"`
+}
+
+// LangfuseImportSummary reports the outcome of a Langfuse import. Skipped spans were valid but
+// dropped at ingest (duplicates, noise filters, transforms or the trace span cap).
+type LangfuseImportSummary struct {
+	Traces       int      `json:"traces"`
+	Observations int      `json:"observations"`
+	Inserted     int      `json:"inserted"`
+	Skipped      int      `json:"skipped"`
+	Failed       int      `json:"failed"`
+	Errors       []string `json:"errors"`
+}
+
+// readLangfuseExport collects the traces and observations of an export. It accepts a JSON array,
+// an API page ({"data": [...]}), an object with "traces" and/or "observations", single objects
+// and JSONL, in any mix; traces may carry their observations.
+func readLangfuseExport(in io.Reader) ([]langfuseTrace, []langfuseObservation, error) {
+	var traces []langfuseTrace
+	var observations []langfuseObservation
+	var add func(raw json.RawMessage) error
+	add = func(raw json.RawMessage) error {
+		raw = bytes.TrimSpace(raw)
+		if len(raw) == 0 || raw[0] == '"' || string(raw) == "null" {
+			return nil // observation ids of trace listings
+		}
+		if raw[0] == '[' {
+			var items []json.RawMessage
+			if err := json.Unmarshal(raw, &items); err != nil {
+				return err
+			}
+			for _, item := range items {
+				if err := add(item); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		var probe struct {
+			ID           string          `json:"id"`
+			TraceID      string          `json:"traceId"`
+			Type         string          `json:"type"`
+			Data         json.RawMessage `json:"data"`
+			Traces       json.RawMessage `json:"traces"`
+			Observations json.RawMessage `json:"observations"`
+		}
+		if err := json.Unmarshal(raw, &probe); err != nil {
+			return err
+		}
+		switch {
+		case probe.ID == "" && (probe.Data != nil || probe.Traces != nil || probe.Observations != nil):
+			for _, part := range []json.RawMessage{probe.Data, probe.Traces, probe.Observations} {
+				if err := add(part); err != nil {
+					return err
+				}
+			}
+		case probe.Type != "" && probe.TraceID != "":
+			var obs langfuseObservation
+			if err := decodeLangfuse(raw, &obs); err != nil {
+				return fmt.Errorf("observation %s: %v", probe.ID, err)
+			}
+			observations = append(observations, obs)
+		case probe.ID != "":
+			var t langfuseTrace
+			if err := decodeLangfuse(raw, &t); err != nil {
+				return fmt.Errorf("trace %s: %v", probe.ID, err)
+			}
+			for _, o := range t.Observations {
+				if err := add(o); err != nil {
+					return err
+				}
+			}
+			t.Observations = nil
+			traces = append(traces, t)
+		default:
+			return fmt.Errorf("object is neither a Langfuse trace nor an observation")
+		}
+		return nil
+	}
+
+	dec := json.NewDecoder(in)
+	for {
+		var raw json.RawMessage
+		err := dec.Decode(&raw)
+		if err == io.EOF {
+			return traces, observations, nil
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid JSON: %v", err)
+		}
+		if err := add(raw); err != nil {
+			return nil, nil, err
+		}
+	}
+}
+
+// decodeLangfuse decodes with numbers kept as json.Number, which jsonAnyValue turns into ints
+func decodeLangfuse(raw json.RawMessage, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// langfuseID converts a Langfuse id to an OTLP id: hex ids of the right size (including UUIDs)
+// are kept, anything else is hashed
+func langfuseID(id string, size int) []byte {
+	if b, err := hex.DecodeString(strings.ReplaceAll(id, "-", "")); err == nil && len(b) == size {
+		return b
+	}
+	sum := sha256.Sum256([]byte(id))
+	return sum[:size]
+}
+
+// langfuseText is the text of a Langfuse input or output: strings as they are, the content of
+// a chat message, the last user message of a message list (or the last message for outputs),
+// and JSON for anything else
+func langfuseText(v any, input bool) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case map[string]any:
+		if s, ok := x["content"].(string); ok {
+			return s
+		}
+		if msgs, ok := x["messages"].([]any); ok {
+			return langfuseText(msgs, input)
+		}
+	case []any:
+		for i := len(x) - 1; i >= 0; i-- {
+			m, ok := x[i].(map[string]any)
+			if !ok {
+				break
+			}
+			role, _ := m["role"].(string)
+			if content, ok := m["content"].(string); ok && (!input || role == "user") {
+				return content
+			}
+		}
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// langfuseSpans converts traces and observations to OTLP spans. A trace becomes a root span
+// covering its observations; observations without a parent become its children, or roots when
+// their trace is not part of the export.
+func langfuseSpans(traces []langfuseTrace, observations []langfuseObservation, project string) []*tracepbv1.Span {
+	type traceInfo struct {
+		t   *langfuseTrace
+		end time.Time
+	}
+	byID := make(map[string]*traceInfo, len(traces))
+	for i := range traces {
+		byID[traces[i].ID] = &traceInfo{t: &traces[i], end: traces[i].Timestamp}
+	}
+	for _, o := range observations {
+		if info := byID[o.TraceID]; info != nil {
+			end := o.StartTime
+			if o.EndTime != nil {
+				end = *o.EndTime
+			}
+			if end.After(info.end) {
+				info.end = end
+			}
+		}
+	}
+
+	common := func(attrs map[string]any) []*commonpb.KeyValue {
+		if project != "" {
+			attrs["simpleTraces.project.id"] = project
+		}
+		keys := make([]string, 0, len(attrs))
+		for k := range attrs {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		kvs := make([]*commonpb.KeyValue, 0, len(keys))
+		for _, k := range keys {
+			kvs = append(kvs, &commonpb.KeyValue{Key: k, Value: jsonAnyValue(attrs[k])})
+		}
+		return kvs
+	}
+
+	spans := make([]*tracepbv1.Span, 0, len(traces)+len(observations))
+	for _, t := range traces {
+		attrs := map[string]any{"langfuse.trace.id": t.ID}
+		for k, v := range map[string]string{"session.id": t.SessionID, "user.id": t.UserID,
+			"langfuse.release": t.Release, "langfuse.version": t.Version, "deployment.environment": t.Environment} {
+			if v != "" {
+				attrs[k] = v
+			}
+		}
+		if t.Input != nil {
+			attrs["langfuse.input"] = langfuseText(t.Input, true)
+		}
+		if t.Output != nil {
+			attrs["langfuse.output"] = langfuseText(t.Output, false)
+		}
+		if t.Metadata != nil {
+			attrs["langfuse.metadata"] = t.Metadata
+		}
+		if len(t.Tags) > 0 {
+			tags := make([]any, len(t.Tags))
+			for i, tag := range t.Tags {
+				tags[i] = tag
+			}
+			attrs["langfuse.tags"] = tags
+		}
+		name := t.Name
+		if name == "" {
+			name = "langfuse trace"
+		}
+		spans = append(spans, &tracepbv1.Span{
+			TraceId:           langfuseID(t.ID, 16),
+			SpanId:            langfuseID("trace:"+t.ID, 8),
+			Name:              name,
+			Kind:              tracepbv1.Span_SPAN_KIND_SERVER,
+			StartTimeUnixNano: uint64(t.Timestamp.UnixNano()),
+			EndTimeUnixNano:   uint64(byID[t.ID].end.UnixNano()),
+			Attributes:        common(attrs),
+		})
+	}
+
+	for _, o := range observations {
+		attrs := map[string]any{
+			"langfuse.observation.id":   o.ID,
+			"langfuse.observation.type": strings.ToUpper(o.Type),
+			"langfuse.trace.id":         o.TraceID,
+		}
+		if info := byID[o.TraceID]; info != nil && info.t.SessionID != "" {
+			attrs["session.id"] = info.t.SessionID
+		}
+		if o.Level != "" && o.Level != "DEFAULT" {
+			attrs["langfuse.level"] = o.Level
+		}
+		if o.Version != "" {
+			attrs["langfuse.version"] = o.Version
+		}
+		if o.Metadata != nil {
+			attrs["langfuse.metadata"] = o.Metadata
+		}
+		if o.CalculatedTotalCost != nil {
+			attrs["langfuse.cost_usd"] = *o.CalculatedTotalCost
+		}
+		promptKey, responseKey := "langfuse.input", "langfuse.output"
+		if strings.EqualFold(o.Type, "GENERATION") {
+			promptKey, responseKey = "gen_ai.prompt", "gen_ai.response"
+			if o.Model != "" {
+				attrs["gen_ai.request.model"] = o.Model
+			}
+			for k, v := range o.ModelParameters {
+				attrs["gen_ai.request."+k] = v
+			}
+			in, out := o.PromptTokens, o.CompletionTokens
+			if o.Usage != nil && (o.Usage.Input > 0 || o.Usage.Output > 0) {
+				in, out = o.Usage.Input, o.Usage.Output
+			}
+			if o.UsageDetails["input"] > 0 || o.UsageDetails["output"] > 0 {
+				in, out = o.UsageDetails["input"], o.UsageDetails["output"]
+			}
+			if in > 0 {
+				attrs["gen_ai.usage.input_tokens"] = int64(in)
+			}
+			if out > 0 {
+				attrs["gen_ai.usage.output_tokens"] = int64(out)
+			}
+			if msgs, ok := o.Input.([]any); ok {
+				attrs["simpleTraces.messages"] = msgs
+			}
+		}
+		if o.Input != nil {
+			attrs[promptKey] = langfuseText(o.Input, true)
+		}
+		if o.Output != nil {
+			attrs[responseKey] = langfuseText(o.Output, false)
+		}
+
+		end := o.StartTime
+		if o.EndTime != nil && !o.EndTime.Before(o.StartTime) {
+			end = *o.EndTime
+		}
+		name := o.Name
+		if name == "" {
+			name = strings.ToLower(o.Type)
+		}
+		span := &tracepbv1.Span{
+			TraceId:           langfuseID(o.TraceID, 16),
+			SpanId:            langfuseID(o.ID, 8),
+			Name:              name,
+			Kind:              tracepbv1.Span_SPAN_KIND_INTERNAL,
+			StartTimeUnixNano: uint64(o.StartTime.UnixNano()),
+			EndTimeUnixNano:   uint64(end.UnixNano()),
+			Attributes:        common(attrs),
+		}
+		switch {
+		case o.ParentObservationID != "":
+			span.ParentSpanId = langfuseID(o.ParentObservationID, 8)
+		case byID[o.TraceID] != nil:
+			span.ParentSpanId = langfuseID("trace:"+o.TraceID, 8)
+		}
+		if strings.EqualFold(o.Type, "GENERATION") {
+			span.Kind = tracepbv1.Span_SPAN_KIND_CLIENT
+		}
+		if o.Level == "ERROR" {
+			span.Status = &tracepbv1.Status{Code: tracepbv1.Status_STATUS_CODE_ERROR, Message: o.StatusMessage}
+		}
+		spans = append(spans, span)
+	}
+	return spans
+}
+
+// importLangfuse stores the spans of a Langfuse export in batches through the OTLP ingest pipeline
+func importLangfuse(h *OTLPHandler, in io.Reader, project string) (LangfuseImportSummary, error) {
+	summary := LangfuseImportSummary{Errors: []string{}}
+	traces, observations, err := readLangfuseExport(in)
+	if err != nil {
+		return summary, err
+	}
+	summary.Traces, summary.Observations = len(traces), len(observations)
+	for i := 0; i < len(traces); i++ {
+		if traces[i].ID == "" || traces[i].Timestamp.IsZero() {
+			summary.Failed++
+			if len(summary.Errors) < importMaxErrors {
+				summary.Errors = append(summary.Errors, fmt.Sprintf("trace %q: id and timestamp are required", traces[i].ID))
+			}
+			traces = append(traces[:i], traces[i+1:]...)
+			i--
+		}
+	}
+	for i := 0; i < len(observations); i++ {
+		if observations[i].ID == "" || observations[i].StartTime.IsZero() {
+			summary.Failed++
+			if len(summary.Errors) < importMaxErrors {
+				summary.Errors = append(summary.Errors, fmt.Sprintf("observation %q: id and startTime are required", observations[i].ID))
+			}
+			observations = append(observations[:i], observations[i+1:]...)
+			i--
+		}
+	}
+
+	spans := langfuseSpans(traces, observations, project)
+	for start := 0; start < len(spans); start += importBatchSize {
+		batch := spans[start:min(start+importBatchSize, len(spans))]
+		res, err := h.Export(&tracepb.ExportTraceServiceRequest{
+			ResourceSpans: []*tracepbv1.ResourceSpans{{ScopeSpans: []*tracepbv1.ScopeSpans{{
+				Scope: &commonpb.InstrumentationScope{Name: "langfuse-import"},
+				Spans: batch,
+			}}}},
+		})
+		summary.Inserted += res.Stored
+		summary.Skipped += res.Dropped
+		summary.Failed += res.Rejected
+		if msg := exportError(res, err); msg != "" && len(summary.Errors) < importMaxErrors {
+			summary.Errors = append(summary.Errors, msg)
+		}
+	}
+	return summary, nil
+}
+
+// importLangfuseHandler imports a Langfuse export (see readLangfuseExport), as the request body
+// (optionally gzip-compressed) or the first file of a multipart form. ?project= sets the project
+// of the imported spans.
+func importLangfuseHandler(h *OTLPHandler, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		in, err := importReader(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		summary, err := importLangfuse(h, in, strings.TrimSpace(r.URL.Query().Get("project")))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid Langfuse export: %v", err), http.StatusBadRequest)
+			return
+		}
+		logger.Info("Imported %d spans from a Langfuse export of %d traces and %d observations (%d skipped, %d failed)",
+			summary.Inserted, summary.Traces, summary.Observations, summary.Skipped, summary.Failed)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(summary)
+	}
+}
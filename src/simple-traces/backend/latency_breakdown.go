@@ -0,0 +1,132 @@
+package backend
+
+import (
+	"sort"
+	"time"
+)
+
+// LatencyBreakdown splits the wall time of a trace group into what it was waiting on.
+// Fractions add up to 1; "other" covers orchestration, HTTP and time no child span accounts for.
+type LatencyBreakdown struct {
+	WallMS int64   `json:"wall_ms"`
+	LLM    float64 `json:"llm"`
+	Tool   float64 `json:"tool"`
+	DB     float64 `json:"db"`
+	Other  float64 `json:"other"`
+}
+
+// breakdownBucket maps span categories to breakdown buckets
+func breakdownBucket(category string) string {
+	switch category {
+	case "llm", "tool":
+		return category
+	case "db", "retrieval":
+		return "db"
+	}
+	return "other"
+}
+
+// computeLatencyBreakdown walks the trace timeline. At every instant the time goes to the
+// innermost running spans (those without a running child); parallel spans share it equally,
+// so concurrent tool calls don't count twice.
+func computeLatencyBreakdown(spans []Span) *LatencyBreakdown {
+	if len(spans) == 0 {
+		return nil
+	}
+	type event struct {
+		at    time.Time
+		start bool
+		idx   int
+	}
+	events := make([]event, 0, 2*len(spans))
+	first, last := spans[0].StartTime, spans[0].EndTime
+	for i, sp := range spans {
+		if sp.EndTime.Before(sp.StartTime) {
+			continue
+		}
+		events = append(events, event{sp.StartTime, true, i}, event{sp.EndTime, false, i})
+		if sp.StartTime.Before(first) {
+			first = sp.StartTime
+		}
+		if sp.EndTime.After(last) {
+			last = sp.EndTime
+		}
+	}
+	wall := last.Sub(first)
+	b := &LatencyBreakdown{WallMS: wall.Milliseconds()}
+	if wall <= 0 {
+		return b
+	}
+	sort.Slice(events, func(i, j int) bool {
+		if !events[i].at.Equal(events[j].at) {
+			return events[i].at.Before(events[j].at)
+		}
+		return !events[i].start && events[j].start // ends first so touching spans don't overlap
+	})
+
+	totals := map[string]time.Duration{}
+	active := map[int]bool{}
+	prev := first
+	for _, ev := range events {
+		if d := ev.at.Sub(prev); d > 0 {
+			attributeInterval(spans, active, d, totals)
+		}
+		prev = ev.at
+		if ev.start {
+			active[ev.idx] = true
+		} else {
+			delete(active, ev.idx)
+		}
+	}
+
+	share := func(bucket string) float64 { return float64(totals[bucket]) / float64(wall) }
+	b.LLM, b.Tool, b.DB, b.Other = share("llm"), share("tool"), share("db"), share("other")
+	return b
+}
+
+// attributeInterval splits d between the innermost active spans
+func attributeInterval(spans []Span, active map[int]bool, d time.Duration, totals map[string]time.Duration) {
+	hasActiveChild := make(map[string]bool, len(active))
+	for i := range active {
+		if p := spans[i].ParentSpanID; p != "" {
+			hasActiveChild[p] = true
+		}
+	}
+	var leaves []int
+	for i := range active {
+		if !hasActiveChild[spans[i].SpanID] {
+			leaves = append(leaves, i)
+		}
+	}
+	if len(leaves) == 0 {
+		totals["other"] += d
+		return
+	}
+	part := d / time.Duration(len(leaves))
+	for _, i := range leaves {
+		totals[breakdownBucket(spans[i].Category)] += part
+	}
+}
+
+// attachLatencyBreakdown fills the latency breakdown of trace groups; failures only drop the field
+func (g *GormDB) attachLatencyBreakdown(groups []TraceGroup) {
+	if len(groups) == 0 {
+		return
+	}
+	ids := make([]string, len(groups))
+	for i, gr := range groups {
+		ids[i] = gr.TraceID
+	}
+	var spans []Span
+	if err := g.db.Select("span_id, trace_id, parent_span_id, start_time, end_time, category").
+		Where("trace_id IN ?", ids).Find(&spans).Error; err != nil {
+		return
+	}
+	byTrace := make(map[string][]Span, len(groups))
+	for _, sp := range spans {
+		byTrace[sp.TraceID] = append(byTrace[sp.TraceID], sp)
+	}
+	for i := range groups {
+		groups[i].LatencyBreakdown = computeLatencyBreakdown(byTrace[groups[i].TraceID])
+	}
+}
@@ -0,0 +1,155 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultHeatmapBounds are the latency bucket bounds in ms, roughly logarithmic
+var defaultHeatmapBounds = []int64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000, 10000, 20000, 50000, 100000}
+
+// maxHeatmapColumns bounds the time buckets of a heatmap
+const maxHeatmapColumns = 1000
+
+// LatencyHeatmap counts spans per time bucket and latency bucket. Counts[i][j] is the number of
+// spans that started in the bucket starting at Times[i] and took at most LatencyBoundsMS[j]
+// (and more than the previous bound); the last column counts spans slower than every bound.
+type LatencyHeatmap struct {
+	Since           time.Time   `json:"since"`
+	Until           time.Time   `json:"until"`
+	IntervalMS      int64       `json:"interval_ms"`
+	LatencyBoundsMS []int64     `json:"latency_bounds_ms"`
+	Times           []time.Time `json:"times"`
+	Counts          [][]int64   `json:"counts"`
+	Total           int64       `json:"total"`
+	// MaxCount is the largest cell, for scaling colors
+	MaxCount int64 `json:"max_count"`
+}
+
+// HeatmapQuery selects the spans of a heatmap and how they are bucketed
+type HeatmapQuery struct {
+	Filter StatsFilter
+	// Name and Category narrow the spans to one operation or kind
+	Name     string
+	Category string
+	Interval time.Duration
+	BoundsMS []int64
+}
+
+// GetLatencyHeatmap buckets the spans of the query's range by start time and duration; the
+// range must be bounded on both ends
+func (g *GormDB) GetLatencyHeatmap(q HeatmapQuery) (LatencyHeatmap, error) {
+	until := q.Filter.Until
+	hm := LatencyHeatmap{Since: q.Filter.Since, Until: until, IntervalMS: q.Interval.Milliseconds(), LatencyBoundsMS: q.BoundsMS}
+	columns := int((until.Sub(q.Filter.Since) + q.Interval - 1) / q.Interval)
+	hm.Times = make([]time.Time, columns)
+	hm.Counts = make([][]int64, columns)
+	for i := range hm.Counts {
+		hm.Times[i] = q.Filter.Since.Add(time.Duration(i) * q.Interval)
+		hm.Counts[i] = make([]int64, len(q.BoundsMS)+1)
+	}
+
+	query := q.Filter.apply(g.db.Model(&Span{})).Select("start_time, duration_ms")
+	if q.Name != "" {
+		query = query.Where("name = ?", q.Name)
+	}
+	if q.Category != "" {
+		query = query.Where("category = ?", q.Category)
+	}
+	var rows []struct {
+		StartTime  time.Time
+		DurationMS int64
+	}
+	if err := query.Limit(500000).Scan(&rows).Error; err != nil {
+		return hm, err
+	}
+	for _, r := range rows {
+		i := int(r.StartTime.Sub(q.Filter.Since) / q.Interval)
+		if i < 0 || i >= columns {
+			continue
+		}
+		j := sort.Search(len(q.BoundsMS), func(j int) bool { return r.DurationMS <= q.BoundsMS[j] })
+		hm.Counts[i][j]++
+		hm.Total++
+		hm.MaxCount = max(hm.MaxCount, hm.Counts[i][j])
+	}
+	return hm, nil
+}
+
+// parseHeatmapBounds reads ascending latency bounds in ms ("10,100,1000")
+func parseHeatmapBounds(s string) ([]int64, error) {
+	var bounds []int64
+	for _, part := range splitList(s) {
+		v, err := strconv.ParseInt(part, 10, 64)
+		if err != nil || v <= 0 || (len(bounds) > 0 && v <= bounds[len(bounds)-1]) {
+			return nil, fmt.Errorf("latency_buckets must be ascending positive milliseconds, got %q", s)
+		}
+		bounds = append(bounds, v)
+	}
+	if len(bounds) == 0 {
+		return nil, fmt.Errorf("latency_buckets must be ascending positive milliseconds, got %q", s)
+	}
+	return bounds, nil
+}
+
+// getLatencyHeatmapHandler returns span counts by start time and latency bucket for a latency
+// heatmap. The interval defaults to a sixtieth of the range.
+func getLatencyHeatmapHandler(db Database, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+		filter, err := parseStatsFilter(params)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		q := HeatmapQuery{
+			Filter:   filter,
+			Name:     strings.TrimSpace(params.Get("name")),
+			Category: strings.TrimSpace(params.Get("category")),
+			BoundsMS: defaultHeatmapBounds,
+		}
+		until := filter.Until
+		if until.IsZero() {
+			until = time.Now()
+		}
+		span := until.Sub(filter.Since)
+		if span <= 0 {
+			http.Error(w, "until must be after since", http.StatusBadRequest)
+			return
+		}
+		q.Interval = max((span / 60).Truncate(time.Second), time.Second)
+		if s := strings.TrimSpace(params.Get("interval")); s != "" {
+			d, err := parseWindow(s)
+			if err != nil || d <= 0 {
+				http.Error(w, fmt.Sprintf("invalid interval %q", s), http.StatusBadRequest)
+				return
+			}
+			q.Interval = d
+		}
+		if span/q.Interval > maxHeatmapColumns {
+			http.Error(w, fmt.Sprintf("interval too small: at most %d time buckets", maxHeatmapColumns), http.StatusBadRequest)
+			return
+		}
+		// buckets start on multiples of the interval, so consecutive requests line up
+		q.Filter.Since, q.Filter.Until = filter.Since.Truncate(q.Interval), until
+		if s := strings.TrimSpace(params.Get("latency_buckets")); s != "" {
+			if q.BoundsMS, err = parseHeatmapBounds(s); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		hm, err := db.GetLatencyHeatmap(q)
+		if err != nil {
+			logger.Error("Failed to get latency heatmap: %v", err)
+			http.Error(w, fmt.Sprintf("Failed to get latency heatmap: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(hm)
+	}
+}
@@ -0,0 +1,104 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// LLMClient calls an OpenAI-compatible chat completions endpoint. It backs optional
+// features that need a model (conversation summaries, natural-language queries).
+type LLMClient struct {
+	url    string
+	model  string
+	apiKey string
+	client *http.Client
+}
+
+// NewLLMClient returns nil when no LLM is configured
+func NewLLMClient(config *Config) *LLMClient {
+	if config.LLMAPIKey == "" && config.LLMURL == "" {
+		return nil
+	}
+	url := config.LLMURL
+	if url == "" {
+		url = "https://api.openai.com/v1/chat/completions"
+	}
+	return &LLMClient{
+		url:    url,
+		model:  config.LLMModel,
+		apiKey: config.LLMAPIKey,
+		client: &http.Client{Timeout: 60 * time.Second},
+	}
+}
+
+// Model returns the configured model name
+func (c *LLMClient) Model() string { return c.model }
+
+// CompleteJSON sends a system and user message asking for a JSON object answer and
+// decodes the reply into out.
+func (c *LLMClient) CompleteJSON(system, user string, out any) error {
+	text, err := c.complete(system, user, true)
+	if err != nil {
+		return err
+	}
+	text = strings.TrimSpace(text)
+	// tolerate models that wrap JSON in a markdown fence
+	text = strings.TrimPrefix(text, "```json")
+	text = strings.TrimPrefix(text, "```")
+	text = strings.TrimSuffix(text, "```")
+	if err := json.Unmarshal([]byte(strings.TrimSpace(text)), out); err != nil {
+		return fmt.Errorf("decode model answer: %w", err)
+	}
+	return nil
+}
+
+func (c *LLMClient) complete(system, user string, jsonMode bool) (string, error) {
+	payload := map[string]any{
+		"model": c.model,
+		"messages": []map[string]string{
+			{"role": "system", "content": system},
+			{"role": "user", "content": user},
+		},
+		"temperature": 0,
+	}
+	if jsonMode {
+		payload["response_format"] = map[string]string{"type": "json_object"}
+	}
+	body, _ := json.Marshal(payload)
+	req, err := http.NewRequest(http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("chat completion failed: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	var parsed struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return "", fmt.Errorf("decode chat completion: %w", err)
+	}
+	if len(parsed.Choices) == 0 {
+		return "", fmt.Errorf("chat completion returned no choices")
+	}
+	return parsed.Choices[0].Message.Content, nil
+}
@@ -0,0 +1,194 @@
+package backend
+
+import (
+	"fmt"
+	"io"
+	"log/syslog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logSink is an additional log destination next to stdout/stderr
+type logSink interface {
+	// writer returns where messages of the given level are written
+	writer(level LogLevel) io.Writer
+	Close() error
+}
+
+// AddLogSinks opens the file and syslog sinks configured in config and attaches them to the
+// logger. Console output is kept either way.
+func (l *Logger) AddLogSinks(config *Config) error {
+	var sinks []logSink
+	if config.LogFile != "" {
+		f, err := openRotatingFile(config.LogFile, int64(config.LogFileMaxSizeMB)<<20, config.LogFileMaxAge, config.LogFileMaxBackups)
+		if err != nil {
+			return fmt.Errorf("open log file: %w", err)
+		}
+		sinks = append(sinks, f)
+	}
+	if config.LogSyslog != "" {
+		s, err := openSyslog(config.LogSyslog)
+		if err != nil {
+			return fmt.Errorf("connect to syslog: %w", err)
+		}
+		sinks = append(sinks, s)
+	}
+	l.mu.Lock()
+	l.sinks = append(l.sinks, sinks...)
+	l.mu.Unlock()
+	l.setOutputs()
+	return nil
+}
+
+// CloseSinks flushes and closes the log sinks; later messages only go to the console
+func (l *Logger) CloseSinks() {
+	l.mu.Lock()
+	sinks := l.sinks
+	l.sinks = nil
+	l.mu.Unlock()
+	l.setOutputs()
+	for _, s := range sinks {
+		s.Close()
+	}
+}
+
+// rotatingFile is a log file that is renamed to <path>.<timestamp> once it grows past maxSize
+// bytes or has been written for longer than maxAge; only the newest maxBackups renamed files are
+// kept. A zero limit disables that check.
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	opened time.Time
+}
+
+func openRotatingFile(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*rotatingFile, error) {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	r := &rotatingFile{path: path, maxSize: maxSize, maxAge: maxAge, maxBackups: maxBackups}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size, r.opened = f, info.Size(), time.Now()
+	return nil
+}
+
+func (r *rotatingFile) writer(LogLevel) io.Writer { return r }
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return len(p), nil
+	}
+	if r.size > 0 && ((r.maxSize > 0 && r.size+int64(len(p)) > r.maxSize) || (r.maxAge > 0 && time.Since(r.opened) > r.maxAge)) {
+		if err := r.rotate(); err != nil {
+			// keep logging into the current file rather than losing messages
+			fmt.Fprintf(os.Stderr, "log rotation failed: %v\n", err)
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	backup := r.path + "." + time.Now().Format("20060102-150405.000")
+	renameErr := os.Rename(r.path, backup)
+	if err := r.open(); err != nil {
+		r.f = nil
+		return err
+	}
+	if renameErr != nil {
+		return renameErr
+	}
+	if r.maxBackups > 0 {
+		backups, _ := filepath.Glob(r.path + ".*")
+		sort.Strings(backups) // timestamps sort chronologically
+		for len(backups) > r.maxBackups {
+			os.Remove(backups[0])
+			backups = backups[1:]
+		}
+	}
+	return nil
+}
+
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
+
+// syslogSink forwards messages to syslog with the severity of their level
+type syslogSink struct {
+	w *syslog.Writer
+}
+
+// openSyslog connects to the local syslog daemon ("local") or a remote one (udp://host:514,
+// tcp://host:514)
+func openSyslog(target string) (*syslogSink, error) {
+	network, addr := "", ""
+	if target != "local" {
+		u, err := url.Parse(target)
+		if err != nil || u.Host == "" || (u.Scheme != "udp" && u.Scheme != "tcp") {
+			return nil, fmt.Errorf("LOG_SYSLOG must be local, udp://host:port or tcp://host:port, got %q", target)
+		}
+		network, addr = u.Scheme, u.Host
+	}
+	w, err := syslog.Dial(network, addr, syslog.LOG_INFO|syslog.LOG_DAEMON, "simple-traces")
+	if err != nil {
+		return nil, err
+	}
+	return &syslogSink{w: w}, nil
+}
+
+func (s *syslogSink) writer(level LogLevel) io.Writer {
+	send := map[LogLevel]func(string) error{DEBUG: s.w.Debug, INFO: s.w.Info, WARN: s.w.Warning, ERROR: s.w.Err}[level]
+	return syslogLevelWriter(send)
+}
+
+func (s *syslogSink) Close() error { return s.w.Close() }
+
+// syslogLevelWriter writes each message at one syslog severity
+type syslogLevelWriter func(string) error
+
+func (f syslogLevelWriter) Write(p []byte) (int, error) {
+	if err := f(strings.TrimSuffix(string(p), "\n")); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
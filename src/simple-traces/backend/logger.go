@@ -5,6 +5,8 @@ import (
 	"log"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 )
 
 // LogLevel represents the logging level
@@ -17,58 +19,75 @@ const (
 	ERROR
 )
 
+var logLevelNames = map[LogLevel]string{DEBUG: "DEBUG", INFO: "INFO", WARN: "WARN", ERROR: "ERROR"}
+
+func (lvl LogLevel) String() string {
+	return logLevelNames[lvl]
+}
+
 // Logger provides structured logging with different levels
 type Logger struct {
 	debugLogger *log.Logger
 	infoLogger  *log.Logger
 	warnLogger  *log.Logger
 	errorLogger *log.Logger
-	level       LogLevel
+	level       atomic.Int32 // LogLevel, changed at runtime through the admin API
+
+	mu sync.Mutex // guards the outputs below
+
+	// stdout receives debug to warn messages, errors go to stderr; sinks receive every level
+	stdout io.Writer
+	sinks  []logSink
 }
 
 var globalLogger *Logger
 
 // InitLogger initializes the global logger with the specified log level
 func InitLogger(levelStr string) *Logger {
-	level := parseLogLevel(levelStr)
-
-	var debugOut, infoOut, warnOut, errorOut io.Writer
-
-	// Configure output based on log level
-	switch level {
-	case DEBUG:
-		debugOut = os.Stdout
-		infoOut = os.Stdout
-		warnOut = os.Stdout
-		errorOut = os.Stderr
-	case INFO:
-		debugOut = io.Discard
-		infoOut = os.Stdout
-		warnOut = os.Stdout
-		errorOut = os.Stderr
-	case WARN:
-		debugOut = io.Discard
-		infoOut = io.Discard
-		warnOut = os.Stdout
-		errorOut = os.Stderr
-	case ERROR:
-		debugOut = io.Discard
-		infoOut = io.Discard
-		warnOut = io.Discard
-		errorOut = os.Stderr
-	}
+	return initLogger(levelStr, os.Stdout)
+}
+
+// InitStderrLogger is InitLogger for processes whose stdout carries a protocol (MCP over stdio)
+func InitStderrLogger(levelStr string) *Logger {
+	return initLogger(levelStr, os.Stderr)
+}
 
+func initLogger(levelStr string, stdout io.Writer) *Logger {
 	globalLogger = &Logger{
-		debugLogger: log.New(debugOut, "[DEBUG] ", log.LstdFlags|log.Lshortfile),
-		infoLogger:  log.New(infoOut, "[INFO]  ", log.LstdFlags),
-		warnLogger:  log.New(warnOut, "[WARN]  ", log.LstdFlags),
-		errorLogger: log.New(errorOut, "[ERROR] ", log.LstdFlags|log.Lshortfile),
-		level:       level,
+		debugLogger: log.New(io.Discard, "[DEBUG] ", log.LstdFlags|log.Lshortfile),
+		infoLogger:  log.New(io.Discard, "[INFO]  ", log.LstdFlags),
+		warnLogger:  log.New(io.Discard, "[WARN]  ", log.LstdFlags),
+		errorLogger: log.New(io.Discard, "[ERROR] ", log.LstdFlags|log.Lshortfile),
+		stdout:      stdout,
 	}
+	globalLogger.level.Store(int32(parseLogLevel(levelStr)))
+	globalLogger.setOutputs()
 
 	return globalLogger
 }
 
+// setOutputs points each level's logger at the console and the sinks, or discards it when the
+// level is below the configured one
+func (l *Logger) setOutputs() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	level := l.Level()
+	for lvl, lg := range map[LogLevel]*log.Logger{DEBUG: l.debugLogger, INFO: l.infoLogger, WARN: l.warnLogger, ERROR: l.errorLogger} {
+		if lvl < level {
+			lg.SetOutput(io.Discard)
+			continue
+		}
+		outs := []io.Writer{l.stdout}
+		if lvl == ERROR {
+			outs[0] = os.Stderr
+		}
+		for _, s := range l.sinks {
+			outs = append(outs, s.writer(lvl))
+		}
+		lg.SetOutput(io.MultiWriter(outs...))
+	}
+}
+
 // GetLogger returns the global logger instance
 func GetLogger() *Logger {
 	if globalLogger == nil {
@@ -77,30 +96,47 @@ func GetLogger() *Logger {
 	return globalLogger
 }
 
+// Level returns the current log level
+func (l *Logger) Level() LogLevel {
+	return LogLevel(l.level.Load())
+}
+
+// SetLevel changes the log level of a running process
+func (l *Logger) SetLevel(level LogLevel) {
+	l.level.Store(int32(level))
+	l.setOutputs()
+}
+
 // Debug logs a debug message with verbose details
 func (l *Logger) Debug(format string, v ...interface{}) {
-	if l.level <= DEBUG {
+	if l.Level() <= DEBUG {
 		l.debugLogger.Printf(format, v...)
 	}
 }
 
+// DebugEnabled reports whether debug messages are written, so callers can skip building
+// expensive debug output
+func (l *Logger) DebugEnabled() bool {
+	return l.Level() <= DEBUG
+}
+
 // Info logs an informational message
 func (l *Logger) Info(format string, v ...interface{}) {
-	if l.level <= INFO {
+	if l.Level() <= INFO {
 		l.infoLogger.Printf(format, v...)
 	}
 }
 
 // Warn logs a warning message
 func (l *Logger) Warn(format string, v ...interface{}) {
-	if l.level <= WARN {
+	if l.Level() <= WARN {
 		l.warnLogger.Printf(format, v...)
 	}
 }
 
 // Error logs an error message
 func (l *Logger) Error(format string, v ...interface{}) {
-	if l.level <= ERROR {
+	if l.Level() <= ERROR {
 		l.errorLogger.Printf(format, v...)
 	}
 }
@@ -111,16 +147,23 @@ func (l *Logger) Fatal(format string, v ...interface{}) {
 }
 
 func parseLogLevel(levelStr string) LogLevel {
-	switch strings.ToUpper(levelStr) {
+	if level, ok := lookupLogLevel(levelStr); ok {
+		return level
+	}
+	return INFO
+}
+
+// lookupLogLevel is parseLogLevel without the INFO fallback, for validating user input
+func lookupLogLevel(levelStr string) (LogLevel, bool) {
+	switch strings.ToUpper(strings.TrimSpace(levelStr)) {
 	case "DEBUG":
-		return DEBUG
+		return DEBUG, true
 	case "INFO":
-		return INFO
+		return INFO, true
 	case "WARN", "WARNING":
-		return WARN
+		return WARN, true
 	case "ERROR":
-		return ERROR
-	default:
-		return INFO
+		return ERROR, true
 	}
+	return INFO, false
 }
@@ -0,0 +1,281 @@
+package backend
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"gorm.io/gorm"
+
+	logspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspbv1 "go.opentelemetry.io/proto/otlp/logs/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+)
+
+// LogRecord is an OTLP log record. Agents instrumented with the gen_ai semantic conventions
+// may emit prompts and completions as log events instead of span attributes; trace_id and
+// span_id tie them to the span they were emitted in.
+type LogRecord struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	TraceID        string    `gorm:"index" json:"trace_id,omitempty"`
+	SpanID         string    `gorm:"index" json:"span_id,omitempty"`
+	ProjectID      string    `gorm:"index" json:"project_id"`
+	ConversationID string    `gorm:"index" json:"conversation_id,omitempty"`
+	Timestamp      time.Time `gorm:"column:log_time;index" json:"timestamp"`
+	SeverityNumber int32     `json:"severity_number,omitempty"`
+	SeverityText   string    `json:"severity_text,omitempty"`
+	EventName      string    `gorm:"index" json:"event_name,omitempty"`
+	ServiceName    string    `json:"service_name,omitempty"`
+	Body           string    `gorm:"type:text" json:"body,omitempty"`
+	Attributes     string    `gorm:"type:text" json:"attributes,omitempty"`
+}
+
+// LogFilter narrows log record listings
+type LogFilter struct {
+	ProjectID      string
+	TraceID        string
+	SpanID         string
+	ConversationID string
+	EventName      string
+	MinSeverity    int32
+	Search         string
+}
+
+// severityNumbers maps severity names to the lowest OTLP severity number of their range
+var severityNumbers = map[string]int32{"TRACE": 1, "DEBUG": 5, "INFO": 9, "WARN": 13, "WARNING": 13, "ERROR": 17, "FATAL": 21}
+
+// OTLPLogsHandler ingests OTLP/HTTP log exports at /v1/logs
+type OTLPLogsHandler struct {
+	db     Database
+	logger *Logger
+	// maxPayload bounds request bodies, see defaultMaxPayloadBytes
+	maxPayload int64
+}
+
+// NewOTLPLogsHandler creates a handler storing log records in db
+func NewOTLPLogsHandler(db Database, logger *Logger) *OTLPLogsHandler {
+	metrics.Describe("simpletraces_logs_received_total", "counter", "Log records received via OTLP")
+	metrics.Describe("simpletraces_logs_stored_total", "counter", "Log records written to the database")
+	return &OTLPLogsHandler{db: db, logger: logger, maxPayload: defaultMaxPayloadBytes}
+}
+
+// SetMaxPayloadBytes bounds the body of log exports, as sent and decompressed; 0 disables the limit
+func (h *OTLPLogsHandler) SetMaxPayloadBytes(n int64) {
+	h.maxPayload = n
+}
+
+func (h *OTLPLogsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req logspb.ExportLogsServiceRequest
+	if !decodeOTLPRequest(w, r, &req, h.maxPayload, h.logger) {
+		return
+	}
+
+	var rows []LogRecord
+	for _, rl := range req.ResourceLogs {
+		for _, sl := range rl.ScopeLogs {
+			for _, lr := range sl.LogRecords {
+				metrics.Inc("simpletraces_logs_received_total")
+				rows = append(rows, logRecordRow(lr, rl.Resource))
+			}
+		}
+	}
+	h.linkConversations(rows)
+	if err := h.db.InsertLogRecords(rows); err != nil {
+		h.logger.Error("Failed to store %d log records: %v", len(rows), err)
+		writeOTLPError(w, http.StatusInternalServerError, codes.Unavailable, "Failed to store log records")
+		return
+	}
+	metrics.Add("simpletraces_logs_stored_total", float64(len(rows)))
+	h.logger.Info("Stored %d log records from OTLP export", len(rows))
+
+	writeOTLPResponse(w, &logspb.ExportLogsServiceResponse{})
+}
+
+// linkConversations fills in the conversation of records that only carry a trace id from the
+// spans already stored for that trace
+func (h *OTLPLogsHandler) linkConversations(rows []LogRecord) {
+	byTrace := make(map[string]string)
+	for i := range rows {
+		lr := &rows[i]
+		if lr.ConversationID != "" || lr.TraceID == "" {
+			continue
+		}
+		conv, ok := byTrace[lr.TraceID]
+		if !ok {
+			var err error
+			if conv, err = h.db.LookupConversationIDByTraceID(lr.TraceID); err != nil {
+				h.logger.Warn("Failed to look up the conversation of trace %s: %v", lr.TraceID, err)
+			}
+			byTrace[lr.TraceID] = conv
+		}
+		lr.ConversationID = conv
+	}
+}
+
+// logRecordRow converts an OTLP log record; resource attributes are kept under resource.* and,
+// like for spans, at the top level when the record doesn't set them itself
+func logRecordRow(lr *logspbv1.LogRecord, resource *resourcepb.Resource) LogRecord {
+	attrs := make(map[string]any, len(lr.Attributes))
+	for _, attr := range lr.Attributes {
+		if attr != nil {
+			putAnyValue(attrs, attr.Key, attr.Value, nil)
+		}
+	}
+	if resource != nil {
+		for _, attr := range resource.Attributes {
+			if attr == nil {
+				continue
+			}
+			putAnyValue(attrs, "resource."+attr.Key, attr.Value, nil)
+			if _, exists := attrs[attr.Key]; !exists {
+				putAnyValue(attrs, attr.Key, attr.Value, nil)
+			}
+		}
+	}
+
+	ts := lr.TimeUnixNano
+	if ts == 0 {
+		ts = lr.ObservedTimeUnixNano
+	}
+	timestamp := time.Now()
+	if ts != 0 {
+		timestamp = time.Unix(0, int64(ts))
+	}
+	eventName := lr.EventName
+	if eventName == "" {
+		eventName = firstStringAttr(attrs, []string{"event.name"})
+	}
+	projectID := firstStringAttr(attrs, projectIDKeys)
+	if projectID == "" {
+		projectID = "default"
+	}
+	serviceName, _ := attrs["service.name"].(string)
+	attrsStr, _ := json.Marshal(attrs)
+
+	return LogRecord{
+		TraceID:        hex.EncodeToString(lr.TraceId),
+		SpanID:         hex.EncodeToString(lr.SpanId),
+		ProjectID:      projectID,
+		ConversationID: firstStringAttr(attrs, conversationIDKeys),
+		Timestamp:      timestamp,
+		SeverityNumber: int32(lr.SeverityNumber),
+		SeverityText:   lr.SeverityText,
+		EventName:      eventName,
+		ServiceName:    serviceName,
+		Body:           logBodyText(lr.Body),
+		Attributes:     string(attrsStr),
+	}
+}
+
+// logBodyText stores string bodies as-is and structured ones as JSON
+func logBodyText(v *commonpb.AnyValue) string {
+	if v == nil || v.Value == nil {
+		return ""
+	}
+	if s, ok := v.Value.(*commonpb.AnyValue_StringValue); ok {
+		return s.StringValue
+	}
+	raw, _ := json.Marshal(anyValueToInterface(v))
+	return string(raw)
+}
+
+// InsertLogRecords stores log records
+func (g *GormDB) InsertLogRecords(records []LogRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	return g.db.CreateInBatches(records, g.batchRows(&LogRecord{})).Error
+}
+
+// GetLogRecords lists log records, newest first
+func (g *GormDB) GetLogRecords(limit int, before time.Time, filter LogFilter) ([]LogRecord, error) {
+	var records []LogRecord
+	query := g.db.Model(&LogRecord{})
+	query = applyLogFilter(query, filter)
+	if !before.IsZero() {
+		query = query.Where("log_time < ?", before)
+	}
+	err := query.Order("log_time DESC, id DESC").Limit(limit).Find(&records).Error
+	return records, err
+}
+
+func applyLogFilter(query *gorm.DB, filter LogFilter) *gorm.DB {
+	if filter.ProjectID != "" {
+		query = query.Where("project_id = ?", filter.ProjectID)
+	}
+	if filter.TraceID != "" {
+		query = query.Where("trace_id = ?", filter.TraceID)
+	}
+	if filter.SpanID != "" {
+		query = query.Where("span_id = ?", filter.SpanID)
+	}
+	if filter.ConversationID != "" {
+		query = query.Where("conversation_id = ?", filter.ConversationID)
+	}
+	if filter.EventName != "" {
+		query = query.Where("event_name = ?", filter.EventName)
+	}
+	if filter.MinSeverity > 0 {
+		query = query.Where("severity_number >= ?", filter.MinSeverity)
+	}
+	if search := strings.TrimSpace(filter.Search); search != "" {
+		pattern := "%" + likeEscaper.Replace(strings.ToLower(search)) + "%"
+		query = query.Where("LOWER(body) LIKE ? ESCAPE '\\' OR LOWER(attributes) LIKE ? ESCAPE '\\'", pattern, pattern)
+	}
+	return query
+}
+
+// getLogsHandler lists log records filtered by project, trace_id, span_id, conversation,
+// event, min_severity (a number or DEBUG/INFO/WARN/ERROR/FATAL) and q (body/attribute search)
+func getLogsHandler(db Database, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		limit := 100
+		if s := strings.TrimSpace(q.Get("limit")); s != "" {
+			if v, err := strconv.Atoi(s); err == nil && v > 0 {
+				limit = v
+			}
+		}
+		var before time.Time
+		if sb := strings.TrimSpace(q.Get("before")); sb != "" {
+			t, err := time.Parse(time.RFC3339Nano, sb)
+			if err != nil {
+				http.Error(w, "before must be an RFC 3339 timestamp", http.StatusBadRequest)
+				return
+			}
+			before = t
+		}
+		filter := LogFilter{
+			ProjectID:      strings.TrimSpace(q.Get("project")),
+			TraceID:        strings.TrimSpace(q.Get("trace_id")),
+			SpanID:         strings.TrimSpace(q.Get("span_id")),
+			ConversationID: strings.TrimSpace(q.Get("conversation")),
+			EventName:      strings.TrimSpace(q.Get("event")),
+			Search:         q.Get("q"),
+		}
+		if s := strings.TrimSpace(q.Get("min_severity")); s != "" {
+			if n, ok := severityNumbers[strings.ToUpper(s)]; ok {
+				filter.MinSeverity = n
+			} else if n, err := strconv.Atoi(s); err == nil {
+				filter.MinSeverity = int32(n)
+			} else {
+				http.Error(w, "min_severity must be a number or TRACE, DEBUG, INFO, WARN, ERROR or FATAL", http.StatusBadRequest)
+				return
+			}
+		}
+		records, err := db.GetLogRecords(limit, before, filter)
+		if err != nil {
+			logger.Error("Failed to get log records: %v", err)
+			http.Error(w, fmt.Sprintf("Failed to get log records: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(records)
+	}
+}
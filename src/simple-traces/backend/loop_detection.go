@@ -0,0 +1,143 @@
+package backend
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// Loop detection looks at the LLM and tool steps of a trace in start order and flags a trace
+// when a short sequence of steps repeats back to back, the usual shape of an agent stuck
+// calling the same tool with the same outcome.
+const (
+	// maxLoopPeriod is the longest repeating segment looked for
+	maxLoopPeriod = 8
+	// minLoopRepeats is how many consecutive times a segment must occur
+	minLoopRepeats = 3
+	// minLoopSteps is how many steps the repetitions must cover, so a single step needs
+	// more repeats than a longer segment (a few identical calls in a row are often legitimate)
+	minLoopSteps = 6
+)
+
+// toolNameKeys carry the name of the tool a tool span invoked
+var toolNameKeys = []string{"gen_ai.tool.name", "tool.name", "function.name"}
+
+// LoopSuspicion describes the repeating segment found in a trace
+type LoopSuspicion struct {
+	Segment []string `json:"segment"`
+	Repeats int      `json:"repeats"`
+	// StartSpanID is the first span of the first repetition
+	StartSpanID string `json:"start_span_id"`
+}
+
+// loopStep is the signature of a span for loop detection: its name, plus the tool name when the
+// span name doesn't already include it
+func loopStep(sp Span) string {
+	if sp.Category != "tool" || sp.Attributes == "" {
+		return sp.Name
+	}
+	var attrs map[string]any
+	if json.Unmarshal([]byte(sp.Attributes), &attrs) != nil {
+		return sp.Name
+	}
+	if tool := firstStringAttr(attrs, toolNameKeys); tool != "" && !strings.Contains(sp.Name, tool) {
+		return sp.Name + " " + tool
+	}
+	return sp.Name
+}
+
+// detectLoop returns the repeating segment covering the most steps, or nil. Spans must be
+// sorted by start time.
+func detectLoop(spans []Span) *LoopSuspicion {
+	steps := make([]string, 0, len(spans))
+	ids := make([]string, 0, len(spans))
+	for _, sp := range spans {
+		if sp.Category == "llm" || sp.Category == "tool" {
+			steps = append(steps, loopStep(sp))
+			ids = append(ids, sp.SpanID)
+		}
+	}
+	var best *LoopSuspicion
+	bestCovered := 0
+	for period := 1; period <= maxLoopPeriod && 2*period <= len(steps); period++ {
+		// run counts how many steps in a row equal the step one period later; a run of
+		// length n means the segment at its start repeats n/period+1 times
+		run := 0
+		for i := len(steps) - period - 1; i >= -1; i-- {
+			if i >= 0 && steps[i] == steps[i+period] {
+				run++
+				continue
+			}
+			start := i + 1
+			repeats := run/period + 1
+			covered := repeats * period
+			run = 0
+			if repeats < minLoopRepeats || covered < minLoopSteps || covered <= bestCovered {
+				continue
+			}
+			if period > 1 && isRepetition(steps[start:start+period]) {
+				continue // already found with a shorter period
+			}
+			bestCovered = covered
+			best = &LoopSuspicion{
+				Segment:     append([]string(nil), steps[start:start+period]...),
+				Repeats:     repeats,
+				StartSpanID: ids[start],
+			}
+		}
+	}
+	return best
+}
+
+// isRepetition reports whether seg is itself a shorter segment repeated
+func isRepetition(seg []string) bool {
+	for p := 1; p < len(seg); p++ {
+		if len(seg)%p != 0 {
+			continue
+		}
+		same := true
+		for i := p; i < len(seg) && same; i++ {
+			same = seg[i] == seg[i-p]
+		}
+		if same {
+			return true
+		}
+	}
+	return false
+}
+
+// attachLoopDetection flags trace groups whose agent steps repeat; failures only drop the fields
+func (g *GormDB) attachLoopDetection(groups []TraceGroup) {
+	if len(groups) == 0 {
+		return
+	}
+	ids := make([]string, len(groups))
+	for i, gr := range groups {
+		ids[i] = gr.TraceID
+	}
+	var spans []Span
+	if err := g.db.Select("span_id, trace_id, name, category, start_time, seq, attributes").
+		Where("trace_id IN ? AND category IN ?", ids, []string{"llm", "tool"}).
+		Find(&spans).Error; err != nil {
+		return
+	}
+	sort.SliceStable(spans, func(i, j int) bool {
+		if !spans[i].StartTime.Equal(spans[j].StartTime) {
+			return spans[i].StartTime.Before(spans[j].StartTime)
+		}
+		if spans[i].Seq != spans[j].Seq {
+			return spans[i].Seq < spans[j].Seq
+		}
+		return spans[i].SpanID < spans[j].SpanID
+	})
+	byTrace := make(map[string][]Span, len(groups))
+	for _, sp := range spans {
+		byTrace[sp.TraceID] = append(byTrace[sp.TraceID], sp)
+	}
+	for i := range groups {
+		if loop := detectLoop(byTrace[groups[i].TraceID]); loop != nil {
+			groups[i].LoopSuspected = true
+			groups[i].Loop = loop
+		}
+	}
+}
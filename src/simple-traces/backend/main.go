@@ -1,31 +1,211 @@
 package backend
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"runtime"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
 )
 
+// shutdownTimeout is how long in-flight requests may take to finish after SIGINT or SIGTERM
+const shutdownTimeout = 30 * time.Second
+
 type Config struct {
 	DBType       string
 	DBConnection string
 	Port         string
-	FrontendDir  string
-	LogLevel     string
+	// Listen overrides Port: "[host]:port", "unix:/path" or "systemd[:name]"
+	Listen         string
+	UnixSocketMode fs.FileMode
+	// Optional separate listener for OTLP ingest (same syntax as Listen); when set the
+	// UI/API listener no longer accepts /v1/traces
+	IngestListen string
+	// Bearer token required on ingest requests when set
+	IngestToken string
+	// Origins allowed to call the UI/API from browsers ("*" for any)
+	CORSOrigins string
+	// Bearer token required on /api/admin endpoints when set; without it they are only served to
+	// localhost
+	AdminToken  string
+	FrontendDir string
+	LogLevel    string
+
+	// HTTP server limits; zero disables a timeout
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+	// Connection reuse for exporters: h2c, HTTP/2 streams per connection, TCP keep-alive period
+	H2C             bool
+	HTTP2MaxStreams int
+	TCPKeepAlive    time.Duration
+
+	// Embeddings (optional): provider is "", "local" or "openai"
+	EmbeddingsProvider string
+	EmbeddingsURL      string
+	EmbeddingsModel    string
+	EmbeddingsAPIKey   string
+	EmbeddingsDim      int
+	// EmbeddingsSearchWindow is how many of the most recent vectors semantic search compares
+	EmbeddingsSearchWindow int
+
+	// Conversation clustering over stored embeddings
+	ClusterInterval time.Duration
+	ClusterWindow   time.Duration
+	ClusterCount    int
+
+	// OpenAI-compatible chat completions endpoint for LLM-backed features
+	LLMURL             string
+	LLMModel           string
+	LLMAPIKey          string
+	SummarizeEnabled   bool
+	SummaryInterval    time.Duration
+	SummaryQuietPeriod time.Duration
+
+	// Trace health scoring: how often quiet traces are (re)scored (0 disables) and how long
+	// a trace must be idle first
+	HealthScoreInterval    time.Duration
+	HealthScoreQuietPeriod time.Duration
+
+	// "model=tokens" context window overrides for token budget checks
+	ModelContextLimits string
+	// "model=input:output" prices in USD per million tokens on top of the built-in list
+	ModelPrices string
+
+	// JSON file with ingest transform rules (expr-lang expressions)
+	TransformsFile string
+	// JSON file mapping project ids to the JSON schema of their structured LLM outputs
+	ResponseSchemasFile string
+	// JSON file with user-defined metrics computed for every span (expr-lang expressions)
+	DerivedMetricsFile string
+	// Issue tracker integration: "github" or "jira"; PublicURL is used for share links
+	IssueTracker  string
+	PublicURL     string
+	GitHubRepo    string
+	GitHubToken   string
+	GitHubAPIURL  string
+	JiraURL       string
+	JiraProject   string
+	JiraIssueType string
+	JiraEmail     string
+	JiraAPIToken  string
+
+	// API response caching: "path=ttl" pairs, paths ending in * match prefixes
+	CacheRoutes     string
+	CacheMaxEntries int
+	// Shared cache for multi-replica deployments; the in-memory cache is used when empty
+	CacheRedisURL string
+
+	// How often SQLite is optimized and incrementally vacuumed (0 disables)
+	SQLiteMaintenanceInterval time.Duration
+	// Comma-separated read-only databases merged into listings ("sqlite:/path", "postgres://...")
+	ReadSources string
+	// Postgres read replica serving queries while writes go to DBConnection
+	DBReadReplica string
+	// Migrate older database schemas at startup instead of refusing to run
+	DBAutoMigrate bool
+
+	// Built-in noise filters: healthcheck, static, zero_duration, all or none
+	NoiseFilters string
+	// Spans stored per trace before further spans are dropped (0 = unlimited)
+	MaxSpansPerTrace int
+	// Built-in status derivation for UNSET spans: error_attribute, http_5xx, exception, all or none
+	StatusRules string
+	// Recently stored span ids are remembered for DedupWindow (up to DedupSize ids)
+	DedupWindow time.Duration
+	DedupSize   int
+	// Where the dedup window is kept: memory, or database to also catch resends after a restart
+	// or to another instance
+	DedupStore string
+	// Goroutines transforming the spans of one OTLP export
+	IngestWorkers int
+
+	// Log file written next to the console, rotated past LogFileMaxSizeMB or LogFileMaxAge
+	LogFile           string
+	LogFileMaxSizeMB  int
+	LogFileMaxAge     time.Duration
+	LogFileMaxBackups int
+	// Syslog destination: local, udp://host:514 or tcp://host:514
+	LogSyslog string
+	// JSON previews of one in LogOTLPPayloadEvery exports, cut after LogOTLPPayloadMaxBytes
+	LogOTLPPayloads        bool
+	LogOTLPPayloadMaxBytes int
+	LogOTLPPayloadEvery    int
+	// Paths left out of the request log, and "path=rate" fractions of requests logged
+	RequestLogExclude string
+	RequestLogSample  string
+	// Warn when the p95 lag between span end and arrival of an export reaches this (0 disables)
+	IngestLagAlert time.Duration
+	// A trace is complete once its root span arrived and no span arrived for this long
+	TraceCompleteAfter time.Duration
+	// NATS ingest: OTLP exports published on NATSSubject; NATSStream reads it through a durable
+	// JetStream consumer, NATSQueue load-balances a plain subscription across servers
+	NATSURL      string
+	NATSSubject  string
+	NATSStream   string
+	NATSConsumer string
+	NATSQueue    string
+	// WatchDir is polled every WatchInterval for *.jsonl span files; offsets are kept in
+	// WatchStateFile (default: a file in WatchDir)
+	WatchDir       string
+	WatchInterval  time.Duration
+	WatchStateFile string
+	// Export requests and spans per second accepted from one client address on /v1/traces
+	// (0 = unlimited)
+	IngestRateLimit     int
+	IngestSpanRateLimit int
+	// Largest ingest request body accepted, compressed or decompressed (0 = unlimited)
+	MaxPayloadBytes int
+	// Exports queued for IngestWriters goroutines writing up to IngestBatchSpans spans at a
+	// time, waiting up to IngestBatchWait for more (0 = write before answering); exports are
+	// refused with 429 once IngestQueueHighWater are queued (0 = when the queue is full)
+	IngestQueueSize      int
+	IngestQueueHighWater int
+	IngestWriters        int
+	IngestBatchSpans     int
+	IngestBatchWait      time.Duration
+	// Span attributes naming the product feature a call belongs to, for cost attribution
+	CostFeatureAttributes string
+	// End users using more than UserTokenQuota tokens in UserQuotaWindow are flagged; checked
+	// every UserQuotaInterval (0 disables)
+	UserTokenQuota    int
+	UserQuotaWindow   time.Duration
+	UserQuotaInterval time.Duration
+	// LLM calls made after their model was idle for ColdStartIdle are marked as cold starts
+	// (0 disables)
+	ColdStartIdle time.Duration
 }
 
-// Run starts the Simple Traces server using environment configuration.
-func Run(logLevelFlag string) error {
+// Run starts the Simple Traces server using environment configuration. With demo set it
+// serves a bundled demo dataset from an in-memory database instead of the configured one.
+func Run(logLevelFlag string, demo bool) error {
 	config := loadConfig(logLevelFlag)
+	if demo {
+		config.DBType, config.DBConnection = "sqlite", ":memory:"
+		config.ReadSources, config.DBReadReplica = "", ""
+	}
 
 	// Initialize logger
 	logger := InitLogger(config.LogLevel)
+	if err := logger.AddLogSinks(&config); err != nil {
+		logger.Error("Failed to set up log sinks: %v", err)
+		return fmt.Errorf("init log sinks: %w", err)
+	}
+	defer logger.CloseSinks()
 	logger.Info("Starting Simple Traces server")
 	logger.Info("Log level: %s", config.LogLevel)
 
@@ -34,8 +214,23 @@ func Run(logLevelFlag string) error {
 		logger.Error("Failed to initialize database: %v", err)
 		return fmt.Errorf("init db: %w", err)
 	}
+	db, err = WithReadSources(db, config.ReadSources, config.LogLevel, logger)
+	if err != nil {
+		logger.Error("Failed to open read sources: %v", err)
+		return fmt.Errorf("init read sources: %w", err)
+	}
 	defer db.Close()
 	logger.Info("Database initialized successfully (type: %s)", config.DBType)
+	if config.ReadSources != "" {
+		logger.Info("Reading listings from %d additional database(s)", len(splitList(config.ReadSources)))
+	}
+	if config.LogLevel == "DEBUG" {
+		checkQueryPlans(db, logger)
+	}
+	if config.DBType != "postgres" && config.SQLiteMaintenanceInterval > 0 {
+		maintainer := NewDBMaintainer(db, config.SQLiteMaintenanceInterval, logger)
+		defer maintainer.Close()
+	}
 
 	router := mux.NewRouter()
 
@@ -44,45 +239,395 @@ func Run(logLevelFlag string) error {
 
 	// Spans endpoints: list and import JSONL examples
 	api.HandleFunc("/spans", getSpansHandler(db, logger)).Methods("GET")
+	api.HandleFunc("/spans/{id}", patchSpanHandler(db, logger)).Methods("PATCH")
+	api.HandleFunc("/assertions", assertionsHandler(db, logger)).Methods("POST")
+	api.HandleFunc("/runs", getRunsHandler(db, logger)).Methods("GET")
+	api.HandleFunc("/runs/{id}", getRunHandler(db, logger)).Methods("GET")
+	api.HandleFunc("/spans/{id}/comments", getCommentsHandler(db, logger)).Methods("GET")
+	api.HandleFunc("/spans/{id}/comments", createCommentHandler(db, logger)).Methods("POST")
 
 	// Grouped traces (OTLP trace_id)
 	api.HandleFunc("/trace-groups", getTraceGroupsHandler(db, logger)).Methods("GET")
+	api.HandleFunc("/trace-groups/slowest", getSlowestTracesHandler(db, config.PublicURL, logger)).Methods("GET")
 	api.HandleFunc("/trace-groups/{trace_id}", getTraceGroupSpansHandler(db, logger)).Methods("GET")
 	api.HandleFunc("/trace-groups/{trace_id}", deleteTraceGroupHandler(db, logger)).Methods("DELETE")
+	api.HandleFunc("/trace-groups/{trace_id}", updateTraceGroupHandler(db, logger)).Methods("PATCH")
+	api.HandleFunc("/trace-groups/{trace_id}/assign-conversation", assignTraceConversationHandler(db, logger)).Methods("POST")
+	api.HandleFunc("/trace-groups/{trace_id}/documents", getRetrievedDocumentsHandler(db, logger)).Methods("GET")
+	api.HandleFunc("/logs", getLogsHandler(db, logger)).Methods("GET")
+	api.HandleFunc("/metrics", getMetricPointsHandler(db, logger)).Methods("GET")
+	api.HandleFunc("/trace-groups/{trace_id}/critical-path", getCriticalPathHandler(db, logger)).Methods("GET")
+	api.Handle("/graphql", newGraphQLHandler(db)).Methods("POST")
+	api.HandleFunc("/trace-groups/{trace_id}/comments", getCommentsHandler(db, logger)).Methods("GET")
+	api.HandleFunc("/trace-groups/{trace_id}/comments", createCommentHandler(db, logger)).Methods("POST")
+
+	issueTracker, err := NewIssueTracker(&config)
+	if err != nil {
+		logger.Error("Failed to initialize issue tracker: %v", err)
+		return fmt.Errorf("init issue tracker: %w", err)
+	}
+	if issueTracker != nil {
+		logger.Info("Issue tracker enabled: %s", issueTracker.Name())
+	}
+	api.HandleFunc("/trace-groups/{trace_id}/issue", createTraceIssueHandler(db, issueTracker, config.PublicURL, logger)).Methods("POST")
+
+	// Per-user notification settings, consumed by alerting
+	api.HandleFunc("/notification-preferences", getNotificationPreferencesHandler(db, logger)).Methods("GET")
+	api.HandleFunc("/users/{user_id}/notifications", getNotificationPreferenceHandler(db, logger)).Methods("GET")
+	api.HandleFunc("/users/{user_id}/notifications", putNotificationPreferenceHandler(db, logger)).Methods("PUT")
+	api.HandleFunc("/users/{user_id}/notifications", deleteNotificationPreferenceHandler(db, logger)).Methods("DELETE")
+
+	// Comments
+	api.HandleFunc("/comments/{comment_id}", updateCommentHandler(db, logger)).Methods("PATCH", "PUT")
+	api.HandleFunc("/comments/{comment_id}", deleteCommentHandler(db, logger)).Methods("DELETE")
 
 	// Projects API
 	api.HandleFunc("/projects", getProjectsHandler(db, logger)).Methods("GET")
 	api.HandleFunc("/projects", createProjectHandler(db, logger)).Methods("POST")
 	api.HandleFunc("/projects/{id}", getProjectByIDHandler(db, logger)).Methods("GET")
 
+	// Stats
+	api.HandleFunc("/stats/guardrails", getGuardrailStatsHandler(db, logger)).Methods("GET")
+	api.HandleFunc("/stats/finish-reasons", getFinishReasonStatsHandler(db, logger)).Methods("GET")
+	api.HandleFunc("/stats/prompt-breakdown", getPromptBreakdownStatsHandler(db, logger)).Methods("GET")
+	api.HandleFunc("/stats/retrieval", getRetrievalStatsHandler(db, logger)).Methods("GET")
+	api.HandleFunc("/stats/http", getHTTPStatsHandler(db, logger)).Methods("GET")
+	api.HandleFunc("/stats/entry-points", getEntryPointStatsHandler(db, logger)).Methods("GET")
+	api.HandleFunc("/stats/db", getDBStatsHandler(db, logger)).Methods("GET")
+	api.HandleFunc("/stats/latency-heatmap", getLatencyHeatmapHandler(db, logger)).Methods("GET")
+	api.HandleFunc("/stats/engagement", getEngagementHandler(db, logger)).Methods("GET")
+	api.HandleFunc("/stats/structured-outputs", getStructuredOutputStatsHandler(db, logger)).Methods("GET")
+	api.HandleFunc("/stats/metrics", getDerivedMetricStatsHandler(db, logger)).Methods("GET")
+	api.HandleFunc("/stats/storage", getStorageStatsHandler(db, logger)).Methods("GET")
+
+	contextLimits, err := parseContextLimits(config.ModelContextLimits)
+	if err != nil {
+		logger.Error("Invalid MODEL_CONTEXT_LIMITS: %v", err)
+		return fmt.Errorf("parse context limits: %w", err)
+	}
+	api.HandleFunc("/stats/token-budget", getTokenBudgetStatsHandler(db, contextLimits, logger)).Methods("GET")
+	prices, err := parseModelPrices(config.ModelPrices)
+	if err != nil {
+		logger.Error("Invalid MODEL_PRICES: %v", err)
+		return fmt.Errorf("parse model prices: %w", err)
+	}
+	api.HandleFunc("/stats/duplicate-prompts", getDuplicatePromptsHandler(db, prices, logger)).Methods("GET")
+	api.HandleFunc("/stats/cost-attribution", getCostAttributionHandler(db, prices, splitList(config.CostFeatureAttributes), logger)).Methods("GET")
+	quotaMonitor := NewUserQuotaMonitor(db, prices, int64(config.UserTokenQuota), config.UserQuotaWindow, config.UserQuotaInterval, logger)
+	if quotaMonitor != nil {
+		defer quotaMonitor.Close()
+		logger.Info("Flagging users over %d tokens per %s", config.UserTokenQuota, config.UserQuotaWindow)
+	}
+	api.HandleFunc("/stats/top-users", getTopUsersHandler(db, prices, quotaMonitor, logger)).Methods("GET")
+	api.HandleFunc("/stats/cold-starts", getColdStartStatsHandler(db, logger)).Methods("GET")
+
+	// Database administration
+	admin := api.PathPrefix("/admin").Subrouter()
+	if config.AdminToken != "" {
+		admin.Use(bearerAuthMiddleware(config.AdminToken, "admin"))
+	} else {
+		admin.Use(loopbackOnlyMiddleware("admin"))
+		logger.Info("ADMIN_TOKEN is not set, /api/admin is only served to localhost")
+	}
+	admin.HandleFunc("/storage", getStorageUsageHandler(db, logger)).Methods("GET")
+	admin.HandleFunc("/storage/vacuum", vacuumHandler(db, logger)).Methods("POST")
+	admin.HandleFunc("/log-level", getLogLevelHandler(logger)).Methods("GET")
+	admin.HandleFunc("/log-level", setLogLevelHandler(logger)).Methods("PUT")
+	admin.HandleFunc("/conversations/merge", mergeConversationsHandler(db, logger)).Methods("POST")
+	admin.HandleFunc("/conversations/{id}/split", splitConversationHandler(db, logger)).Methods("POST")
+	admin.HandleFunc("/overrides", getAttributeOverridesHandler(db, logger)).Methods("GET")
+	admin.HandleFunc("/spans/{id}/overrides", setAttributeOverridesHandler(db, logger)).Methods("PATCH")
+	admin.HandleFunc("/trace-groups/{trace_id}/overrides", setAttributeOverridesHandler(db, logger)).Methods("PATCH")
+
 	// Conversations API
-	api.HandleFunc("/conversations", getConversationsHandler(db, logger)).Methods("GET")
+	api.HandleFunc("/conversations", getConversationsHandler(db, prices, logger)).Methods("GET")
+	api.HandleFunc("/conversations/search", searchConversationTranscriptsHandler(db, logger)).Methods("GET")
+	api.HandleFunc("/conversations/{id}/transcript", getConversationTranscriptHandler(db, logger)).Methods("GET")
+	api.HandleFunc("/conversations/{id}/documents", getRetrievedDocumentsHandler(db, logger)).Methods("GET")
+	api.HandleFunc("/conversations/{id}/token-budget", getConversationTokenBudgetHandler(db, contextLimits, logger)).Methods("GET")
+	api.HandleFunc("/conversations/{id}/baseline", getConversationBaselineHandler(db, prices, logger)).Methods("GET")
+	api.HandleFunc("/conversations/{id}/latency", getConversationLatencyHandler(db, logger)).Methods("GET")
 	api.HandleFunc("/conversations/{id}", deleteConversationHandler(db, logger)).Methods("DELETE")
 
 	// OpenTelemetry OTLP endpoint
 	otlpHandler := NewOTLPHandler(db, logger)
-	router.HandleFunc("/v1/traces", otlpHandler.ServeHTTP).Methods("POST")
-	logger.Info("OTLP HTTP endpoint enabled at /v1/traces")
+	otlpHandler.SetWorkers(config.IngestWorkers)
+	if preview := NewPayloadPreview(config.LogOTLPPayloads, config.LogOTLPPayloadMaxBytes, config.LogOTLPPayloadEvery); preview != nil {
+		otlpHandler.SetPayloadPreview(preview)
+		logger.Info("Logging OTLP payload previews for 1 in %d exports, up to %d bytes", preview.every, config.LogOTLPPayloadMaxBytes)
+	}
+	transforms, err := LoadTransforms(config.TransformsFile)
+	if err != nil {
+		logger.Error("Failed to load ingest transforms: %v", err)
+		return fmt.Errorf("load transforms: %w", err)
+	}
+	if noise := NewNoiseFilter(config.NoiseFilters); noise != nil {
+		otlpHandler.SetNoiseFilter(noise)
+		logger.Info("Noise filters enabled: %s", strings.Join(noise.Enabled(), ", "))
+	}
+	if rules := NewStatusRules(config.StatusRules); rules != nil {
+		otlpHandler.SetStatusRules(rules)
+		logger.Info("Status derivation enabled: %s", strings.Join(rules.Enabled(), ", "))
+	}
+	otlpHandler.SetTraceSpanCap(NewTraceSpanCap(config.MaxSpansPerTrace))
+	otlpHandler.SetRateLimit(NewIngestRateLimit(config.IngestRateLimit, config.IngestSpanRateLimit))
+	otlpHandler.SetMaxPayloadBytes(int64(config.MaxPayloadBytes))
+	ingestLag := NewIngestLagMonitor(config.IngestLagAlert)
+	otlpHandler.SetColdStartDetector(NewColdStartDetector(config.ColdStartIdle))
+	api.HandleFunc("/stats/ingest-lag", getIngestLagStatsHandler(db, ingestLag, logger)).Methods("GET")
+	if dedup := NewSpanDedup(config.DedupWindow, config.DedupSize); dedup != nil {
+		switch config.DedupStore {
+		case "memory":
+		case "database":
+			dedup.SetStore(db)
+			logger.Info("Span dedup window of %s is kept in the database", config.DedupWindow)
+		default:
+			return fmt.Errorf("SPAN_DEDUP_STORE must be memory or database, not %q", config.DedupStore)
+		}
+		otlpHandler.SetDedup(dedup)
+	}
+	if transforms != nil {
+		otlpHandler.SetTransforms(transforms)
+		logger.Info("Loaded %d ingest transforms from %s", transforms.Len(), config.TransformsFile)
+	}
+	schemas, err := LoadResponseSchemas(config.ResponseSchemasFile)
+	if err != nil {
+		logger.Error("Failed to load response schemas: %v", err)
+		return fmt.Errorf("load response schemas: %w", err)
+	}
+	otlpHandler.SetResponseSchemas(schemas)
+	derived, err := LoadDerivedMetrics(config.DerivedMetricsFile)
+	if err != nil {
+		logger.Error("Failed to load derived metrics: %v", err)
+		return fmt.Errorf("load derived metrics: %w", err)
+	}
+	if derived != nil {
+		otlpHandler.SetDerivedMetrics(derived)
+		logger.Info("Loaded %d derived metrics from %s", derived.Len(), config.DerivedMetricsFile)
+	}
+	if schemas.Len() > 0 {
+		logger.Info("Loaded response schemas of %d projects from %s", schemas.Len(), config.ResponseSchemasFile)
+	}
+
+	queue := NewIngestQueue(otlpHandler, config.IngestQueueSize, config.IngestQueueHighWater, config.IngestWriters, config.IngestBatchSpans, config.IngestBatchWait)
+	if queue != nil {
+		defer queue.Close()
+		otlpHandler.SetQueue(queue)
+		logger.Info("Ingest queue of %d exports (high-water mark %d) written by %d writers in batches of up to %d spans",
+			config.IngestQueueSize, queue.highWater, config.IngestWriters, config.IngestBatchSpans)
+	}
+	api.HandleFunc("/stats/ingest-queue", getIngestQueueStatsHandler(queue)).Methods("GET")
+	admin.HandleFunc("/dead-letters", getDeadLettersHandler(db, logger)).Methods("GET")
+	admin.HandleFunc("/dead-letters/replay", replayDeadLettersHandler(db, otlpHandler, logger)).Methods("POST")
+	admin.HandleFunc("/dead-letters/{id}/replay", replayDeadLetterHandler(db, otlpHandler, logger)).Methods("POST")
+	admin.HandleFunc("/dead-letters/{id}", deleteDeadLetterHandler(db, logger)).Methods("DELETE")
+
+	// Live span stream (SSE) for the UI and `simple-traces tail`
+	spanStream := NewSpanStream()
+	otlpHandler.OnInsert(spanStream.Publish)
+	api.HandleFunc("/spans/stream", spanStreamHandler(spanStream, logger)).Methods("GET")
+
+	// Optional embeddings for semantic search
+	embedder, err := NewEmbedder(&config)
+	if err != nil {
+		logger.Error("Failed to initialize embeddings: %v", err)
+		return fmt.Errorf("init embeddings: %w", err)
+	}
+	if embedder != nil {
+		indexer := NewEmbeddingIndexer(db, embedder, logger)
+		defer indexer.Close()
+		otlpHandler.OnInsert(indexer.Enqueue)
+		logger.Info("Embeddings enabled (provider: %s, model: %s)", config.EmbeddingsProvider, embedder.Model())
+	}
+	api.HandleFunc("/search/semantic", semanticSearchHandler(db, embedder, config.EmbeddingsSearchWindow, logger)).Methods("GET")
+
+	var clusterer *ConversationClusterer
+	if embedder != nil {
+		clusterer = NewConversationClusterer(db, embedder, &config, logger)
+		defer clusterer.Close()
+	}
+	api.HandleFunc("/clusters", getClustersHandler(clusterer, logger)).Methods("GET")
+	admin.HandleFunc("/clusters/refresh", refreshClustersHandler(clusterer, logger)).Methods("POST")
+
+	if config.HealthScoreInterval > 0 {
+		scorer := NewHealthScorer(db, &config, logger)
+		defer scorer.Close()
+	}
+
+	// Optional LLM-generated conversation summaries
+	llm := NewLLMClient(&config)
+	api.HandleFunc("/query/natural", naturalQueryHandler(llm, logger)).Methods("POST")
+	if config.SummarizeEnabled {
+		if llm == nil {
+			logger.Warn("SUMMARIZE_CONVERSATIONS is set but no LLM is configured (LLM_API_KEY or LLM_URL)")
+		} else {
+			summarizer := NewSummarizer(db, llm, &config, logger)
+			defer summarizer.Close()
+			logger.Info("Conversation summaries enabled (model: %s)", llm.Model())
+		}
+	}
+
+	if demo {
+		if err := seedDemo(db, otlpHandler, logger); err != nil {
+			logger.Error("Failed to load demo data: %v", err)
+			return fmt.Errorf("load demo data: %w", err)
+		}
+	}
+	// the demo spans ended hours ago; attach the lag monitor after seeding them so they aren't
+	// reported as exporters falling behind
+	otlpHandler.SetIngestLagMonitor(ingestLag)
+
+	// Ingest sources other than HTTP (message queues)
+	sources, err := StartIngestSources(&config, otlpHandler, logger)
+	if err != nil {
+		logger.Error("Failed to start ingest sources: %v", err)
+		return fmt.Errorf("start ingest sources: %w", err)
+	}
+	defer CloseIngestSources(sources, logger)
+
+	// OTLP ingest runs on the main listener unless INGEST_LISTEN gives it its own
+	ingestRouter := router
+	if config.IngestListen != "" {
+		ingestRouter = mux.NewRouter()
+		// answer exporters and imports still pointed at the UI/API port instead of letting the SPA
+		// fallback accept them
+		elsewhere := func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "Ingest is served on a separate listener (INGEST_LISTEN)", http.StatusNotFound)
+		}
+		router.HandleFunc("/v1/{signal:traces|logs|metrics}", elsewhere)
+		router.HandleFunc("/v1/traces/stream", elsewhere)
+		router.HandleFunc("/api/spans/import", elsewhere)
+		router.HandleFunc("/api/import/langfuse", elsewhere)
+		router.HandleFunc("/api/traces", elsewhere)
+		router.HandleFunc("/api/v2/spans", elsewhere)
+	}
+	ingest := ingestRouter.NewRoute().Subrouter()
+	ingest.HandleFunc("/v1/traces", otlpHandler.ServeHTTP).Methods("POST")
+	ingest.Handle("/v1/traces/stream", wsIngestHandler(otlpHandler, config.CORSOrigins, logger)).Methods("GET")
+	logsHandler := NewOTLPLogsHandler(db, logger)
+	logsHandler.SetMaxPayloadBytes(int64(config.MaxPayloadBytes))
+	ingest.Handle("/v1/logs", logsHandler).Methods("POST")
+	metricsHandler := NewOTLPMetricsHandler(db, logger)
+	metricsHandler.SetMaxPayloadBytes(int64(config.MaxPayloadBytes))
+	ingest.Handle("/v1/metrics", metricsHandler).Methods("POST")
+	ingest.HandleFunc("/api/v2/spans", zipkinHandler(otlpHandler, logger)).Methods("POST")
+	ingest.HandleFunc("/api/traces", jaegerHandler(otlpHandler, logger)).Methods("POST")
+	ingest.HandleFunc("/api/spans/import", importSpansHandler(otlpHandler, logger)).Methods("POST")
+	ingest.HandleFunc("/api/import/langfuse", importLangfuseHandler(otlpHandler, logger)).Methods("POST")
+	if config.IngestToken != "" {
+		ingest.Use(bearerAuthMiddleware(config.IngestToken, "ingest"))
+		logger.Info("Ingest requires a bearer token (INGEST_TOKEN)")
+	}
+	router.Handle("/metrics", metrics).Methods("GET")
+	router.Handle("/mcp", NewMCPServer(db, logger))
+	logger.Info("OTLP HTTP endpoints enabled at /v1/traces, /v1/logs and /v1/metrics")
 
 	// Serve embedded frontend static files with SPA fallback
 	router.PathPrefix("/").Handler(newSPAHandler(getFrontendFS()))
 
-	// Enable CORS for development
-	router.Use(corsMiddleware)
-	router.Use(loggingMiddleware(logger))
+	// Browser access is only needed for the UI/API; a separate ingest listener gets no CORS headers
+	router.Use(corsMiddleware(config.CORSOrigins))
+	requestLogRules, err := ParseRequestLogRules(config.RequestLogExclude, config.RequestLogSample)
+	if err != nil {
+		logger.Error("Invalid REQUEST_LOG_SAMPLE: %v", err)
+		return fmt.Errorf("parse request log rules: %w", err)
+	}
+	router.Use(loggingMiddleware(logger, requestLogRules))
+	// ahead of the response cache so cached bodies stay uncompressed and serve any client
+	router.Use(compressionMiddleware)
+	if ingestRouter != router {
+		ingestRouter.Use(loggingMiddleware(logger, requestLogRules))
+	}
+
+	var cacheStore CacheStore = newMemoryCache(config.CacheMaxEntries)
+	if config.CacheRedisURL != "" && config.CacheRoutes != "" {
+		rc, err := newRedisCache(config.CacheRedisURL)
+		if err != nil {
+			logger.Error("Failed to connect to the Redis cache: %v", err)
+			return fmt.Errorf("init cache: %w", err)
+		}
+		defer rc.Close()
+		cacheStore = rc
+		logger.Info("Response cache shared through Redis")
+	}
+	responseCache, err := NewResponseCache(cacheStore, config.CacheRoutes)
+	if err != nil {
+		logger.Error("Invalid CACHE_ROUTES: %v", err)
+		return fmt.Errorf("init cache: %w", err)
+	}
+	if responseCache != nil {
+		router.Use(responseCache.Middleware)
+		api.Use(responseCache.InvalidateOnWrite)
+		otlpHandler.SetResponseCache(responseCache)
+		logger.Info("Response cache enabled for %s", config.CacheRoutes)
+	}
+
+	listenOn := config.Listen
+	if listenOn == "" {
+		listenOn = ":" + config.Port
+	}
+	logger.Debug("HTTP limits: read header %s, read %s, write %s, idle %s, max header %d bytes, h2c %t",
+		config.ReadHeaderTimeout, config.ReadTimeout, config.WriteTimeout, config.IdleTimeout, config.MaxHeaderBytes, config.H2C)
+	listener, err := listen(listenOn, config)
+	if err != nil {
+		logger.Error("Server failed to start on %s: %v", listenOn, err)
+		return fmt.Errorf("listen: %w", err)
+	}
+	logger.Info("Server listening on %s", listener.Addr())
+
+	// long-lived requests (span streams) end when shutdown begins instead of holding it up
+	baseCtx, cancelBase := context.WithCancel(context.Background())
+	defer cancelBase()
+	var servers []*http.Server
+	serve := func(handler http.Handler, l net.Listener, errs chan<- error) {
+		srv := newHTTPServer(config, handler)
+		srv.BaseContext = func(net.Listener) context.Context { return baseCtx }
+		srv.RegisterOnShutdown(cancelBase)
+		servers = append(servers, srv)
+		go func() { errs <- srv.Serve(l) }()
+	}
+
+	errs := make(chan error, 2)
+	if ingestRouter != router {
+		ingestListener, err := listen(config.IngestListen, config)
+		if err != nil {
+			listener.Close()
+			logger.Error("Ingest server failed to start on %s: %v", config.IngestListen, err)
+			return fmt.Errorf("listen ingest: %w", err)
+		}
+		logger.Info("OTLP ingest listening on %s", ingestListener.Addr())
+		serve(ingestRouter, ingestListener, errs)
+	}
 
-	addr := ":" + config.Port
-	logger.Info("Server starting on %s", addr)
+	if addr, ok := listener.Addr().(*net.TCPAddr); ok {
+		// Print a clickable URL for local development
+		baseURL := fmt.Sprintf("http://localhost:%d", addr.Port)
+		logger.Info("Open in your browser: %s", baseURL)
+		logger.Debug("Alternative: http://127.0.0.1:%d", addr.Port)
+		logger.Debug("API base: %s/api", baseURL)
+		if ingestRouter == router {
+			logger.Info("OTLP ingest endpoint: %s/v1/traces", baseURL)
+		}
+	}
+	serve(router, listener, errs)
 
-	// Print a clickable URL for local development
-	baseURL := fmt.Sprintf("http://localhost:%s", config.Port)
-	logger.Info("Open in your browser: %s", baseURL)
-	logger.Debug("Alternative: http://127.0.0.1:%s", config.Port)
-	logger.Debug("API base: %s/api", baseURL)
-	logger.Info("OTLP ingest endpoint: %s/v1/traces", baseURL)
-	if err := http.ListenAndServe(addr, router); err != nil {
-		logger.Error("Server failed to start: %v", err)
-		return fmt.Errorf("listen and serve: %w", err)
+	stop, cancelStop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancelStop()
+	select {
+	case err := <-errs:
+		logger.Error("Server failed: %v", err)
+		return fmt.Errorf("serve: %w", err)
+	case <-stop.Done():
+	}
+	// stop accepting requests before the deferred closes (ingest queue, sources, database) run,
+	// so no handler writes into them while they shut down
+	logger.Info("Shutting down, waiting up to %s for requests to finish", shutdownTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			logger.Warn("Requests still running at shutdown: %v", err)
+		}
 	}
 	return nil
 }
@@ -91,10 +636,116 @@ func loadConfig(logLevelFlag string) Config {
 	config := Config{
 		DBType: getEnv("DB_TYPE", "sqlite"),
 		// Default to a local, writable path for non-container runs; Dockerfile overrides to /data/traces.db
-		DBConnection: getEnv("DB_CONNECTION", "./data/traces.db"),
-		Port:         getEnv("PORT", "8080"),
-		FrontendDir:  "", // No longer used - frontend is embedded
-		LogLevel:     getLogLevel(logLevelFlag),
+		DBConnection:   getEnv("DB_CONNECTION", "./data/traces.db"),
+		Port:           getEnv("PORT", "8080"),
+		Listen:         getEnv("LISTEN", ""),
+		UnixSocketMode: getEnvFileMode("UNIX_SOCKET_MODE", 0o660),
+		IngestListen:   getEnv("INGEST_LISTEN", ""),
+		IngestToken:    getEnv("INGEST_TOKEN", ""),
+		CORSOrigins:    getEnv("CORS_ALLOWED_ORIGINS", "*"),
+		AdminToken:     getEnv("ADMIN_TOKEN", ""),
+		FrontendDir:    "", // No longer used - frontend is embedded
+		LogLevel:       getLogLevel(logLevelFlag),
+
+		ReadHeaderTimeout: getEnvDuration("HTTP_READ_HEADER_TIMEOUT", 10*time.Second),
+		ReadTimeout:       getEnvDuration("HTTP_READ_TIMEOUT", time.Minute),
+		WriteTimeout:      getEnvDuration("HTTP_WRITE_TIMEOUT", 2*time.Minute),
+		IdleTimeout:       getEnvDuration("HTTP_IDLE_TIMEOUT", 2*time.Minute),
+		MaxHeaderBytes:    getEnvInt("HTTP_MAX_HEADER_BYTES", 1<<20),
+		H2C:               getEnv("HTTP_H2C", "true") == "true",
+		HTTP2MaxStreams:   getEnvInt("HTTP2_MAX_CONCURRENT_STREAMS", 250),
+		TCPKeepAlive:      getEnvDuration("HTTP_TCP_KEEPALIVE", 30*time.Second),
+
+		EmbeddingsProvider:     strings.ToLower(getEnv("EMBEDDINGS_PROVIDER", "")),
+		EmbeddingsURL:          getEnv("EMBEDDINGS_URL", "https://api.openai.com/v1/embeddings"),
+		EmbeddingsModel:        getEnv("EMBEDDINGS_MODEL", "text-embedding-3-small"),
+		EmbeddingsAPIKey:       getEnv("EMBEDDINGS_API_KEY", ""),
+		EmbeddingsDim:          getEnvInt("EMBEDDINGS_DIM", 256),
+		EmbeddingsSearchWindow: getEnvInt("EMBEDDINGS_SEARCH_WINDOW", 20000),
+
+		ClusterInterval: getEnvDuration("CLUSTER_INTERVAL", 15*time.Minute),
+		ClusterWindow:   getEnvDuration("CLUSTER_WINDOW", 7*24*time.Hour),
+		ClusterCount:    getEnvInt("CLUSTER_COUNT", 0),
+
+		LLMURL:             getEnv("LLM_URL", ""),
+		LLMModel:           getEnv("LLM_MODEL", "gpt-4o-mini"),
+		LLMAPIKey:          getEnv("LLM_API_KEY", ""),
+		SummarizeEnabled:   getEnv("SUMMARIZE_CONVERSATIONS", "false") == "true",
+		SummaryInterval:    getEnvDuration("SUMMARY_INTERVAL", time.Minute),
+		SummaryQuietPeriod: getEnvDuration("SUMMARY_QUIET_PERIOD", 5*time.Minute),
+
+		HealthScoreInterval:    getEnvDuration("HEALTH_SCORE_INTERVAL", time.Minute),
+		HealthScoreQuietPeriod: getEnvDuration("HEALTH_SCORE_QUIET_PERIOD", 30*time.Second),
+
+		SQLiteMaintenanceInterval: getEnvDuration("SQLITE_MAINTENANCE_INTERVAL", time.Hour),
+		ReadSources:               getEnv("READ_SOURCES", ""),
+		DBReadReplica:             getEnv("DB_READ_REPLICA", ""),
+		DBAutoMigrate:             getEnv("DB_AUTO_MIGRATE", "true") == "true",
+
+		ModelContextLimits: getEnv("MODEL_CONTEXT_LIMITS", ""),
+		ModelPrices:        getEnv("MODEL_PRICES", ""),
+
+		TransformsFile:      getEnv("INGEST_TRANSFORMS_FILE", ""),
+		ResponseSchemasFile: getEnv("RESPONSE_SCHEMAS_FILE", ""),
+		DerivedMetricsFile:  getEnv("DERIVED_METRICS_FILE", ""),
+		NoiseFilters:        getEnv("NOISE_FILTERS", defaultNoiseFilter),
+		StatusRules:         getEnv("STATUS_RULES", defaultStatusRules),
+		DedupWindow:         getEnvDuration("SPAN_DEDUP_WINDOW", 10*time.Minute),
+		DedupSize:           getEnvInt("SPAN_DEDUP_SIZE", 100000),
+		DedupStore:          getEnv("SPAN_DEDUP_STORE", "memory"),
+		IngestWorkers:       getEnvInt("INGEST_WORKERS", runtime.NumCPU()),
+
+		MaxSpansPerTrace: getEnvInt("MAX_SPANS_PER_TRACE", 10000),
+
+		CacheRoutes:     getEnv("CACHE_ROUTES", ""),
+		CacheMaxEntries: getEnvInt("CACHE_MAX_ENTRIES", 1000),
+		CacheRedisURL:   getEnv("CACHE_REDIS_URL", ""),
+
+		IssueTracker:  getEnv("ISSUE_TRACKER", ""),
+		PublicURL:     getEnv("PUBLIC_URL", ""),
+		GitHubRepo:    getEnv("GITHUB_REPO", ""),
+		GitHubToken:   getEnv("GITHUB_TOKEN", ""),
+		GitHubAPIURL:  getEnv("GITHUB_API_URL", "https://api.github.com"),
+		JiraURL:       getEnv("JIRA_URL", ""),
+		JiraProject:   getEnv("JIRA_PROJECT", ""),
+		JiraIssueType: getEnv("JIRA_ISSUE_TYPE", "Bug"),
+		JiraEmail:     getEnv("JIRA_EMAIL", ""),
+		JiraAPIToken:  getEnv("JIRA_API_TOKEN", ""),
+
+		LogFile:           getEnv("LOG_FILE", ""),
+		LogFileMaxSizeMB:  getEnvInt("LOG_FILE_MAX_SIZE_MB", 100),
+		LogFileMaxAge:     getEnvDuration("LOG_FILE_MAX_AGE", 24*time.Hour),
+		LogFileMaxBackups: getEnvInt("LOG_FILE_MAX_BACKUPS", 7),
+		LogSyslog:         getEnv("LOG_SYSLOG", ""),
+
+		LogOTLPPayloads:        getEnv("LOG_OTLP_PAYLOADS", "") == "true",
+		LogOTLPPayloadMaxBytes: getEnvInt("LOG_OTLP_PAYLOAD_MAX_BYTES", 4096),
+		LogOTLPPayloadEvery:    getEnvInt("LOG_OTLP_PAYLOAD_SAMPLE_EVERY", 10),
+		RequestLogExclude:      getEnv("REQUEST_LOG_EXCLUDE", ""),
+		RequestLogSample:       getEnv("REQUEST_LOG_SAMPLE", ""),
+		IngestLagAlert:         getEnvDuration("INGEST_LAG_ALERT", 2*time.Minute),
+		TraceCompleteAfter:     getEnvDuration("TRACE_COMPLETE_AFTER", 30*time.Second),
+		NATSURL:                getEnv("NATS_URL", ""),
+		NATSSubject:            getEnv("NATS_SUBJECT", "otlp.traces"),
+		NATSStream:             getEnv("NATS_STREAM", ""),
+		NATSConsumer:           getEnv("NATS_CONSUMER", "simple-traces"),
+		NATSQueue:              getEnv("NATS_QUEUE", ""),
+		WatchDir:               getEnv("WATCH_DIR", ""),
+		WatchInterval:          getEnvDuration("WATCH_INTERVAL", 2*time.Second),
+		WatchStateFile:         getEnv("WATCH_STATE_FILE", ""),
+		IngestRateLimit:        getEnvInt("INGEST_RATE_LIMIT", 0),
+		IngestSpanRateLimit:    getEnvInt("INGEST_SPAN_RATE_LIMIT", 0),
+		MaxPayloadBytes:        getEnvInt("MAX_PAYLOAD_BYTES", defaultMaxPayloadBytes),
+		IngestQueueSize:        getEnvInt("INGEST_QUEUE_SIZE", 0),
+		IngestQueueHighWater:   getEnvInt("INGEST_QUEUE_HIGH_WATER", 0),
+		IngestWriters:          getEnvInt("INGEST_WRITERS", 2),
+		IngestBatchSpans:       getEnvInt("INGEST_BATCH_SPANS", 2000),
+		IngestBatchWait:        getEnvDuration("INGEST_BATCH_WAIT", 100*time.Millisecond),
+		CostFeatureAttributes:  getEnv("COST_FEATURE_ATTRIBUTES", "feature,app.feature"),
+		UserTokenQuota:         getEnvInt("USER_TOKEN_QUOTA", 0),
+		UserQuotaWindow:        getEnvDuration("USER_QUOTA_WINDOW", 24*time.Hour),
+		UserQuotaInterval:      getEnvDuration("USER_QUOTA_INTERVAL", 5*time.Minute),
+		ColdStartIdle:          getEnvDuration("COLD_START_IDLE", 10*time.Minute),
 	}
 
 	if config.DBType == "postgres" && config.DBConnection == "./traces.db" {
@@ -111,6 +762,33 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+func getEnvFileMode(key string, defaultValue fs.FileMode) fs.FileMode {
+	if value := strings.TrimSpace(os.Getenv(key)); value != "" {
+		if v, err := strconv.ParseUint(value, 8, 32); err == nil {
+			return fs.FileMode(v)
+		}
+	}
+	return defaultValue
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	if value := strings.TrimSpace(os.Getenv(key)); value != "" {
+		if v, err := strconv.Atoi(value); err == nil {
+			return v
+		}
+	}
+	return defaultValue
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := strings.TrimSpace(os.Getenv(key)); value != "" {
+		if v, err := time.ParseDuration(value); err == nil {
+			return v
+		}
+	}
+	return defaultValue
+}
+
 // getLogLevel returns log level from flag or environment, preferring flag
 func getLogLevel(flagValue string) string {
 	if flagValue != "" {
@@ -119,24 +797,41 @@ func getLogLevel(flagValue string) string {
 	return getEnv("LOG_LEVEL", "INFO")
 }
 
-func corsMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+// corsMiddleware allows browser calls from the given comma separated origins ("*" for any)
+func corsMiddleware(origins string) mux.MiddlewareFunc {
+	allowed := make(map[string]bool)
+	for _, o := range splitList(origins) {
+		allowed[strings.TrimSuffix(o, "/")] = true
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if allowed["*"] {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else if origin := r.Header.Get("Origin"); allowed[origin] {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Add("Vary", "Origin")
+			}
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, If-None-Match")
+			w.Header().Set("Access-Control-Expose-Headers", "ETag, X-Next-Cursor")
 
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
+			if r.Method == "OPTIONS" {
+				w.WriteHeader(http.StatusOK)
+				return
+			}
 
-		next.ServeHTTP(w, r)
-	})
+			next.ServeHTTP(w, r)
+		})
+	}
 }
 
-func loggingMiddleware(logger *Logger) func(http.Handler) http.Handler {
+func loggingMiddleware(logger *Logger, rules *RequestLogRules) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if rules.Excluded(r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
 			start := time.Now()
 
 			// Log request
@@ -149,7 +844,9 @@ func loggingMiddleware(logger *Logger) func(http.Handler) http.Handler {
 
 			// Log response
 			duration := time.Since(start)
-			logger.Info("Request: %s %s - Status: %d - Duration: %v", r.Method, r.URL.Path, wrapped.statusCode, duration)
+			if rules.Sampled(r.URL.Path, wrapped.statusCode) {
+				logger.Info("Request: %s %s - Status: %d - Duration: %v", r.Method, r.URL.Path, wrapped.statusCode, duration)
+			}
 		})
 	}
 }
@@ -164,6 +861,11 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+// Unwrap lets http.ResponseController reach the underlying writer (flushing, deadlines)
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 type TraceInput struct {
 	Model        string                 `json:"model"`
 	Input        string                 `json:"input"`
@@ -192,17 +894,76 @@ func getSpansHandler(db Database, logger *Logger) http.HandlerFunc {
 				before = t
 			}
 		}
-		spans, err := db.GetSpans(limit, before)
+		filter, err := parseSpanFilter(q)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		spans, err := db.GetSpansFiltered(limit, before, filter)
 		if err != nil {
 			logger.Error("Failed to get spans: %v", err)
 			http.Error(w, fmt.Sprintf("Failed to get spans: %v", err), http.StatusInternalServerError)
 			return
 		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(spans)
+		writeSpans(w, r, spans)
 	}
 }
 
+// parseSpanFilter reads the span filters shared by span listings: project, run, model, category,
+// status, http_method, http_route, db_fingerprint, schema, violation, violation_type, finish_reason,
+// since/until, min_duration_ms, attr and annotation
+func parseSpanFilter(q url.Values) (SpanFilter, error) {
+	filter := SpanFilter{
+		ProjectID:       strings.TrimSpace(q.Get("project")),
+		RunID:           strings.TrimSpace(q.Get("run")),
+		Model:           strings.TrimSpace(q.Get("model")),
+		ViolationType:   strings.TrimSpace(q.Get("violation_type")),
+		FinishReason:    strings.TrimSpace(q.Get("finish_reason")),
+		Category:        strings.TrimSpace(q.Get("category")),
+		StatusCode:      strings.ToUpper(strings.TrimSpace(q.Get("status"))),
+		HTTPMethod:      strings.ToUpper(strings.TrimSpace(q.Get("http_method"))),
+		HTTPRoute:       strings.TrimSpace(q.Get("http_route")),
+		DBFingerprintID: strings.TrimSpace(q.Get("db_fingerprint")),
+		PromptHash:      strings.TrimSpace(q.Get("prompt_hash")),
+		SchemaStatus:    strings.TrimSpace(q.Get("schema")),
+	}
+	for name, dst := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if s := strings.TrimSpace(q.Get(name)); s != "" {
+			t, err := parseTimeParam(s)
+			if err != nil {
+				return filter, fmt.Errorf("invalid %s: %v", name, err)
+			}
+			*dst = t
+		}
+	}
+	if s := strings.TrimSpace(q.Get("min_duration_ms")); s != "" {
+		v, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || v < 0 {
+			return filter, fmt.Errorf("invalid min_duration_ms")
+		}
+		filter.MinDurationMS = v
+	}
+	for _, a := range q["attr"] {
+		if f := parseAttributeFilter(a); f.Key != "" {
+			filter.Attributes = append(filter.Attributes, f)
+		}
+	}
+	for _, a := range q["annotation"] {
+		if f := parseAnnotationFilter(a); f.Key != "" {
+			filter.Annotations = append(filter.Annotations, f)
+		}
+	}
+	if v := strings.TrimSpace(q.Get("violation")); v != "" {
+		b := v == "true"
+		filter.Violation = &b
+	}
+	if v := strings.TrimSpace(q.Get("cold_start")); v != "" {
+		b := v == "true"
+		filter.ColdStart = &b
+	}
+	return filter, nil
+}
+
 // getTraceGroupsHandler returns groups of spans by trace_id, ordered by most recent activity
 func getTraceGroupsHandler(db Database, logger *Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -221,11 +982,29 @@ func getTraceGroupsHandler(db Database, logger *Logger) http.HandlerFunc {
 				before = t
 			}
 		}
-		search := strings.TrimSpace(q.Get("q"))
-		groups, err := db.GetTraceGroups(limit, before)
-		if search != "" {
-			groups, err = db.GetTraceGroupsWithSearch(limit, before, search)
+		filter := TraceGroupFilter{
+			Search:     strings.TrimSpace(q.Get("q")),
+			Status:     strings.TrimSpace(q.Get("status")),
+			Assignee:   strings.TrimSpace(q.Get("assignee")),
+			OnlyErrors: q.Get("errors") == "true",
+			RunID:      strings.TrimSpace(q.Get("run")),
+			EntryPoint: strings.TrimSpace(q.Get("entry_point")),
+			Sort:       strings.TrimSpace(q.Get("sort")),
 		}
+		switch q.Get("complete") {
+		case "":
+		case "true", "false":
+			complete := q.Get("complete") == "true"
+			filter.Complete = &complete
+		default:
+			http.Error(w, "complete must be true or false", http.StatusBadRequest)
+			return
+		}
+		if filter.Sort != "" && filter.Sort != TraceGroupSortHealth {
+			http.Error(w, "sort must be health", http.StatusBadRequest)
+			return
+		}
+		groups, err := db.GetTraceGroupsFiltered(limit, before, filter)
 		if err != nil {
 			logger.Error("Failed to get trace groups: %v", err)
 			http.Error(w, fmt.Sprintf("Failed to get trace groups: %v", err), http.StatusInternalServerError)
@@ -248,20 +1027,77 @@ func getTraceGroupSpansHandler(db Database, logger *Logger) http.HandlerFunc {
 			}
 		}
 		search := strings.TrimSpace(r.URL.Query().Get("q"))
-		spans, err := db.GetTraceGroupSpans(traceID, limit)
-		if search != "" {
-			spans, err = db.GetTraceGroupSpansWithSearch(traceID, limit, search)
+
+		// live traces are polled; answer 304 when nothing changed since the client's copy
+		version, err := db.GetTraceGroupVersion(traceID)
+		if err != nil {
+			logger.Warn("Failed to compute version of trace %s: %v", traceID, err)
+		} else {
+			etag := version.ETag()
+			if search != "" || r.URL.Query().Get("limit") != "" || r.URL.Query().Get("attributes") != "" ||
+				r.URL.Query().Get("after") != "" {
+				// the representation also depends on the query; fold it into the tag
+				etag = strings.TrimSuffix(etag, `"`) + fmt.Sprintf("-%x\"", fnvHash(r.URL.RawQuery))
+			}
+			w.Header().Set("ETag", etag)
+			w.Header().Set("X-Trace-Complete", strconv.FormatBool(version.Complete))
+			if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatches(inm, etag) {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+
+		filter := TraceSpanFilter{Search: search}
+		if after := strings.TrimSpace(r.URL.Query().Get("after")); after != "" {
+			seq, err := strconv.ParseInt(after, 10, 64)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid cursor %q", after), http.StatusBadRequest)
+				return
+			}
+			filter.After = &seq
 		}
+		spans, err := db.GetTraceGroupSpansFiltered(traceID, limit, filter)
 		if err != nil {
 			logger.Error("Failed to get group spans: %v", err)
 			http.Error(w, fmt.Sprintf("Failed to get group spans: %v", err), http.StatusInternalServerError)
 			return
 		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(spans)
+		// cursor for the next incremental fetch: the latest span received (or the one given).
+		// A truncated start-time ordered page has no safe cursor.
+		var next *int64
+		if filter.After != nil || len(spans) < limit {
+			next = filter.After
+			for _, sp := range spans {
+				if next == nil || sp.Seq > *next {
+					next = &sp.Seq
+				}
+			}
+		}
+		if next != nil {
+			w.Header().Set("X-Next-Cursor", strconv.FormatInt(*next, 10))
+		}
+		writeSpans(w, r, spans)
 	}
 }
 
+// etagMatches reports whether an If-None-Match header lists etag (weak comparison)
+func etagMatches(header, etag string) bool {
+	want := strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == want {
+			return true
+		}
+	}
+	return false
+}
+
+func fnvHash(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
+
 // deleteTraceGroupHandler deletes all spans for a given trace_id (trace group)
 func deleteTraceGroupHandler(db Database, logger *Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -361,7 +1197,7 @@ func createProjectHandler(db Database, logger *Logger) http.HandlerFunc {
 }
 
 // getConversationsHandler returns paginated conversations ordered by last_end_time DESC
-func getConversationsHandler(db Database, logger *Logger) http.HandlerFunc {
+func getConversationsHandler(db Database, prices ModelPrices, logger *Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		q := r.URL.Query()
 		limit := 100
@@ -388,6 +1224,9 @@ func getConversationsHandler(db Database, logger *Logger) http.HandlerFunc {
 			http.Error(w, fmt.Sprintf("Failed to get conversations: %v", err), http.StatusInternalServerError)
 			return
 		}
+		if q.Get("baseline") == "true" {
+			attachBaselines(db, convs, prices)
+		}
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(convs)
 	}
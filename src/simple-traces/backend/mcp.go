@@ -0,0 +1,386 @@
+package backend
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// MCP (Model Context Protocol) lets coding assistants and agents call simple-traces as a
+// tool server. The server speaks JSON-RPC 2.0 over stdio (`simple-traces mcp`) and over
+// HTTP at POST /mcp (the streamable HTTP transport, answering with plain JSON).
+
+// mcpProtocolVersions are the protocol revisions we accept, newest first
+var mcpProtocolVersions = []string{"2025-06-18", "2025-03-26", "2024-11-05"}
+
+// maxMCPAttributeBytes truncates span attributes in tool results to keep them readable for a model
+const maxMCPAttributeBytes = 2000
+
+type jsonrpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type jsonrpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type jsonrpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *jsonrpcError   `json:"error,omitempty"`
+}
+
+// JSON-RPC error codes
+const (
+	jsonrpcParseError     = -32700
+	jsonrpcInvalidRequest = -32600
+	jsonrpcMethodNotFound = -32601
+	jsonrpcInvalidParams  = -32602
+)
+
+// mcpTool describes a tool in tools/list
+type mcpTool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	InputSchema map[string]any `json:"inputSchema"`
+}
+
+// mcpSchema builds a JSON schema for a tool's arguments from "name": "type description" pairs
+func mcpSchema(required []string, props map[string][2]string) map[string]any {
+	properties := map[string]any{}
+	for name, p := range props {
+		properties[name] = map[string]any{"type": p[0], "description": p[1]}
+	}
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+var statsWindowProps = map[string][2]string{
+	"window":  {"string", "Time window ending now, e.g. 24h or 7d (default 24h)"},
+	"project": {"string", "Only this project"},
+	"model":   {"string", "Only this model"},
+}
+
+var mcpTools = []mcpTool{
+	{
+		Name:        "search_traces",
+		Description: "List recent traces (one agent run or request each), newest first, optionally matching text in span names, attributes or events.",
+		InputSchema: mcpSchema(nil, map[string][2]string{
+			"query":       {"string", "Text to search for"},
+			"errors_only": {"boolean", "Only traces with at least one failed span"},
+			"limit":       {"integer", "Maximum number of traces (default 20, max 100)"},
+		}),
+	},
+	{
+		Name:        "get_trace",
+		Description: "Get the spans of a trace in start order, with model, category, status, duration and (truncated) attributes.",
+		InputSchema: mcpSchema([]string{"trace_id"}, map[string][2]string{
+			"trace_id": {"string", "Trace id as returned by search_traces"},
+			"limit":    {"integer", "Maximum number of spans (default 200)"},
+		}),
+	},
+	{
+		Name:        "search_conversations",
+		Description: "Find conversations whose prompts or responses contain the given text.",
+		InputSchema: mcpSchema([]string{"query"}, map[string][2]string{
+			"query": {"string", "Text to search for in prompts and responses"},
+			"limit": {"integer", "Maximum number of conversations (default 20)"},
+		}),
+	},
+	{
+		Name:        "get_conversation",
+		Description: "Get a conversation with its summary and the prompt/response transcript of every turn.",
+		InputSchema: mcpSchema([]string{"conversation_id"}, map[string][2]string{
+			"conversation_id": {"string", "Conversation id"},
+			"limit":           {"integer", "Maximum number of turns (default 100)"},
+		}),
+	},
+	{
+		Name:        "get_stats",
+		Description: "Aggregate statistics per model: guardrails (refusal/moderation rates), finish_reasons, prompt_breakdown (prompt tokens by role) or retrieval (vector store latency).",
+		InputSchema: mcpSchema([]string{"kind"}, func() map[string][2]string {
+			props := map[string][2]string{"kind": {"string", "One of guardrails, finish_reasons, prompt_breakdown, retrieval"}}
+			for k, v := range statsWindowProps {
+				props[k] = v
+			}
+			return props
+		}()),
+	},
+}
+
+// MCPServer answers MCP requests from the database
+type MCPServer struct {
+	db     Database
+	logger *Logger
+}
+
+// NewMCPServer creates an MCP server
+func NewMCPServer(db Database, logger *Logger) *MCPServer {
+	return &MCPServer{db: db, logger: logger}
+}
+
+// handle processes one JSON-RPC message; notifications return nil
+func (s *MCPServer) handle(msg []byte) *jsonrpcResponse {
+	var req jsonrpcRequest
+	if err := json.Unmarshal(msg, &req); err != nil {
+		return &jsonrpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &jsonrpcError{jsonrpcParseError, "parse error"}}
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		return &jsonrpcResponse{JSONRPC: "2.0", ID: orNull(req.ID), Error: &jsonrpcError{jsonrpcInvalidRequest, "invalid request"}}
+	}
+	if len(req.ID) == 0 {
+		// notifications (e.g. notifications/initialized) need no answer
+		return nil
+	}
+	resp := &jsonrpcResponse{JSONRPC: "2.0", ID: req.ID}
+	switch req.Method {
+	case "initialize":
+		var p struct {
+			ProtocolVersion string `json:"protocolVersion"`
+		}
+		json.Unmarshal(req.Params, &p)
+		version := mcpProtocolVersions[0]
+		if slices.Contains(mcpProtocolVersions, p.ProtocolVersion) {
+			version = p.ProtocolVersion
+		}
+		resp.Result = map[string]any{
+			"protocolVersion": version,
+			"capabilities":    map[string]any{"tools": map[string]any{}},
+			"serverInfo":      map[string]string{"name": "simple-traces", "version": "1.0.0"},
+			"instructions":    "Query traces, conversations and statistics of LLM applications recorded by simple-traces.",
+		}
+	case "ping":
+		resp.Result = map[string]any{}
+	case "tools/list":
+		resp.Result = map[string]any{"tools": mcpTools}
+	case "tools/call":
+		var p struct {
+			Name      string         `json:"name"`
+			Arguments map[string]any `json:"arguments"`
+		}
+		if err := json.Unmarshal(req.Params, &p); err != nil {
+			resp.Error = &jsonrpcError{jsonrpcInvalidParams, "invalid params"}
+			break
+		}
+		result, err := s.callTool(p.Name, mcpArgs(p.Arguments))
+		if err == errUnknownTool {
+			resp.Error = &jsonrpcError{jsonrpcInvalidParams, "unknown tool: " + p.Name}
+			break
+		}
+		// tool failures are reported to the model as results, not protocol errors
+		if err != nil {
+			resp.Result = mcpText(err.Error(), true)
+			break
+		}
+		text, _ := json.MarshalIndent(result, "", "  ")
+		resp.Result = mcpText(string(text), false)
+	default:
+		resp.Error = &jsonrpcError{jsonrpcMethodNotFound, "method not found: " + req.Method}
+	}
+	return resp
+}
+
+func orNull(id json.RawMessage) json.RawMessage {
+	if len(id) == 0 {
+		return json.RawMessage("null")
+	}
+	return id
+}
+
+func mcpText(text string, isError bool) map[string]any {
+	return map[string]any{
+		"content": []map[string]string{{"type": "text", "text": text}},
+		"isError": isError,
+	}
+}
+
+// mcpArgs reads tool arguments leniently; models sometimes send numbers as strings
+type mcpArgs map[string]any
+
+func (a mcpArgs) str(key string) string {
+	if v, ok := a[key]; ok && v != nil {
+		return strings.TrimSpace(fmt.Sprint(v))
+	}
+	return ""
+}
+
+func (a mcpArgs) int(key string, def, maxValue int) int {
+	n, ok := asInt(a[key])
+	if !ok || n <= 0 {
+		return def
+	}
+	return min(int(n), maxValue)
+}
+
+func (a mcpArgs) bool(key string) bool {
+	v := a[key]
+	return v == true || v == "true"
+}
+
+var errUnknownTool = fmt.Errorf("unknown tool")
+
+func (s *MCPServer) callTool(name string, args mcpArgs) (any, error) {
+	switch name {
+	case "search_traces":
+		return s.db.GetTraceGroupsFiltered(args.int("limit", 20, 100), time.Time{}, TraceGroupFilter{
+			Search:     args.str("query"),
+			OnlyErrors: args.bool("errors_only"),
+		})
+	case "get_trace":
+		traceID := args.str("trace_id")
+		if traceID == "" {
+			return nil, fmt.Errorf("trace_id is required")
+		}
+		spans, err := s.db.GetTraceGroupSpans(traceID, args.int("limit", 200, 2000))
+		if err != nil {
+			return nil, err
+		}
+		if len(spans) == 0 {
+			return nil, fmt.Errorf("trace %s not found", traceID)
+		}
+		for i := range spans {
+			spans[i].Attributes = truncateString(spans[i].Attributes, maxMCPAttributeBytes)
+			spans[i].Events = truncateString(spans[i].Events, maxMCPAttributeBytes)
+		}
+		return spans, nil
+	case "search_conversations":
+		query := args.str("query")
+		if query == "" {
+			return nil, fmt.Errorf("query is required")
+		}
+		return s.db.SearchConversationTranscripts(query, args.int("limit", 20, 100))
+	case "get_conversation":
+		id := args.str("conversation_id")
+		if id == "" {
+			return nil, fmt.Errorf("conversation_id is required")
+		}
+		convs, err := s.db.GetConversationsFiltered(1, time.Time{}, ConversationFilter{ID: id})
+		if err != nil {
+			return nil, err
+		}
+		if len(convs) == 0 {
+			return nil, fmt.Errorf("conversation %s not found", id)
+		}
+		turns, err := s.db.GetConversationTranscript(id, args.int("limit", 100, 1000))
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"conversation": convs[0], "turns": turns}, nil
+	case "get_stats":
+		q := url.Values{}
+		for _, k := range []string{"window", "project", "model"} {
+			if v := args.str(k); v != "" {
+				q.Set(k, v)
+			}
+		}
+		filter, err := parseStatsFilter(q)
+		if err != nil {
+			return nil, err
+		}
+		switch args.str("kind") {
+		case "guardrails":
+			return s.db.GetGuardrailStats(filter)
+		case "finish_reasons":
+			return s.db.GetFinishReasonStats(filter)
+		case "prompt_breakdown":
+			return s.db.GetPromptBreakdownStats(filter)
+		case "retrieval":
+			return s.db.GetRetrievalStats(filter)
+		}
+		return nil, fmt.Errorf("kind must be one of guardrails, finish_reasons, prompt_breakdown, retrieval")
+	}
+	return nil, errUnknownTool
+}
+
+func truncateString(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "...(truncated)"
+}
+
+// ServeHTTP implements the streamable HTTP transport without server-initiated streams:
+// every POSTed request gets a JSON response, notifications get 202 Accepted.
+func (s *MCPServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		// we never push messages, so there is no SSE stream to open
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+	resp := s.handle(body)
+	if resp == nil {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// ServeStdio reads newline-delimited JSON-RPC messages from in and writes responses to out
+// until in is closed
+func (s *MCPServer) ServeStdio(in io.Reader, out io.Writer) error {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64<<10), 4<<20)
+	enc := json.NewEncoder(out)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(strings.TrimSpace(string(line))) == 0 {
+			continue
+		}
+		if resp := s.handle(line); resp != nil {
+			if err := enc.Encode(resp); err != nil {
+				return err
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// RunMCP serves MCP over stdin/stdout against the configured database, for assistants that
+// start simple-traces as a subprocess. Logs go to stderr.
+func RunMCP(logLevelFlag string) error {
+	config := loadConfig(logLevelFlag)
+	logger := InitStderrLogger(config.LogLevel)
+	// GORM's SQL log (DEBUG) writes to stdout by default
+	gormlogger.Default = gormlogger.New(log.New(os.Stderr, "\r\n", log.LstdFlags), gormlogger.Config{
+		SlowThreshold: 200 * time.Millisecond,
+		LogLevel:      gormlogger.Warn,
+	})
+	db, err := InitDatabase(&config)
+	if err != nil {
+		logger.Error("Failed to initialize database: %v", err)
+		return fmt.Errorf("init db: %w", err)
+	}
+	db, err = WithReadSources(db, config.ReadSources, config.LogLevel, logger)
+	if err != nil {
+		logger.Error("Failed to open read sources: %v", err)
+		return fmt.Errorf("init read sources: %w", err)
+	}
+	defer db.Close()
+	logger.Info("Serving MCP on stdio (database type: %s)", config.DBType)
+	return NewMCPServer(db, logger).ServeStdio(os.Stdin, os.Stdout)
+}
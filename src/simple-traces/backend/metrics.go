@@ -0,0 +1,110 @@
+package backend
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Metrics is a minimal registry of counters and gauges exposed in the Prometheus text format
+type Metrics struct {
+	mu     sync.Mutex
+	help   map[string]string
+	types  map[string]string
+	values map[string]map[string]float64 // metric name -> rendered labels -> value
+}
+
+// metrics is the process-wide registry served at /metrics
+var metrics = NewMetrics()
+
+// NewMetrics creates an empty registry
+func NewMetrics() *Metrics {
+	return &Metrics{
+		help:   make(map[string]string),
+		types:  make(map[string]string),
+		values: make(map[string]map[string]float64),
+	}
+}
+
+// Describe registers the help text and type ("counter" or "gauge") of a metric
+func (m *Metrics) Describe(name, typ, help string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.help[name] = help
+	m.types[name] = typ
+	if m.values[name] == nil {
+		m.values[name] = make(map[string]float64)
+	}
+}
+
+// Add increments a counter; labels are key/value pairs
+func (m *Metrics) Add(name string, delta float64, labels ...string) {
+	key := renderLabels(labels)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.values[name] == nil {
+		m.values[name] = make(map[string]float64)
+	}
+	m.values[name][key] += delta
+}
+
+// Inc increments a counter by one
+func (m *Metrics) Inc(name string, labels ...string) {
+	m.Add(name, 1, labels...)
+}
+
+// Set sets a gauge
+func (m *Metrics) Set(name string, value float64, labels ...string) {
+	key := renderLabels(labels)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.values[name] == nil {
+		m.values[name] = make(map[string]float64)
+	}
+	m.values[name][key] = value
+}
+
+func renderLabels(labels []string) string {
+	if len(labels) < 2 {
+		return ""
+	}
+	parts := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		v := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[i+1])
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, labels[i], v))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// ServeHTTP writes all metrics in the Prometheus text exposition format
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	names := make([]string, 0, len(m.values))
+	for name := range m.values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var sb strings.Builder
+	for _, name := range names {
+		if help := m.help[name]; help != "" {
+			fmt.Fprintf(&sb, "# HELP %s %s\n", name, help)
+		}
+		if typ := m.types[name]; typ != "" {
+			fmt.Fprintf(&sb, "# TYPE %s %s\n", name, typ)
+		}
+		series := m.values[name]
+		keys := make([]string, 0, len(series))
+		for k := range series {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(&sb, "%s%s %g\n", name, k, series[k])
+		}
+	}
+	m.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write([]byte(sb.String()))
+}
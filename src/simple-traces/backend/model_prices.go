@@ -0,0 +1,82 @@
+package backend
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ModelPrice is the list price of a model in USD per million tokens
+type ModelPrice struct {
+	Input  float64
+	Output float64
+}
+
+// defaultModelPrices maps model name prefixes to list prices. The longest matching prefix
+// wins; MODEL_PRICES adds or overrides entries (e.g. negotiated rates or newer models).
+var defaultModelPrices = map[string]ModelPrice{
+	"gpt-4o":            {2.5, 10},
+	"gpt-4o-mini":       {0.15, 0.6},
+	"gpt-4.1":           {2, 8},
+	"gpt-4.1-mini":      {0.4, 1.6},
+	"gpt-4.1-nano":      {0.1, 0.4},
+	"gpt-4-turbo":       {10, 30},
+	"gpt-3.5-turbo":     {0.5, 1.5},
+	"o1":                {15, 60},
+	"o3":                {2, 8},
+	"o3-mini":           {1.1, 4.4},
+	"o4-mini":           {1.1, 4.4},
+	"claude-3-5-haiku":  {0.8, 4},
+	"claude-3-5-sonnet": {3, 15},
+	"claude-3-7-sonnet": {3, 15},
+	"claude-sonnet-4":   {3, 15},
+	"claude-opus-4":     {15, 75},
+	"gemini-1.5-flash":  {0.075, 0.3},
+	"gemini-1.5-pro":    {1.25, 5},
+	"gemini-2.0-flash":  {0.1, 0.4},
+	"gemini-2.5-flash":  {0.3, 2.5},
+	"gemini-2.5-pro":    {1.25, 10},
+}
+
+// ModelPrices resolves the price of a model
+type ModelPrices map[string]ModelPrice
+
+// parseModelPrices reads "model=input:output" pairs (USD per million tokens) on top of the defaults
+func parseModelPrices(spec string) (ModelPrices, error) {
+	prices := make(ModelPrices, len(defaultModelPrices))
+	for k, v := range defaultModelPrices {
+		prices[k] = v
+	}
+	for _, part := range splitList(spec) {
+		model, rates, ok := strings.Cut(part, "=")
+		in, out, ok2 := strings.Cut(rates, ":")
+		input, err := strconv.ParseFloat(strings.TrimSpace(in), 64)
+		output, err2 := strconv.ParseFloat(strings.TrimSpace(out), 64)
+		if !ok || !ok2 || err != nil || err2 != nil || input < 0 || output < 0 {
+			return nil, fmt.Errorf("model price %q: want model=input:output", part)
+		}
+		prices[strings.ToLower(strings.TrimSpace(model))] = ModelPrice{Input: input, Output: output}
+	}
+	return prices, nil
+}
+
+// For returns the price of a model and whether it is known
+func (p ModelPrices) For(model string) (ModelPrice, bool) {
+	model = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(model)), "models/")
+	best, price := -1, ModelPrice{}
+	for prefix, mp := range p {
+		if strings.HasPrefix(model, prefix) && len(prefix) > best {
+			best, price = len(prefix), mp
+		}
+	}
+	return price, best >= 0
+}
+
+// Cost is the USD cost of a call, or 0 for unknown models
+func (p ModelPrices) Cost(model string, inputTokens, outputTokens int64) float64 {
+	price, ok := p.For(model)
+	if !ok {
+		return 0
+	}
+	return (float64(inputTokens)*price.Input + float64(outputTokens)*price.Output) / 1e6
+}
@@ -0,0 +1,177 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"google.golang.org/protobuf/proto"
+
+	tracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+)
+
+// natsRetryDelay is how long JetStream waits before redelivering an export that could not be stored
+const natsRetryDelay = 5 * time.Second
+
+// natsSource subscribes to a NATS subject carrying protobuf OTLP trace exports, optionally
+// compressed as named by a Content-Encoding message header.
+//
+// With NATS_STREAM set, it reads the subject through a durable JetStream consumer and acks a
+// message only once its spans were stored, so exports survive restarts and database outages
+// (at-least-once; the dedup window absorbs redeliveries). Without it, it is a plain (queue)
+// subscription: messages published while the server is down are lost.
+type natsSource struct {
+	h      *OTLPHandler
+	logger *Logger
+	nc     *nats.Conn
+	sub    *nats.Subscription
+	cons   jetstream.ConsumeContext
+}
+
+// newNATSSource connects to NATS_URL; nil when it is not set
+func newNATSSource(config *Config, h *OTLPHandler, logger *Logger) (IngestSource, error) {
+	if config.NATSURL == "" {
+		return nil, nil
+	}
+	metrics.Describe("simpletraces_nats_messages_total", "counter", "OTLP exports received from NATS by outcome (stored, invalid or failed)")
+	s := &natsSource{h: h, logger: logger}
+	nc, err := nats.Connect(config.NATSURL,
+		nats.Name("simple-traces"),
+		nats.MaxReconnects(-1),
+		nats.RetryOnFailedConnect(true),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				logger.Warn("Disconnected from NATS: %v", err)
+			}
+		}),
+		nats.ReconnectHandler(func(nc *nats.Conn) {
+			logger.Info("Reconnected to NATS at %s", nc.ConnectedUrlRedacted())
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("connect to NATS: %w", err)
+	}
+	s.nc = nc
+
+	if config.NATSStream == "" {
+		s.sub, err = nc.QueueSubscribe(config.NATSSubject, config.NATSQueue, s.handleCore)
+		if err != nil {
+			nc.Close()
+			return nil, fmt.Errorf("subscribe to %s: %w", config.NATSSubject, err)
+		}
+		return s, nil
+	}
+
+	js, err := jetstream.New(nc)
+	if err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("open JetStream: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	consumer, err := js.CreateOrUpdateConsumer(ctx, config.NATSStream, jetstream.ConsumerConfig{
+		Durable:       config.NATSConsumer,
+		FilterSubject: config.NATSSubject,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+	})
+	if err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("create consumer %s on stream %s: %w", config.NATSConsumer, config.NATSStream, err)
+	}
+	s.cons, err = consumer.Consume(s.handleJetStream)
+	if err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("consume stream %s: %w", config.NATSStream, err)
+	}
+	return s, nil
+}
+
+// Name describes the subscription
+func (s *natsSource) Name() string {
+	if s.cons != nil {
+		return "nats (JetStream)"
+	}
+	return "nats"
+}
+
+// Close stops receiving and waits for the message being stored, then disconnects
+func (s *natsSource) Close() error {
+	if s.cons != nil {
+		s.cons.Drain()
+		<-s.cons.Closed()
+	}
+	if s.sub != nil {
+		s.sub.Drain()
+	}
+	return s.nc.Drain()
+}
+
+// export decodes and stores one message. invalid is set when the message can never be stored,
+// so redelivering it is pointless.
+func (s *natsSource) export(subject string, header nats.Header, data []byte) (invalid bool, err error) {
+	body, err := decodeOTLPPayload(header.Get("Content-Encoding"), bytes.NewReader(data), s.h.maxPayload)
+	if err != nil {
+		return true, err
+	}
+	var req tracepb.ExportTraceServiceRequest
+	if err := proto.Unmarshal(body, &req); err != nil {
+		return true, fmt.Errorf("parse OTLP export: %w", err)
+	}
+	s.logger.Debug("Received OTLP payload from NATS subject %s: %s", subject, formatBytes(len(body)))
+	s.h.preview.Log(s.logger, &req)
+	if _, err := s.h.Export(&req); err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+// handleCore stores a message of a plain subscription; requests get an empty export response
+func (s *natsSource) handleCore(msg *nats.Msg) {
+	invalid, err := s.export(msg.Subject, msg.Header, msg.Data)
+	s.record(msg.Subject, invalid, err)
+	if msg.Reply == "" {
+		return
+	}
+	if err != nil {
+		resp := nats.NewMsg(msg.Reply)
+		resp.Header.Set("Nats-Service-Error", err.Error())
+		msg.RespondMsg(resp)
+		return
+	}
+	respBytes, _ := proto.Marshal(&tracepb.ExportTraceServiceResponse{})
+	msg.Respond(respBytes)
+}
+
+// handleJetStream acks a message once stored, terminates invalid ones and has failed ones redelivered
+func (s *natsSource) handleJetStream(msg jetstream.Msg) {
+	invalid, err := s.export(msg.Subject(), msg.Headers(), msg.Data())
+	s.record(msg.Subject(), invalid, err)
+	var ackErr error
+	switch {
+	case invalid:
+		ackErr = msg.TermWithReason(err.Error())
+	case err != nil:
+		ackErr = msg.NakWithDelay(natsRetryDelay)
+	default:
+		ackErr = msg.Ack()
+	}
+	if ackErr != nil {
+		s.logger.Warn("Failed to acknowledge NATS message on %s: %v", msg.Subject(), ackErr)
+	}
+}
+
+func (s *natsSource) record(subject string, invalid bool, err error) {
+	switch {
+	case invalid:
+		metrics.Inc("simpletraces_nats_messages_total", "outcome", "invalid")
+		s.logger.Warn("Dropped invalid OTLP export from NATS subject %s: %v", subject, err)
+	case err != nil:
+		metrics.Inc("simpletraces_nats_messages_total", "outcome", "failed")
+		s.logger.Error("Failed to store OTLP export from NATS subject %s: %v", subject, err)
+	default:
+		metrics.Inc("simpletraces_nats_messages_total", "outcome", "stored")
+	}
+}
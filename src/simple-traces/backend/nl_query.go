@@ -0,0 +1,180 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// nlParam kinds used to validate what the model suggested
+const (
+	nlString = "string"
+	nlTime   = "time"
+	nlInt    = "int"
+	nlBool   = "bool"
+	nlWindow = "window"
+)
+
+// nlTarget is an API listing the natural-language endpoint can translate a question into
+type nlTarget struct {
+	Path        string
+	Description string
+	Params      map[string]string
+}
+
+var statsParams = map[string]string{"project": nlString, "model": nlString, "since": nlTime, "until": nlTime, "window": nlWindow}
+
+// nlTargets is the filter schema sent to the model. Keys are the target names it answers with.
+var nlTargets = map[string]nlTarget{
+	"spans": {"/api/spans", "individual spans (LLM calls, tool calls, db queries), newest first", map[string]string{
+		"project": nlString, "model": nlString, "category": nlString, "status": nlString,
+		"finish_reason": nlString, "schema": nlString, "violation": nlBool, "violation_type": nlString,
+		"cold_start": nlBool, "since": nlTime, "until": nlTime, "min_duration_ms": nlInt, "annotation": nlString,
+	}},
+	"trace_groups": {"/api/trace-groups", "traces (one agent run / request each), most recent first", map[string]string{
+		"q": nlString, "status": nlString, "assignee": nlString, "errors": nlBool,
+	}},
+	"conversations": {"/api/conversations", "conversations (sessions of several traces), most recent first", map[string]string{
+		"q": nlString,
+	}},
+	"stats/guardrails":         {"/api/stats/guardrails", "refusal, content filter and guardrail violation rates per model", statsParams},
+	"stats/finish-reasons":     {"/api/stats/finish-reasons", "how LLM calls finished (stop, length, tool_calls, content_filter) per model", statsParams},
+	"stats/prompt-breakdown":   {"/api/stats/prompt-breakdown", "prompt tokens by role (system, user, history, tool)", statsParams},
+	"stats/retrieval":          {"/api/stats/retrieval", "vector store query latency and result counts", statsParams},
+	"stats/http":               {"/api/stats/http", "HTTP endpoint throughput, error rate and latency percentiles per route", statsParams},
+	"stats/db":                 {"/api/stats/db", "slowest and most frequent database queries by normalized statement", statsParams},
+	"stats/structured-outputs": {"/api/stats/structured-outputs", "how often LLM structured (JSON) outputs match their schema, per model", statsParams},
+	"stats/metrics":            {"/api/stats/metrics", "user-defined derived metrics (e.g. cost) per metric and model", statsParams},
+	"stats/token-budget":       {"/api/stats/token-budget", "conversations closest to the model context limit", statsParams},
+	"stats/cold-starts":        {"/api/stats/cold-starts", "LLM calls slowed by a cold model endpoint after idle time, cold vs warm latency per model", statsParams},
+}
+
+const nlQuerySystemPrompt = `You translate questions about an LLM tracing tool into an API query.
+Reply with a JSON object: {"target": "<target>", "filters": {"<param>": "<value>"}, "explanation": "<one short sentence>"}.
+Only use the targets and parameters listed below; leave out filters the question doesn't ask for.
+Times are RFC3339 in UTC; "window" is a duration like 24h or 7d. Span categories are llm, tool, db, retrieval, http and agent.
+Span status is OK, ERROR or UNSET. Consider calls slow when they take 5000 ms or more unless the question says otherwise.
+If the question can't be answered with these targets, reply with {"target": "", "explanation": "<why>"}.`
+
+// NLQuery is a structured query derived from a natural-language question
+type NLQuery struct {
+	Question    string            `json:"question"`
+	Target      string            `json:"target"`
+	Filters     map[string]string `json:"filters"`
+	Explanation string            `json:"explanation,omitempty"`
+	// URL runs the query against the REST API
+	URL string `json:"url,omitempty"`
+	// Filters the model suggested that are unknown or invalid for the target
+	Dropped []string `json:"dropped,omitempty"`
+}
+
+// nlSchemaText describes the targets and their parameters for the prompt
+func nlSchemaText() string {
+	names := make([]string, 0, len(nlTargets))
+	for name := range nlTargets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		t := nlTargets[name]
+		params := make([]string, 0, len(t.Params))
+		for p, kind := range t.Params {
+			params = append(params, p+" ("+kind+")")
+		}
+		sort.Strings(params)
+		fmt.Fprintf(&b, "- %s: %s. Parameters: %s\n", name, t.Description, strings.Join(params, ", "))
+	}
+	return b.String()
+}
+
+// validateNLQuery keeps the filters that are valid for the target and builds the API URL
+func validateNLQuery(q *NLQuery) error {
+	target, ok := nlTargets[q.Target]
+	if !ok {
+		return fmt.Errorf("no matching query: %s", q.Explanation)
+	}
+	values := url.Values{}
+	valid := make(map[string]string)
+	for name, raw := range q.Filters {
+		v := strings.TrimSpace(raw)
+		kind, known := target.Params[name]
+		if !known || v == "" || !validNLValue(kind, v) {
+			q.Dropped = append(q.Dropped, name)
+			continue
+		}
+		valid[name] = v
+		values.Set(name, v)
+	}
+	sort.Strings(q.Dropped)
+	q.Filters = valid
+	q.URL = target.Path
+	if len(values) > 0 {
+		q.URL += "?" + values.Encode()
+	}
+	return nil
+}
+
+func validNLValue(kind, v string) bool {
+	switch kind {
+	case nlTime:
+		_, err := parseTimeParam(v)
+		return err == nil
+	case nlInt:
+		n, err := strconv.ParseInt(v, 10, 64)
+		return err == nil && n >= 0
+	case nlBool:
+		return v == "true" || v == "false"
+	case nlWindow:
+		_, err := parseWindow(v)
+		return err == nil
+	}
+	return true
+}
+
+// naturalQueryHandler answers POST {"question": "..."} with the structured query for it
+func naturalQueryHandler(llm *LLMClient, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if llm == nil {
+			http.Error(w, "Natural-language queries need an LLM (set LLM_API_KEY or LLM_URL)", http.StatusServiceUnavailable)
+			return
+		}
+		var req struct {
+			Question string `json:"question"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Question) == "" {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		user := fmt.Sprintf("Current time: %s\n\nTargets:\n%s\nQuestion: %s",
+			time.Now().UTC().Format(time.RFC3339), nlSchemaText(), strings.TrimSpace(req.Question))
+		var answer struct {
+			Target      string         `json:"target"`
+			Filters     map[string]any `json:"filters"`
+			Explanation string         `json:"explanation"`
+		}
+		if err := llm.CompleteJSON(nlQuerySystemPrompt, user, &answer); err != nil {
+			logger.Error("Natural-language query failed: %v", err)
+			http.Error(w, fmt.Sprintf("Failed to translate question: %v", err), http.StatusBadGateway)
+			return
+		}
+		// models don't always quote numbers and booleans
+		q := NLQuery{Question: strings.TrimSpace(req.Question), Target: answer.Target, Explanation: answer.Explanation, Filters: map[string]string{}}
+		for k, v := range answer.Filters {
+			if v != nil {
+				q.Filters[k] = fmt.Sprint(v)
+			}
+		}
+		if err := validateNLQuery(&q); err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(q)
+	}
+}
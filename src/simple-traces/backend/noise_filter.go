@@ -0,0 +1,145 @@
+package backend
+
+import (
+	"path"
+	"strings"
+
+	tracepbv1 "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+// Built-in noise filters, enabled via NOISE_FILTERS (comma separated, "none" disables all)
+const (
+	NoiseHealthCheck   = "healthcheck"
+	NoiseStaticAsset   = "static"
+	NoiseZeroDuration  = "zero_duration"
+	defaultNoiseFilter = NoiseHealthCheck + "," + NoiseStaticAsset
+)
+
+// NoiseFilter discards spans that carry no useful information before they are transformed
+type NoiseFilter struct {
+	enabled map[string]bool
+}
+
+var healthCheckPaths = map[string]bool{
+	"/health": true, "/healthz": true, "/healthcheck": true, "/health-check": true,
+	"/ready": true, "/readyz": true, "/readiness": true,
+	"/live": true, "/livez": true, "/liveness": true,
+	"/ping": true,
+}
+
+var healthCheckAgents = []string{"kube-probe", "elb-healthchecker", "googlehc", "consul health check"}
+
+var staticExtensions = map[string]bool{
+	".js": true, ".mjs": true, ".css": true, ".map": true,
+	".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".svg": true, ".ico": true, ".webp": true,
+	".woff": true, ".woff2": true, ".ttf": true, ".eot": true,
+}
+
+// NewNoiseFilter builds a filter from a comma separated list of filter names
+func NewNoiseFilter(spec string) *NoiseFilter {
+	f := &NoiseFilter{enabled: make(map[string]bool)}
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch name {
+		case "", "none":
+		case "all":
+			f.enabled[NoiseHealthCheck] = true
+			f.enabled[NoiseStaticAsset] = true
+			f.enabled[NoiseZeroDuration] = true
+		default:
+			f.enabled[name] = true
+		}
+	}
+	if len(f.enabled) == 0 {
+		return nil
+	}
+	return f
+}
+
+// Enabled lists the active filters
+func (f *NoiseFilter) Enabled() []string {
+	if f == nil {
+		return nil
+	}
+	var out []string
+	for _, name := range []string{NoiseHealthCheck, NoiseStaticAsset, NoiseZeroDuration} {
+		if f.enabled[name] {
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+// Match returns the name of the filter that discards span, or "" to keep it
+func (f *NoiseFilter) Match(span *tracepbv1.Span) string {
+	if f == nil {
+		return ""
+	}
+	if f.enabled[NoiseZeroDuration] && span.Kind == tracepbv1.Span_SPAN_KIND_INTERNAL &&
+		span.EndTimeUnixNano <= span.StartTimeUnixNano {
+		return NoiseZeroDuration
+	}
+	if !f.enabled[NoiseHealthCheck] && !f.enabled[NoiseStaticAsset] {
+		return ""
+	}
+
+	var urlPath, userAgent string
+	for _, attr := range span.Attributes {
+		if attr == nil || attr.Value == nil {
+			continue
+		}
+		s := attr.Value.GetStringValue()
+		switch attr.Key {
+		case "http.route", "http.target", "url.path":
+			if urlPath == "" {
+				urlPath = s
+			}
+		case "http.url", "url.full":
+			if urlPath == "" {
+				urlPath = urlPathOf(s)
+			}
+		case "http.user_agent", "user_agent.original":
+			userAgent = strings.ToLower(s)
+		}
+	}
+	if urlPath == "" {
+		// server spans are often named "GET /healthz"
+		if i := strings.Index(span.Name, " /"); i >= 0 {
+			urlPath = span.Name[i+1:]
+		} else if strings.HasPrefix(span.Name, "/") {
+			urlPath = span.Name
+		}
+	}
+	if i := strings.IndexAny(urlPath, "?#"); i >= 0 {
+		urlPath = urlPath[:i]
+	}
+
+	if f.enabled[NoiseHealthCheck] {
+		if healthCheckPaths[strings.ToLower(strings.TrimSuffix(urlPath, "/"))] {
+			return NoiseHealthCheck
+		}
+		for _, agent := range healthCheckAgents {
+			if userAgent != "" && strings.Contains(userAgent, agent) {
+				return NoiseHealthCheck
+			}
+		}
+	}
+	if f.enabled[NoiseStaticAsset] && urlPath != "" {
+		if staticExtensions[strings.ToLower(path.Ext(urlPath))] {
+			return NoiseStaticAsset
+		}
+	}
+	return ""
+}
+
+// urlPathOf returns the path part of an absolute URL
+func urlPathOf(u string) string {
+	if i := strings.Index(u, "://"); i >= 0 {
+		u = u[i+3:]
+		if j := strings.Index(u, "/"); j >= 0 {
+			return u[j:]
+		}
+		return "/"
+	}
+	return u
+}
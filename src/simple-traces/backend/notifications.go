@@ -0,0 +1,239 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// Alert severities in increasing order
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+var severityRank = map[string]int{SeverityInfo: 0, SeverityWarning: 1, SeverityCritical: 2}
+
+// Notification channels
+const (
+	ChannelEmail = "email"
+	ChannelSlack = "slack"
+)
+
+// NotificationPreference holds what alerts a user wants and where. There are no user
+// accounts; users are identified by a free-form id (usually their email address).
+type NotificationPreference struct {
+	UserID string `gorm:"primaryKey" json:"user_id"`
+	// Comma separated project ids; empty means all projects
+	Projects string `json:"projects"`
+	// Alerts below this severity are not sent
+	MinSeverity string `json:"min_severity"`
+	// Comma separated channels: email, slack
+	Channels     string    `json:"channels"`
+	Email        string    `json:"email,omitempty"`
+	SlackWebhook string    `json:"slack_webhook,omitempty"`
+	Muted        bool      `json:"muted"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// Wants reports whether an alert of the given project and severity should reach this user
+func (p NotificationPreference) Wants(projectID, severity string) bool {
+	if p.Muted {
+		return false
+	}
+	if rank, ok := severityRank[severity]; !ok || rank < severityRank[p.MinSeverity] {
+		return false
+	}
+	if strings.TrimSpace(p.Projects) == "" {
+		return true
+	}
+	for _, id := range splitList(p.Projects) {
+		if id == projectID {
+			return true
+		}
+	}
+	return false
+}
+
+// HasChannel reports whether the user enabled a channel
+func (p NotificationPreference) HasChannel(channel string) bool {
+	for _, c := range splitList(p.Channels) {
+		if c == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// splitList splits a comma separated list, dropping blanks
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+// validate normalizes the preference and checks it is deliverable
+func (p *NotificationPreference) validate() error {
+	p.MinSeverity = strings.ToLower(strings.TrimSpace(p.MinSeverity))
+	if p.MinSeverity == "" {
+		p.MinSeverity = SeverityWarning
+	}
+	if _, ok := severityRank[p.MinSeverity]; !ok {
+		return fmt.Errorf("min_severity must be one of info, warning, critical")
+	}
+	p.Projects = strings.Join(splitList(p.Projects), ",")
+	channels := splitList(strings.ToLower(p.Channels))
+	if len(channels) == 0 {
+		channels = []string{ChannelEmail}
+	}
+	for _, c := range channels {
+		switch c {
+		case ChannelEmail:
+			if p.Email == "" && strings.Contains(p.UserID, "@") {
+				p.Email = p.UserID
+			}
+			if _, err := mail.ParseAddress(p.Email); err != nil {
+				return fmt.Errorf("email channel requires a valid email")
+			}
+		case ChannelSlack:
+			if !strings.HasPrefix(p.SlackWebhook, "https://") {
+				return fmt.Errorf("slack channel requires an https slack_webhook")
+			}
+		default:
+			return fmt.Errorf("unknown channel %q (want email or slack)", c)
+		}
+	}
+	p.Channels = strings.Join(channels, ",")
+	return nil
+}
+
+func (g *GormDB) GetNotificationPreferences() ([]NotificationPreference, error) {
+	var prefs []NotificationPreference
+	if err := g.db.Order("user_id ASC").Find(&prefs).Error; err != nil {
+		return nil, err
+	}
+	return prefs, nil
+}
+
+func (g *GormDB) GetNotificationPreference(userID string) (*NotificationPreference, error) {
+	var p NotificationPreference
+	if err := g.db.Where("user_id = ?", userID).First(&p).Error; err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (g *GormDB) SaveNotificationPreference(p *NotificationPreference) error {
+	p.UpdatedAt = time.Now()
+	return g.db.Save(p).Error
+}
+
+func (g *GormDB) DeleteNotificationPreference(userID string) (int64, error) {
+	result := g.db.Where("user_id = ?", userID).Delete(&NotificationPreference{})
+	return result.RowsAffected, result.Error
+}
+
+// NotificationRecipients returns the preferences of users who want an alert of the given
+// project and severity; the alerting engine delivers to each of their channels
+func NotificationRecipients(db Database, projectID, severity string) ([]NotificationPreference, error) {
+	prefs, err := db.GetNotificationPreferences()
+	if err != nil {
+		return nil, err
+	}
+	var out []NotificationPreference
+	for _, p := range prefs {
+		if p.Wants(projectID, severity) {
+			out = append(out, p)
+		}
+	}
+	return out, nil
+}
+
+// getNotificationPreferencesHandler lists all users' notification settings
+func getNotificationPreferencesHandler(db Database, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		prefs, err := db.GetNotificationPreferences()
+		if err != nil {
+			logger.Error("Failed to get notification preferences: %v", err)
+			http.Error(w, fmt.Sprintf("Failed to get notification preferences: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(prefs)
+	}
+}
+
+// getNotificationPreferenceHandler returns one user's settings
+func getNotificationPreferenceHandler(db Database, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := strings.TrimSpace(mux.Vars(r)["user_id"])
+		p, err := db.GetNotificationPreference(userID)
+		if err == gorm.ErrRecordNotFound {
+			http.Error(w, "No notification preferences for this user", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error("Failed to get notification preferences of %s: %v", userID, err)
+			http.Error(w, fmt.Sprintf("Failed to get notification preferences: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(p)
+	}
+}
+
+// putNotificationPreferenceHandler creates or replaces one user's settings
+func putNotificationPreferenceHandler(db Database, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var p NotificationPreference
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		p.UserID = strings.TrimSpace(mux.Vars(r)["user_id"])
+		if p.UserID == "" {
+			http.Error(w, "missing user id", http.StatusBadRequest)
+			return
+		}
+		if err := p.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := db.SaveNotificationPreference(&p); err != nil {
+			logger.Error("Failed to save notification preferences of %s: %v", p.UserID, err)
+			http.Error(w, fmt.Sprintf("Failed to save notification preferences: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(p)
+	}
+}
+
+// deleteNotificationPreferenceHandler removes one user's settings
+func deleteNotificationPreferenceHandler(db Database, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := strings.TrimSpace(mux.Vars(r)["user_id"])
+		deleted, err := db.DeleteNotificationPreference(userID)
+		if err != nil {
+			logger.Error("Failed to delete notification preferences of %s: %v", userID, err)
+			http.Error(w, fmt.Sprintf("Failed to delete notification preferences: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"ok":      true,
+			"deleted": deleted,
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package backend
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// openInferenceCategories maps openinference.span.kind to the span category; kinds not listed
+// (retrievers are recognized by retrievalStore) fall back to the usual detection
+var openInferenceCategories = map[string]string{
+	"LLM":   "llm",
+	"AGENT": "agent",
+	"CHAIN": "agent",
+	"TOOL":  "tool",
+}
+
+// augmentOpenInferenceAttrs normalizes OpenInference keys (as sent by Arize Phoenix and the
+// OpenInference instrumentations) into the gen_ai.* and simpleTraces.* keys the rest of the
+// ingest path reads, so model detection, token usage, prompt breakdown and search work alike.
+// Keys already present are left untouched. It returns the keys that were added.
+func augmentOpenInferenceAttrs(attrs map[string]any) []string {
+	var added []string
+	set := func(k string, v any) {
+		if _, exists := attrs[k]; !exists {
+			attrs[k] = v
+			added = append(added, k)
+		}
+	}
+	kind, _ := attrs["openinference.span.kind"].(string)
+	kind = strings.ToUpper(strings.TrimSpace(kind))
+
+	if m, ok := attrs["llm.model_name"].(string); ok && strings.TrimSpace(m) != "" {
+		set("gen_ai.request.model", m)
+	}
+	for _, k := range []string{"llm.provider", "llm.system"} {
+		if s, ok := attrs[k].(string); ok && strings.TrimSpace(s) != "" {
+			set("gen_ai.system", s)
+			break
+		}
+	}
+	if n, ok := asInt(attrs["llm.token_count.prompt"]); ok {
+		set("gen_ai.usage.input_tokens", n)
+	}
+	if n, ok := asInt(attrs["llm.token_count.completion"]); ok {
+		set("gen_ai.usage.output_tokens", n)
+	}
+
+	input := openInferenceMessages(attrs, "llm.input_messages.")
+	if len(input) > 0 {
+		set("simpleTraces.messages", input)
+		lastUser := ""
+		for _, m := range input {
+			if msg := m.(map[string]any); strings.EqualFold(msg["role"].(string), "user") {
+				lastUser, _ = msg["content"].(string)
+			}
+		}
+		if strings.TrimSpace(lastUser) != "" {
+			set("gen_ai.prompt", lastUser)
+		}
+	}
+	if output := openInferenceMessages(attrs, "llm.output_messages."); len(output) > 0 {
+		var parts []string
+		for _, m := range output {
+			if c, _ := m.(map[string]any)["content"].(string); strings.TrimSpace(c) != "" {
+				parts = append(parts, c)
+			}
+		}
+		if len(parts) > 0 {
+			set("gen_ai.response", strings.Join(parts, "\n\n"))
+		}
+	}
+	// Without structured messages the raw input/output of an LLM span is the best prompt/response
+	if kind == "LLM" {
+		if s, ok := attrs["input.value"].(string); ok && strings.TrimSpace(s) != "" {
+			set("gen_ai.prompt", s)
+		}
+		if s, ok := attrs["output.value"].(string); ok && strings.TrimSpace(s) != "" {
+			set("gen_ai.response", s)
+		}
+	}
+	return added
+}
+
+// openInferenceMessages rebuilds the indexed flat message keys under prefix
+// (<prefix><i>.message.role, .message.content or .message.contents.<j>.message_content.text)
+// into OpenAI-style {"role", "content"} messages, ordered by index
+func openInferenceMessages(attrs map[string]any, prefix string) []any {
+	type message struct {
+		role    string
+		content string
+		parts   map[int]string
+	}
+	byIndex := make(map[int]*message)
+	for k, v := range attrs {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		idx, rest, ok := strings.Cut(k[len(prefix):], ".")
+		if !ok {
+			continue
+		}
+		i, err := strconv.Atoi(idx)
+		if err != nil {
+			continue
+		}
+		s, ok := v.(string)
+		if !ok {
+			continue
+		}
+		m := byIndex[i]
+		if m == nil {
+			m = &message{parts: make(map[int]string)}
+			byIndex[i] = m
+		}
+		switch {
+		case rest == "message.role":
+			m.role = s
+		case rest == "message.content":
+			m.content = s
+		case strings.HasPrefix(rest, "message.contents.") && strings.HasSuffix(rest, ".message_content.text"):
+			j, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(rest, "message.contents."), ".message_content.text"))
+			if err == nil {
+				m.parts[j] = s
+			}
+		}
+	}
+	if len(byIndex) == 0 {
+		return nil
+	}
+	indexes := make([]int, 0, len(byIndex))
+	for i := range byIndex {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	out := make([]any, 0, len(indexes))
+	for _, i := range indexes {
+		m := byIndex[i]
+		content := m.content
+		if content == "" && len(m.parts) > 0 {
+			js := make([]int, 0, len(m.parts))
+			for j := range m.parts {
+				js = append(js, j)
+			}
+			sort.Ints(js)
+			texts := make([]string, len(js))
+			for n, j := range js {
+				texts[n] = m.parts[j]
+			}
+			content = strings.Join(texts, "\n\n")
+		}
+		out = append(out, map[string]any{"role": m.role, "content": content})
+	}
+	return out
+}
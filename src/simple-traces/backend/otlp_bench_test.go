@@ -0,0 +1,73 @@
+package backend
+
+import (
+	"testing"
+
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepbv1 "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+// benchOTLPSpan is a typical LLM call: gen_ai attributes, a nested key-value list the way
+// some SDKs send request metadata, and the resource attributes of a service
+func benchOTLPSpan() (*tracepbv1.Span, *resourcepb.Resource) {
+	str := func(k, v string) *commonpb.KeyValue {
+		return &commonpb.KeyValue{Key: k, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: v}}}
+	}
+	num := func(k string, v int64) *commonpb.KeyValue {
+		return &commonpb.KeyValue{Key: k, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: v}}}
+	}
+	kvlist := func(k string, kvs ...*commonpb.KeyValue) *commonpb.KeyValue {
+		return &commonpb.KeyValue{Key: k, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_KvlistValue{KvlistValue: &commonpb.KeyValueList{Values: kvs}}}}
+	}
+	span := &tracepbv1.Span{
+		TraceId:           []byte{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+		SpanId:            []byte{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+		ParentSpanId:      []byte{0x11, 0x11, 0x11, 0x11, 0x11, 0x11, 0x11, 0x11},
+		Name:              "chat gpt-4o",
+		Kind:              tracepbv1.Span_SPAN_KIND_CLIENT,
+		StartTimeUnixNano: 1_760_000_000_000_000_000,
+		EndTimeUnixNano:   1_760_000_001_250_000_000,
+		Attributes: []*commonpb.KeyValue{
+			str("gen_ai.operation.name", "chat"),
+			str("gen_ai.system", "openai"),
+			str("gen_ai.request.model", "gpt-4o"),
+			str("gen_ai.response.model", "gpt-4o-2024-08-06"),
+			num("gen_ai.usage.input_tokens", 1432),
+			num("gen_ai.usage.output_tokens", 211),
+			str("gen_ai.prompt", `[{"role":"system","content":"You are a helpful support agent."},{"role":"user","content":"Where is my order 1234?"}]`),
+			str("gen_ai.completion", `[{"role":"assistant","content":"Your order shipped yesterday and arrives on Friday."}]`),
+			str("session.id", "conv-42"),
+			str("user.id", "alice"),
+			kvlist("request", str("temperature", "0.2"), num("max_tokens", 512), kvlist("metadata", str("feature", "support"), str("tenant", "acme"))),
+		},
+		Status: &tracepbv1.Status{Code: tracepbv1.Status_STATUS_CODE_OK},
+	}
+	resource := &resourcepb.Resource{Attributes: []*commonpb.KeyValue{
+		str("service.name", "support-agent"),
+		str("service.version", "1.4.2"),
+		str("deployment.environment", "production"),
+		str("telemetry.sdk.language", "python"),
+	}}
+	return span, resource
+}
+
+func BenchmarkTransformSpan(b *testing.B) {
+	h := NewOTLPHandler(nil, InitStderrLogger("ERROR"))
+	span, resource := benchOTLPSpan()
+	b.ReportAllocs()
+	for b.Loop() {
+		h.transformSpan(span, resource)
+	}
+}
+
+func BenchmarkPutAnyValue(b *testing.B) {
+	span, _ := benchOTLPSpan()
+	b.ReportAllocs()
+	for b.Loop() {
+		attrs := make(map[string]any, len(span.Attributes)+8)
+		for _, kv := range span.Attributes {
+			putAnyValue(attrs, kv.Key, kv.Value, nil)
+		}
+	}
+}
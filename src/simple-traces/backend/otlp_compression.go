@@ -0,0 +1,148 @@
+package backend
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/proto"
+)
+
+// errUnsupportedEncoding is returned for a Content-Encoding the OTLP endpoint can't decode
+type errUnsupportedEncoding string
+
+func (e errUnsupportedEncoding) Error() string {
+	return fmt.Sprintf("unsupported Content-Encoding %q (supported: gzip, zstd, identity)", string(e))
+}
+
+// errPayloadTooLarge is returned for payloads over the handler's limit, compressed or not
+type errPayloadTooLarge int64
+
+func (e errPayloadTooLarge) Error() string {
+	return fmt.Sprintf("payload exceeds %d bytes (MAX_PAYLOAD_BYTES)", int64(e))
+}
+
+// defaultMaxPayloadBytes is the MAX_PAYLOAD_BYTES default. The limit bounds ingest request
+// bodies both as sent and once decompressed, so neither a huge export nor a compression bomb
+// can exhaust memory; 0 disables it.
+const defaultMaxPayloadBytes = 16 << 20
+
+// readOTLPBody reads a request body of at most limit bytes (0 for no limit), decompressing it
+// according to its Content-Encoding
+func readOTLPBody(w http.ResponseWriter, r *http.Request, limit int64) ([]byte, error) {
+	if limit > 0 {
+		if r.ContentLength > limit {
+			return nil, errPayloadTooLarge(limit)
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+	}
+	body, err := decodeOTLPPayload(r.Header.Get("Content-Encoding"), r.Body, limit)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return nil, errPayloadTooLarge(limit)
+	}
+	return body, err
+}
+
+// readPayload reads in to the end, failing once it passes limit bytes
+func readPayload(in io.Reader, limit int64) ([]byte, error) {
+	if limit <= 0 {
+		return io.ReadAll(in)
+	}
+	body, err := io.ReadAll(io.LimitReader(in, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > limit {
+		return nil, errPayloadTooLarge(limit)
+	}
+	return body, nil
+}
+
+// decodeOTLPPayload reads an OTLP payload compressed with the given content encoding, failing
+// when it decompresses to more than limit bytes. Sources other than HTTP (message queues)
+// carry the encoding in their own headers.
+func decodeOTLPPayload(encoding string, in io.Reader, limit int64) ([]byte, error) {
+	switch enc := strings.ToLower(strings.TrimSpace(encoding)); enc {
+	case "", "identity":
+		return readPayload(in, limit)
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(in)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip body: %w", err)
+		}
+		defer zr.Close()
+		body, err := readPayload(zr, limit)
+		if _, tooLarge := err.(errPayloadTooLarge); err != nil && !tooLarge {
+			return nil, fmt.Errorf("invalid gzip body: %w", err)
+		}
+		return body, err
+	case "zstd":
+		zr, err := zstd.NewReader(in, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, fmt.Errorf("invalid zstd body: %w", err)
+		}
+		defer zr.Close()
+		body, err := readPayload(zr, limit)
+		if _, tooLarge := err.(errPayloadTooLarge); err != nil && !tooLarge {
+			return nil, fmt.Errorf("invalid zstd body: %w", err)
+		}
+		return body, err
+	default:
+		return nil, errUnsupportedEncoding(enc)
+	}
+}
+
+// writeOTLPError answers with a google.rpc.Status body, the error format OTLP/HTTP exporters parse
+func writeOTLPError(w http.ResponseWriter, status int, code codes.Code, msg string) {
+	body, err := proto.Marshal(&spb.Status{Code: int32(code), Message: msg})
+	if err != nil {
+		http.Error(w, msg, status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// decodeOTLPRequest reads and unmarshals an OTLP/HTTP export of at most limit bytes into req,
+// answering the request itself and returning false when that fails
+func decodeOTLPRequest(w http.ResponseWriter, r *http.Request, req proto.Message, limit int64, logger *Logger) bool {
+	body, err := readOTLPBody(w, r, limit)
+	defer r.Body.Close()
+	if enc, ok := err.(errUnsupportedEncoding); ok {
+		logger.Warn("Rejected OTLP request to %s with Content-Encoding %q", r.URL.Path, string(enc))
+		writeOTLPError(w, http.StatusUnsupportedMediaType, codes.Unimplemented, err.Error())
+		return false
+	}
+	if _, ok := err.(errPayloadTooLarge); ok {
+		logger.Warn("Rejected OTLP request to %s: %v", r.URL.Path, err)
+		writeOTLPError(w, http.StatusRequestEntityTooLarge, codes.InvalidArgument, err.Error())
+		return false
+	}
+	if err != nil {
+		logger.Error("Failed to read OTLP request body: %v", err)
+		writeOTLPError(w, http.StatusBadRequest, codes.InvalidArgument, "Failed to read request body: "+err.Error())
+		return false
+	}
+	if err := proto.Unmarshal(body, req); err != nil {
+		logger.Error("Failed to unmarshal OTLP request to %s: %v", r.URL.Path, err)
+		http.Error(w, "Failed to parse OTLP request", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// writeOTLPResponse answers an export with its (empty) protobuf response message
+func writeOTLPResponse(w http.ResponseWriter, resp proto.Message) {
+	respBytes, _ := proto.Marshal(resp)
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.WriteHeader(http.StatusOK)
+	w.Write(respBytes)
+}
@@ -1,14 +1,16 @@
 package backend
 
 import (
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/protobuf/proto"
 
 	tracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
@@ -33,18 +35,131 @@ func formatBytes(b int) string {
 
 // OTLPHandler handles OTLP trace data via HTTP
 type OTLPHandler struct {
-	db     Database
-	logger *Logger
+	db         Database
+	logger     *Logger
+	onInsert   []func([]Span)
+	transforms *Transforms
+	status     *StatusRules
+	noise      *NoiseFilter
+	dedup      *SpanDedup
+	spanCap    *TraceSpanCap
+	schemas    *ResponseSchemas
+	derived    *DerivedMetrics
+	// workers bounds the goroutines transforming the spans of one export
+	workers int
+	preview *PayloadPreview
+	lag     *IngestLagMonitor
+	cold    *ColdStartDetector
+	cache   *ResponseCache
+	limit   *IngestRateLimit
+	queue   *IngestQueue
+	// maxPayload bounds request bodies, see defaultMaxPayloadBytes
+	maxPayload int64
 }
 
 // NewOTLPHandler creates a new OTLP handler
 func NewOTLPHandler(db Database, logger *Logger) *OTLPHandler {
+	metrics.Describe("simpletraces_spans_received_total", "counter", "Spans received via OTLP")
+	metrics.Describe("simpletraces_spans_dropped_total", "counter", "Spans discarded at ingest by reason (duplicate, noise filter, transform or trace_cap)")
+	metrics.Describe("simpletraces_spans_stored_total", "counter", "Spans written to the database")
+	metrics.Describe("simpletraces_spans_insert_errors_total", "counter", "Spans that failed to be written")
+	metrics.Describe("simpletraces_dead_letters_total", "counter", "Spans and conversation upserts that failed to be written, stored as dead letters by kind")
+	metrics.Describe("simpletraces_spans_rejected_total", "counter", "Spans rejected at ingest by reason (invalid or insert_error)")
+	metrics.Describe("simpletraces_traces_truncated_total", "counter", "Traces that reached MAX_SPANS_PER_TRACE")
 	return &OTLPHandler{
-		db:     db,
-		logger: logger,
+		db:         db,
+		logger:     logger,
+		workers:    1,
+		maxPayload: defaultMaxPayloadBytes,
 	}
 }
 
+// SetWorkers sets how many goroutines transform the spans of one export in parallel
+func (h *OTLPHandler) SetWorkers(n int) {
+	if n < 1 {
+		n = 1
+	}
+	h.workers = n
+}
+
+// OnInsert registers a hook called with every batch of spans after it was stored.
+// Hooks run on the request goroutine (or an ingest queue writer) and must not block.
+func (h *OTLPHandler) OnInsert(fn func([]Span)) {
+	h.onInsert = append(h.onInsert, fn)
+}
+
+// SetPayloadPreview enables logging previews of sampled exports
+func (h *OTLPHandler) SetPayloadPreview(p *PayloadPreview) {
+	h.preview = p
+}
+
+// SetIngestLagMonitor enables warnings when exporters fall behind
+func (h *OTLPHandler) SetIngestLagMonitor(m *IngestLagMonitor) {
+	h.lag = m
+}
+
+// SetResponseCache installs the API response cache dropped after spans are stored
+func (h *OTLPHandler) SetResponseCache(c *ResponseCache) {
+	h.cache = c
+}
+
+// SetColdStartDetector installs the detector marking LLM calls after an idle period
+func (h *OTLPHandler) SetColdStartDetector(d *ColdStartDetector) {
+	h.cold = d
+}
+
+// SetMaxPayloadBytes bounds the body of exports, as sent and decompressed; 0 disables the limit.
+// The Zipkin and Jaeger endpoints and NATS messages share it.
+func (h *OTLPHandler) SetMaxPayloadBytes(n int64) {
+	h.maxPayload = n
+}
+
+// SetRateLimit installs the per-source request and span rate limits of /v1/traces
+func (h *OTLPHandler) SetRateLimit(l *IngestRateLimit) {
+	h.limit = l
+}
+
+// SetQueue makes /v1/traces queue exports for the queue's writers instead of writing them
+// before answering
+func (h *OTLPHandler) SetQueue(q *IngestQueue) {
+	h.queue = q
+}
+
+// SetTransforms installs ingest transforms applied to every span before it is stored
+func (h *OTLPHandler) SetTransforms(t *Transforms) {
+	h.transforms = t
+}
+
+// SetStatusRules installs the built-in rules deriving an ERROR status for UNSET spans
+func (h *OTLPHandler) SetStatusRules(r *StatusRules) {
+	h.status = r
+}
+
+// SetNoiseFilter installs the built-in noise filters applied before spans are transformed
+func (h *OTLPHandler) SetNoiseFilter(f *NoiseFilter) {
+	h.noise = f
+}
+
+// SetTraceSpanCap installs the per-trace span limit
+func (h *OTLPHandler) SetTraceSpanCap(c *TraceSpanCap) {
+	h.spanCap = c
+}
+
+// SetDedup installs the recently-seen span cache used to skip resent spans
+func (h *OTLPHandler) SetDedup(d *SpanDedup) {
+	h.dedup = d
+}
+
+// SetResponseSchemas enables structured output validation of LLM spans
+func (h *OTLPHandler) SetResponseSchemas(s *ResponseSchemas) {
+	h.schemas = s
+}
+
+// SetDerivedMetrics installs the user-defined metrics computed for every span
+func (h *OTLPHandler) SetDerivedMetrics(d *DerivedMetrics) {
+	h.derived = d
+}
+
 // ServeHTTP handles OTLP HTTP requests
 func (h *OTLPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	h.logger.Debug("Received OTLP request: %s %s", r.Method, r.URL.Path)
@@ -55,15 +170,32 @@ func (h *OTLPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	body, err := io.ReadAll(r.Body)
+	source := rateLimitSource(r)
+	if wait := h.limit.AllowRequest(source); wait > 0 {
+		h.logger.Debug("Rate limited OTLP request from %s for %s", source, wait)
+		writeRateLimited(w, wait, "Too many export requests, retry later")
+		return
+	}
+
+	body, err := readOTLPBody(w, r, h.maxPayload)
+	defer r.Body.Close()
+	if enc, ok := err.(errUnsupportedEncoding); ok {
+		h.logger.Warn("Rejected OTLP request with Content-Encoding %q", string(enc))
+		writeOTLPError(w, http.StatusUnsupportedMediaType, codes.Unimplemented, err.Error())
+		return
+	}
+	if _, ok := err.(errPayloadTooLarge); ok {
+		h.logger.Warn("Rejected OTLP request from %s: %v", source, err)
+		writeOTLPError(w, http.StatusRequestEntityTooLarge, codes.InvalidArgument, err.Error())
+		return
+	}
 	if err != nil {
 		h.logger.Error("Failed to read OTLP request body: %v", err)
-		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		writeOTLPError(w, http.StatusBadRequest, codes.InvalidArgument, "Failed to read request body: "+err.Error())
 		return
 	}
-	defer r.Body.Close()
 
-	h.logger.Debug("Received OTLP payload: %s (Content-Type=%s)", formatBytes(len(body)), r.Header.Get("Content-Type"))
+	h.logger.Debug("Received OTLP payload: %s (Content-Type=%s, Content-Encoding=%s)", formatBytes(len(body)), r.Header.Get("Content-Type"), r.Header.Get("Content-Encoding"))
 
 	// Parse OTLP trace request
 	var req tracepb.ExportTraceServiceRequest
@@ -73,183 +205,537 @@ func (h *OTLPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Also dump a JSON view of the OTLP content for debugging
-	{
-		marshaler := protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: false, Indent: "  "}
-		if b, err := marshaler.Marshal(&req); err == nil {
-			h.logger.Debug("OTLP JSON preview: %s", string(b))
+	if wait := h.limit.AllowSpans(source, countSpans(&req)); wait > 0 {
+		h.logger.Debug("Rate limited OTLP export from %s for %s", source, wait)
+		writeRateLimited(w, wait, "Too many spans, retry later")
+		return
+	}
+
+	h.preview.Log(h.logger, &req)
+
+	var result ExportResult
+	if h.queue != nil {
+		// written later by the queue's writers, so rejections are not known yet
+		if !h.queue.Offer(&req) {
+			h.logger.Debug("Ingest queue is at its high-water mark, refusing export from %s", source)
+			writeRateLimited(w, time.Second, "Ingest queue is full, retry later")
+			return
 		}
+	} else if result, err = h.Export(&req); err != nil {
+		// nothing could be stored; exporters retry on 503
+		writeOTLPError(w, http.StatusServiceUnavailable, codes.Unavailable, err.Error())
+		return
+	}
+
+	// Send success response, telling the exporter which spans were rejected for good
+	resp := &tracepb.ExportTraceServiceResponse{}
+	if result.Rejected > 0 {
+		resp.PartialSuccess = &tracepb.ExportTracePartialSuccess{
+			RejectedSpans: int64(result.Rejected),
+			ErrorMessage:  result.Message,
+		}
+	}
+	respBytes, err := proto.Marshal(resp)
+	if err != nil {
+		h.logger.Error("Failed to marshal OTLP response: %v", err)
+		http.Error(w, "Failed to create response", http.StatusInternalServerError)
+		return
 	}
 
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.WriteHeader(http.StatusOK)
+	w.Write(respBytes)
+}
+
+// ExportResult counts what became of the spans of an export
+type ExportResult struct {
+	// Stored spans were written to the database
+	Stored int
+	// Dropped spans were discarded on purpose: duplicates, noise, transforms, the trace span cap
+	Dropped int
+	// Rejected spans were invalid or failed to be written
+	Rejected int
+	// Message explains the first rejections
+	Message string
+}
+
+// maxRejectReasons bounds the rejection reasons quoted in ExportResult.Message
+const maxRejectReasons = 3
+
+// Export stores the spans of a parsed OTLP export, as ServeHTTP does for requests to
+// /v1/traces. Other ingest paths (other wire formats, imports) convert their input to an
+// export and call it, so every span goes through the same filters and derivations. Invalid
+// spans are rejected and the rest stored; when a batch insert fails, spans are written one by
+// one so a single bad row only rejects itself. Spans and conversation updates that fail to be
+// written are kept as dead letters, see ReplayDeadLetter, and the spans counted as rejected.
+// The error is set when no span could be written nor kept as a dead letter, which callers that
+// can redeliver (message queues) retry; the result then counts the spans not stored as rejected.
+func (h *OTLPHandler) Export(req *tracepb.ExportTraceServiceRequest) (ExportResult, error) {
+	return h.export([]*tracepb.ExportTraceServiceRequest{req}, true)
+}
+
+// export is Export for one or more exports written together (see IngestQueue); deadLetters is
+// false when replaying dead letters, whose failures are reported to the caller instead.
+// Conversations are derived per export as if each was written on its own.
+func (h *OTLPHandler) export(reqs []*tracepb.ExportTraceServiceRequest, deadLetters bool) (ExportResult, error) {
+	req := mergeExports(reqs)
 	h.logger.Info("Processing OTLP trace export with %d resource spans", len(req.ResourceSpans))
+	received := time.Now()
 
 	// Process each resource span
 	spansProcessed := 0
+	spansDropped := 0
+	var reasons []string
+	spansRejected := 0
+	reject := func(reason, detail string) {
+		metrics.Inc("simpletraces_spans_rejected_total", "reason", reason)
+		spansRejected++
+		if len(reasons) < maxRejectReasons {
+			reasons = append(reasons, detail)
+		}
+	}
+	result := func() ExportResult {
+		r := ExportResult{Stored: spansProcessed, Dropped: spansDropped, Rejected: spansRejected}
+		if spansRejected > 0 {
+			r.Message = fmt.Sprintf("%d spans rejected: %s", spansRejected, strings.Join(reasons, "; "))
+		}
+		return r
+	}
 	// Collect spans for batch insert for efficiency
 	var spanRows []Span
-	// collect conversation aggregates for batch upsert
-	convAgg := make(map[string]*ConversationUpdate)
+	// user ids derived per span id, for the conversations of the stored spans
+	userIDs := make(map[string]string)
+	// truncation markers are stored, but stand for dropped spans
+	markers := make(map[string]bool)
+	// spans of this batch (see dedupKey), to also catch duplicates within one export; they are
+	// reserved in the dedup window until stored, see releaseDedupKeys
+	batchIDs := make(map[string]bool)
+	// index in reqs of the export each span came from
+	partOf := make(map[string]int)
 
-	for _, rs := range req.ResourceSpans {
-		for _, ss := range rs.ScopeSpans {
-			for _, span := range ss.Spans {
-				// Transform span
-				spanRow := h.transformSpan(span, rs.Resource)
-				spanRows = append(spanRows, spanRow)
-				spansProcessed++
-
-				// derive conversation id from span attributes
-				convID := deriveConversationIDFromJSON(spanRow.Attributes)
-				userID := deriveUserIDFromJSON(spanRow.Attributes)
-
-				if convID != "" {
-					cu := convAgg[convID]
-					start := spanRow.StartTime
-					end := spanRow.EndTime
-					if cu == nil {
-						convAgg[convID] = &ConversationUpdate{
-							ID:        convID,
-							ProjectID: spanRow.ProjectID,
-							UserID:    userID,
-							Start:     start,
-							End:       end,
-						}
-					} else {
-						if start.Before(cu.Start) {
-							cu.Start = start
-						}
-						if end.After(cu.End) {
-							cu.End = end
-						}
-						// Update user_id if it was empty and we now have one
-						if cu.UserID == "" && userID != "" {
-							cu.UserID = userID
-						}
+	if h.spanCap != nil {
+		var traceIDs []string
+		seenTraces := make(map[string]bool)
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				for _, span := range ss.Spans {
+					if id := hex.EncodeToString(span.TraceId); !seenTraces[id] {
+						seenTraces[id] = true
+						traceIDs = append(traceIDs, id)
 					}
-					h.logger.Debug("Derived conversation_id=%s user_id=%s for span_id=%s trace_id=%s", convID, userID, spanRow.SpanID, spanRow.TraceID)
 				}
 			}
 		}
+		if err := h.spanCap.Seed(h.db, traceIDs); err != nil {
+			h.logger.Error("Failed to count stored spans of %d traces: %v", len(traceIDs), err)
+		}
+	}
+	if h.dedup.Stored() {
+		var keys []string
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				for _, span := range ss.Spans {
+					keys = append(keys, dedupKey(hex.EncodeToString(span.TraceId), hex.EncodeToString(span.SpanId)))
+				}
+			}
+		}
+		if err := h.dedup.Seed(keys); err != nil {
+			h.logger.Error("Failed to look up %d spans in the dedup window: %v", len(keys), err)
+		}
+	}
+
+	// Filtering is cheap and depends on order (duplicates within the export), so it runs first;
+	// the CPU-heavy transform of the remaining spans is spread over the worker pool.
+	var jobs []transformJob
+	for part, r := range reqs {
+		for _, rs := range r.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				for _, span := range ss.Spans {
+					metrics.Inc("simpletraces_spans_received_total")
+					if reason := invalidSpan(span); reason != "" {
+						reject("invalid", reason)
+						continue
+					}
+					if reason := h.noise.Match(span); reason != "" {
+						metrics.Inc("simpletraces_spans_dropped_total", "reason", reason)
+						spansDropped++
+						continue
+					}
+					key := dedupKey(hex.EncodeToString(span.TraceId), hex.EncodeToString(span.SpanId))
+					if batchIDs[key] || h.dedup.SeenOrAdd(key) {
+						metrics.Inc("simpletraces_spans_dropped_total", "reason", "duplicate")
+						h.logger.Debug("Skipping duplicate span %s", key)
+						spansDropped++
+						continue
+					}
+					batchIDs[key] = true
+					jobs = append(jobs, transformJob{span: span, resource: rs.Resource, part: part})
+				}
+			}
+		}
+	}
+	h.transformAll(jobs)
+
+	for _, job := range jobs {
+		job.row.IngestLagMS = ingestLag(received, job.row.EndTime)
+		partOf[job.row.SpanID] = job.part
+		if !job.keep {
+			metrics.Inc("simpletraces_spans_dropped_total", "reason", "transform")
+			spansDropped++
+			continue
+		}
+		// only spans that are kept count against the cap, in the order of the export
+		ok, truncate := h.spanCap.Admit(job.row.TraceID)
+		if !ok && !truncate {
+			metrics.Inc("simpletraces_spans_dropped_total", "reason", "trace_cap")
+			spansDropped++
+			continue
+		}
+		if truncate {
+			h.logger.Warn("Trace %s reached %d spans, dropping further spans", job.row.TraceID, h.spanCap.max)
+			metrics.Inc("simpletraces_spans_dropped_total", "reason", "trace_cap")
+			metrics.Inc("simpletraces_traces_truncated_total")
+			marker := h.spanCap.truncationMarker(job.row)
+			markers[marker.SpanID] = true
+			spanRows = append(spanRows, marker)
+			spansDropped++
+			continue
+		}
+		spanRows = append(spanRows, job.row)
+		if job.userID != "" {
+			userIDs[job.row.SpanID] = job.userID
+		}
+	}
+
+	if n := h.cold.Mark(spanRows); n > 0 {
+		h.logger.Debug("Marked %d LLM calls as cold starts", n)
 	}
 
 	// Batch insert spans
+	assignSpanSeq(spanRows)
 	if err := h.db.BatchInsertSpans(spanRows); err != nil {
-		h.logger.Error("Failed to batch insert %d spans: %v", len(spanRows), err)
+		h.logger.Error("Failed to batch insert %d spans, inserting them one by one: %v", len(spanRows), err)
+		var stored []Span
+		var lastErr error
+		failed := make(map[string]bool)
+		for _, sp := range spanRows {
+			if err := h.db.BatchInsertSpans([]Span{sp}); err != nil {
+				lastErr = err
+				metrics.Inc("simpletraces_spans_insert_errors_total")
+				if markers[sp.SpanID] {
+					continue
+				}
+				reject("insert_error", fmt.Sprintf("span %s: %v", sp.SpanID, err))
+				failed[sp.SpanID] = true
+				continue
+			}
+			stored = append(stored, sp)
+		}
+		// dead-lettered spans are answered as rejected, not as a retryable error: a resend would
+		// store them, and replaying the dead letter store them again
+		kept := deadLetters && h.deadLetterSpans(req, failed, lastErr)
+		if len(stored) == 0 {
+			h.releaseDedupKeys(batchIDs, nil)
+			if kept {
+				return result(), nil
+			}
+			return result(), fmt.Errorf("store %d spans: %w", len(spanRows), lastErr)
+		}
+		spanRows = stored
+	}
+	h.releaseDedupKeys(batchIDs, spanRows)
+
+	// collect conversation aggregates of the stored spans for batch upsert
+	convAgg := make(map[string]*ConversationUpdate)
+	for _, spanRow := range spanRows {
+		if markers[spanRow.SpanID] {
+			continue
+		}
+		spansProcessed++
+		convID, userID := spanRow.ConversationID, userIDs[spanRow.SpanID]
+		if convID != "" {
+			cu := convAgg[convID]
+			start := spanRow.StartTime
+			end := spanRow.EndTime
+			if cu == nil {
+				convAgg[convID] = &ConversationUpdate{
+					ID:        convID,
+					ProjectID: spanRow.ProjectID,
+					UserID:    userID,
+					Start:     start,
+					End:       end,
+				}
+			} else {
+				if start.Before(cu.Start) {
+					cu.Start = start
+				}
+				if end.After(cu.End) {
+					cu.End = end
+				}
+				// Update user_id if it was empty and we now have one
+				if cu.UserID == "" && userID != "" {
+					cu.UserID = userID
+				}
+			}
+			h.logger.Debug("Derived conversation_id=%s user_id=%s for span_id=%s trace_id=%s", convID, userID, spanRow.SpanID, spanRow.TraceID)
+		}
+	}
+
+	metrics.Add("simpletraces_spans_stored_total", float64(len(spanRows)))
+	h.lag.Observe(h.logger, spanRows)
+	if h.dedup != nil {
+		keys := make([]string, len(spanRows))
+		for i, sp := range spanRows {
+			keys[i] = dedupKey(sp.TraceID, sp.SpanID)
+		}
+		if err := h.dedup.Add(keys...); err != nil {
+			h.logger.Error("Failed to record %d stored spans for de-duplication: %v", len(keys), err)
+		}
+	}
+	var docs []RetrievedDocument
+	for _, sp := range spanRows {
+		docs = append(docs, extractRetrievedDocuments(sp)...)
+	}
+	if err := h.db.InsertRetrievedDocuments(docs); err != nil {
+		h.logger.Error("Failed to store %d retrieved documents: %v", len(docs), err)
+	}
+	if err := h.db.InsertMetricValues(metricValues(spanRows)); err != nil {
+		h.logger.Error("Failed to store derived metrics: %v", err)
+	}
+	if err := h.db.RecordAttributeSizes(measureAttributeSizes(spanRows)); err != nil {
+		h.logger.Error("Failed to record attribute sizes: %v", err)
+	}
+	for _, fn := range h.onInsert {
+		fn(spanRows)
 	}
 
 	// upsert conversations
 	if len(convAgg) > 0 {
 		updates := make([]ConversationUpdate, 0, len(convAgg))
-		for convID, v := range convAgg {
+		for _, v := range convAgg {
 			updates = append(updates, *v)
-			// also propagate this conversation id to all spans that share the same trace id if missing
-			// we use the span trace_id as fallback linkage: update after inserts
-			for _, sp := range spanRows {
-				// propagate for spans that occurred in this batch with the same conversation id found
-				// Note: deriveConversationIDFromJSON used attributes only; here we ensure every span under the same OTLP trace
-				// gets the conv id if not already present.
-				_, _ = h.db.PropagateConversationID(sp.TraceID, convID)
+		}
+		// also propagate the conversation id to all spans that share a trace id with a span it was
+		// derived from (including spans stored by earlier exports); when the export carries a
+		// single conversation, traces without any conversation attribute are linked to it as well
+		byTrace := make(map[string]string)
+		for _, sp := range spanRows {
+			if sp.ConversationID != "" {
+				byTrace[sp.TraceID] = sp.ConversationID
+			}
+		}
+		single := make(map[int]string)
+		for _, sp := range spanRows {
+			if sp.ConversationID == "" || markers[sp.SpanID] {
+				continue
+			}
+			if conv, ok := single[partOf[sp.SpanID]]; ok && conv != sp.ConversationID {
+				single[partOf[sp.SpanID]] = ""
+			} else if !ok {
+				single[partOf[sp.SpanID]] = sp.ConversationID
+			}
+		}
+		for _, sp := range spanRows {
+			if _, ok := byTrace[sp.TraceID]; !ok && single[partOf[sp.SpanID]] != "" {
+				byTrace[sp.TraceID] = single[partOf[sp.SpanID]]
 			}
 		}
+		if _, err := h.db.PropagateConversationIDs(byTrace); err != nil {
+			h.logger.Error("Failed to propagate conversation ids: %v", err)
+		}
 		if err := h.db.BatchUpsertConversations(updates); err != nil {
 			h.logger.Error("Failed to upsert conversations: %v", err)
+			if deadLetters {
+				if payload, jerr := json.Marshal(updates); jerr == nil {
+					h.deadLetter(deadLetterConversations, payload, len(updates), err)
+				}
+			}
 		}
 	}
 
-	h.logger.Info("Successfully processed %d spans from OTLP export", spansProcessed)
-
-	// Send success response
-	resp := &tracepb.ExportTraceServiceResponse{}
-	respBytes, err := proto.Marshal(resp)
-	if err != nil {
-		h.logger.Error("Failed to marshal OTLP response: %v", err)
-		http.Error(w, "Failed to create response", http.StatusInternalServerError)
-		return
+	// cached views are stale once the spans and their conversations are written
+	if len(spanRows) > 0 {
+		h.cache.Invalidate()
 	}
 
-	w.Header().Set("Content-Type", "application/x-protobuf")
-	w.WriteHeader(http.StatusOK)
-	w.Write(respBytes)
+	if spansDropped > 0 {
+		h.logger.Info("Successfully processed %d spans from OTLP export (%d dropped as duplicates, noise, by transforms or the trace span cap)", spansProcessed, spansDropped)
+	} else {
+		h.logger.Info("Successfully processed %d spans from OTLP export", spansProcessed)
+	}
+	if spansRejected > 0 {
+		h.logger.Warn("Rejected %d spans of OTLP export: %s", spansRejected, strings.Join(reasons, "; "))
+	}
+	return result(), nil
 }
 
-// deriveConversationIDFromJSON picks a conversation id from preferred keys in span attributes JSON
-func deriveConversationIDFromJSON(attrsJSON string) string {
-	if attrsJSON == "" {
-		return ""
+// mergeExports joins exports into one request; a single export is returned as is
+func mergeExports(reqs []*tracepb.ExportTraceServiceRequest) *tracepb.ExportTraceServiceRequest {
+	if len(reqs) == 1 {
+		return reqs[0]
+	}
+	merged := &tracepb.ExportTraceServiceRequest{}
+	for _, r := range reqs {
+		merged.ResourceSpans = append(merged.ResourceSpans, r.ResourceSpans...)
 	}
+	return merged
+}
 
-	var attrs map[string]interface{}
-	if err := json.Unmarshal([]byte(attrsJSON), &attrs); err != nil {
-		return ""
+// invalidSpan returns why a span can't be stored, or "" when it can: OTLP requires 16-byte
+// trace ids and 8-byte span ids that are not all zero
+func invalidSpan(span *tracepbv1.Span) string {
+	switch {
+	case len(span.TraceId) != 16 || allZero(span.TraceId):
+		return fmt.Sprintf("invalid trace id %x", span.TraceId)
+	case len(span.SpanId) != 8 || allZero(span.SpanId):
+		return fmt.Sprintf("invalid span id %x of trace %x", span.SpanId, span.TraceId)
+	case len(span.ParentSpanId) != 0 && len(span.ParentSpanId) != 8:
+		return fmt.Sprintf("invalid parent span id %x of span %x", span.ParentSpanId, span.SpanId)
 	}
+	return ""
+}
 
-	// scan by preference order
-	pref := []string{
-		"simpleTraces.conversation.id",
-		"gcp.vertex.agent.session_id",
-		"gen_ai.conversation.id",
-		"conversation.id",
-		"conversation_id",
-		"session.conversation_id",
-		"session.id",
-		"chat.id",
-		"thread.id",
+func allZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
 	}
+	return true
+}
 
-	for _, k := range pref {
-		if v, ok := attrs[k]; ok {
-			if strVal, ok := v.(string); ok && strings.TrimSpace(strVal) != "" {
-				return strVal
-			}
+// projectIDKeys are the attributes a project id is taken from, in order of preference
+var projectIDKeys = []string{
+	"simpleTraces.project.id",
+	"project.id",
+	"resource.project.id",
+	"gcp.project.id",
+	"service.namespace",
+}
+
+// conversationIDKeys are the attributes a conversation id is taken from, in order of preference
+var conversationIDKeys = []string{
+	"simpleTraces.conversation.id",
+	"gcp.vertex.agent.session_id",
+	"gen_ai.conversation.id",
+	"conversation.id",
+	"conversation_id",
+	"session.conversation_id",
+	"session.id",
+	"chat.id",
+	"thread.id",
+}
+
+// runIDKeys are the attributes a run (experiment, eval sweep, CI job) id is taken from
+var runIDKeys = []string{
+	"simpleTraces.run.id",
+}
+
+// userIDKeys are the attributes a user id is taken from, in order of preference
+var userIDKeys = []string{
+	"simpleTraces.user.id",
+	"user.id",
+	"user_id",
+	"enduser.id",
+	"actor.id",
+	"gen_ai.user.id",
+	"session.user.id",
+}
+
+// firstStringAttr returns the first non-blank string attribute of keys
+func firstStringAttr(attrs map[string]any, keys []string) string {
+	for _, k := range keys {
+		if v, ok := attrs[k].(string); ok && strings.TrimSpace(v) != "" {
+			return v
 		}
 	}
 	return ""
 }
 
-// Generated by Copilot
-// deriveUserIDFromJSON picks a user id from preferred keys in span attributes JSON
-func deriveUserIDFromJSON(attrsJSON string) string {
-	if attrsJSON == "" {
-		return ""
-	}
+// transformJob is one span of an export on its way through the worker pool
+type transformJob struct {
+	span     *tracepbv1.Span
+	resource *resourcepb.Resource
+	// part is the index of the span's export among those written together
+	part int
 
-	var attrs map[string]interface{}
-	if err := json.Unmarshal([]byte(attrsJSON), &attrs); err != nil {
-		return ""
-	}
+	row    Span
+	keep   bool
+	userID string
+}
 
-	// scan by preference order
-	pref := []string{
-		"simpleTraces.user.id",
-		"user.id",
-		"user_id",
-		"enduser.id",
-		"actor.id",
-		"gen_ai.user.id",
-		"session.user.id",
+// transformAll transforms the spans of an export on up to h.workers goroutines.
+// Results are written in place so the order is preserved.
+func (h *OTLPHandler) transformAll(jobs []transformJob) {
+	run := func(job *transformJob) {
+		job.row, job.userID, job.keep = h.transformSpan(job.span, job.resource)
+	}
+	workers := min(h.workers, len(jobs))
+	if workers <= 1 {
+		for i := range jobs {
+			run(&jobs[i])
+		}
+		return
+	}
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Go(func() {
+			for {
+				i := int(next.Add(1)) - 1
+				if i >= len(jobs) {
+					return
+				}
+				run(&jobs[i])
+			}
+		})
 	}
+	wg.Wait()
+}
 
-	for _, k := range pref {
-		if v, ok := attrs[k]; ok {
-			if strVal, ok := v.(string); ok && strings.TrimSpace(strVal) != "" {
-				return strVal
+// putAnyValue stores an OTLP attribute value under key, flattening key-value lists into
+// dot-notated keys on the way instead of building nested maps first. Arrays stay as-is.
+// Keys produced by flattening are appended to produced when it is not nil.
+func putAnyValue(attrs map[string]any, key string, v *commonpb.AnyValue, produced *[]string) {
+	if kv, ok := v.GetValue().(*commonpb.AnyValue_KvlistValue); ok && kv.KvlistValue != nil {
+		for _, kvp := range kv.KvlistValue.Values {
+			if kvp != nil {
+				putAnyValue(attrs, key+"."+kvp.Key, kvp.Value, produced)
 			}
 		}
+		return
+	}
+	attrs[key] = anyValueToInterface(v)
+	if produced != nil && strings.Contains(key, ".") {
+		*produced = append(*produced, key)
 	}
-	return ""
 }
 
-// transformSpan converts an OTLP span to our Span struct
-func (h *OTLPHandler) transformSpan(span *tracepbv1.Span, resource *resourcepb.Resource) Span {
-	h.logger.Debug("Processing OTLP span: %s", span.Name)
+// transformSpan converts an OTLP span into a row and the user id found in its attributes;
+// keep is false when an ingest transform dropped it. Attributes are flattened straight from
+// the protobuf values into the one map that is finally stored, so each span is encoded to
+// JSON exactly once.
+func (h *OTLPHandler) transformSpan(span *tracepbv1.Span, resource *resourcepb.Resource) (spanRow Span, userID string, keep bool) {
+	debug := h.logger.DebugEnabled()
+	if debug {
+		h.logger.Debug("Processing OTLP span: %s", span.Name)
+	}
+	var flattened *[]string
+	if debug {
+		flattened = new([]string)
+	}
 
-	// Extract attributes into a map
-	attrs := make(map[string]interface{})
+	// Extract attributes (flattened) into a map
+	size := len(span.Attributes) + 8
+	if resource != nil {
+		size += 2 * len(resource.Attributes)
+	}
+	attrs := make(map[string]any, size)
 	for _, attr := range span.Attributes {
 		if attr == nil {
 			continue
 		}
-		attrs[attr.Key] = anyValueToInterface(attr.Value)
+		putAnyValue(attrs, attr.Key, attr.Value, flattened)
 	}
 
 	// Also add resource attributes
@@ -259,31 +745,44 @@ func (h *OTLPHandler) transformSpan(span *tracepbv1.Span, resource *resourcepb.R
 				continue
 			}
 			key := attr.Key
-			val := anyValueToInterface(attr.Value)
 			// record prefixed resource attribute
-			attrs["resource."+key] = val
+			putAnyValue(attrs, "resource."+key, attr.Value, nil)
 			// Also propagate to top-level if not present already
 			if _, exists := attrs[key]; !exists {
-				attrs[key] = val
-				h.logger.Debug("Propagated resource attribute to top-level: %s <- resource.%s", key, key)
+				putAnyValue(attrs, key, attr.Value, nil)
+				if debug {
+					h.logger.Debug("Propagated resource attribute to top-level: %s <- resource.%s", key, key)
+				}
 			}
 		}
 	}
+	if debug && len(*flattened) > 0 {
+		// Log only in debug: which keys resulted from flattening (i.e., implicit renames to dot-notation)
+		h.logger.Debug("Flattened nested attributes into dot-keys (%d): %v", len(*flattened), *flattened)
+	}
 
 	// Provider-specific augmentation (e.g., Vertex Agent JSON fields)
-	if added := augmentVertexAttrs(attrs); len(added) > 0 {
+	if added := augmentVertexAttrs(attrs); debug && len(added) > 0 {
 		h.logger.Debug("Derived attributes added: %v", added)
 	}
+	if added := augmentOpenInferenceAttrs(attrs); debug && len(added) > 0 {
+		h.logger.Debug("Derived OpenInference attributes added: %v", added)
+	}
+	if added := augmentGenAIAttrs(attrs, span.Events); debug && len(added) > 0 {
+		h.logger.Debug("Derived gen_ai attributes added: %v", added)
+	}
 
 	// Extract model and IO usage info from attributes (with broader provider coverage)
 	model, modelSrc := detectModelFromAttrs(attrs)
 	if strings.TrimSpace(model) == "" {
 		model = "unknown"
 	}
-	if strings.TrimSpace(modelSrc) != "" {
-		h.logger.Debug("Detected model='%s' from key '%s'", model, modelSrc)
-	} else {
-		h.logger.Debug("Detected model='%s' (no explicit source key)", model)
+	if debug {
+		if strings.TrimSpace(modelSrc) != "" {
+			h.logger.Debug("Detected model='%s' from key '%s'", model, modelSrc)
+		} else {
+			h.logger.Debug("Detected model='%s' (no explicit source key)", model)
+		}
 	}
 
 	// Calculate duration in milliseconds
@@ -292,10 +791,12 @@ func (h *OTLPHandler) transformSpan(span *tracepbv1.Span, resource *resourcepb.R
 	duration := endTime.Sub(startTime).Milliseconds()
 
 	// Add span metadata
+	spanID := hex.EncodeToString(span.SpanId)
+	traceID := hex.EncodeToString(span.TraceId)
 	attrs["span.name"] = span.Name
 	attrs["span.kind"] = spanKindToString(span.Kind)
-	attrs["trace.id"] = fmt.Sprintf("%x", span.TraceId)
-	attrs["span.id"] = fmt.Sprintf("%x", span.SpanId)
+	attrs["trace.id"] = traceID
+	attrs["span.id"] = spanID
 
 	if span.Status != nil {
 		attrs["span.status.code"] = statusCodeToString(span.Status.Code)
@@ -305,15 +806,16 @@ func (h *OTLPHandler) transformSpan(span *tracepbv1.Span, resource *resourcepb.R
 	}
 
 	// Add events to metadata if any
+	var events []map[string]interface{}
 	if len(span.Events) > 0 {
-		events := make([]map[string]interface{}, 0, len(span.Events))
+		events = make([]map[string]interface{}, 0, len(span.Events))
 		for _, event := range span.Events {
 			eventData := map[string]interface{}{
 				"name":      event.Name,
 				"timestamp": time.Unix(0, int64(event.TimeUnixNano)).Format(time.RFC3339Nano),
 			}
 			if len(event.Attributes) > 0 {
-				eventAttrs := make(map[string]interface{})
+				eventAttrs := make(map[string]interface{}, len(event.Attributes))
 				for _, attr := range event.Attributes {
 					if attr == nil {
 						continue
@@ -327,76 +829,153 @@ func (h *OTLPHandler) transformSpan(span *tracepbv1.Span, resource *resourcepb.R
 		attrs["span.events"] = events
 	}
 
-	// Flatten attributes for metadata and typed storage (record any nested-key renames)
-	flat, flattenedKeys := FlattenAttrsWithTrace(attrs)
-	if len(flattenedKeys) > 0 {
-		// Log only in debug: which keys resulted from flattening (i.e., implicit renames to dot-notation)
-		h.logger.Debug("Flattened nested attributes into dot-keys (%d): %v", len(flattenedKeys), flattenedKeys)
+	// Derive everything from the span's own attributes before adding simpleTraces.* keys
+	category := detectCategory(span.Name, attrs)
+	var store string
+	var results int64
+	if category == "retrieval" {
+		store = retrievalStore(attrs)
+		results = retrievalResultCount(attrs)
 	}
-
-	// Build span row: store flattened attributes (without events) as JSON for display
-	attrsOnly := make(map[string]interface{})
-	// Keep the flattened attributes except events (handled separately)
-	for k, v := range flat {
-		switch k {
-		case "span.events":
-			// handled below
-		default:
-			attrsOnly[k] = v
+	var httpMethod, httpRoute string
+	var httpStatus int64
+	if category == "http" {
+		httpMethod, httpRoute, httpStatus = extractHTTPAttrs(span.Name, attrs)
+	}
+	dbOperation, dbFingerprint, dbFingerprintID := extractDBAttrs(attrs)
+	breakdown, hasBreakdown := computePromptBreakdown(attrs)
+	inputTokens, outputTokens := extractTokenUsage(attrs)
+	finishReason := extractFinishReason(attrs)
+	violationType, violationReason := detectGuardrailViolation(attrs)
+	if violationType != "" && debug {
+		h.logger.Debug("Guardrail violation on span %s: %s (%s)", spanID, violationType, violationReason)
+	}
+	status, statusDesc := "", ""
+	if span.Status != nil {
+		status, statusDesc = statusCodeToString(span.Status.Code), span.Status.Message
+	}
+	derivedBy := ""
+	if status == "" || status == "UNSET" {
+		if rule, desc := h.status.Derive(attrs, events); rule != "" {
+			status, derivedBy = "ERROR", rule
+			if statusDesc == "" {
+				statusDesc = desc
+			}
 		}
 	}
+
+	// Extract project_id from attributes with preference order
+	projectID := firstStringAttr(attrs, projectIDKeys)
+	if projectID == "" {
+		projectID = "default"
+	}
+
+	// Events are stored in their own column, everything else is kept for display
+	delete(attrs, "span.events")
 	// Add derived attributes for UI/search convenience
 	if strings.TrimSpace(model) != "" && strings.ToLower(model) != "unknown" {
-		attrsOnly["simpleTraces.model"] = model
+		attrs["simpleTraces.model"] = model
 	}
-	attrsOnly["simpleTraces.category"] = detectCategory(span.Name, flat)
-
-	// Extract project_id from attributes with preference order
-	projectID := "default"
-	projectKeys := []string{
-		"simpleTraces.project.id",
-		"project.id",
-		"resource.project.id",
-		"gcp.project.id",
-		"service.namespace",
-	}
-	for _, key := range projectKeys {
-		if projAttr, ok := attrs[key]; ok {
-			if projStr, ok := projAttr.(string); ok && strings.TrimSpace(projStr) != "" {
-				projectID = projStr
-				break
-			}
+	attrs["simpleTraces.category"] = category
+	if category == "retrieval" {
+		attrs["simpleTraces.retrieval.store"] = store
+		if results > 0 {
+			attrs["simpleTraces.retrieval.result_count"] = results
 		}
 	}
+	if hasBreakdown {
+		attrs["simpleTraces.prompt_tokens.system"] = breakdown.System
+		attrs["simpleTraces.prompt_tokens.user"] = breakdown.User
+		attrs["simpleTraces.prompt_tokens.history"] = breakdown.History
+		attrs["simpleTraces.prompt_tokens.tool"] = breakdown.Tool
+	}
+	if violationType != "" {
+		attrs["simpleTraces.violation"] = violationType
+	}
+	if derivedBy != "" {
+		attrs["simpleTraces.status.derived"] = derivedBy
+	}
 	// Also store in attributes for consistency
-	attrsOnly["simpleTraces.project.id"] = projectID
+	attrs["simpleTraces.project.id"] = projectID
 
-	attrsStr, _ := json.Marshal(attrsOnly)
+	// Operator-defined transforms may add attributes (including the project) or drop the span
+	if h.transforms != nil {
+		var keep bool
+		if status, keep = h.transforms.Apply(span.Name, spanKindToString(span.Kind), status, duration, attrs, h.logger); !keep {
+			return Span{}, "", false
+		}
+		if p, ok := attrs["simpleTraces.project.id"].(string); ok && strings.TrimSpace(p) != "" {
+			projectID = p
+		}
+	}
+
+	var schemaStatus, schemaErrors, promptHash, responseHash string
+	if category == "llm" {
+		promptHash, responseHash = promptHashes(attrs)
+		var errs []string
+		if schemaStatus, errs = h.schemas.Check(projectID, attrs); schemaStatus != "" {
+			attrs["simpleTraces.schema.status"] = schemaStatus
+			schemaErrors = strings.Join(errs, "; ")
+		}
+	}
+
+	attrsStr, _ := json.Marshal(attrs)
 	var eventsStr []byte
-	if ev, ok := attrs["span.events"]; ok {
-		eventsStr, _ = json.Marshal(ev)
-	}
-
-	spanRow := Span{
-		SpanID:       fmt.Sprintf("%x", span.SpanId),
-		TraceID:      fmt.Sprintf("%x", span.TraceId),
-		ProjectID:    projectID,
-		ParentSpanID: fmt.Sprintf("%x", span.ParentSpanId),
-		Name:         span.Name,
-		StartTime:    startTime,
-		EndTime:      endTime,
-		DurationMS:   duration,
-		StatusCode:   "",
-		StatusDesc:   "",
-		Attributes:   string(attrsStr),
-		Events:       string(eventsStr),
+	if events != nil {
+		eventsStr, _ = json.Marshal(events)
 	}
-	if span.Status != nil {
-		spanRow.StatusCode = statusCodeToString(span.Status.Code)
-		spanRow.StatusDesc = span.Status.Message
+
+	spanRow = Span{
+		SpanID:         spanID,
+		TraceID:        traceID,
+		ProjectID:      projectID,
+		ParentSpanID:   spanParentID(span.ParentSpanId),
+		ConversationID: firstStringAttr(attrs, conversationIDKeys),
+		RunID:          firstStringAttr(attrs, runIDKeys),
+		Name:           span.Name,
+		StartTime:      startTime,
+		EndTime:        endTime,
+		DurationMS:     duration,
+		StatusCode:     status,
+		StatusDesc:     statusDesc,
+		Attributes:     string(attrsStr),
+		Events:         string(eventsStr),
+
+		ViolationType:   violationType,
+		ViolationReason: violationReason,
+		FinishReason:    finishReason,
+		Category:        category,
+		RetrievalStore:  store,
+		RetrievalCount:  results,
+
+		HTTPMethod:     httpMethod,
+		HTTPRoute:      httpRoute,
+		HTTPStatusCode: httpStatus,
+
+		DBOperation:     dbOperation,
+		DBFingerprint:   dbFingerprint,
+		DBFingerprintID: dbFingerprintID,
+
+		SchemaStatus: schemaStatus,
+		SchemaErrors: schemaErrors,
+
+		PromptHash:   promptHash,
+		ResponseHash: responseHash,
+
+		PromptTokensSystem:  breakdown.System,
+		PromptTokensUser:    breakdown.User,
+		PromptTokensHistory: breakdown.History,
+		PromptTokensTool:    breakdown.Tool,
+
+		InputTokens:  inputTokens,
+		OutputTokens: outputTokens,
+	}
+	if m, ok := attrs["simpleTraces.model"].(string); ok {
+		spanRow.Model = m
 	}
+	spanRow.DerivedMetrics = h.derived.Evaluate(spanRow, spanKindToString(span.Kind), attrs, h.logger)
 
-	return spanRow
+	return spanRow, firstStringAttr(attrs, userIDKeys), true
 }
 
 // augmentVertexAttrs parses provider-specific blobs (like Vertex Agent request/response) into normalized keys
@@ -487,6 +1066,13 @@ func augmentVertexAttrs(attrs map[string]any) []string {
 						}
 					}
 				}
+				// finish reason (e.g. STOP, MAX_TOKENS, SAFETY)
+				if fr, ok := resp["finish_reason"].(string); ok && strings.TrimSpace(fr) != "" {
+					if _, exists := attrs["gen_ai.response.finish_reasons"]; !exists {
+						attrs["gen_ai.response.finish_reasons"] = []any{fr}
+						added = append(added, "gen_ai.response.finish_reasons")
+					}
+				}
 				// usage tokens
 				if usage, ok := resp["usage_metadata"].(map[string]any); ok {
 					if _, exists := attrs["gen_ai.usage.input_tokens"]; !exists {
@@ -541,7 +1127,7 @@ func detectModelFromAttrs(attrs map[string]any) (string, string) {
 	// direct keys first
 	keys := []string{
 		"simpleTraces.model", // already normalized
-		"llm.model", "gen_ai.request.model", "openai.model", "anthropic.model",
+		"llm.model", "gen_ai.request.model", "gen_ai.response.model", "llm.model_name", "openai.model", "anthropic.model",
 		"vertex.model", "google.vertex.model", "ai.model", "model",
 	}
 	for _, k := range keys {
@@ -596,13 +1182,28 @@ func detectModelFromAttrs(attrs map[string]any) (string, string) {
 func detectCategory(name string, attrs map[string]any) string {
 	n := strings.ToLower(name)
 	has := func(k string) bool { _, ok := attrs[k]; return ok }
+	// OpenInference spans declare their kind
+	if kind, ok := attrs["openinference.span.kind"].(string); ok {
+		if c, ok := openInferenceCategories[strings.ToUpper(kind)]; ok {
+			return c
+		}
+	}
+	if op, ok := attrs["gen_ai.operation.name"].(string); ok {
+		if c, ok := genAIOperationCategories[strings.ToLower(op)]; ok {
+			return c
+		}
+	}
 	// LLM calls
 	if has("llm.model") || has("gen_ai.request.model") || has("simpleTraces.model") || strings.Contains(n, "call_llm") ||
 		strings.Contains(n, "openai") || strings.Contains(n, "anthropic") || strings.Contains(n, "gemini") {
 		return "llm"
 	}
+	// Vector DB / retrieval (checked before HTTP since most vector DB clients are HTTP based)
+	if retrievalStore(attrs) != "" {
+		return "retrieval"
+	}
 	// HTTP
-	if has("http.method") || has("http.url") || strings.Contains(n, "http") {
+	if has("http.method") || has("http.request.method") || has("http.url") || has("url.full") || strings.Contains(n, "http") {
 		return "http"
 	}
 	// Database
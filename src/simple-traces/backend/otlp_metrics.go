@@ -0,0 +1,250 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+
+	metricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspbv1 "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+)
+
+// storedMetricPrefixes are the OTLP metric names kept; other metrics are acknowledged so
+// collectors exporting everything don't fail, but not stored
+var storedMetricPrefixes = []string{"gen_ai."}
+
+// MetricPoint is one data point of an OTLP metric, such as gen_ai.client.token.usage or
+// gen_ai.client.operation.duration. Values are stored as exported: cumulative sums and
+// histograms repeat the running total in every export.
+type MetricPoint struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	Name        string    `gorm:"index" json:"name"`
+	Kind        string    `json:"kind"` // gauge, sum, histogram, exponential_histogram or summary
+	Unit        string    `json:"unit,omitempty"`
+	Temporality string    `json:"temporality,omitempty"` // delta or cumulative
+	ProjectID   string    `gorm:"index" json:"project_id"`
+	ServiceName string    `json:"service_name,omitempty"`
+	Model       string    `gorm:"index" json:"model,omitempty"`
+	Time        time.Time `gorm:"column:point_time;index" json:"time"`
+	StartTime   time.Time `json:"start_time,omitempty"`
+	// Value is the gauge or sum value, or the sum of a histogram's observations
+	Value      float64  `json:"value"`
+	Count      uint64   `json:"count,omitempty"`
+	Min        *float64 `json:"min,omitempty"`
+	Max        *float64 `json:"max,omitempty"`
+	Attributes string   `gorm:"type:text" json:"attributes,omitempty"`
+}
+
+// OTLPMetricsHandler ingests OTLP/HTTP metric exports at /v1/metrics
+type OTLPMetricsHandler struct {
+	db     Database
+	logger *Logger
+	// maxPayload bounds request bodies, see defaultMaxPayloadBytes
+	maxPayload int64
+}
+
+// NewOTLPMetricsHandler creates a handler storing gen_ai metric points in db
+func NewOTLPMetricsHandler(db Database, logger *Logger) *OTLPMetricsHandler {
+	metrics.Describe("simpletraces_otlp_metrics_received_total", "counter", "Metrics received via OTLP, stored or not")
+	metrics.Describe("simpletraces_metric_points_stored_total", "counter", "Metric data points written to the database")
+	return &OTLPMetricsHandler{db: db, logger: logger, maxPayload: defaultMaxPayloadBytes}
+}
+
+// SetMaxPayloadBytes bounds the body of metric exports, as sent and decompressed; 0 disables the limit
+func (h *OTLPMetricsHandler) SetMaxPayloadBytes(n int64) {
+	h.maxPayload = n
+}
+
+func (h *OTLPMetricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req metricspb.ExportMetricsServiceRequest
+	if !decodeOTLPRequest(w, r, &req, h.maxPayload, h.logger) {
+		return
+	}
+
+	var rows []MetricPoint
+	received := 0
+	for _, rm := range req.ResourceMetrics {
+		for _, sm := range rm.ScopeMetrics {
+			for _, m := range sm.Metrics {
+				metrics.Inc("simpletraces_otlp_metrics_received_total")
+				received++
+				if storedMetric(m.Name) {
+					rows = append(rows, metricPointRows(m, rm.Resource)...)
+				}
+			}
+		}
+	}
+	if err := h.db.InsertMetricPoints(rows); err != nil {
+		h.logger.Error("Failed to store %d metric points: %v", len(rows), err)
+		writeOTLPError(w, http.StatusInternalServerError, codes.Unavailable, "Failed to store metric points")
+		return
+	}
+	metrics.Add("simpletraces_metric_points_stored_total", float64(len(rows)))
+	h.logger.Debug("Stored %d metric points of %d metrics from OTLP export", len(rows), received)
+
+	writeOTLPResponse(w, &metricspb.ExportMetricsServiceResponse{})
+}
+
+func storedMetric(name string) bool {
+	for _, p := range storedMetricPrefixes {
+		if strings.HasPrefix(name, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// metricPointRows converts the data points of one metric
+func metricPointRows(m *metricspbv1.Metric, resource *resourcepb.Resource) []MetricPoint {
+	newPoint := func(kind string, temporality metricspbv1.AggregationTemporality, attrs []*commonpb.KeyValue, start, end uint64) MetricPoint {
+		p := MetricPoint{Name: m.Name, Kind: kind, Unit: m.Unit, Time: time.Unix(0, int64(end))}
+		switch temporality {
+		case metricspbv1.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA:
+			p.Temporality = "delta"
+		case metricspbv1.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE:
+			p.Temporality = "cumulative"
+		}
+		if start != 0 {
+			p.StartTime = time.Unix(0, int64(start))
+		}
+		setMetricPointAttrs(&p, attrs, resource)
+		return p
+	}
+	numberValue := func(dp *metricspbv1.NumberDataPoint) float64 {
+		if v, ok := dp.Value.(*metricspbv1.NumberDataPoint_AsInt); ok {
+			return float64(v.AsInt)
+		}
+		return dp.GetAsDouble()
+	}
+
+	var out []MetricPoint
+	switch data := m.Data.(type) {
+	case *metricspbv1.Metric_Gauge:
+		for _, dp := range data.Gauge.GetDataPoints() {
+			p := newPoint("gauge", 0, dp.Attributes, dp.StartTimeUnixNano, dp.TimeUnixNano)
+			p.Value = numberValue(dp)
+			out = append(out, p)
+		}
+	case *metricspbv1.Metric_Sum:
+		for _, dp := range data.Sum.GetDataPoints() {
+			p := newPoint("sum", data.Sum.AggregationTemporality, dp.Attributes, dp.StartTimeUnixNano, dp.TimeUnixNano)
+			p.Value = numberValue(dp)
+			out = append(out, p)
+		}
+	case *metricspbv1.Metric_Histogram:
+		for _, dp := range data.Histogram.GetDataPoints() {
+			p := newPoint("histogram", data.Histogram.AggregationTemporality, dp.Attributes, dp.StartTimeUnixNano, dp.TimeUnixNano)
+			p.Value, p.Count, p.Min, p.Max = dp.GetSum(), dp.Count, dp.Min, dp.Max
+			out = append(out, p)
+		}
+	case *metricspbv1.Metric_ExponentialHistogram:
+		for _, dp := range data.ExponentialHistogram.GetDataPoints() {
+			p := newPoint("exponential_histogram", data.ExponentialHistogram.AggregationTemporality, dp.Attributes, dp.StartTimeUnixNano, dp.TimeUnixNano)
+			p.Value, p.Count, p.Min, p.Max = dp.GetSum(), dp.Count, dp.Min, dp.Max
+			out = append(out, p)
+		}
+	case *metricspbv1.Metric_Summary:
+		for _, dp := range data.Summary.GetDataPoints() {
+			p := newPoint("summary", 0, dp.Attributes, dp.StartTimeUnixNano, dp.TimeUnixNano)
+			p.Value, p.Count = dp.Sum, dp.Count
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// setMetricPointAttrs stores the data point attributes and derives project, service and model
+// from them and the resource
+func setMetricPointAttrs(p *MetricPoint, attrs []*commonpb.KeyValue, resource *resourcepb.Resource) {
+	values := make(map[string]any, len(attrs))
+	for _, attr := range attrs {
+		if attr != nil {
+			putAnyValue(values, attr.Key, attr.Value, nil)
+		}
+	}
+	raw, _ := json.Marshal(values)
+	p.Attributes = string(raw)
+
+	// resource attributes only take part in lookups, they would repeat in every point
+	lookup := values
+	if resource != nil && len(resource.Attributes) > 0 {
+		lookup = make(map[string]any, len(values)+len(resource.Attributes))
+		for _, attr := range resource.Attributes {
+			if attr != nil {
+				putAnyValue(lookup, attr.Key, attr.Value, nil)
+			}
+		}
+		for k, v := range values {
+			lookup[k] = v
+		}
+	}
+	p.ProjectID = firstStringAttr(lookup, projectIDKeys)
+	if p.ProjectID == "" {
+		p.ProjectID = "default"
+	}
+	p.ServiceName = firstStringAttr(lookup, []string{"service.name"})
+	p.Model = firstStringAttr(lookup, []string{"gen_ai.response.model", "gen_ai.request.model"})
+}
+
+// InsertMetricPoints stores metric data points
+func (g *GormDB) InsertMetricPoints(points []MetricPoint) error {
+	if len(points) == 0 {
+		return nil
+	}
+	return g.db.CreateInBatches(points, g.batchRows(&MetricPoint{})).Error
+}
+
+// GetMetricPoints lists data points of the stats filter's window, newest first, optionally of
+// one metric
+func (g *GormDB) GetMetricPoints(filter StatsFilter, name string, limit int) ([]MetricPoint, error) {
+	query := g.db.Model(&MetricPoint{}).Where("point_time >= ?", filter.Since)
+	if !filter.Until.IsZero() {
+		query = query.Where("point_time < ?", filter.Until)
+	}
+	if filter.ProjectID != "" {
+		query = query.Where("project_id = ?", filter.ProjectID)
+	}
+	if filter.Model != "" {
+		query = query.Where("model = ?", filter.Model)
+	}
+	if name != "" {
+		query = query.Where("name = ?", name)
+	}
+	var points []MetricPoint
+	err := query.Order("point_time DESC, id DESC").Limit(limit).Find(&points).Error
+	return points, err
+}
+
+// getMetricPointsHandler lists stored OTLP metric points filtered by name, project, model and
+// the usual stats window
+func getMetricPointsHandler(db Database, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		filter, err := parseStatsFilter(q)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		limit := 1000
+		if s := strings.TrimSpace(q.Get("limit")); s != "" {
+			if v, err := strconv.Atoi(s); err == nil && v > 0 {
+				limit = v
+			}
+		}
+		points, err := db.GetMetricPoints(filter, strings.TrimSpace(q.Get("name")), limit)
+		if err != nil {
+			logger.Error("Failed to get metric points: %v", err)
+			http.Error(w, fmt.Sprintf("Failed to get metric points: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(points)
+	}
+}
@@ -0,0 +1,41 @@
+package backend
+
+import (
+	"sync/atomic"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// PayloadPreview logs a JSON rendering of sampled OTLP exports for debugging instrumentation.
+// Full payloads can add up to gigabytes of logs per hour, so previews are opt-in, written for
+// one export in every `every` and cut after maxBytes.
+type PayloadPreview struct {
+	maxBytes int
+	every    int64
+	seen     atomic.Int64
+}
+
+// NewPayloadPreview returns nil, which logs nothing, unless enabled
+func NewPayloadPreview(enabled bool, maxBytes, every int) *PayloadPreview {
+	if !enabled {
+		return nil
+	}
+	return &PayloadPreview{maxBytes: maxBytes, every: int64(max(every, 1))}
+}
+
+// Log writes the preview of msg if this export is sampled
+func (p *PayloadPreview) Log(logger *Logger, msg proto.Message) {
+	if p == nil || (p.seen.Add(1)-1)%p.every != 0 {
+		return
+	}
+	b, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(msg)
+	if err != nil {
+		return
+	}
+	if p.maxBytes > 0 && len(b) > p.maxBytes {
+		logger.Info("OTLP payload preview (%s, truncated): %s...", formatBytes(len(b)), b[:p.maxBytes])
+		return
+	}
+	logger.Info("OTLP payload preview (%s): %s", formatBytes(len(b)), b)
+}
@@ -0,0 +1,190 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// PromptBreakdown estimates how the prompt tokens of an LLM call are split between
+// the system prompt, the current user message, earlier turns and tool/retrieved content.
+type PromptBreakdown struct {
+	System  int64 `json:"system"`
+	User    int64 `json:"user"`
+	History int64 `json:"history"`
+	Tool    int64 `json:"tool"`
+}
+
+// estimateTokens uses the common ~4 characters per token heuristic
+func estimateTokens(s string) int64 {
+	n := len(strings.TrimSpace(s))
+	if n == 0 {
+		return 0
+	}
+	return int64((n + 3) / 4)
+}
+
+// computePromptBreakdown derives a breakdown from simpleTraces.messages (Vertex/Gemini
+// contents or OpenAI-style messages) and simpleTraces.system_instruction. ok is false
+// when no message array is available.
+func computePromptBreakdown(attrs map[string]any) (PromptBreakdown, bool) {
+	var b PromptBreakdown
+	msgs, ok := attrs["simpleTraces.messages"].([]any)
+	if !ok || len(msgs) == 0 {
+		return b, false
+	}
+	if si, ok := attrs["simpleTraces.system_instruction"].(string); ok {
+		b.System += estimateTokens(si)
+	}
+
+	// the last user message is the current turn; everything else from earlier turns is history
+	lastUser := -1
+	for i, item := range msgs {
+		if m, ok := item.(map[string]any); ok {
+			if role, _ := m["role"].(string); strings.EqualFold(role, "user") && !isToolResult(m) {
+				lastUser = i
+			}
+		}
+	}
+	for i, item := range msgs {
+		m, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		role, _ := m["role"].(string)
+		tokens := estimateTokens(messageText(m))
+		switch {
+		case strings.EqualFold(role, "system") || strings.EqualFold(role, "developer"):
+			b.System += tokens
+		case strings.EqualFold(role, "tool") || strings.EqualFold(role, "function") || isToolResult(m):
+			b.Tool += tokens
+		case i == lastUser:
+			b.User += tokens
+		default:
+			b.History += tokens
+		}
+	}
+	return b, true
+}
+
+// messageText concatenates the text of a message in either the parts[] (Gemini) or
+// content (OpenAI, string or array of parts) shape. Tool results are included as JSON.
+func messageText(m map[string]any) string {
+	var sb strings.Builder
+	appendPart := func(p any) {
+		switch pv := p.(type) {
+		case string:
+			sb.WriteString(pv)
+		case map[string]any:
+			if t, ok := pv["text"].(string); ok {
+				sb.WriteString(t)
+			}
+			for _, k := range []string{"function_response", "functionResponse", "function_call", "functionCall"} {
+				if v, ok := pv[k]; ok {
+					if raw, err := json.Marshal(v); err == nil {
+						sb.Write(raw)
+					}
+				}
+			}
+		}
+	}
+	if parts, ok := m["parts"].([]any); ok {
+		for _, p := range parts {
+			appendPart(p)
+		}
+	}
+	switch c := m["content"].(type) {
+	case string:
+		sb.WriteString(c)
+	case []any:
+		for _, p := range c {
+			appendPart(p)
+		}
+	}
+	return sb.String()
+}
+
+// isToolResult reports whether a user-role message only carries function responses (Gemini)
+func isToolResult(m map[string]any) bool {
+	parts, ok := m["parts"].([]any)
+	if !ok || len(parts) == 0 {
+		return false
+	}
+	for _, p := range parts {
+		pm, ok := p.(map[string]any)
+		if !ok {
+			return false
+		}
+		_, a := pm["function_response"]
+		_, b := pm["functionResponse"]
+		if !a && !b {
+			return false
+		}
+	}
+	return true
+}
+
+// PromptBreakdownStats aggregates prompt breakdowns per model
+type PromptBreakdownStats struct {
+	Model         string  `json:"model"`
+	Spans         int64   `json:"spans"`
+	SystemTokens  int64   `json:"system_tokens"`
+	UserTokens    int64   `json:"user_tokens"`
+	HistoryTokens int64   `json:"history_tokens"`
+	ToolTokens    int64   `json:"tool_tokens"`
+	SystemShare   float64 `json:"system_share"`
+	UserShare     float64 `json:"user_share"`
+	HistoryShare  float64 `json:"history_share"`
+	ToolShare     float64 `json:"tool_share"`
+}
+
+// GetPromptBreakdownStats sums estimated prompt tokens by role per model
+func (g *GormDB) GetPromptBreakdownStats(filter StatsFilter) ([]PromptBreakdownStats, error) {
+	var rows []PromptBreakdownStats
+	if err := filter.apply(g.db.Model(&Span{})).
+		Select(`model, COUNT(*) AS spans,
+			SUM(prompt_tokens_system) AS system_tokens,
+			SUM(prompt_tokens_user) AS user_tokens,
+			SUM(prompt_tokens_history) AS history_tokens,
+			SUM(prompt_tokens_tool) AS tool_tokens`).
+		Where("model <> ''").
+		Where("prompt_tokens_system + prompt_tokens_user + prompt_tokens_history + prompt_tokens_tool > 0").
+		Group("model").
+		Order("spans DESC").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	for i := range rows {
+		r := &rows[i]
+		total := r.SystemTokens + r.UserTokens + r.HistoryTokens + r.ToolTokens
+		r.SystemShare = ratio(r.SystemTokens, total)
+		r.UserShare = ratio(r.UserTokens, total)
+		r.HistoryShare = ratio(r.HistoryTokens, total)
+		r.ToolShare = ratio(r.ToolTokens, total)
+	}
+	return rows, nil
+}
+
+// getPromptBreakdownStatsHandler reports how context is split between system prompt,
+// current user input, history and tool/retrieved content per model
+func getPromptBreakdownStatsHandler(db Database, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := parseStatsFilter(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		stats, err := db.GetPromptBreakdownStats(filter)
+		if err != nil {
+			logger.Error("Failed to get prompt breakdown stats: %v", err)
+			http.Error(w, fmt.Sprintf("Failed to get prompt breakdown stats: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if stats == nil {
+			stats = []PromptBreakdownStats{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats)
+	}
+}
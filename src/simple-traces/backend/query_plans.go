@@ -0,0 +1,112 @@
+package backend
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// redundantSpanIndexes were replaced by composite indexes that start with the same column
+var redundantSpanIndexes = []string{"idx_trace_id", "idx_group_id", "idx_spans_project_id", "idx_spans_conversation_id"}
+
+// dropRedundantIndexes removes indexes of older versions that only slow down ingest now
+func dropRedundantIndexes(db *gorm.DB) {
+	m := db.Migrator()
+	for _, name := range redundantSpanIndexes {
+		if m.HasIndex(&Span{}, name) {
+			m.DropIndex(&Span{}, name)
+		}
+	}
+}
+
+// QueryPlan is the database's plan for one of the hot list/search queries
+type QueryPlan struct {
+	Name string   `json:"name"`
+	SQL  string   `json:"sql"`
+	Plan []string `json:"plan"`
+	// FullScan is set when the spans table is read without an index
+	FullScan bool `json:"full_scan"`
+}
+
+// hotQueries builds the queries behind the trace group, span and conversation views
+func hotQueries(db *gorm.DB) map[string]string {
+	var rows []map[string]any
+	since := time.Now().Add(-24 * time.Hour)
+	return map[string]string{
+		"trace groups": db.ToSQL(func(tx *gorm.DB) *gorm.DB {
+			return tx.Model(&Span{}).
+				Select("trace_id, MIN(start_time) as first_start_time, MAX(end_time) as last_end_time, COUNT(*) as span_count, " +
+					"SUM(CASE WHEN status_code = 'ERROR' THEN 1 ELSE 0 END) as error_count").
+				Group("trace_id").Order("MAX(end_time) DESC").Limit(100).Scan(&rows)
+		}),
+		"trace group spans": db.ToSQL(func(tx *gorm.DB) *gorm.DB {
+			return tx.Model(&Span{}).Where("trace_id = ?", "t").Order("start_time ASC, seq ASC, span_id ASC").Limit(1000).Find(&rows)
+		}),
+		"trace group spans after cursor": db.ToSQL(func(tx *gorm.DB) *gorm.DB {
+			return tx.Model(&Span{}).Where("trace_id = ?", "t").
+				Where("end_time > ? OR (end_time = ? AND span_id > ?)", since, since, "s").
+				Order("end_time ASC, span_id ASC").Limit(1000).Find(&rows)
+		}),
+		"spans": db.ToSQL(func(tx *gorm.DB) *gorm.DB {
+			return tx.Model(&Span{}).Order("start_time DESC").Limit(1000).Find(&rows)
+		}),
+		"project spans": db.ToSQL(func(tx *gorm.DB) *gorm.DB {
+			return tx.Model(&Span{}).Where("project_id = ?", "default").Order("start_time DESC").Limit(1000).Find(&rows)
+		}),
+		"conversation spans": db.ToSQL(func(tx *gorm.DB) *gorm.DB {
+			return tx.Model(&Span{}).Where("conversation_id = ?", "c").Order("start_time ASC, seq ASC, span_id ASC").Limit(1000).Find(&rows)
+		}),
+		"stats window": db.ToSQL(func(tx *gorm.DB) *gorm.DB {
+			return tx.Model(&Span{}).Select("model, COUNT(*)").Where("start_time >= ?", since).Group("model").Scan(&rows)
+		}),
+		"conversations": db.ToSQL(func(tx *gorm.DB) *gorm.DB {
+			return tx.Model(&Conversation{}).Order("last_end_time DESC").Limit(100).Find(&rows)
+		}),
+	}
+}
+
+// ExplainQueries returns the plans of the hot queries. Only SQLite plans are checked for full
+// scans; PostgreSQL picks sequential scans on small tables regardless of indexes.
+func (g *GormDB) ExplainQueries() ([]QueryPlan, error) {
+	dialect := g.db.Dialector.Name()
+	var plans []QueryPlan
+	for name, sql := range hotQueries(g.db) {
+		p := QueryPlan{Name: name, SQL: sql}
+		if dialect == "postgres" {
+			if err := g.db.Raw("EXPLAIN " + sql).Scan(&p.Plan).Error; err != nil {
+				return nil, err
+			}
+		} else {
+			var steps []struct{ Detail string }
+			if err := g.db.Raw("EXPLAIN QUERY PLAN " + sql).Scan(&steps).Error; err != nil {
+				return nil, err
+			}
+			for _, s := range steps {
+				p.Plan = append(p.Plan, s.Detail)
+				// e.g. "SCAN spans" as opposed to "SCAN spans USING INDEX ..." or "SEARCH spans ..."
+				if strings.HasPrefix(s.Detail, "SCAN ") && !strings.Contains(s.Detail, " INDEX ") {
+					p.FullScan = true
+				}
+			}
+		}
+		plans = append(plans, p)
+	}
+	return plans, nil
+}
+
+// checkQueryPlans logs the plans of the hot queries and warns about full table scans
+func checkQueryPlans(db Database, logger *Logger) {
+	plans, err := db.ExplainQueries()
+	if err != nil {
+		logger.Warn("Query plan check failed: %v", err)
+		return
+	}
+	for _, p := range plans {
+		if p.FullScan {
+			logger.Warn("Query plan for %s reads the whole table: %s (%s)", p.Name, strings.Join(p.Plan, "; "), p.SQL)
+		} else {
+			logger.Debug("Query plan for %s: %s", p.Name, strings.Join(p.Plan, "; "))
+		}
+	}
+}
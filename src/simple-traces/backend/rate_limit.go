@@ -0,0 +1,141 @@
+package backend
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	tracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/grpc/codes"
+)
+
+// rateLimitIdle is how long a source's buckets are kept after its last request
+const rateLimitIdle = 10 * time.Minute
+
+// IngestRateLimit limits how many export requests and spans per second each source (client
+// address) may send, so a runaway exporter can't saturate the database. Every source has a
+// token bucket per limit holding up to one second of its rate. A request is admitted while its
+// bucket is positive and then takes its full cost, so one export larger than the bucket still
+// passes and the source waits for the debt to refill.
+type IngestRateLimit struct {
+	requests, spans float64
+
+	mu        sync.Mutex
+	sources   map[string]*rateSource
+	lastSweep time.Time
+}
+
+type rateSource struct {
+	requests, spans tokenBucket
+	lastSeen        time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take refills the bucket and takes n tokens when it is positive; otherwise it returns how long
+// until it will be
+func (b *tokenBucket) take(rate, n float64, now time.Time) time.Duration {
+	if b.last.IsZero() {
+		b.tokens = rate
+	} else {
+		b.tokens = math.Min(rate, b.tokens+now.Sub(b.last).Seconds()*rate)
+	}
+	b.last = now
+	if b.tokens <= 0 {
+		return time.Duration((-b.tokens + 1) / rate * float64(time.Second))
+	}
+	b.tokens -= n
+	return 0
+}
+
+// NewIngestRateLimit creates limits of requests and spans per second per source; a limit of
+// zero or less is not enforced, and nil is returned when neither is
+func NewIngestRateLimit(requests, spans int) *IngestRateLimit {
+	if requests <= 0 && spans <= 0 {
+		return nil
+	}
+	metrics.Describe("simpletraces_ingest_rate_limited_total", "counter", "Export requests answered with 429 by limit (requests or spans)")
+	return &IngestRateLimit{requests: float64(requests), spans: float64(spans), sources: make(map[string]*rateSource)}
+}
+
+// AllowRequest counts a request of source; the wait is set when it is over the request limit
+func (l *IngestRateLimit) AllowRequest(source string) (wait time.Duration) {
+	if l == nil || l.requests <= 0 {
+		return 0
+	}
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	wait = l.source(source, now).requests.take(l.requests, 1, now)
+	if wait > 0 {
+		metrics.Inc("simpletraces_ingest_rate_limited_total", "limit", "requests")
+	}
+	return wait
+}
+
+// AllowSpans counts n spans of source; the wait is set when it is over the span limit
+func (l *IngestRateLimit) AllowSpans(source string, n int) (wait time.Duration) {
+	if l == nil || l.spans <= 0 || n == 0 {
+		return 0
+	}
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	wait = l.source(source, now).spans.take(l.spans, float64(n), now)
+	if wait > 0 {
+		metrics.Inc("simpletraces_ingest_rate_limited_total", "limit", "spans")
+	}
+	return wait
+}
+
+// source returns the buckets of a source and forgets idle ones; callers hold mu
+func (l *IngestRateLimit) source(key string, now time.Time) *rateSource {
+	if now.Sub(l.lastSweep) > time.Minute {
+		l.lastSweep = now
+		for k, s := range l.sources {
+			if now.Sub(s.lastSeen) > rateLimitIdle {
+				delete(l.sources, k)
+			}
+		}
+	}
+	s := l.sources[key]
+	if s == nil {
+		s = &rateSource{}
+		l.sources[key] = s
+	}
+	s.lastSeen = now
+	return s
+}
+
+// countSpans counts the spans of an export
+func countSpans(req *tracepb.ExportTraceServiceRequest) int {
+	n := 0
+	for _, rs := range req.ResourceSpans {
+		for _, ss := range rs.ScopeSpans {
+			n += len(ss.Spans)
+		}
+	}
+	return n
+}
+
+// rateLimitSource identifies the sender of a request by its address
+func rateLimitSource(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// writeRateLimited answers 429 with a Retry-After of whole seconds, which OTLP exporters honor
+// before retrying
+func writeRateLimited(w http.ResponseWriter, wait time.Duration, msg string) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	writeOTLPError(w, http.StatusTooManyRequests, codes.ResourceExhausted, msg)
+}
@@ -0,0 +1,68 @@
+package backend
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RequestLogRules decides which requests loggingMiddleware logs: excluded paths are never
+// logged and sampled paths only for a fraction of requests. Failed requests (4xx/5xx) of
+// sampled paths are always logged.
+type RequestLogRules struct {
+	exclude []string
+	sample  []sampledPath
+}
+
+type sampledPath struct {
+	pattern string
+	rate    float64
+}
+
+// ParseRequestLogRules reads comma-separated exclusions ("/assets/*,/favicon.ico") and
+// "path=rate" samples ("/v1/traces=0.01"); patterns ending in * match prefixes
+func ParseRequestLogRules(exclude, sample string) (*RequestLogRules, error) {
+	rules := &RequestLogRules{exclude: splitList(exclude)}
+	for _, part := range splitList(sample) {
+		pattern, rateStr, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("request log sample %q: want path=rate", part)
+		}
+		rate, err := strconv.ParseFloat(strings.TrimSpace(rateStr), 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("request log sample %q: rate must be between 0 and 1", part)
+		}
+		rules.sample = append(rules.sample, sampledPath{pattern: strings.TrimSpace(pattern), rate: rate})
+	}
+	// longest pattern wins
+	sort.SliceStable(rules.sample, func(i, j int) bool { return len(rules.sample[i].pattern) > len(rules.sample[j].pattern) })
+	return rules, nil
+}
+
+// Excluded reports whether requests to path are never logged
+func (r *RequestLogRules) Excluded(path string) bool {
+	if r == nil {
+		return false
+	}
+	for _, p := range r.exclude {
+		if pathMatches(p, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// Sampled reports whether a finished request is logged
+func (r *RequestLogRules) Sampled(path string, status int) bool {
+	if r == nil || status >= 400 {
+		return true
+	}
+	for _, s := range r.sample {
+		if pathMatches(s.pattern, path) {
+			return rand.Float64() < s.rate
+		}
+	}
+	return true
+}
@@ -0,0 +1,369 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// RetrievedDocument is a document returned by a retrieval span (vector DB query, RAG step)
+type RetrievedDocument struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	SpanID         string    `gorm:"index" json:"span_id"`
+	TraceID        string    `gorm:"index" json:"trace_id"`
+	ConversationID string    `gorm:"index" json:"conversation_id,omitempty"`
+	ProjectID      string    `gorm:"index" json:"project_id"`
+	Position       int       `json:"position"`
+	DocumentID     string    `gorm:"index" json:"document_id,omitempty"`
+	Score          *float64  `json:"score,omitempty"`
+	Content        string    `gorm:"type:text" json:"content,omitempty"`
+	Metadata       string    `gorm:"type:text" json:"metadata,omitempty"`
+	Source         string    `json:"source,omitempty"`
+	RetrievedAt    time.Time `json:"retrieved_at"`
+}
+
+// matches OpenInference-style flattened keys: retrieval.documents.<i>.document.<field>
+var retrievalDocKey = regexp.MustCompile(`^retrieval\.documents\.(\d+)\.document\.(id|content|score|metadata)$`)
+
+// extractRetrievedDocuments parses retrieval.documents attributes of a stored span.
+// Both flattened keys and an array (or JSON string) under retrieval.documents are supported.
+func extractRetrievedDocuments(sp Span) []RetrievedDocument {
+	if !strings.Contains(sp.Attributes, "retrieval.documents") {
+		return nil
+	}
+	var attrs map[string]any
+	if err := json.Unmarshal([]byte(sp.Attributes), &attrs); err != nil {
+		return nil
+	}
+	byPos := make(map[int]*RetrievedDocument)
+	get := func(pos int) *RetrievedDocument {
+		d := byPos[pos]
+		if d == nil {
+			d = &RetrievedDocument{Position: pos}
+			byPos[pos] = d
+		}
+		return d
+	}
+	setField := func(d *RetrievedDocument, field string, v any) {
+		switch field {
+		case "id":
+			d.DocumentID = fmt.Sprintf("%v", v)
+		case "content":
+			if s, ok := v.(string); ok {
+				d.Content = s
+			} else if b, err := json.Marshal(v); err == nil {
+				d.Content = string(b)
+			}
+		case "score":
+			if f, ok := asFloat(v); ok {
+				d.Score = &f
+			}
+		case "metadata":
+			if s, ok := v.(string); ok {
+				d.Metadata = s
+			} else if b, err := json.Marshal(v); err == nil {
+				d.Metadata = string(b)
+			}
+		}
+	}
+
+	for k, v := range attrs {
+		m := retrievalDocKey.FindStringSubmatch(k)
+		if m == nil {
+			continue
+		}
+		pos, _ := strconv.Atoi(m[1])
+		setField(get(pos), m[2], v)
+	}
+
+	var list []any
+	switch v := attrs["retrieval.documents"].(type) {
+	case []any:
+		list = v
+	case string:
+		_ = json.Unmarshal([]byte(v), &list)
+	}
+	for i, item := range list {
+		m, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		// accept {"document": {...}}, {"document.id": ...} and plain {"id": ...}
+		if inner, ok := m["document"].(map[string]any); ok {
+			m = inner
+		}
+		d := get(i)
+		for _, field := range []string{"id", "content", "score", "metadata"} {
+			if v, ok := m[field]; ok {
+				setField(d, field, v)
+			} else if v, ok := m["document."+field]; ok {
+				setField(d, field, v)
+			}
+		}
+	}
+
+	source, _ := attrs["db.system"].(string)
+	out := make([]RetrievedDocument, 0, len(byPos))
+	for _, d := range byPos {
+		d.SpanID = sp.SpanID
+		d.TraceID = sp.TraceID
+		d.ConversationID = sp.ConversationID
+		d.ProjectID = sp.ProjectID
+		d.Source = source
+		d.RetrievedAt = sp.EndTime
+		out = append(out, *d)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Position < out[j].Position })
+	return out
+}
+
+// asFloat coerces numeric attribute values (and numeric strings) to float64
+func asFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int64:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func (g *GormDB) InsertRetrievedDocuments(docs []RetrievedDocument) error {
+	if len(docs) == 0 {
+		return nil
+	}
+	return g.db.CreateInBatches(docs, 100).Error
+}
+
+// GetRetrievedDocuments lists documents for a conversation (including traces linked to it
+// after ingest) or for a single trace, in retrieval order
+func (g *GormDB) GetRetrievedDocuments(conversationID, traceID string) ([]RetrievedDocument, error) {
+	var docs []RetrievedDocument
+	query := g.db.Order("retrieved_at ASC, span_id ASC, position ASC").Limit(5000)
+	if conversationID != "" {
+		query = query.Where("conversation_id = ? OR trace_id IN (?)", conversationID,
+			g.db.Model(&Span{}).Distinct("trace_id").Where("conversation_id = ?", conversationID))
+	}
+	if traceID != "" {
+		query = query.Where("trace_id = ?", traceID)
+	}
+	if err := query.Find(&docs).Error; err != nil {
+		return nil, err
+	}
+	return docs, nil
+}
+
+// RetrievalStep groups the documents returned by one retrieval span together with the
+// LLM span that consumed them (the first LLM call in the same trace after the retrieval)
+type RetrievalStep struct {
+	SpanID       string              `json:"span_id"`
+	TraceID      string              `json:"trace_id"`
+	Source       string              `json:"source,omitempty"`
+	RetrievedAt  time.Time           `json:"retrieved_at"`
+	AnswerSpanID string              `json:"answer_span_id,omitempty"`
+	Documents    []RetrievedDocument `json:"documents"`
+}
+
+// getRetrievedDocumentsHandler lists documents fed into answers of a conversation or trace group
+func getRetrievedDocumentsHandler(db Database, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		conversationID := strings.TrimSpace(vars["id"])
+		traceID := strings.TrimSpace(vars["trace_id"])
+		docs, err := db.GetRetrievedDocuments(conversationID, traceID)
+		if err != nil {
+			logger.Error("Failed to get retrieved documents: %v", err)
+			http.Error(w, fmt.Sprintf("Failed to get documents: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		steps := []*RetrievalStep{}
+		bySpan := make(map[string]*RetrievalStep)
+		for _, d := range docs {
+			st := bySpan[d.SpanID]
+			if st == nil {
+				st = &RetrievalStep{SpanID: d.SpanID, TraceID: d.TraceID, Source: d.Source, RetrievedAt: d.RetrievedAt}
+				bySpan[d.SpanID] = st
+				steps = append(steps, st)
+			}
+			st.Documents = append(st.Documents, d)
+		}
+
+		// link each retrieval to the next LLM call of its trace
+		traceSpans := make(map[string][]Span)
+		for _, st := range steps {
+			spans, ok := traceSpans[st.TraceID]
+			if !ok {
+				spans, err = db.GetTraceGroupSpans(st.TraceID, 5000)
+				if err != nil {
+					logger.Warn("Failed to load spans of trace %s: %v", st.TraceID, err)
+				}
+				traceSpans[st.TraceID] = spans
+			}
+			for _, sp := range spans {
+				if sp.Model != "" && !sp.StartTime.Before(st.RetrievedAt) {
+					st.AnswerSpanID = sp.SpanID
+					break
+				}
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(steps)
+	}
+}
+
+// vector stores recognized from db.system values
+var vectorStores = map[string]string{
+	"pinecone": "pinecone",
+	"qdrant":   "qdrant",
+	"weaviate": "weaviate",
+	"chroma":   "chroma",
+	"chromadb": "chroma",
+	"milvus":   "milvus",
+	"lancedb":  "lancedb",
+	"pgvector": "pgvector",
+	"vespa":    "vespa",
+}
+
+// retrievalStore returns the vector store a span talks to, or "" if it is not a retrieval span.
+// It recognizes db.system values, vendor attribute prefixes (pinecone.*, qdrant.*, weaviate.*, ...),
+// pgvector distance operators in SQL and generic retrieval attributes.
+func retrievalStore(attrs map[string]any) string {
+	if sys, ok := attrs["db.system"].(string); ok {
+		if store, ok := vectorStores[strings.ToLower(sys)]; ok {
+			return store
+		}
+		if strings.HasPrefix(strings.ToLower(sys), "postgres") {
+			if stmt, ok := attrs["db.statement"].(string); ok {
+				if strings.Contains(stmt, "<->") || strings.Contains(stmt, "<=>") || strings.Contains(stmt, "<#>") {
+					return "pgvector"
+				}
+			}
+		}
+	}
+	for k := range attrs {
+		lk := strings.ToLower(k)
+		for prefix, store := range vectorStores {
+			if strings.HasPrefix(lk, prefix+".") || strings.HasPrefix(lk, "db."+prefix+".") {
+				return store
+			}
+		}
+	}
+	if kind, ok := attrs["openinference.span.kind"].(string); ok && strings.EqualFold(kind, "RETRIEVER") {
+		return "retriever"
+	}
+	for k := range attrs {
+		if strings.HasPrefix(k, "retrieval.documents") || strings.HasPrefix(k, "db.vector.") {
+			return "retriever"
+		}
+	}
+	return ""
+}
+
+// retrievalResultCount returns how many results a retrieval span returned
+func retrievalResultCount(attrs map[string]any) int64 {
+	for _, k := range []string{"db.response.returned_rows", "db.vector.query.result_count", "retrieval.result_count", "qdrant.result_count", "pinecone.result_count"} {
+		if n, ok := asInt(attrs[k]); ok {
+			return n
+		}
+	}
+	if list, ok := attrs["retrieval.documents"].([]any); ok {
+		return int64(len(list))
+	}
+	var max int64 = -1
+	for k := range attrs {
+		if m := retrievalDocKey.FindStringSubmatch(k); m != nil {
+			if pos, err := strconv.ParseInt(m[1], 10, 64); err == nil && pos > max {
+				max = pos
+			}
+		}
+	}
+	return max + 1
+}
+
+// RetrievalStats reports latency and result counts of retrieval spans per store
+type RetrievalStats struct {
+	Store      string  `json:"store"`
+	Count      int64   `json:"count"`
+	AvgMS      float64 `json:"avg_ms"`
+	P50MS      int64   `json:"p50_ms"`
+	P95MS      int64   `json:"p95_ms"`
+	AvgResults float64 `json:"avg_results"`
+	Empty      int64   `json:"empty_results"`
+}
+
+// GetRetrievalStats computes per-store latency percentiles and result counts
+func (g *GormDB) GetRetrievalStats(filter StatsFilter) ([]RetrievalStats, error) {
+	var rows []struct {
+		RetrievalStore string
+		DurationMS     int64
+		RetrievalCount int64
+	}
+	if err := filter.apply(g.db.Model(&Span{})).
+		Select("retrieval_store, duration_ms, retrieval_count").
+		Where("category = ?", "retrieval").
+		Limit(200000).
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	durations := make(map[string][]int64)
+	counts := make(map[string][]int64)
+	for _, r := range rows {
+		durations[r.RetrievalStore] = append(durations[r.RetrievalStore], r.DurationMS)
+		counts[r.RetrievalStore] = append(counts[r.RetrievalStore], r.RetrievalCount)
+	}
+	out := make([]RetrievalStats, 0, len(durations))
+	for store, ds := range durations {
+		st := RetrievalStats{
+			Store:      store,
+			Count:      int64(len(ds)),
+			AvgMS:      average(ds),
+			P50MS:      percentile(ds, 50),
+			P95MS:      percentile(ds, 95),
+			AvgResults: average(counts[store]),
+		}
+		for _, c := range counts[store] {
+			if c == 0 {
+				st.Empty++
+			}
+		}
+		out = append(out, st)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Count > out[j].Count })
+	return out, nil
+}
+
+// getRetrievalStatsHandler returns retrieval latency and result counts per vector store
+func getRetrievalStatsHandler(db Database, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := parseStatsFilter(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		stats, err := db.GetRetrievalStats(filter)
+		if err != nil {
+			logger.Error("Failed to get retrieval stats: %v", err)
+			http.Error(w, fmt.Sprintf("Failed to get retrieval stats: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats)
+	}
+}
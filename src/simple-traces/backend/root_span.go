@@ -0,0 +1,175 @@
+package backend
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// rootSpanSQL matches root spans, the entry points of their traces
+const rootSpanSQL = "(parent_span_id = '' OR parent_span_id IS NULL)"
+
+// spanParentID encodes a parent span id. Some SDKs send an all-zero id instead of none for
+// root spans; both are stored empty so that root spans are found the same way.
+func spanParentID(id []byte) string {
+	for _, b := range id {
+		if b != 0 {
+			return hex.EncodeToString(id)
+		}
+	}
+	return ""
+}
+
+// attachRootSpans fills the entry point of trace groups whose root span has arrived. A trace
+// with several root spans (broken propagation) takes the earliest.
+func (g *GormDB) attachRootSpans(groups []TraceGroup) {
+	if len(groups) == 0 {
+		return
+	}
+	ids := make([]string, len(groups))
+	for i, gr := range groups {
+		ids[i] = gr.TraceID
+	}
+	var roots []Span
+	if err := g.db.Select("trace_id, name, attributes").
+		Where("trace_id IN ? AND "+rootSpanSQL, ids).
+		Order("start_time ASC, seq ASC").
+		Find(&roots).Error; err != nil {
+		return
+	}
+	byTrace := make(map[string]Span, len(groups))
+	for _, sp := range roots {
+		if _, ok := byTrace[sp.TraceID]; !ok {
+			byTrace[sp.TraceID] = sp
+		}
+	}
+	for i := range groups {
+		if root, ok := byTrace[groups[i].TraceID]; ok {
+			groups[i].RootName = root.Name
+			groups[i].RootAttributes = root.Attributes
+		}
+	}
+}
+
+// EntryPointStats aggregates the traces started by one root span name
+type EntryPointStats struct {
+	Name string `json:"name"`
+	// Traces whose root span started in the queried range
+	Traces int64 `json:"traces"`
+	// ErrorTraces have at least one span in ERROR status
+	ErrorTraces int64   `json:"error_traces"`
+	ErrorRate   float64 `json:"error_rate"`
+	// Duration of the root span
+	AvgMS float64 `json:"avg_ms"`
+	P50MS int64   `json:"p50_ms"`
+	P95MS int64   `json:"p95_ms"`
+	P99MS int64   `json:"p99_ms"`
+	// AvgSpans is the average span count of the traces
+	AvgSpans float64 `json:"avg_spans"`
+}
+
+// GetEntryPointStats groups traces by the name of their root span, most frequent first
+func (g *GormDB) GetEntryPointStats(filter StatsFilter) ([]EntryPointStats, error) {
+	var roots []struct {
+		TraceID    string
+		Name       string
+		DurationMS int64
+	}
+	if err := filter.apply(g.db.Model(&Span{})).
+		Select("trace_id, name, duration_ms").
+		Where(rootSpanSQL).
+		Limit(200000).
+		Scan(&roots).Error; err != nil {
+		return nil, err
+	}
+	if len(roots) == 0 {
+		return []EntryPointStats{}, nil
+	}
+
+	var counts []struct {
+		TraceID    string
+		SpanCount  int64
+		ErrorCount int64
+	}
+	rootTraces := filter.apply(g.db.Model(&Span{})).Select("trace_id").Where(rootSpanSQL)
+	if err := g.db.Model(&Span{}).
+		Select("trace_id, COUNT(*) AS span_count, SUM(CASE WHEN status_code = 'ERROR' THEN 1 ELSE 0 END) AS error_count").
+		Where("trace_id IN (?)", rootTraces).
+		Group("trace_id").
+		Scan(&counts).Error; err != nil {
+		return nil, err
+	}
+	type traceCounts struct{ spans, errors int64 }
+	byTrace := make(map[string]traceCounts, len(counts))
+	for _, c := range counts {
+		byTrace[c.TraceID] = traceCounts{c.SpanCount, c.ErrorCount}
+	}
+
+	type acc struct {
+		durations     []int64
+		spans, errors int64
+		traces        map[string]bool
+	}
+	byName := make(map[string]*acc)
+	for _, r := range roots {
+		a := byName[r.Name]
+		if a == nil {
+			a = &acc{traces: make(map[string]bool)}
+			byName[r.Name] = a
+		}
+		a.durations = append(a.durations, r.DurationMS)
+		// a trace with several roots of the same name counts once
+		if a.traces[r.TraceID] {
+			continue
+		}
+		a.traces[r.TraceID] = true
+		c := byTrace[r.TraceID]
+		a.spans += c.spans
+		if c.errors > 0 {
+			a.errors++
+		}
+	}
+	stats := make([]EntryPointStats, 0, len(byName))
+	for name, a := range byName {
+		traces := int64(len(a.traces))
+		stats = append(stats, EntryPointStats{
+			Name:        name,
+			Traces:      traces,
+			ErrorTraces: a.errors,
+			ErrorRate:   ratio(a.errors, traces),
+			AvgMS:       average(a.durations),
+			P50MS:       percentile(a.durations, 50),
+			P95MS:       percentile(a.durations, 95),
+			P99MS:       percentile(a.durations, 99),
+			AvgSpans:    float64(a.spans) / float64(traces),
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Traces != stats[j].Traces {
+			return stats[i].Traces > stats[j].Traces
+		}
+		return stats[i].Name < stats[j].Name
+	})
+	return stats, nil
+}
+
+// getEntryPointStatsHandler returns trace stats grouped by entry-point operation
+func getEntryPointStatsHandler(db Database, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := parseStatsFilter(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		stats, err := db.GetEntryPointStats(filter)
+		if err != nil {
+			logger.Error("Failed to get entry point stats: %v", err)
+			http.Error(w, fmt.Sprintf("Failed to get entry point stats: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats)
+	}
+}
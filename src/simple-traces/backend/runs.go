@@ -0,0 +1,150 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// TraceRun groups the traces tagged with the same simpleTraces.run.id, e.g. one eval sweep or CI job.
+// A trace belongs to a run when any of its spans carries the run id.
+type TraceRun struct {
+	ID             string      `json:"id"`
+	ProjectID      string      `json:"project_id"`
+	FirstStartTime time.Time   `json:"first_start_time"`
+	LastEndTime    time.Time   `json:"last_end_time"`
+	Metrics        SpanMetrics `json:"metrics"`
+}
+
+// runTraceIDs is a subquery of the trace ids that belong to a run
+func (g *GormDB) runTraceIDs(runID string) *gorm.DB {
+	return g.db.Model(&Span{}).Distinct("trace_id").Where("run_id = ?", runID)
+}
+
+// GetRuns lists runs by most recent activity, each with metrics over all spans of its traces
+func (g *GormDB) GetRuns(limit int, before time.Time, projectID string) ([]TraceRun, error) {
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	var rows []struct {
+		RunID          string
+		ProjectID      string
+		FirstStartTime dbTime
+		LastEndTime    dbTime
+	}
+	query := g.db.Model(&Span{}).
+		Select("run_id, MIN(project_id) as project_id, MIN(start_time) as first_start_time, MAX(end_time) as last_end_time").
+		Where("run_id <> ''").
+		Group("run_id").
+		Order("MAX(end_time) DESC").
+		Limit(limit)
+	if projectID != "" {
+		query = query.Where("project_id = ?", projectID)
+	}
+	if !before.IsZero() {
+		query = query.Having("MAX(end_time) < ?", before)
+	}
+	if err := query.Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	runs := make([]TraceRun, 0, len(rows))
+	for _, r := range rows {
+		m, err := g.GetSpanMetrics(SpanFilter{RunID: r.RunID})
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, TraceRun{
+			ID:             r.RunID,
+			ProjectID:      r.ProjectID,
+			FirstStartTime: r.FirstStartTime.Time,
+			LastEndTime:    r.LastEndTime.Time,
+			Metrics:        m,
+		})
+	}
+	return runs, nil
+}
+
+// GetRun returns one run, or nil when no span carries the id
+func (g *GormDB) GetRun(runID string) (*TraceRun, error) {
+	var row struct {
+		ProjectID      string
+		FirstStartTime dbTime
+		LastEndTime    dbTime
+		Spans          int64
+	}
+	if err := g.db.Model(&Span{}).
+		Select("MIN(project_id) as project_id, MIN(start_time) as first_start_time, MAX(end_time) as last_end_time, COUNT(*) as spans").
+		Where("trace_id IN (?)", g.runTraceIDs(runID)).
+		Scan(&row).Error; err != nil {
+		return nil, err
+	}
+	if row.Spans == 0 {
+		return nil, nil
+	}
+	m, err := g.GetSpanMetrics(SpanFilter{RunID: runID})
+	if err != nil {
+		return nil, err
+	}
+	return &TraceRun{
+		ID:             runID,
+		ProjectID:      row.ProjectID,
+		FirstStartTime: row.FirstStartTime.Time,
+		LastEndTime:    row.LastEndTime.Time,
+		Metrics:        m,
+	}, nil
+}
+
+// getRunsHandler lists runs (?project=, ?limit=, ?before= on last activity)
+func getRunsHandler(db Database, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		limit := 50
+		if s := strings.TrimSpace(q.Get("limit")); s != "" {
+			if v, err := strconv.Atoi(s); err == nil && v > 0 {
+				limit = v
+			}
+		}
+		var before time.Time
+		if s := strings.TrimSpace(q.Get("before")); s != "" {
+			t, err := parseTimeParam(s)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid before: %v", err), http.StatusBadRequest)
+				return
+			}
+			before = t
+		}
+		runs, err := db.GetRuns(limit, before, strings.TrimSpace(q.Get("project")))
+		if err != nil {
+			logger.Error("Failed to get runs: %v", err)
+			http.Error(w, fmt.Sprintf("Failed to get runs: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(runs)
+	}
+}
+
+// getRunHandler returns one run with its metrics
+func getRunHandler(db Database, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		runID := strings.TrimSpace(mux.Vars(r)["id"])
+		run, err := db.GetRun(runID)
+		if err != nil {
+			logger.Error("Failed to get run %s: %v", runID, err)
+			http.Error(w, fmt.Sprintf("Failed to get run: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if run == nil {
+			http.Error(w, "Run not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(run)
+	}
+}
@@ -0,0 +1,62 @@
+package backend
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// schemaVersion is the database schema this binary expects. Bump it with every model change
+// that needs a migration, so binaries older than a database refuse to run against it instead
+// of misreading or silently dropping columns they don't know.
+const schemaVersion = 13
+
+// SchemaInfo records the schema version of a database in its single row
+type SchemaInfo struct {
+	ID        int `gorm:"primaryKey"`
+	Version   int
+	UpdatedAt time.Time
+}
+
+// TableName keeps the singular name, the table holds one row
+func (SchemaInfo) TableName() string {
+	return "schema_info"
+}
+
+// checkSchemaVersion compares the version stored in the database with schemaVersion and reports
+// whether the schema should be migrated. Empty databases are always created; older schemas are
+// only migrated with autoMigrate, and newer ones are refused.
+func checkSchemaVersion(db *gorm.DB, autoMigrate bool) (bool, error) {
+	m := db.Migrator()
+	if !m.HasTable(&Span{}) {
+		return true, nil
+	}
+	stored := 0 // databases created before versioning
+	if m.HasTable(&SchemaInfo{}) {
+		var info SchemaInfo
+		if err := db.Limit(1).Find(&info).Error; err != nil {
+			return false, fmt.Errorf("read schema version: %w", err)
+		}
+		stored = info.Version
+	}
+	switch {
+	case stored > schemaVersion:
+		return false, fmt.Errorf("database schema version %d is newer than this binary supports (%d); "+
+			"upgrade simple-traces, or point DB_CONNECTION at a database this version created", stored, schemaVersion)
+	case stored < schemaVersion && !autoMigrate:
+		return false, fmt.Errorf("database schema version %d is older than this binary expects (%d); "+
+			"back up the database and start once with DB_AUTO_MIGRATE=true to upgrade it", stored, schemaVersion)
+	}
+	return autoMigrate, nil
+}
+
+// recordSchemaVersion stores schemaVersion after a successful migration
+func recordSchemaVersion(db *gorm.DB) error {
+	if err := db.AutoMigrate(&SchemaInfo{}); err != nil {
+		return err
+	}
+	return db.Clauses(clause.OnConflict{UpdateAll: true}).
+		Create(&SchemaInfo{ID: 1, Version: schemaVersion}).Error
+}
@@ -0,0 +1,210 @@
+package backend
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// newHTTPServer builds the API/ingest server from config. Besides HTTP/1.1 it accepts
+// HTTP/2 over cleartext (h2c) so OTLP exporters can multiplex on one long-lived connection.
+func newHTTPServer(config Config, handler http.Handler) *http.Server {
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetUnencryptedHTTP2(config.H2C)
+	conns := newConnTracker()
+	return &http.Server{
+		Handler:           conns.countRequests(handler),
+		ReadHeaderTimeout: config.ReadHeaderTimeout,
+		ReadTimeout:       config.ReadTimeout,
+		WriteTimeout:      config.WriteTimeout,
+		IdleTimeout:       config.IdleTimeout,
+		MaxHeaderBytes:    config.MaxHeaderBytes,
+		Protocols:         protocols,
+		HTTP2: &http.HTTP2Config{
+			MaxConcurrentStreams: config.HTTP2MaxStreams,
+			// keep idle exporter connections healthy through NATs and load balancers
+			SendPingTimeout: config.IdleTimeout / 2,
+		},
+		ConnState: conns.track,
+	}
+}
+
+// listen opens the server socket described by spec:
+//
+//	"" or "[host]:port"  TCP, with the configured keep-alive period
+//	"unix:/path/to.sock" unix domain socket, created with UnixSocketMode
+//	"systemd[:name]"     socket inherited through systemd socket activation
+func listen(spec string, config Config) (net.Listener, error) {
+	switch {
+	case strings.HasPrefix(spec, "unix:"):
+		return listenUnix(strings.TrimPrefix(spec, "unix:"), config.UnixSocketMode)
+	case spec == "systemd" || strings.HasPrefix(spec, "systemd:"):
+		return systemdListener(strings.TrimPrefix(strings.TrimPrefix(spec, "systemd"), ":"))
+	}
+	lc := net.ListenConfig{KeepAlive: config.TCPKeepAlive}
+	return lc.Listen(context.Background(), "tcp", spec)
+}
+
+func listenUnix(path string, mode fs.FileMode) (net.Listener, error) {
+	if path == "" {
+		return nil, fmt.Errorf("unix socket path is empty")
+	}
+	// a socket file left behind by an unclean shutdown would make bind fail
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&fs.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use by another process", path)
+		}
+		os.Remove(path)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// systemdListener returns a socket passed by systemd (LISTEN_PID/LISTEN_FDS, starting at fd 3).
+// With a name, the socket whose FileDescriptorName= matches is used, otherwise the first one.
+func systemdListener(name string) (net.Listener, error) {
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, errors.New("no sockets passed by systemd (LISTEN_PID not set for this process)")
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count < 1 {
+		return nil, errors.New("no sockets passed by systemd (LISTEN_FDS)")
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	const firstFD = 3
+	for i := 0; i < count; i++ {
+		if name != "" && (i >= len(names) || names[i] != name) {
+			continue
+		}
+		f := os.NewFile(uintptr(firstFD+i), "systemd-socket")
+		ln, err := net.FileListener(f)
+		f.Close() // FileListener dups the descriptor
+		if err != nil {
+			return nil, fmt.Errorf("systemd socket %d: %w", i, err)
+		}
+		return ln, nil
+	}
+	return nil, fmt.Errorf("no systemd socket named %q (LISTEN_FDNAMES=%s)", name, os.Getenv("LISTEN_FDNAMES"))
+}
+
+// bearerAuthMiddleware rejects requests without "Authorization: Bearer <token>"; scope labels
+// rejections in metrics (e.g. ingest, admin)
+func bearerAuthMiddleware(token, scope string) func(http.Handler) http.Handler {
+	metrics.Describe("simpletraces_unauthorized_requests_total", "counter", "Requests rejected for a missing or wrong bearer token, by scope")
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			// browsers can't set headers on WebSocket handshakes
+			if !ok && strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+				got = r.URL.Query().Get("access_token")
+				ok = got != ""
+			}
+			if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				metrics.Inc("simpletraces_unauthorized_requests_total", "scope", scope)
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// loopbackOnlyMiddleware serves requests only from the local machine: loopback TCP peers and
+// unix sockets. Requests relayed by a proxy (X-Forwarded-For or Forwarded set) are refused as
+// well, since behind a local reverse proxy every peer is loopback.
+func loopbackOnlyMiddleware(scope string) func(http.Handler) http.Handler {
+	metrics.Describe("simpletraces_forbidden_requests_total", "counter", "Requests from other hosts refused on local-only endpoints, by scope")
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !isLocalRequest(r) {
+				metrics.Inc("simpletraces_forbidden_requests_total", "scope", scope)
+				http.Error(w, "forbidden: only served on localhost without a token", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// isLocalRequest reports whether r came straight from the local machine
+func isLocalRequest(r *http.Request) bool {
+	if r.Header.Get("X-Forwarded-For") != "" || r.Header.Get("Forwarded") != "" {
+		return false
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		// unix socket peers have no host:port address
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// connTracker exports connection counts and the protocol of served requests to /metrics
+type connTracker struct {
+	mu     sync.Mutex
+	states map[net.Conn]http.ConnState
+}
+
+func newConnTracker() *connTracker {
+	metrics.Describe("simpletraces_http_connections_total", "counter", "Accepted HTTP connections")
+	metrics.Describe("simpletraces_http_connections", "gauge", "Open HTTP connections by state (active or idle)")
+	metrics.Describe("simpletraces_http_requests_total", "counter", "HTTP requests by protocol")
+	metrics.Set("simpletraces_http_connections", 0, "state", "active")
+	metrics.Set("simpletraces_http_connections", 0, "state", "idle")
+	return &connTracker{states: make(map[net.Conn]http.ConnState)}
+}
+
+func (t *connTracker) track(c net.Conn, state http.ConnState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	prev, known := t.states[c]
+	if known {
+		t.gauge(prev, -1)
+	}
+	switch state {
+	case http.StateNew:
+		metrics.Inc("simpletraces_http_connections_total")
+		t.states[c] = state
+	case http.StateHijacked, http.StateClosed:
+		delete(t.states, c)
+	default:
+		t.states[c] = state
+		t.gauge(state, 1)
+	}
+}
+
+// gauge adjusts the open connection gauge; new connections are counted once they turn active
+func (t *connTracker) gauge(state http.ConnState, delta float64) {
+	switch state {
+	case http.StateActive:
+		metrics.Add("simpletraces_http_connections", delta, "state", "active")
+	case http.StateIdle:
+		metrics.Add("simpletraces_http_connections", delta, "state", "idle")
+	}
+}
+
+func (t *connTracker) countRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		metrics.Inc("simpletraces_http_requests_total", "proto", r.Proto)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,162 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxSlowestTraces bounds the leaderboard size
+const maxSlowestTraces = 200
+
+// SlowTrace is an entry of the slowest traces leaderboard: the trace group summary plus its
+// duration, the span that dominated its critical path and links for drilling in
+type SlowTrace struct {
+	TraceGroup
+	Rank           int    `json:"rank"`
+	DurationMS     int64  `json:"duration_ms"`
+	ProjectID      string `json:"project_id"`
+	ConversationID string `json:"conversation_id,omitempty"`
+	// Bottleneck is the span with the most time on the critical path
+	Bottleneck *CriticalSpan `json:"bottleneck,omitempty"`
+	// Links to the trace, its critical path and its conversation
+	Links map[string]string `json:"links"`
+}
+
+// GetSlowestTraces ranks the traces that started in the filter's time range by wall time
+// (first span start to last span end), slowest first. The model filter is ignored: traces
+// mix spans of several models.
+func (g *GormDB) GetSlowestTraces(filter StatsFilter, limit int) ([]SlowTrace, error) {
+	if limit <= 0 || limit > maxSlowestTraces {
+		limit = 20
+	}
+	filter.Model = ""
+	var rows []struct {
+		TraceID        string
+		ProjectID      string
+		ConversationID string
+		FirstStartTime dbTime
+		LastEndTime    dbTime
+		SpanCount      int
+		ErrorCount     int
+		RootCount      int
+		LastSeq        int64
+	}
+	if err := filter.apply(g.db.Model(&Span{})).
+		Select("trace_id, MAX(project_id) AS project_id, MAX(conversation_id) AS conversation_id, " +
+			"MIN(start_time) AS first_start_time, MAX(end_time) AS last_end_time, COUNT(*) AS span_count, " +
+			"SUM(CASE WHEN status_code = 'ERROR' THEN 1 ELSE 0 END) AS error_count, " +
+			rootCountSQL + " AS root_count, MAX(seq) AS last_seq").
+		Group("trace_id").
+		Limit(100000).
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	out := make([]SlowTrace, len(rows))
+	for i, r := range rows {
+		out[i] = SlowTrace{
+			TraceGroup: TraceGroup{
+				TraceID:        r.TraceID,
+				FirstStartTime: r.FirstStartTime.Time,
+				LastEndTime:    r.LastEndTime.Time,
+				SpanCount:      r.SpanCount,
+				ErrorCount:     r.ErrorCount,
+				IsComplete:     g.traceComplete(r.RootCount, r.LastSeq),
+			},
+			DurationMS:     r.LastEndTime.Sub(r.FirstStartTime.Time).Milliseconds(),
+			ProjectID:      r.ProjectID,
+			ConversationID: r.ConversationID,
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].DurationMS != out[j].DurationMS {
+			return out[i].DurationMS > out[j].DurationMS
+		}
+		return out[i].TraceID < out[j].TraceID
+	})
+	if len(out) > limit {
+		out = out[:limit]
+	}
+
+	groups := make([]TraceGroup, len(out))
+	for i := range out {
+		groups[i] = out[i].TraceGroup
+	}
+	g.attachRootSpans(groups)
+	g.attachTriage(groups)
+	g.attachLatencyBreakdown(groups)
+	g.attachLoopDetection(groups)
+	g.attachHealth(groups)
+	for i := range out {
+		out[i].TraceGroup = groups[i]
+		out[i].Rank = i + 1
+	}
+	g.attachBottlenecks(out)
+	return out, nil
+}
+
+// attachBottlenecks fills in the span that dominated the critical path of each trace
+func (g *GormDB) attachBottlenecks(traces []SlowTrace) {
+	if len(traces) == 0 {
+		return
+	}
+	ids := make([]string, len(traces))
+	for i, t := range traces {
+		ids[i] = t.TraceID
+	}
+	var spans []Span
+	if err := g.db.Select("span_id, trace_id, parent_span_id, name, start_time, end_time, category").
+		Where("trace_id IN ?", ids).Find(&spans).Error; err != nil {
+		return
+	}
+	byTrace := make(map[string][]Span, len(traces))
+	for _, sp := range spans {
+		byTrace[sp.TraceID] = append(byTrace[sp.TraceID], sp)
+	}
+	for i := range traces {
+		if cp := computeCriticalPath(traces[i].TraceID, byTrace[traces[i].TraceID]); len(cp.Spans) > 0 {
+			traces[i].Bottleneck = &cp.Spans[0]
+		}
+	}
+}
+
+// getSlowestTracesHandler returns the slowest traces of a time range (window=24h by default)
+func getSlowestTracesHandler(db Database, publicURL string, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		filter, err := parseStatsFilter(q)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		limit := 20
+		if s := strings.TrimSpace(q.Get("limit")); s != "" {
+			if v, err := strconv.Atoi(s); err == nil && v > 0 {
+				limit = v
+			}
+		}
+		traces, err := db.GetSlowestTraces(filter, limit)
+		if err != nil {
+			logger.Error("Failed to get slowest traces: %v", err)
+			http.Error(w, fmt.Sprintf("Failed to get slowest traces: %v", err), http.StatusInternalServerError)
+			return
+		}
+		base := strings.TrimSuffix(publicURL, "/")
+		for i := range traces {
+			trace := base + "/api/trace-groups/" + url.PathEscape(traces[i].TraceID)
+			traces[i].Links = map[string]string{
+				"trace":         trace,
+				"critical_path": trace + "/critical-path",
+			}
+			if traces[i].ConversationID != "" {
+				traces[i].Links["conversation"] = base + "/conversations/" + url.PathEscape(traces[i].ConversationID)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(traces)
+	}
+}
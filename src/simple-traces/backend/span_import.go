@@ -0,0 +1,329 @@
+package backend
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	tracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	tracepbv1 "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+const (
+	// importBatchSize is how many spans are stored per export during an import
+	importBatchSize = 500
+	// importMaxLine is the longest accepted line
+	importMaxLine = 16 << 20
+	// importMaxErrors is how many line errors are reported individually
+	importMaxErrors = 100
+)
+
+// importSpan is one line of a JSONL import, in the format the spans API returns. Attributes
+// and events may be JSON objects or, as stored, JSON strings.
+type importSpan struct {
+	SpanID         string          `json:"span_id"`
+	TraceID        string          `json:"trace_id"`
+	ParentSpanID   string          `json:"parent_span_id"`
+	ProjectID      string          `json:"project_id"`
+	ConversationID string          `json:"conversation_id"`
+	RunID          string          `json:"run_id"`
+	Name           string          `json:"name"`
+	Kind           string          `json:"kind"`
+	StartTime      time.Time       `json:"start_time"`
+	EndTime        time.Time       `json:"end_time"`
+	DurationMS     int64           `json:"duration_ms"`
+	StatusCode     string          `json:"status_code"`
+	StatusDesc     string          `json:"status_description"`
+	Attributes     json.RawMessage `json:"attributes"`
+	Events         json.RawMessage `json:"events"`
+}
+
+type importEvent struct {
+	Name       string         `json:"name"`
+	Timestamp  time.Time      `json:"timestamp"`
+	Attributes map[string]any `json:"attributes"`
+}
+
+// ImportLineError is a line of an import that was not stored
+type ImportLineError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// ImportSummary reports the outcome of an import. Skipped spans were valid but dropped at
+// ingest (duplicates, noise filters, transforms or the trace span cap).
+type ImportSummary struct {
+	Lines    int               `json:"lines"`
+	Inserted int               `json:"inserted"`
+	Skipped  int               `json:"skipped"`
+	Failed   int               `json:"failed"`
+	Errors   []ImportLineError `json:"errors"`
+}
+
+// importSpansHandler imports newline-delimited span JSON, either as the raw request body
+// (optionally gzip-compressed) or as the first file of a multipart form. The input is read line
+// by line and stored in batches through the OTLP ingest pipeline; invalid lines are reported
+// and skipped.
+func importSpansHandler(h *OTLPHandler, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		in, err := importReader(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		summary, err := importSpans(h, in)
+		if err != nil {
+			// the lines read so far are stored; report them along with the error
+			logger.Warn("Span import stopped after %d lines: %v", summary.Lines, err)
+			summary.Errors = append(summary.Errors, ImportLineError{Line: summary.Lines + 1, Error: err.Error()})
+		}
+		logger.Info("Imported %d spans from %d lines (%d skipped, %d failed)", summary.Inserted, summary.Lines, summary.Skipped, summary.Failed)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(summary)
+	}
+}
+
+// importReader returns the JSONL stream of a request
+func importReader(r *http.Request) (io.Reader, error) {
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+		mr, err := r.MultipartReader()
+		if err != nil {
+			return nil, err
+		}
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				return nil, fmt.Errorf("multipart form has no file")
+			}
+			if err != nil {
+				return nil, err
+			}
+			if part.FileName() != "" {
+				return part, nil
+			}
+		}
+	}
+	switch enc := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))); enc {
+	case "", "identity":
+		return r.Body, nil
+	case "gzip", "x-gzip":
+		return gzip.NewReader(r.Body)
+	default:
+		return nil, errUnsupportedEncoding(enc)
+	}
+}
+
+// exportError describes why spans of an export were not stored, "" when all were
+func exportError(res ExportResult, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return res.Message
+}
+
+// importSpans reads spans from in until EOF; the error is set when reading failed
+func importSpans(h *OTLPHandler, in io.Reader) (ImportSummary, error) {
+	summary := ImportSummary{Errors: []ImportLineError{}}
+	batch := &tracepbv1.ScopeSpans{}
+	flush := func() {
+		if len(batch.Spans) == 0 {
+			return
+		}
+		res, err := h.Export(&tracepb.ExportTraceServiceRequest{
+			ResourceSpans: []*tracepbv1.ResourceSpans{{ScopeSpans: []*tracepbv1.ScopeSpans{batch}}},
+		})
+		if msg := exportError(res, err); msg != "" && len(summary.Errors) < importMaxErrors {
+			summary.Errors = append(summary.Errors, ImportLineError{Line: summary.Lines, Error: msg})
+		}
+		summary.Inserted += res.Stored
+		summary.Skipped += res.Dropped
+		summary.Failed += res.Rejected
+		batch = &tracepbv1.ScopeSpans{}
+	}
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 0, 64<<10), importMaxLine)
+	for scanner.Scan() {
+		summary.Lines++
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		span, err := parseImportLine(line)
+		if err != nil {
+			summary.Failed++
+			if len(summary.Errors) < importMaxErrors {
+				summary.Errors = append(summary.Errors, ImportLineError{Line: summary.Lines, Error: err.Error()})
+			}
+			continue
+		}
+		batch.Spans = append(batch.Spans, span)
+		if len(batch.Spans) >= importBatchSize {
+			flush()
+		}
+	}
+	flush()
+	return summary, scanner.Err()
+}
+
+// parseImportLine validates one line and converts it to an OTLP span
+func parseImportLine(line []byte) (*tracepbv1.Span, error) {
+	var in importSpan
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber()
+	if err := dec.Decode(&in); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %v", err)
+	}
+	traceID, err := zipkinID(strings.TrimSpace(in.TraceID), 16)
+	if err != nil {
+		return nil, fmt.Errorf("trace_id: %v", err)
+	}
+	spanID, err := zipkinID(strings.TrimSpace(in.SpanID), 8)
+	if err != nil {
+		return nil, fmt.Errorf("span_id: %v", err)
+	}
+	var parentID []byte
+	if p := strings.TrimSpace(in.ParentSpanID); p != "" {
+		if parentID, err = zipkinID(p, 8); err != nil {
+			return nil, fmt.Errorf("parent_span_id: %v", err)
+		}
+	}
+	if strings.TrimSpace(in.Name) == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	if in.StartTime.IsZero() {
+		return nil, fmt.Errorf("start_time is required")
+	}
+	end := in.EndTime
+	if end.IsZero() {
+		end = in.StartTime.Add(time.Duration(in.DurationMS) * time.Millisecond)
+	}
+	if end.Before(in.StartTime) {
+		return nil, fmt.Errorf("end_time is before start_time")
+	}
+
+	attrs, err := decodeImportJSON[map[string]any](in.Attributes)
+	if err != nil {
+		return nil, fmt.Errorf("attributes: %v", err)
+	}
+	events, err := decodeImportJSON[[]importEvent](in.Events)
+	if err != nil {
+		return nil, fmt.Errorf("events: %v", err)
+	}
+
+	span := &tracepbv1.Span{
+		TraceId:           traceID,
+		SpanId:            spanID,
+		ParentSpanId:      parentID,
+		Name:              in.Name,
+		Kind:              tracepbv1.Span_SPAN_KIND_INTERNAL,
+		StartTimeUnixNano: uint64(in.StartTime.UnixNano()),
+		EndTimeUnixNano:   uint64(end.UnixNano()),
+	}
+	kind := in.Kind
+	if kind == "" {
+		kind, _ = attrs["span.kind"].(string)
+	}
+	if k, ok := zipkinKinds[strings.ToUpper(kind)]; ok {
+		span.Kind = k
+	}
+	switch strings.ToUpper(in.StatusCode) {
+	case "OK":
+		span.Status = &tracepbv1.Status{Code: tracepbv1.Status_STATUS_CODE_OK, Message: in.StatusDesc}
+	case "ERROR":
+		span.Status = &tracepbv1.Status{Code: tracepbv1.Status_STATUS_CODE_ERROR, Message: in.StatusDesc}
+	}
+
+	// top-level ids apply unless the attributes already name them
+	for key, v := range map[string]string{
+		"simpleTraces.project.id":      in.ProjectID,
+		"simpleTraces.conversation.id": in.ConversationID,
+		"simpleTraces.run.id":          in.RunID,
+	} {
+		if _, ok := attrs[key]; !ok && v != "" {
+			if attrs == nil {
+				attrs = make(map[string]any)
+			}
+			attrs[key] = v
+		}
+	}
+	for k, v := range attrs {
+		span.Attributes = append(span.Attributes, &commonpb.KeyValue{Key: k, Value: jsonAnyValue(v)})
+	}
+	for _, ev := range events {
+		if ev.Timestamp.IsZero() {
+			ev.Timestamp = in.StartTime
+		}
+		e := &tracepbv1.Span_Event{Name: ev.Name, TimeUnixNano: uint64(ev.Timestamp.UnixNano())}
+		for k, v := range ev.Attributes {
+			e.Attributes = append(e.Attributes, &commonpb.KeyValue{Key: k, Value: jsonAnyValue(v)})
+		}
+		span.Events = append(span.Events, e)
+	}
+	return span, nil
+}
+
+// decodeImportJSON decodes a field given either as JSON or as a string holding JSON
+func decodeImportJSON[T any](raw json.RawMessage) (T, error) {
+	var out T
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || string(raw) == "null" {
+		return out, nil
+	}
+	if raw[0] == '"' {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return out, err
+		}
+		if strings.TrimSpace(s) == "" {
+			return out, nil
+		}
+		raw = json.RawMessage(s)
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	err := dec.Decode(&out)
+	return out, err
+}
+
+// jsonAnyValue converts a decoded JSON value (numbers as json.Number) to an OTLP value
+func jsonAnyValue(v any) *commonpb.AnyValue {
+	switch x := v.(type) {
+	case string:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: x}}
+	case bool:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: x}}
+	case int64:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: x}}
+	case float64:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: x}}
+	case json.Number:
+		if n, err := x.Int64(); err == nil {
+			return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: n}}
+		}
+		f, _ := x.Float64()
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: f}}
+	case []any:
+		arr := &commonpb.ArrayValue{}
+		for _, e := range x {
+			arr.Values = append(arr.Values, jsonAnyValue(e))
+		}
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_ArrayValue{ArrayValue: arr}}
+	case map[string]any:
+		kv := &commonpb.KeyValueList{}
+		for k, e := range x {
+			kv.Values = append(kv.Values, &commonpb.KeyValue{Key: k, Value: jsonAnyValue(e)})
+		}
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_KvlistValue{KvlistValue: kv}}
+	}
+	return &commonpb.AnyValue{}
+}
@@ -0,0 +1,62 @@
+package backend
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// lastSpanSeq is the latest server sequence number handed out
+var lastSpanSeq atomic.Int64
+
+// nextSpanSeq returns an increasing sequence number. It starts from the clock in microseconds,
+// so it keeps increasing across restarts without reading the database, stays exact in
+// JavaScript numbers and tells when a span arrived (see traceComplete).
+func nextSpanSeq() int64 {
+	for {
+		last := lastSpanSeq.Load()
+		next := max(last+1, time.Now().UnixMicro())
+		if lastSpanSeq.CompareAndSwap(last, next) {
+			return next
+		}
+	}
+}
+
+// assignSpanSeq numbers the spans of one export. Sequence numbers follow receive order across
+// exports; within an export, spans are numbered by start time and, for identical start times,
+// parents before their children, as exporters usually send children first (they end first).
+func assignSpanSeq(rows []Span) {
+	depth := make(map[string]int, len(rows))
+	parent := make(map[string]string, len(rows))
+	for _, sp := range rows {
+		parent[sp.SpanID] = sp.ParentSpanID
+	}
+	var depthOf func(id string, guard int) int
+	depthOf = func(id string, guard int) int {
+		if d, ok := depth[id]; ok {
+			return d
+		}
+		p, ok := parent[id]
+		if !ok || p == "" || guard > len(rows) {
+			return 0
+		}
+		d := depthOf(p, guard+1) + 1
+		depth[id] = d
+		return d
+	}
+
+	order := make([]int, len(rows))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := &rows[order[i]], &rows[order[j]]
+		if !a.StartTime.Equal(b.StartTime) {
+			return a.StartTime.Before(b.StartTime)
+		}
+		return depthOf(a.SpanID, 0) < depthOf(b.SpanID, 0)
+	})
+	for _, i := range order {
+		rows[i].Seq = nextSpanSeq()
+	}
+}
@@ -0,0 +1,167 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// spanStreamBuffer is how many batches a subscriber may fall behind before batches are dropped
+const spanStreamBuffer = 64
+
+// SpanStream fans newly stored spans out to live subscribers (the SSE endpoint)
+type SpanStream struct {
+	mu   sync.Mutex
+	subs map[chan []Span]struct{}
+}
+
+// NewSpanStream creates an empty stream
+func NewSpanStream() *SpanStream {
+	metrics.Describe("simpletraces_stream_subscribers", "gauge", "Clients connected to the live span stream")
+	metrics.Describe("simpletraces_stream_dropped_batches_total", "counter", "Span batches not delivered to slow stream clients")
+	return &SpanStream{subs: make(map[chan []Span]struct{})}
+}
+
+// Publish hands a stored batch to every subscriber without blocking ingest
+func (s *SpanStream) Publish(spans []Span) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ch := range s.subs {
+		select {
+		case ch <- spans:
+		default:
+			metrics.Inc("simpletraces_stream_dropped_batches_total")
+		}
+	}
+}
+
+func (s *SpanStream) subscribe() chan []Span {
+	ch := make(chan []Span, spanStreamBuffer)
+	s.mu.Lock()
+	s.subs[ch] = struct{}{}
+	metrics.Set("simpletraces_stream_subscribers", float64(len(s.subs)))
+	s.mu.Unlock()
+	return ch
+}
+
+func (s *SpanStream) unsubscribe(ch chan []Span) {
+	s.mu.Lock()
+	delete(s.subs, ch)
+	metrics.Set("simpletraces_stream_subscribers", float64(len(s.subs)))
+	s.mu.Unlock()
+}
+
+// streamFilter selects spans for one stream client
+type streamFilter struct {
+	SpanFilter
+	TraceID string
+}
+
+func (f streamFilter) match(sp Span) bool {
+	switch {
+	case f.ProjectID != "" && sp.ProjectID != f.ProjectID,
+		f.ConversationID != "" && sp.ConversationID != f.ConversationID,
+		f.TraceID != "" && sp.TraceID != f.TraceID,
+		f.Model != "" && sp.Model != f.Model,
+		f.Category != "" && sp.Category != f.Category,
+		f.StatusCode != "" && sp.StatusCode != f.StatusCode,
+		f.ViolationType != "" && sp.ViolationType != f.ViolationType,
+		f.FinishReason != "" && !strings.Contains(sp.FinishReason, f.FinishReason),
+		f.MinDurationMS > 0 && sp.DurationMS < f.MinDurationMS:
+		return false
+	}
+	if f.Violation != nil && *f.Violation != (sp.ViolationType != "") {
+		return false
+	}
+	if f.ColdStart != nil && *f.ColdStart != sp.ColdStart {
+		return false
+	}
+	return true
+}
+
+func parseStreamFilter(q map[string][]string) (streamFilter, error) {
+	get := func(k string) string {
+		if v := q[k]; len(v) > 0 {
+			return strings.TrimSpace(v[0])
+		}
+		return ""
+	}
+	f := streamFilter{TraceID: get("trace_id")}
+	f.ProjectID = get("project")
+	f.ConversationID = get("conversation_id")
+	f.Model = get("model")
+	f.Category = get("category")
+	f.StatusCode = strings.ToUpper(get("status"))
+	f.ViolationType = get("violation_type")
+	f.FinishReason = get("finish_reason")
+	if v := get("violation"); v != "" {
+		b := v == "true"
+		f.Violation = &b
+	}
+	if v := get("cold_start"); v != "" {
+		b := v == "true"
+		f.ColdStart = &b
+	}
+	if v := get("min_duration_ms"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			return f, fmt.Errorf("invalid min_duration_ms")
+		}
+		f.MinDurationMS = n
+	}
+	return f, nil
+}
+
+// spanStreamHandler streams stored spans as server-sent events ("span" events with the span
+// as JSON). It takes the same filters as GET /api/spans plus trace_id and conversation_id.
+func spanStreamHandler(stream *SpanStream, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := parseStreamFilter(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rc := http.NewResponseController(w)
+		// the stream outlives HTTP_WRITE_TIMEOUT
+		if err := rc.SetWriteDeadline(time.Time{}); err != nil && err != http.ErrNotSupported {
+			logger.Warn("Failed to clear write deadline of span stream: %v", err)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, ": connected\n\n")
+		if err := rc.Flush(); err != nil {
+			logger.Error("Span stream needs a flushable response: %v", err)
+			return
+		}
+
+		ch := stream.subscribe()
+		defer stream.unsubscribe(ch)
+		heartbeat := time.NewTicker(15 * time.Second)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": ping\n\n")
+			case spans := <-ch:
+				for _, sp := range spans {
+					if !filter.match(sp) {
+						continue
+					}
+					data, _ := json.Marshal(sp)
+					fmt.Fprintf(w, "event: span\ndata: %s\n\n", data)
+				}
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
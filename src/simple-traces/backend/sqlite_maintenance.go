@@ -0,0 +1,78 @@
+package backend
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SQLite auto_vacuum modes as reported by PRAGMA auto_vacuum
+const (
+	sqliteAutoVacuumNone        = 0
+	sqliteAutoVacuumIncremental = 2
+)
+
+// prepareSQLite enables incremental auto-vacuum on new databases, which lets maintenance return
+// pages freed by deletions to the filesystem without rewriting the whole file. The mode can only
+// change before the first table is created; existing databases switch on their next full vacuum.
+func prepareSQLite(db *gorm.DB) error {
+	var pages int64
+	if err := db.Raw("PRAGMA page_count").Scan(&pages).Error; err != nil {
+		return err
+	}
+	if pages > 0 {
+		return nil
+	}
+	return db.Exec("PRAGMA auto_vacuum = INCREMENTAL").Error
+}
+
+// DBMaintainer periodically refreshes SQLite planner statistics and returns free pages left by
+// deleted spans, so query plans and the file size don't degrade over months of use
+type DBMaintainer struct {
+	db     Database
+	logger *Logger
+	stop   chan struct{}
+}
+
+// NewDBMaintainer starts the background worker
+func NewDBMaintainer(db Database, interval time.Duration, logger *Logger) *DBMaintainer {
+	metrics.Describe("simpletraces_db_maintenance_runs_total", "counter", "Scheduled database maintenance runs by result")
+	metrics.Describe("simpletraces_db_reclaimed_bytes_total", "counter", "Bytes returned to the filesystem by incremental vacuum")
+	m := &DBMaintainer{db: db, logger: logger, stop: make(chan struct{})}
+	go m.loop(interval)
+	return m
+}
+
+// Close stops the worker
+func (m *DBMaintainer) Close() {
+	close(m.stop)
+}
+
+func (m *DBMaintainer) loop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+			m.runOnce()
+		}
+	}
+}
+
+func (m *DBMaintainer) runOnce() {
+	res, err := m.db.Vacuum("incremental")
+	if err != nil {
+		metrics.Inc("simpletraces_db_maintenance_runs_total", "result", "error")
+		m.logger.Error("Database maintenance failed: %v", err)
+		return
+	}
+	metrics.Inc("simpletraces_db_maintenance_runs_total", "result", "ok")
+	if reclaimed := res.BytesBefore - res.BytesAfter; reclaimed > 0 {
+		metrics.Add("simpletraces_db_reclaimed_bytes_total", float64(reclaimed))
+		m.logger.Info("Database maintenance reclaimed %d bytes in %dms", reclaimed, res.DurationMS)
+	} else {
+		m.logger.Debug("Database maintenance finished in %dms (%v)", res.DurationMS, res.Statements)
+	}
+}
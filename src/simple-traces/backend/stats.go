@@ -0,0 +1,136 @@
+package backend
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// StatsFilter narrows span-based stats queries. Zero values mean "no restriction";
+// Since defaults to the last 24 hours when parsed from a request.
+type StatsFilter struct {
+	ProjectID string
+	Model     string
+	Since     time.Time
+	Until     time.Time
+}
+
+// apply adds the filter conditions to a query over the spans table
+func (f StatsFilter) apply(q *gorm.DB) *gorm.DB {
+	if f.ProjectID != "" {
+		q = q.Where("project_id = ?", f.ProjectID)
+	}
+	if f.Model != "" {
+		q = q.Where("model = ?", f.Model)
+	}
+	if !f.Since.IsZero() {
+		q = q.Where("start_time >= ?", f.Since)
+	}
+	if !f.Until.IsZero() {
+		q = q.Where("start_time < ?", f.Until)
+	}
+	return q
+}
+
+// parseStatsFilter reads project, model and the time range from query parameters.
+// The range is either window=<duration> (e.g. 24h, 7d) or since/until as RFC3339.
+func parseStatsFilter(q url.Values) (StatsFilter, error) {
+	f := StatsFilter{
+		ProjectID: strings.TrimSpace(q.Get("project")),
+		Model:     strings.TrimSpace(q.Get("model")),
+	}
+	if s := strings.TrimSpace(q.Get("since")); s != "" {
+		t, err := parseTimeParam(s)
+		if err != nil {
+			return f, fmt.Errorf("invalid since: %v", err)
+		}
+		f.Since = t
+	}
+	if s := strings.TrimSpace(q.Get("until")); s != "" {
+		t, err := parseTimeParam(s)
+		if err != nil {
+			return f, fmt.Errorf("invalid until: %v", err)
+		}
+		f.Until = t
+	}
+	if f.Since.IsZero() {
+		window := 24 * time.Hour
+		if s := strings.TrimSpace(q.Get("window")); s != "" {
+			d, err := parseWindow(s)
+			if err != nil {
+				return f, fmt.Errorf("invalid window: %v", err)
+			}
+			window = d
+		}
+		end := f.Until
+		if end.IsZero() {
+			end = time.Now()
+		}
+		f.Since = end.Add(-window)
+	}
+	return f, nil
+}
+
+// parseWindow accepts Go durations plus a "d" suffix for days (e.g. 7d)
+func parseWindow(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		var days int
+		if _, err := fmt.Sscanf(strings.TrimSuffix(s, "d"), "%d", &days); err != nil || days <= 0 {
+			return 0, fmt.Errorf("bad day count %q", s)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("window must be positive")
+	}
+	return d, nil
+}
+
+func parseTimeParam(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func ratio(part, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(part) / float64(total)
+}
+
+// percentile returns the p-th percentile (0-100) of values using nearest-rank; values are sorted in place
+func percentile(values []int64, p float64) int64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	rank := int(p/100*float64(len(values))+0.5) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(values) {
+		rank = len(values) - 1
+	}
+	return values[rank]
+}
+
+func average(values []int64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	var sum int64
+	for _, v := range values {
+		sum += v
+	}
+	return float64(sum) / float64(len(values))
+}
@@ -0,0 +1,109 @@
+package backend
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Built-in status derivations, enabled via STATUS_RULES (comma separated, "none" disables all).
+// They only apply to spans whose instrumentation left the status UNSET.
+const (
+	StatusErrorAttribute = "error_attribute"
+	StatusHTTP5xx        = "http_5xx"
+	StatusException      = "exception"
+	defaultStatusRules   = StatusErrorAttribute + "," + StatusHTTP5xx
+)
+
+var allStatusRules = []string{StatusErrorAttribute, StatusHTTP5xx, StatusException}
+
+// StatusRules derives an effective ERROR status from attributes and events
+type StatusRules struct {
+	enabled map[string]bool
+}
+
+// errorAttributeKeys are boolean attributes instrumentations (OpenTracing shims, custom code) use to flag failures
+var errorAttributeKeys = []string{"error", "exception"}
+
+// httpStatusKeys hold the HTTP response code of client and server spans
+var httpStatusKeys = []string{"http.response.status_code", "http.status_code"}
+
+// NewStatusRules builds the derivation from a comma separated list of rule names
+func NewStatusRules(spec string) *StatusRules {
+	r := &StatusRules{enabled: make(map[string]bool)}
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch name {
+		case "", "none":
+		case "all":
+			for _, n := range allStatusRules {
+				r.enabled[n] = true
+			}
+		default:
+			r.enabled[name] = true
+		}
+	}
+	if len(r.enabled) == 0 {
+		return nil
+	}
+	return r
+}
+
+// Enabled lists the active rules
+func (r *StatusRules) Enabled() []string {
+	if r == nil {
+		return nil
+	}
+	var out []string
+	for _, name := range allStatusRules {
+		if r.enabled[name] {
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+// Derive returns the rule that marks an UNSET span as failed and a description of why,
+// or "" when no rule matches
+func (r *StatusRules) Derive(attrs map[string]any, events []map[string]any) (rule, desc string) {
+	if r == nil {
+		return "", ""
+	}
+	if r.enabled[StatusErrorAttribute] {
+		for _, key := range errorAttributeKeys {
+			if truthy(attrs[key]) {
+				return StatusErrorAttribute, key + "=true"
+			}
+		}
+		// the Zipkin and OpenTracing exporters carry the status as an attribute
+		if code, ok := attrs["otel.status_code"].(string); ok && strings.EqualFold(code, "ERROR") {
+			return StatusErrorAttribute, "otel.status_code=ERROR"
+		}
+	}
+	if r.enabled[StatusHTTP5xx] {
+		for _, key := range httpStatusKeys {
+			if code, ok := asInt(attrs[key]); ok {
+				if code >= 500 && code <= 599 {
+					return StatusHTTP5xx, fmt.Sprintf("HTTP %d", code)
+				}
+				break
+			}
+		}
+	}
+	if r.enabled[StatusException] {
+		for _, ev := range events {
+			if ev["name"] != "exception" {
+				continue
+			}
+			desc = "exception"
+			if evAttrs, ok := ev["attributes"].(map[string]any); ok {
+				if msg, ok := evAttrs["exception.message"].(string); ok && msg != "" {
+					desc = msg
+				} else if typ, ok := evAttrs["exception.type"].(string); ok && typ != "" {
+					desc = typ
+				}
+			}
+			return StatusException, desc
+		}
+	}
+	return "", ""
+}
@@ -0,0 +1,169 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// eventsSizeKey accounts for the events column, which is stored separately from attributes
+const eventsSizeKey = "(events)"
+
+// AttributeSize accumulates the stored bytes of one attribute key per project and hour
+type AttributeSize struct {
+	Hour       time.Time `gorm:"primaryKey"`
+	ProjectID  string    `gorm:"primaryKey"`
+	Key        string    `gorm:"primaryKey"`
+	Bytes      int64
+	ValueCount int64
+}
+
+// StorageStats is the stored size of one attribute key or project over a time range
+type StorageStats struct {
+	Key       string  `json:"key,omitempty"`
+	ProjectID string  `json:"project_id,omitempty"`
+	Bytes     int64   `json:"bytes"`
+	Values    int64   `json:"values"`
+	AvgBytes  float64 `json:"avg_bytes"`
+	Share     float64 `json:"share"`
+}
+
+// measureAttributeSizes sums the JSON encoded size (key plus value) of every attribute of the spans
+func measureAttributeSizes(spans []Span) []AttributeSize {
+	type bucket struct {
+		hour      time.Time
+		projectID string
+		key       string
+	}
+	sums := make(map[bucket]*AttributeSize)
+	add := func(b bucket, n int64) {
+		s := sums[b]
+		if s == nil {
+			s = &AttributeSize{Hour: b.hour, ProjectID: b.projectID, Key: b.key}
+			sums[b] = s
+		}
+		s.Bytes += n
+		s.ValueCount++
+	}
+	for _, sp := range spans {
+		hour := sp.StartTime.UTC().Truncate(time.Hour)
+		var attrs map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(sp.Attributes), &attrs); err == nil {
+			for k, v := range attrs {
+				add(bucket{hour, sp.ProjectID, k}, int64(len(k)+len(v)))
+			}
+		}
+		if sp.Events != "" {
+			add(bucket{hour, sp.ProjectID, eventsSizeKey}, int64(len(sp.Events)))
+		}
+	}
+	out := make([]AttributeSize, 0, len(sums))
+	for _, s := range sums {
+		out = append(out, *s)
+	}
+	return out
+}
+
+// RecordAttributeSizes adds measured sizes to the hourly totals
+func (g *GormDB) RecordAttributeSizes(sizes []AttributeSize) error {
+	if len(sizes) == 0 {
+		return nil
+	}
+	return g.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "hour"}, {Name: "project_id"}, {Name: "key"}},
+		DoUpdates: clause.Assignments(map[string]any{
+			"bytes":       gorm.Expr("attribute_sizes.bytes + excluded.bytes"),
+			"value_count": gorm.Expr("attribute_sizes.value_count + excluded.value_count"),
+		}),
+	}).CreateInBatches(sizes, 200).Error
+}
+
+// GetStorageStats returns the largest attribute keys (groupBy "key") or projects (groupBy "project")
+// by bytes ingested in the filter's time range. Sizes are tracked per hour, so the range is
+// widened to whole hours; the model filter does not apply.
+func (g *GormDB) GetStorageStats(filter StatsFilter, groupBy string, limit int) ([]StorageStats, error) {
+	column := "key"
+	if groupBy == "project" {
+		column = "project_id"
+	}
+	query := g.db.Model(&AttributeSize{})
+	if filter.ProjectID != "" {
+		query = query.Where("project_id = ?", filter.ProjectID)
+	}
+	if !filter.Since.IsZero() {
+		query = query.Where("hour >= ?", filter.Since.UTC().Truncate(time.Hour))
+	}
+	if !filter.Until.IsZero() {
+		query = query.Where("hour < ?", filter.Until.UTC())
+	}
+
+	var total int64
+	if err := query.Session(&gorm.Session{}).Select("COALESCE(SUM(bytes), 0)").Scan(&total).Error; err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		Name   string
+		Bytes  int64
+		Values int64
+	}
+	err := query.Select(column + " AS name, SUM(bytes) AS bytes, SUM(value_count) AS \"values\"").
+		Group(column).Order("bytes DESC").Limit(limit).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]StorageStats, 0, len(rows))
+	for _, r := range rows {
+		st := StorageStats{Bytes: r.Bytes, Values: r.Values, Share: ratio(r.Bytes, total)}
+		if r.Values > 0 {
+			st.AvgBytes = float64(r.Bytes) / float64(r.Values)
+		}
+		if column == "key" {
+			st.Key = r.Name
+		} else {
+			st.ProjectID = r.Name
+		}
+		out = append(out, st)
+	}
+	return out, nil
+}
+
+// getStorageStatsHandler reports which attribute keys (?group_by=key, default) or projects
+// (?group_by=project) take the most space
+func getStorageStatsHandler(db Database, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := parseStatsFilter(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		groupBy := strings.TrimSpace(r.URL.Query().Get("group_by"))
+		if groupBy == "" {
+			groupBy = "key"
+		}
+		if groupBy != "key" && groupBy != "project" {
+			http.Error(w, "group_by must be key or project", http.StatusBadRequest)
+			return
+		}
+		limit := 50
+		if s := r.URL.Query().Get("limit"); s != "" {
+			if v, err := strconv.Atoi(s); err == nil && v > 0 && v <= 1000 {
+				limit = v
+			}
+		}
+		stats, err := db.GetStorageStats(filter, groupBy, limit)
+		if err != nil {
+			logger.Error("Failed to get storage stats: %v", err)
+			http.Error(w, fmt.Sprintf("Failed to get storage stats: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats)
+	}
+}
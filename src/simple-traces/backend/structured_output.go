@@ -0,0 +1,190 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Results of structured output validation stored in Span.SchemaStatus
+const (
+	SchemaValid   = "valid"
+	SchemaInvalid = "invalid"
+	// SchemaInvalidJSON means the output was not JSON at all
+	SchemaInvalidJSON = "invalid_json"
+)
+
+// responseSchemaKey carries the JSON schema an LLM call requested its output to follow, as JSON text
+const responseSchemaKey = "gen_ai.response.schema"
+
+// ResponseSchemas validates structured LLM outputs at ingest against the schema on the span
+// or, failing that, the schema configured for the span's project
+type ResponseSchemas struct {
+	projects map[string]*JSONSchema
+
+	// schemas sent inline on spans, compiled once; requests usually reuse a handful
+	mu     sync.Mutex
+	inline map[string]*JSONSchema
+}
+
+// maxInlineSchemas bounds the cache of compiled inline schemas
+const maxInlineSchemas = 256
+
+// LoadResponseSchemas reads a JSON object mapping project ids to JSON schemas; an empty path
+// configures no project schemas (inline schemas are still validated)
+func LoadResponseSchemas(path string) (*ResponseSchemas, error) {
+	s := &ResponseSchemas{projects: make(map[string]*JSONSchema), inline: make(map[string]*JSONSchema)}
+	if strings.TrimSpace(path) == "" {
+		return s, nil
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read response schemas: %w", err)
+	}
+	var byProject map[string]any
+	if err := json.Unmarshal(raw, &byProject); err != nil {
+		return nil, fmt.Errorf("parse response schemas: %w", err)
+	}
+	for project, schema := range byProject {
+		compiled, err := CompileJSONSchema(schema)
+		if err != nil {
+			return nil, fmt.Errorf("response schema of project %q: %w", project, err)
+		}
+		s.projects[project] = compiled
+	}
+	return s, nil
+}
+
+// Len returns the number of project schemas
+func (s *ResponseSchemas) Len() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.projects)
+}
+
+// schemaFor returns the schema an LLM span's output should follow, or nil
+func (s *ResponseSchemas) schemaFor(projectID string, attrs map[string]any) (*JSONSchema, error) {
+	text, _ := attrs[responseSchemaKey].(string)
+	if strings.TrimSpace(text) == "" {
+		return s.projects[projectID], nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if compiled, ok := s.inline[text]; ok {
+		return compiled, nil
+	}
+	compiled, err := CompileJSONSchema(text)
+	if err != nil {
+		return nil, err
+	}
+	if len(s.inline) >= maxInlineSchemas {
+		clear(s.inline)
+	}
+	s.inline[text] = compiled
+	return compiled, nil
+}
+
+// Check validates the output of an LLM span. status is "" when the span has no schema (nor a
+// json gen_ai.output.type) or no output.
+func (s *ResponseSchemas) Check(projectID string, attrs map[string]any) (status string, errs []string) {
+	if s == nil {
+		return "", nil
+	}
+	_, output := transcriptText(attrs)
+	if output == "" {
+		return "", nil
+	}
+	schema, err := s.schemaFor(projectID, attrs)
+	if err != nil {
+		return SchemaInvalid, []string{"invalid schema: " + err.Error()}
+	}
+	if schema == nil {
+		// without a schema, outputs declared as JSON (gen_ai.output.type) must at least parse
+		if t, _ := attrs["gen_ai.output.type"].(string); t == "json" {
+			if !json.Valid([]byte(stripCodeFence(output))) {
+				return SchemaInvalidJSON, []string{"output is not valid JSON"}
+			}
+			return SchemaValid, nil
+		}
+		return "", nil
+	}
+	var doc any
+	if err := json.Unmarshal([]byte(stripCodeFence(output)), &doc); err != nil {
+		return SchemaInvalidJSON, []string{err.Error()}
+	}
+	if errs := schema.Validate(doc); len(errs) > 0 {
+		return SchemaInvalid, errs
+	}
+	return SchemaValid, nil
+}
+
+// stripCodeFence removes a markdown ```json fence models like to wrap JSON in
+func stripCodeFence(s string) string {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "```") || !strings.HasSuffix(s, "```") || len(s) < 6 {
+		return s
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "```"), "```")
+	if i := strings.IndexByte(s, '\n'); i >= 0 && !strings.ContainsAny(s[:i], "{[") {
+		s = s[i+1:] // language tag
+	}
+	return strings.TrimSpace(s)
+}
+
+// StructuredOutputStats reports how reliably a model produced valid structured output
+type StructuredOutputStats struct {
+	Model       string  `json:"model,omitempty"`
+	Checked     int64   `json:"checked"`
+	Valid       int64   `json:"valid"`
+	Invalid     int64   `json:"invalid"`
+	InvalidJSON int64   `json:"invalid_json"`
+	ValidRate   float64 `json:"valid_rate"`
+}
+
+// GetStructuredOutputStats returns validation results per model for spans that were checked
+func (g *GormDB) GetStructuredOutputStats(filter StatsFilter) ([]StructuredOutputStats, error) {
+	var rows []StructuredOutputStats
+	query := filter.apply(g.db.Model(&Span{})).
+		Select(`model,
+			COUNT(*) AS checked,
+			SUM(CASE WHEN schema_status = ? THEN 1 ELSE 0 END) AS valid,
+			SUM(CASE WHEN schema_status = ? THEN 1 ELSE 0 END) AS invalid,
+			SUM(CASE WHEN schema_status = ? THEN 1 ELSE 0 END) AS invalid_json`,
+			SchemaValid, SchemaInvalid, SchemaInvalidJSON).
+		Where("schema_status <> ''").
+		Group("model").
+		Order("checked DESC")
+	if err := query.Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	for i := range rows {
+		rows[i].ValidRate = ratio(rows[i].Valid, rows[i].Checked)
+	}
+	return rows, nil
+}
+
+// getStructuredOutputStatsHandler returns structured output validity per model
+func getStructuredOutputStatsHandler(db Database, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := parseStatsFilter(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		stats, err := db.GetStructuredOutputStats(filter)
+		if err != nil {
+			logger.Error("Failed to get structured output stats: %v", err)
+			http.Error(w, fmt.Sprintf("Failed to get structured output stats: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if stats == nil {
+			stats = []StructuredOutputStats{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats)
+	}
+}
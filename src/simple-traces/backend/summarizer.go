@@ -0,0 +1,143 @@
+package backend
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const summarySystemPrompt = `You summarize chat conversations between a user and an AI assistant.
+Reply with a JSON object: {"summary": "<one line, at most 20 words>", "sentiment": "positive" | "neutral" | "negative"}.
+The sentiment is the user's apparent sentiment by the end of the conversation.`
+
+// Summarizer periodically asks the configured LLM for a one-line summary and the user
+// sentiment of conversations that changed since they were last summarized.
+type Summarizer struct {
+	db     Database
+	llm    *LLMClient
+	logger *Logger
+	quiet  time.Duration
+	stop   chan struct{}
+}
+
+// NewSummarizer starts the background worker
+func NewSummarizer(db Database, llm *LLMClient, config *Config, logger *Logger) *Summarizer {
+	s := &Summarizer{
+		db:     db,
+		llm:    llm,
+		logger: logger,
+		quiet:  config.SummaryQuietPeriod,
+		stop:   make(chan struct{}),
+	}
+	go s.loop(config.SummaryInterval)
+	return s
+}
+
+// Close stops the worker
+func (s *Summarizer) Close() {
+	close(s.stop)
+}
+
+func (s *Summarizer) loop(interval time.Duration) {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.runOnce()
+		}
+	}
+}
+
+func (s *Summarizer) runOnce() {
+	// only summarize conversations that have been idle for a while so we don't redo work per turn
+	convs, err := s.db.GetConversationsNeedingSummary(time.Now().Add(-s.quiet), 10)
+	if err != nil {
+		s.logger.Error("Failed to list conversations to summarize: %v", err)
+		return
+	}
+	for _, c := range convs {
+		if err := s.summarize(c.ID); err != nil {
+			// back off so conversations that keep failing don't take the slots of the others
+			retry := summaryRetryDelay(c.SummaryFailures + 1)
+			s.logger.Warn("Failed to summarize conversation %s (attempt %d, retrying in %s): %v",
+				c.ID, c.SummaryFailures+1, retry, err)
+			if err := s.db.RecordSummaryFailure(c.ID, time.Now().Add(retry)); err != nil {
+				s.logger.Error("Failed to record summary failure of conversation %s: %v", c.ID, err)
+			}
+		}
+	}
+}
+
+// summaryRetryDelay is the wait after the given number of consecutive failed summary attempts:
+// doubling from a minute up to a day
+func summaryRetryDelay(failures int) time.Duration {
+	delay := time.Minute
+	for i := 1; i < failures && delay < 24*time.Hour; i++ {
+		delay *= 2
+	}
+	return min(delay, 24*time.Hour)
+}
+
+func (s *Summarizer) summarize(conversationID string) error {
+	turns, err := s.db.GetConversationTranscript(conversationID, 200)
+	if err != nil {
+		return err
+	}
+	transcript := formatTranscript(turns, 12000)
+	if transcript == "" {
+		// nothing to summarize; mark as done so we don't retry every tick
+		return s.db.UpdateConversationSummary(conversationID, "", "")
+	}
+	var out struct {
+		Summary   string `json:"summary"`
+		Sentiment string `json:"sentiment"`
+	}
+	if err := s.llm.CompleteJSON(summarySystemPrompt, transcript, &out); err != nil {
+		return err
+	}
+	sentiment := strings.ToLower(strings.TrimSpace(out.Sentiment))
+	switch sentiment {
+	case "positive", "neutral", "negative":
+	default:
+		sentiment = "neutral"
+	}
+	summary := strings.TrimSpace(strings.SplitN(out.Summary, "\n", 2)[0])
+	s.logger.Debug("Summarized conversation %s: %s (%s)", conversationID, summary, sentiment)
+	return s.db.UpdateConversationSummary(conversationID, summary, sentiment)
+}
+
+// formatTranscript renders turns as a plain-text dialogue, keeping the most recent
+// turns when the result would exceed maxChars
+func formatTranscript(turns []TranscriptTurn, maxChars int) string {
+	var parts []string
+	size := 0
+	for i := len(turns) - 1; i >= 0; i-- {
+		t := turns[i]
+		var b strings.Builder
+		if t.Prompt != "" {
+			fmt.Fprintf(&b, "User: %s\n", t.Prompt)
+		}
+		if t.Response != "" {
+			fmt.Fprintf(&b, "Assistant: %s\n", t.Response)
+		}
+		if b.Len() == 0 {
+			continue
+		}
+		if size+b.Len() > maxChars && len(parts) > 0 {
+			break
+		}
+		size += b.Len()
+		parts = append(parts, b.String())
+	}
+	// restore chronological order
+	for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+		parts[i], parts[j] = parts[j], parts[i]
+	}
+	return strings.Join(parts, "")
+}
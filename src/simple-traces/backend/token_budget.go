@@ -0,0 +1,311 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// defaultContextLimits maps model name prefixes to context window sizes in tokens.
+// The longest matching prefix wins; MODEL_CONTEXT_LIMITS adds or overrides entries.
+var defaultContextLimits = map[string]int64{
+	"gpt-4.1":          1047576,
+	"gpt-4o":           128000,
+	"gpt-4-turbo":      128000,
+	"gpt-4":            8192,
+	"gpt-3.5-turbo":    16385,
+	"gpt-5":            400000,
+	"o1":               200000,
+	"o3":               200000,
+	"o4-mini":          200000,
+	"claude":           200000,
+	"gemini-1.5-pro":   2097152,
+	"gemini-1.5-flash": 1048576,
+	"gemini-2":         1048576,
+	"gemini":           1048576,
+	"llama-3":          128000,
+	"mistral-large":    128000,
+	"command-r":        128000,
+	"deepseek":         128000,
+}
+
+// ContextLimits resolves the context window of a model
+type ContextLimits map[string]int64
+
+// parseContextLimits reads "model=tokens" pairs on top of the defaults
+func parseContextLimits(spec string) (ContextLimits, error) {
+	limits := make(ContextLimits, len(defaultContextLimits))
+	for k, v := range defaultContextLimits {
+		limits[k] = v
+	}
+	for _, part := range splitList(spec) {
+		model, tokens, ok := strings.Cut(part, "=")
+		n, err := strconv.ParseInt(strings.TrimSpace(tokens), 10, 64)
+		if !ok || err != nil || n <= 0 {
+			return nil, fmt.Errorf("context limit %q: want model=tokens", part)
+		}
+		limits[strings.ToLower(strings.TrimSpace(model))] = n
+	}
+	return limits, nil
+}
+
+// For returns the context window of a model, or 0 when unknown
+func (l ContextLimits) For(model string) int64 {
+	// Vertex reports models as "models/gemini-..."
+	model = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(model)), "models/")
+	best, limit := -1, int64(0)
+	for prefix, n := range l {
+		if strings.HasPrefix(model, prefix) && len(prefix) > best {
+			best, limit = len(prefix), n
+		}
+	}
+	return limit
+}
+
+// extractTokenUsage reads the prompt and completion token counts reported by the provider
+func extractTokenUsage(flat map[string]any) (input, output int64) {
+	for _, k := range []string{"gen_ai.usage.input_tokens", "gen_ai.usage.prompt_tokens", "llm.token_count.prompt", "llm.usage.prompt_tokens",
+		"gcp.vertex.agent.llm_response.usage_metadata.prompt_token_count", "usage_metadata.prompt_token_count"} {
+		if n, ok := asInt(flat[k]); ok && n > 0 {
+			input = n
+			break
+		}
+	}
+	for _, k := range []string{"gen_ai.usage.output_tokens", "gen_ai.usage.completion_tokens", "llm.token_count.completion", "llm.usage.completion_tokens",
+		"gcp.vertex.agent.llm_response.usage_metadata.candidates_token_count", "usage_metadata.candidates_token_count"} {
+		if n, ok := asInt(flat[k]); ok && n > 0 {
+			output = n
+			break
+		}
+	}
+	return input, output
+}
+
+// TokenTurn is the context used by one turn (trace) of a conversation: the largest LLM call in it
+type TokenTurn struct {
+	Turn          int       `json:"turn"`
+	TraceID       string    `json:"trace_id"`
+	SpanID        string    `json:"span_id"`
+	StartTime     time.Time `json:"start_time"`
+	Model         string    `json:"model"`
+	Calls         int       `json:"calls"`
+	InputTokens   int64     `json:"input_tokens"`
+	OutputTokens  int64     `json:"output_tokens"`
+	ContextTokens int64     `json:"context_tokens"`
+	// Estimated is set when the provider reported no usage and the prompt size was estimated
+	Estimated   bool    `json:"estimated,omitempty"`
+	Limit       int64   `json:"context_limit,omitempty"`
+	Utilization float64 `json:"utilization,omitempty"`
+}
+
+// TokenBudget summarizes how close a conversation gets to its model's context window
+type TokenBudget struct {
+	ConversationID  string      `json:"conversation_id"`
+	Model           string      `json:"model"`
+	ContextLimit    int64       `json:"context_limit"`
+	Turns           []TokenTurn `json:"turns,omitempty"`
+	TurnCount       int         `json:"turn_count"`
+	PeakTokens      int64       `json:"peak_tokens"`
+	PeakUtilization float64     `json:"peak_utilization"`
+	// Average context growth per turn, i.e. what each turn adds to the history
+	GrowthPerTurn float64 `json:"growth_per_turn"`
+	// Turns left before the threshold is crossed at the current growth rate (-1 when not growing)
+	TurnsUntilThreshold int `json:"turns_until_threshold"`
+	// History turns that fit under the threshold; a starting point for truncation settings
+	MaxHistoryTurns int    `json:"max_history_turns,omitempty"`
+	Advice          string `json:"advice,omitempty"`
+}
+
+// tokenTurns groups LLM spans of a conversation into turns (one per trace) in chronological order
+func tokenTurns(spans []Span, limits ContextLimits) []TokenTurn {
+	byTrace := make(map[string]*TokenTurn)
+	var order []*TokenTurn
+	for _, sp := range spans {
+		context, estimated := sp.InputTokens+sp.OutputTokens, false
+		if sp.InputTokens == 0 {
+			context = sp.PromptTokensSystem + sp.PromptTokensUser + sp.PromptTokensHistory + sp.PromptTokensTool + sp.OutputTokens
+			estimated = true
+		}
+		if context == 0 {
+			continue
+		}
+		t := byTrace[sp.TraceID]
+		if t == nil {
+			t = &TokenTurn{TraceID: sp.TraceID, StartTime: sp.StartTime}
+			byTrace[sp.TraceID] = t
+			order = append(order, t)
+		}
+		t.Calls++
+		if context > t.ContextTokens {
+			t.SpanID, t.Model = sp.SpanID, sp.Model
+			t.InputTokens, t.OutputTokens, t.ContextTokens, t.Estimated = sp.InputTokens, sp.OutputTokens, context, estimated
+		}
+	}
+	sort.SliceStable(order, func(i, j int) bool { return order[i].StartTime.Before(order[j].StartTime) })
+	turns := make([]TokenTurn, len(order))
+	for i, t := range order {
+		t.Turn = i + 1
+		if t.Limit = limits.For(t.Model); t.Limit > 0 {
+			t.Utilization = float64(t.ContextTokens) / float64(t.Limit)
+		}
+		turns[i] = *t
+	}
+	return turns
+}
+
+// computeTokenBudget derives peak usage, growth and truncation advice from the turns
+func computeTokenBudget(conversationID string, turns []TokenTurn, threshold float64) TokenBudget {
+	b := TokenBudget{ConversationID: conversationID, Turns: turns, TurnCount: len(turns), TurnsUntilThreshold: -1}
+	if len(turns) == 0 {
+		return b
+	}
+	for _, t := range turns {
+		if t.ContextTokens >= b.PeakTokens {
+			b.PeakTokens, b.Model, b.ContextLimit = t.ContextTokens, t.Model, t.Limit
+		}
+		if t.Utilization > b.PeakUtilization {
+			b.PeakUtilization = t.Utilization
+		}
+	}
+	first, last := turns[0], turns[len(turns)-1]
+	if len(turns) > 1 {
+		b.GrowthPerTurn = float64(last.ContextTokens-first.ContextTokens) / float64(len(turns)-1)
+	}
+	if b.ContextLimit == 0 {
+		b.Advice = "Unknown context window for this model; set MODEL_CONTEXT_LIMITS to enable utilization checks"
+		return b
+	}
+	budget := threshold * float64(b.ContextLimit)
+	if b.GrowthPerTurn > 0 {
+		b.TurnsUntilThreshold = int(math.Max(0, math.Floor((budget-float64(last.ContextTokens))/b.GrowthPerTurn)))
+		b.MaxHistoryTurns = int(math.Max(0, math.Floor((budget-float64(first.ContextTokens))/b.GrowthPerTurn)))
+	}
+	switch {
+	case b.PeakUtilization >= threshold && b.GrowthPerTurn <= 0:
+		// history isn't what fills the window
+		b.Advice = fmt.Sprintf("Single calls use %.0f%% of the context window; trim the system prompt, tool output or retrieved content", b.PeakUtilization*100)
+	case b.PeakUtilization >= 1:
+		b.Advice = fmt.Sprintf("Context window exceeded; keep at most %d turns of history or summarize older turns", b.MaxHistoryTurns)
+	case b.PeakUtilization >= threshold:
+		b.Advice = fmt.Sprintf("Above %.0f%% of the context window; keep at most %d turns of history or summarize older turns", threshold*100, b.MaxHistoryTurns)
+	case b.TurnsUntilThreshold >= 0 && b.TurnsUntilThreshold <= 5:
+		b.Advice = fmt.Sprintf("At the current growth of %.0f tokens per turn, %.0f%% of the context window is reached in %d turns", b.GrowthPerTurn, threshold*100, b.TurnsUntilThreshold)
+	}
+	return b
+}
+
+// GetConversationLLMSpans returns the LLM calls of a conversation with their token counts
+func (g *GormDB) GetConversationLLMSpans(conversationID string) ([]Span, error) {
+	var spans []Span
+	err := g.db.Select("span_id, trace_id, start_time, model, input_tokens, output_tokens, "+
+		"prompt_tokens_system, prompt_tokens_user, prompt_tokens_history, prompt_tokens_tool").
+		Where("conversation_id = ?", conversationID).
+		Where("input_tokens > 0 OR prompt_tokens_system + prompt_tokens_user + prompt_tokens_history + prompt_tokens_tool > 0").
+		Order("start_time ASC, seq ASC").
+		Limit(5000).
+		Find(&spans).Error
+	return spans, err
+}
+
+// GetConversationPeakTokens returns the largest LLM call of each conversation active in the range
+func (g *GormDB) GetConversationPeakTokens(filter StatsFilter) ([]Span, error) {
+	var rows []struct {
+		ConversationID string
+		Model          string
+		Peak           int64
+	}
+	err := filter.apply(g.db.Model(&Span{})).
+		Select(`conversation_id, MAX(model) AS model, MAX(CASE WHEN input_tokens > 0 THEN input_tokens + output_tokens
+			ELSE prompt_tokens_system + prompt_tokens_user + prompt_tokens_history + prompt_tokens_tool + output_tokens END) AS peak`).
+		Where("conversation_id <> ''").
+		Where("input_tokens > 0 OR prompt_tokens_system + prompt_tokens_user + prompt_tokens_history + prompt_tokens_tool > 0").
+		Group("conversation_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Span, len(rows))
+	for i, r := range rows {
+		out[i] = Span{ConversationID: r.ConversationID, Model: r.Model, InputTokens: r.Peak}
+	}
+	return out, nil
+}
+
+func parseThreshold(s string) (float64, error) {
+	if s == "" {
+		return 0.8, nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v <= 0 || v > 1 {
+		return 0, fmt.Errorf("threshold must be between 0 and 1")
+	}
+	return v, nil
+}
+
+// getConversationTokenBudgetHandler returns per-turn context usage of one conversation
+func getConversationTokenBudgetHandler(db Database, limits ContextLimits, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		threshold, err := parseThreshold(r.URL.Query().Get("threshold"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		id := strings.TrimSpace(mux.Vars(r)["id"])
+		spans, err := db.GetConversationLLMSpans(id)
+		if err != nil {
+			logger.Error("Failed to get LLM spans of conversation %s: %v", id, err)
+			http.Error(w, fmt.Sprintf("Failed to get token budget: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(computeTokenBudget(id, tokenTurns(spans, limits), threshold))
+	}
+}
+
+// getTokenBudgetStatsHandler lists conversations whose largest call used at least ?threshold
+// (default 0.8) of the model's context window, closest to the limit first
+func getTokenBudgetStatsHandler(db Database, limits ContextLimits, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := parseStatsFilter(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		threshold, err := parseThreshold(r.URL.Query().Get("threshold"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		peaks, err := db.GetConversationPeakTokens(filter)
+		if err != nil {
+			logger.Error("Failed to get conversation token peaks: %v", err)
+			http.Error(w, fmt.Sprintf("Failed to get token budget stats: %v", err), http.StatusInternalServerError)
+			return
+		}
+		out := []TokenBudget{}
+		for _, p := range peaks {
+			limit := limits.For(p.Model)
+			if limit == 0 || float64(p.InputTokens)/float64(limit) < threshold {
+				continue
+			}
+			spans, err := db.GetConversationLLMSpans(p.ConversationID)
+			if err != nil {
+				logger.Error("Failed to get LLM spans of conversation %s: %v", p.ConversationID, err)
+				continue
+			}
+			b := computeTokenBudget(p.ConversationID, tokenTurns(spans, limits), threshold)
+			b.Turns = nil
+			out = append(out, b)
+		}
+		sort.Slice(out, func(i, j int) bool { return out[i].PeakUtilization > out[j].PeakUtilization })
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(out)
+	}
+}
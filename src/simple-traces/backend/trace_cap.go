@@ -0,0 +1,154 @@
+package backend
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// traceCapIdle is how long a trace's count is kept in memory after its last span
+const traceCapIdle = time.Hour
+
+// TraceSpanCap limits how many spans are stored per trace, so a runaway agent loop can't fill
+// the database or make its trace unviewable. Counts live in memory and are seeded from the
+// database the first time a trace is seen, so they survive restarts.
+type TraceSpanCap struct {
+	max int64
+
+	mu        sync.Mutex
+	traces    map[string]*traceSpanCount
+	lastSweep time.Time
+}
+
+type traceSpanCount struct {
+	n         int64
+	truncated bool
+	lastSeen  time.Time
+}
+
+// NewTraceSpanCap creates a cap of max spans per trace; zero or less disables it (nil)
+func NewTraceSpanCap(max int) *TraceSpanCap {
+	if max <= 0 {
+		return nil
+	}
+	return &TraceSpanCap{max: int64(max), traces: make(map[string]*traceSpanCount)}
+}
+
+// Seed loads the stored span counts of traces not tracked yet
+func (c *TraceSpanCap) Seed(db Database, traceIDs []string) error {
+	if c == nil || len(traceIDs) == 0 {
+		return nil
+	}
+	now := time.Now()
+	c.mu.Lock()
+	var unseen []string
+	for _, id := range traceIDs {
+		if _, ok := c.traces[id]; !ok {
+			unseen = append(unseen, id)
+		}
+	}
+	c.sweep(now)
+	c.mu.Unlock()
+	if len(unseen) == 0 {
+		return nil
+	}
+	counts, err := db.CountSpansByTraceIDs(unseen)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, id := range unseen {
+		if _, ok := c.traces[id]; !ok {
+			c.traces[id] = &traceSpanCount{n: counts[id], truncated: counts[id] > c.max, lastSeen: now}
+		}
+	}
+	return nil
+}
+
+// Admit counts one more span of traceID and reports whether it may be stored. marker is true
+// for the first span over the cap, when the caller should store the truncation marker.
+func (c *TraceSpanCap) Admit(traceID string) (ok, marker bool) {
+	if c == nil {
+		return true, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := c.traces[traceID]
+	if t == nil {
+		t = &traceSpanCount{}
+		c.traces[traceID] = t
+	}
+	t.lastSeen = time.Now()
+	if t.n < c.max {
+		t.n++
+		return true, false
+	}
+	if t.truncated {
+		return false, false
+	}
+	t.truncated = true
+	return false, true
+}
+
+// sweep forgets traces idle for traceCapIdle, at most once a minute; callers hold mu
+func (c *TraceSpanCap) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < time.Minute {
+		return
+	}
+	c.lastSweep = now
+	for id, t := range c.traces {
+		if now.Sub(t.lastSeen) > traceCapIdle {
+			delete(c.traces, id)
+		}
+	}
+}
+
+// truncationMarker is the span stored once per capped trace. Its id is derived from the trace
+// id so it is only ever stored once.
+func (c *TraceSpanCap) truncationMarker(first Span) Span {
+	sum := sha1.Sum([]byte(first.TraceID + "/simpleTraces.truncated"))
+	attrs, _ := json.Marshal(map[string]any{
+		"simpleTraces.truncated":      true,
+		"simpleTraces.span_cap":       c.max,
+		"simpleTraces.category":       "other",
+		"simpleTraces.project.id":     first.ProjectID,
+		"simpleTraces.truncated.note": "further spans of this trace were dropped at ingest (MAX_SPANS_PER_TRACE)",
+	})
+	return Span{
+		SpanID:         hex.EncodeToString(sum[:8]),
+		TraceID:        first.TraceID,
+		ProjectID:      first.ProjectID,
+		ConversationID: first.ConversationID,
+		Name:           "simpleTraces.truncated",
+		StartTime:      first.StartTime,
+		EndTime:        first.StartTime,
+		StatusCode:     "UNSET",
+		StatusDesc:     "span limit reached",
+		Attributes:     string(attrs),
+		Category:       "other",
+	}
+}
+
+// CountSpansByTraceIDs returns the number of stored spans per trace id
+func (g *GormDB) CountSpansByTraceIDs(traceIDs []string) (map[string]int64, error) {
+	var rows []struct {
+		TraceID string
+		N       int64
+	}
+	counts := make(map[string]int64, len(traceIDs))
+	for start := 0; start < len(traceIDs); start += maxBatchRows {
+		end := min(start+maxBatchRows, len(traceIDs))
+		if err := g.primary().Model(&Span{}).Select("trace_id, COUNT(*) as n").
+			Where("trace_id IN ?", traceIDs[start:end]).
+			Group("trace_id").Scan(&rows).Error; err != nil {
+			return nil, err
+		}
+		for _, r := range rows {
+			counts[r.TraceID] = r.N
+		}
+	}
+	return counts, nil
+}
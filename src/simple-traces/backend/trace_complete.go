@@ -0,0 +1,14 @@
+package backend
+
+import "time"
+
+// rootCountSQL counts the root spans of a group of spans
+const rootCountSQL = "SUM(CASE WHEN " + rootSpanSQL + " THEN 1 ELSE 0 END)"
+
+// traceComplete reports whether a trace is finished: its root span has arrived (SDKs export
+// spans when they end) and no span arrived for the quiet period. The latest arrival is read
+// from the spans' server sequence, which counts in microseconds of the receive time; spans
+// stored before it existed count as arrived long ago.
+func (g *GormDB) traceComplete(rootCount int, lastSeq int64) bool {
+	return rootCount > 0 && lastSeq < time.Now().Add(-g.completeAfter).UnixMicro()
+}
@@ -0,0 +1,129 @@
+package backend
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"gorm.io/gorm"
+)
+
+// The transcript index is a full-text index over the prompt and response text of spans, see
+// transcriptText. It is maintained by the database itself, so every way spans are written or
+// deleted (ingest, retention, imports, migrate) keeps it current:
+//   - SQLite: an FTS4 table filled by triggers on spans. FTS rows are keyed by the integer ids
+//     of span_transcript_docs because the implicit rowids of spans change on VACUUM.
+//   - Postgres: a GIN index on the tsvector of simpletraces_transcript(attributes).
+
+// pgTranscriptMaxChars caps the text indexed per span, tsvectors are limited to 1MB
+const pgTranscriptMaxChars = 256 << 10
+
+// sqliteTranscriptExpr extracts the transcript of a span from its attributes column col,
+// NULL when there is none
+func sqliteTranscriptExpr(col string) string {
+	pick := func(keys []string) string {
+		var parts []string
+		for _, k := range keys {
+			parts = append(parts, fmt.Sprintf(`NULLIF(TRIM(json_extract(%s, '$."%s"')), '')`, col, k))
+		}
+		return "COALESCE(" + strings.Join(parts, ", ") + ", '')"
+	}
+	// TRIM(x) only strips spaces, the newline joining an empty prompt and response has to go too
+	return fmt.Sprintf("CASE WHEN json_valid(%s) THEN NULLIF(TRIM(%s || char(10) || %s, ' ' || char(10)), '') END",
+		col, pick(promptKeys), pick(responseKeys))
+}
+
+// sqliteTranscriptIndex adds the transcript of span new.span_id, in a trigger body
+func sqliteTranscriptIndex() string {
+	return `
+		DELETE FROM span_transcripts WHERE docid IN (SELECT docid FROM span_transcript_docs WHERE span_id = new.span_id);
+		DELETE FROM span_transcript_docs WHERE span_id = new.span_id;
+		INSERT INTO span_transcript_docs(span_id) SELECT new.span_id WHERE ` + sqliteTranscriptExpr("new.attributes") + ` IS NOT NULL;
+		INSERT INTO span_transcripts(docid, transcript) SELECT last_insert_rowid(), ` + sqliteTranscriptExpr("new.attributes") + `
+			WHERE changes() > 0;`
+}
+
+// pgTranscriptExpr is the expression of the Postgres transcript index; queries must use it
+// verbatim for the index to apply
+const pgTranscriptExpr = "to_tsvector('simple', COALESCE(simpletraces_transcript(attributes), ''))"
+
+// pgTranscriptFunc extracts the transcript of a span from its attributes, NULL when there is
+// none or the attributes aren't JSON
+func pgTranscriptFunc() string {
+	pick := func(keys []string) string {
+		var parts []string
+		for _, k := range keys {
+			parts = append(parts, fmt.Sprintf("NULLIF(btrim(j->>'%s'), '')", k))
+		}
+		return "COALESCE(" + strings.Join(parts, ", ") + ")"
+	}
+	return fmt.Sprintf(`CREATE OR REPLACE FUNCTION simpletraces_transcript(attrs text) RETURNS text
+LANGUAGE plpgsql IMMUTABLE AS $$
+DECLARE j jsonb;
+BEGIN
+	j := attrs::jsonb;
+	RETURN left(NULLIF(concat_ws(E'\n', %s, %s), ''), %d);
+EXCEPTION WHEN others THEN
+	RETURN NULL;
+END $$`, pick(promptKeys), pick(responseKeys), pgTranscriptMaxChars)
+}
+
+// createTranscriptIndex creates the transcript index and fills it from the stored spans when
+// it is new
+func createTranscriptIndex(db *gorm.DB) error {
+	if db.Dialector.Name() == "postgres" {
+		if err := db.Exec(pgTranscriptFunc()).Error; err != nil {
+			return err
+		}
+		return db.Exec("CREATE INDEX IF NOT EXISTS idx_spans_transcript ON spans USING GIN (" + pgTranscriptExpr + ")").Error
+	}
+	if db.Migrator().HasTable("span_transcripts") {
+		return nil
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		statements := []string{
+			"CREATE TABLE span_transcript_docs (docid INTEGER PRIMARY KEY, span_id TEXT NOT NULL UNIQUE)",
+			"CREATE VIRTUAL TABLE span_transcripts USING fts4(transcript, tokenize=unicode61)",
+			"CREATE TRIGGER spans_transcript_insert AFTER INSERT ON spans BEGIN" + sqliteTranscriptIndex() + "\nEND",
+			"CREATE TRIGGER spans_transcript_update AFTER UPDATE OF attributes ON spans BEGIN" + sqliteTranscriptIndex() + "\nEND",
+			`CREATE TRIGGER spans_transcript_delete AFTER DELETE ON spans BEGIN
+				DELETE FROM span_transcripts WHERE docid IN (SELECT docid FROM span_transcript_docs WHERE span_id = old.span_id);
+				DELETE FROM span_transcript_docs WHERE span_id = old.span_id;
+			END`,
+			"INSERT INTO span_transcript_docs(span_id) SELECT span_id FROM spans WHERE " + sqliteTranscriptExpr("attributes") + " IS NOT NULL",
+			"INSERT INTO span_transcripts(docid, transcript) SELECT d.docid, " + sqliteTranscriptExpr("s.attributes") +
+				" FROM span_transcript_docs d JOIN spans s ON s.span_id = d.span_id",
+		}
+		for _, stmt := range statements {
+			if err := tx.Exec(stmt).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// transcriptTerms splits a search into the lowercase words the transcript index holds
+func transcriptTerms(search string) []string {
+	return strings.FieldsFunc(strings.ToLower(search), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// whereTranscriptMatches restricts a spans query to spans whose transcript has a word starting
+// with each of terms
+func whereTranscriptMatches(db *gorm.DB, terms []string) *gorm.DB {
+	if db.Dialector.Name() == "postgres" {
+		query := make([]string, len(terms))
+		for i, t := range terms {
+			query[i] = t + ":*"
+		}
+		return db.Where(pgTranscriptExpr+" @@ to_tsquery('simple', ?)", strings.Join(query, " & "))
+	}
+	query := make([]string, len(terms))
+	for i, t := range terms {
+		query[i] = `"` + t + `*"`
+	}
+	return db.Where("span_id IN (SELECT span_id FROM span_transcript_docs WHERE docid IN "+
+		"(SELECT docid FROM span_transcripts WHERE span_transcripts MATCH ?))", strings.Join(query, " "))
+}
@@ -0,0 +1,137 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+)
+
+// TransformRule is one ingest transform loaded from INGEST_TRANSFORMS_FILE.
+// With Attribute set, the expression result is stored under that attribute key
+// (nil results are skipped). With Status set, the span gets that status when the
+// expression is true. Otherwise the expression decides whether the span is kept:
+// `drop` or true discards the span, anything else keeps it.
+//
+// Example file:
+//
+//	[
+//	  {"name": "drop-healthz", "expr": "attrs[\"http.route\"] == \"/healthz\" ? drop : keep"},
+//	  {"name": "env", "attribute": "deployment.env", "expr": "attrs[\"service.name\"] endsWith \"-staging\" ? \"staging\" : \"prod\""},
+//	  {"name": "tool-failed", "status": "ERROR", "expr": "attrs[\"tool.result.success\"] == false"}
+//	]
+type TransformRule struct {
+	Name      string `json:"name"`
+	Expr      string `json:"expr"`
+	Attribute string `json:"attribute,omitempty"`
+	Status    string `json:"status,omitempty"`
+
+	program *vm.Program
+}
+
+// Transforms applies operator-defined expressions to every ingested span
+type Transforms struct {
+	rules []TransformRule
+}
+
+const (
+	transformDrop = "drop"
+	transformKeep = "keep"
+)
+
+// transformEnv is the environment expressions are evaluated in
+func transformEnv(name, kind, status string, durationMS int64, attrs map[string]any) map[string]any {
+	return map[string]any{
+		"attrs":       attrs,
+		"name":        name,
+		"kind":        kind,
+		"status":      status,
+		"duration_ms": durationMS,
+		"drop":        transformDrop,
+		"keep":        transformKeep,
+	}
+}
+
+// LoadTransforms reads and compiles the rules in path. An empty path returns nil.
+func LoadTransforms(path string) (*Transforms, error) {
+	if strings.TrimSpace(path) == "" {
+		return nil, nil
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read transforms: %w", err)
+	}
+	var rules []TransformRule
+	if err := json.Unmarshal(raw, &rules); err != nil {
+		return nil, fmt.Errorf("parse transforms: %w", err)
+	}
+	return NewTransforms(rules)
+}
+
+// NewTransforms compiles the given rules
+func NewTransforms(rules []TransformRule) (*Transforms, error) {
+	env := transformEnv("", "", "", 0, map[string]any{})
+	for i := range rules {
+		r := &rules[i]
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rule-%d", i+1)
+		}
+		r.Status = strings.ToUpper(strings.TrimSpace(r.Status))
+		switch r.Status {
+		case "", "OK", "ERROR", "UNSET":
+		default:
+			return nil, fmt.Errorf("transform %q: status must be OK, ERROR or UNSET", r.Name)
+		}
+		if r.Status != "" && r.Attribute != "" {
+			return nil, fmt.Errorf("transform %q: set either attribute or status", r.Name)
+		}
+		program, err := expr.Compile(r.Expr, expr.Env(env), expr.AllowUndefinedVariables())
+		if err != nil {
+			return nil, fmt.Errorf("compile transform %q: %w", r.Name, err)
+		}
+		r.program = program
+	}
+	return &Transforms{rules: rules}, nil
+}
+
+// Len returns the number of rules
+func (t *Transforms) Len() int {
+	if t == nil {
+		return 0
+	}
+	return len(t.rules)
+}
+
+// Apply runs all rules in order against attrs, mutating it with derived attributes.
+// It returns the (possibly changed) status and false when a rule dropped the span.
+// Evaluation errors skip the rule.
+func (t *Transforms) Apply(name, kind, status string, durationMS int64, attrs map[string]any, logger *Logger) (string, bool) {
+	if t == nil {
+		return status, true
+	}
+	for _, r := range t.rules {
+		out, err := expr.Run(r.program, transformEnv(name, kind, status, durationMS, attrs))
+		if err != nil {
+			logger.Debug("Transform %q failed on span %q: %v", r.Name, name, err)
+			continue
+		}
+		switch {
+		case r.Attribute != "":
+			if out != nil {
+				attrs[r.Attribute] = out
+			}
+		case r.Status != "":
+			if out == true && status != r.Status {
+				status = r.Status
+				attrs["simpleTraces.status.derived"] = r.Name
+			}
+		case out == transformDrop || out == true:
+			logger.Debug("Transform %q dropped span %q", r.Name, name)
+			return status, false
+		}
+	}
+	return status, true
+}
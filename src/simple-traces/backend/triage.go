@@ -0,0 +1,164 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Triage states of a trace group
+const (
+	TriageOpen          = "open"
+	TriageInvestigating = "investigating"
+	TriageResolved      = "resolved"
+	// TriageUntriaged matches groups nobody has triaged yet in filters
+	TriageUntriaged = "untriaged"
+)
+
+var triageStates = map[string]bool{TriageOpen: true, TriageInvestigating: true, TriageResolved: true}
+
+// TraceTriage holds the workflow state of a trace group
+type TraceTriage struct {
+	TraceID   string    `gorm:"primaryKey" json:"trace_id"`
+	Status    string    `gorm:"index" json:"status"`
+	Assignee  string    `gorm:"index" json:"assignee,omitempty"`
+	IssueURL  string    `json:"issue_url,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TraceGroupFilter narrows trace group listings; zero values mean "no restriction"
+type TraceGroupFilter struct {
+	TraceID        string
+	ProjectID      string
+	ConversationID string
+	RunID          string
+	Search         string
+	Status         string
+	Assignee       string
+	// OnlyErrors keeps groups with at least one span in ERROR status
+	OnlyErrors bool
+	// Complete keeps only complete (true) or still active (false) groups, see traceComplete
+	Complete *bool
+	// EntryPoint keeps groups whose root span has this name
+	EntryPoint string
+	// Sort is "" for most recent activity first or TraceGroupSortHealth for the worst health score first
+	Sort string
+}
+
+// TraceGroupSortHealth orders trace groups by health score, worst first
+const TraceGroupSortHealth = "health"
+
+// TriageUpdate changes the status and/or assignee; nil fields are left untouched
+type TriageUpdate struct {
+	Status   *string `json:"status"`
+	Assignee *string `json:"assignee"`
+}
+
+// UpdateTraceTriage applies an update and returns the resulting triage state.
+// New triage rows start as open. gorm.ErrRecordNotFound is returned for unknown traces.
+func (g *GormDB) UpdateTraceTriage(traceID string, u TriageUpdate) (*TraceTriage, error) {
+	var count int64
+	if err := g.db.Model(&Span{}).Where("trace_id = ?", traceID).Limit(1).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	t := TraceTriage{TraceID: traceID, Status: TriageOpen}
+	if err := g.db.Where("trace_id = ?", traceID).Limit(1).Find(&t).Error; err != nil {
+		return nil, err
+	}
+	if u.Status != nil {
+		t.Status = *u.Status
+	}
+	if u.Assignee != nil {
+		t.Assignee = strings.TrimSpace(*u.Assignee)
+	}
+	t.UpdatedAt = time.Now()
+	if err := g.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&t).Error; err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// applyTriageFilter restricts a spans query grouped by trace_id to matching trace groups
+func (g *GormDB) applyTriageFilter(query *gorm.DB, filter TraceGroupFilter) *gorm.DB {
+	switch filter.Status {
+	case "":
+	case TriageUntriaged:
+		query = query.Where("trace_id NOT IN (?)", g.db.Model(&TraceTriage{}).Select("trace_id"))
+	default:
+		query = query.Where("trace_id IN (?)", g.db.Model(&TraceTriage{}).Select("trace_id").Where("status = ?", filter.Status))
+	}
+	if filter.Assignee != "" {
+		query = query.Where("trace_id IN (?)", g.db.Model(&TraceTriage{}).Select("trace_id").Where("assignee = ?", filter.Assignee))
+	}
+	if filter.OnlyErrors {
+		query = query.Having("SUM(CASE WHEN status_code = 'ERROR' THEN 1 ELSE 0 END) > 0")
+	}
+	return query
+}
+
+// attachTriage fills the triage fields of trace groups; failures only drop the fields
+func (g *GormDB) attachTriage(groups []TraceGroup) {
+	if len(groups) == 0 {
+		return
+	}
+	ids := make([]string, len(groups))
+	for i, gr := range groups {
+		ids[i] = gr.TraceID
+	}
+	var rows []TraceTriage
+	if err := g.db.Where("trace_id IN ?", ids).Find(&rows).Error; err != nil {
+		return
+	}
+	byID := make(map[string]TraceTriage, len(rows))
+	for _, r := range rows {
+		byID[r.TraceID] = r
+	}
+	for i := range groups {
+		if t, ok := byID[groups[i].TraceID]; ok {
+			groups[i].Status = t.Status
+			groups[i].Assignee = t.Assignee
+			groups[i].IssueURL = t.IssueURL
+		}
+	}
+}
+
+// updateTraceGroupHandler sets the triage status and/or assignee of a trace group
+func updateTraceGroupHandler(db Database, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		traceID := strings.TrimSpace(mux.Vars(r)["trace_id"])
+		var u TriageUpdate
+		if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		if u.Status == nil && u.Assignee == nil {
+			http.Error(w, "status or assignee is required", http.StatusBadRequest)
+			return
+		}
+		if u.Status != nil && !triageStates[*u.Status] {
+			http.Error(w, "status must be one of open, investigating, resolved", http.StatusBadRequest)
+			return
+		}
+		t, err := db.UpdateTraceTriage(traceID, u)
+		if err == gorm.ErrRecordNotFound {
+			http.Error(w, "Trace group not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error("Failed to update triage of %s: %v", traceID, err)
+			http.Error(w, fmt.Sprintf("Failed to update trace group: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(t)
+	}
+}
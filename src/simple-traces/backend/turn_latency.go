@@ -0,0 +1,156 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// TurnLatency is where the time of one turn of a conversation (one trace, from the user's
+// request to the last span ending) went. LLM, tool, DB and other time split the wait the way
+// LatencyBreakdown does, so parallel calls are not counted twice.
+type TurnLatency struct {
+	Turn    int       `json:"turn"`
+	TraceID string    `json:"trace_id"`
+	Name    string    `json:"name,omitempty"`
+	Start   time.Time `json:"start"`
+	// WaitMS is how long the user waited for the turn
+	WaitMS  int64 `json:"wait_ms"`
+	LLMMS   int64 `json:"llm_ms"`
+	ToolMS  int64 `json:"tool_ms"`
+	DBMS    int64 `json:"db_ms"`
+	OtherMS int64 `json:"other_ms"`
+	// Calls made during the turn
+	LLMCalls  int `json:"llm_calls"`
+	ToolCalls int `json:"tool_calls"`
+	Errors    int `json:"errors"`
+	// GapMS is the time since the previous turn ended, the user's think time
+	GapMS *int64 `json:"gap_ms,omitempty"`
+}
+
+// ConversationLatency is the per-turn latency of a conversation with session totals
+type ConversationLatency struct {
+	ConversationID string        `json:"conversation_id"`
+	Turns          []TurnLatency `json:"turns"`
+	// Totals over all turns
+	WaitMS  int64 `json:"wait_ms"`
+	LLMMS   int64 `json:"llm_ms"`
+	ToolMS  int64 `json:"tool_ms"`
+	DBMS    int64 `json:"db_ms"`
+	OtherMS int64 `json:"other_ms"`
+	// Distribution of the turns' wait
+	AvgWaitMS float64 `json:"avg_wait_ms"`
+	P50WaitMS int64   `json:"p50_wait_ms"`
+	P95WaitMS int64   `json:"p95_wait_ms"`
+	// SlowestTurn is the turn number with the longest wait
+	SlowestTurn int `json:"slowest_turn"`
+}
+
+// GetConversationLatency splits the spans of a conversation into turns by trace, ordered by
+// start, and breaks down each turn's wait. A conversation without spans has no turns.
+func (g *GormDB) GetConversationLatency(conversationID string) (*ConversationLatency, error) {
+	var spans []Span
+	if err := g.db.Select("span_id, trace_id, parent_span_id, name, start_time, end_time, category, status_code").
+		Where("conversation_id = ?", conversationID).
+		Limit(100000).
+		Find(&spans).Error; err != nil {
+		return nil, err
+	}
+	byTrace := make(map[string][]Span)
+	for _, sp := range spans {
+		byTrace[sp.TraceID] = append(byTrace[sp.TraceID], sp)
+	}
+	out := &ConversationLatency{ConversationID: conversationID, Turns: make([]TurnLatency, 0, len(byTrace))}
+	ends := make(map[string]time.Time, len(byTrace))
+	for traceID, trace := range byTrace {
+		t := TurnLatency{TraceID: traceID, Start: trace[0].StartTime}
+		end := trace[0].EndTime
+		for _, sp := range trace {
+			if sp.StartTime.Before(t.Start) {
+				t.Start = sp.StartTime
+			}
+			if sp.EndTime.After(end) {
+				end = sp.EndTime
+			}
+			if sp.ParentSpanID == "" {
+				t.Name = sp.Name
+			}
+			switch sp.Category {
+			case "llm":
+				t.LLMCalls++
+			case "tool":
+				t.ToolCalls++
+			}
+			if sp.StatusCode == "ERROR" {
+				t.Errors++
+			}
+		}
+		ends[traceID] = end
+		if b := computeLatencyBreakdown(trace); b != nil {
+			t.WaitMS = b.WallMS
+			t.LLMMS = int64(b.LLM * float64(b.WallMS))
+			t.ToolMS = int64(b.Tool * float64(b.WallMS))
+			t.DBMS = int64(b.DB * float64(b.WallMS))
+			t.OtherMS = max(t.WaitMS-t.LLMMS-t.ToolMS-t.DBMS, 0)
+		}
+		out.Turns = append(out.Turns, t)
+	}
+	sort.Slice(out.Turns, func(i, j int) bool {
+		if !out.Turns[i].Start.Equal(out.Turns[j].Start) {
+			return out.Turns[i].Start.Before(out.Turns[j].Start)
+		}
+		return out.Turns[i].TraceID < out.Turns[j].TraceID
+	})
+
+	waits := make([]int64, 0, len(out.Turns))
+	var slowest int64 = -1
+	for i := range out.Turns {
+		t := &out.Turns[i]
+		t.Turn = i + 1
+		if i > 0 {
+			gap := max(t.Start.Sub(ends[out.Turns[i-1].TraceID]).Milliseconds(), 0)
+			t.GapMS = &gap
+		}
+		out.WaitMS += t.WaitMS
+		out.LLMMS += t.LLMMS
+		out.ToolMS += t.ToolMS
+		out.DBMS += t.DBMS
+		out.OtherMS += t.OtherMS
+		waits = append(waits, t.WaitMS)
+		if t.WaitMS > slowest {
+			slowest, out.SlowestTurn = t.WaitMS, t.Turn
+		}
+	}
+	out.AvgWaitMS = average(waits)
+	out.P50WaitMS = percentile(waits, 50)
+	out.P95WaitMS = percentile(waits, 95)
+	return out, nil
+}
+
+// getConversationLatencyHandler returns the per-turn latency breakdown of a conversation
+func getConversationLatencyHandler(db Database, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimSpace(mux.Vars(r)["id"])
+		if id == "" {
+			http.Error(w, "missing id", http.StatusBadRequest)
+			return
+		}
+		latency, err := db.GetConversationLatency(id)
+		if err != nil {
+			logger.Error("Failed to get latency of conversation %s: %v", id, err)
+			http.Error(w, fmt.Sprintf("Failed to get conversation latency: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if len(latency.Turns) == 0 {
+			http.Error(w, "Conversation has no spans", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(latency)
+	}
+}
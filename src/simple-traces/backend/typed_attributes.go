@@ -0,0 +1,65 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+)
+
+// TypedAttribute is a span attribute with its type: string, int, float, bool or json
+// (arrays, objects and null)
+type TypedAttribute struct {
+	Type  string `json:"type"`
+	Value any    `json:"value"`
+}
+
+// TypedSpan is a span whose attributes are returned as a typed map instead of a JSON string
+type TypedSpan struct {
+	Span
+	Attributes map[string]TypedAttribute `json:"attributes"`
+}
+
+// typedAttributes decodes stored span attributes, keeping integers apart from floats
+func typedAttributes(raw string) map[string]TypedAttribute {
+	out := map[string]TypedAttribute{}
+	if raw == "" {
+		return out
+	}
+	dec := json.NewDecoder(bytes.NewReader([]byte(raw)))
+	dec.UseNumber()
+	var attrs map[string]any
+	if err := dec.Decode(&attrs); err != nil {
+		return out
+	}
+	for k, v := range attrs {
+		t := AttrType(v)
+		switch t {
+		case "int":
+			if n, ok := v.(json.Number); ok {
+				v, _ = n.Int64()
+			}
+		case "float":
+			if n, ok := v.(json.Number); ok {
+				v, _ = n.Float64()
+			}
+		case "array", "object", "null":
+			t = "json"
+		}
+		out[k] = TypedAttribute{Type: t, Value: v}
+	}
+	return out
+}
+
+// writeSpans encodes spans, with typed attributes when the request asks for ?attributes=typed
+func writeSpans(w http.ResponseWriter, r *http.Request, spans []Span) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.URL.Query().Get("attributes") != "typed" {
+		json.NewEncoder(w).Encode(spans)
+		return
+	}
+	typed := make([]TypedSpan, len(spans))
+	for i, sp := range spans {
+		typed[i] = TypedSpan{Span: sp, Attributes: typedAttributes(sp.Attributes)}
+	}
+	json.NewEncoder(w).Encode(typed)
+}
@@ -0,0 +1,305 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TopUser is the LLM usage of one end user in a time range
+type TopUser struct {
+	UserID        string    `json:"user_id"`
+	Projects      []string  `json:"projects"`
+	Calls         int64     `json:"calls"`
+	Conversations int64     `json:"conversations"`
+	InputTokens   int64     `json:"input_tokens"`
+	OutputTokens  int64     `json:"output_tokens"`
+	TotalTokens   int64     `json:"total_tokens"`
+	Cost          float64   `json:"cost_usd"`
+	FirstSeen     time.Time `json:"first_seen"`
+	LastSeen      time.Time `json:"last_seen"`
+	// OverQuota is set while the UserQuotaMonitor flags the user
+	OverQuota bool `json:"over_quota"`
+}
+
+// topUserSorts are the accepted orders of the top consumers list
+var topUserSorts = map[string]func(a, b TopUser) bool{
+	"tokens": func(a, b TopUser) bool { return a.TotalTokens > b.TotalTokens },
+	"cost":   func(a, b TopUser) bool { return a.Cost > b.Cost },
+	"calls":  func(a, b TopUser) bool { return a.Calls > b.Calls },
+}
+
+// GetTopUsers sums the LLM usage of each end user in the filter's range, ordered by sortBy
+// (tokens, cost or calls). The user of a call is taken from its span's user attributes, else
+// from its conversation; calls without a user are left out.
+func (g *GormDB) GetTopUsers(filter StatsFilter, prices ModelPrices, sortBy string, limit int) ([]TopUser, error) {
+	var rows []struct {
+		ProjectID      string
+		ConversationID string
+		Model          string
+		InputTokens    int64
+		OutputTokens   int64
+		StartTime      time.Time
+		Attributes     string
+	}
+	if err := filter.apply(g.db.Model(&Span{})).
+		Select("project_id, conversation_id, model, input_tokens, output_tokens, start_time, attributes").
+		Where("input_tokens > 0 OR output_tokens > 0").
+		Order("start_time ASC").
+		Limit(200000).
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	users := make([]string, len(rows))
+	var convIDs []string
+	seenConv := make(map[string]bool)
+	for i, r := range rows {
+		var attrs map[string]any
+		json.Unmarshal([]byte(r.Attributes), &attrs)
+		users[i] = firstStringAttr(attrs, userIDKeys)
+		if users[i] == "" && r.ConversationID != "" && !seenConv[r.ConversationID] {
+			seenConv[r.ConversationID] = true
+			convIDs = append(convIDs, r.ConversationID)
+		}
+	}
+	convUsers, err := g.conversationUsers(convIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	type usage struct {
+		TopUser
+		projects, conversations map[string]bool
+	}
+	byUser := make(map[string]*usage)
+	for i, r := range rows {
+		user := users[i]
+		if user == "" {
+			user = convUsers[r.ConversationID]
+		}
+		if user == "" {
+			continue
+		}
+		u := byUser[user]
+		if u == nil {
+			u = &usage{TopUser: TopUser{UserID: user, FirstSeen: r.StartTime}, projects: make(map[string]bool), conversations: make(map[string]bool)}
+			byUser[user] = u
+		}
+		u.Calls++
+		u.InputTokens += r.InputTokens
+		u.OutputTokens += r.OutputTokens
+		u.Cost += prices.Cost(r.Model, r.InputTokens, r.OutputTokens)
+		u.LastSeen = r.StartTime
+		u.projects[r.ProjectID] = true
+		if r.ConversationID != "" {
+			u.conversations[r.ConversationID] = true
+		}
+	}
+	out := make([]TopUser, 0, len(byUser))
+	for _, u := range byUser {
+		t := u.TopUser
+		t.TotalTokens = t.InputTokens + t.OutputTokens
+		t.Conversations = int64(len(u.conversations))
+		t.Projects = make([]string, 0, len(u.projects))
+		for p := range u.projects {
+			t.Projects = append(t.Projects, p)
+		}
+		sort.Strings(t.Projects)
+		out = append(out, t)
+	}
+	less, ok := topUserSorts[sortBy]
+	if !ok {
+		less = topUserSorts["tokens"]
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if less(out[i], out[j]) != less(out[j], out[i]) {
+			return less(out[i], out[j])
+		}
+		return out[i].UserID < out[j].UserID
+	})
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return out, nil
+}
+
+// UserQuotaMonitor flags end users whose token consumption in the trailing window exceeds the
+// quota, to catch abuse of an LLM feature. It checks every interval; a user crossing the quota
+// is logged as a warning once, and again when they fall back under it.
+type UserQuotaMonitor struct {
+	db     Database
+	prices ModelPrices
+	logger *Logger
+	quota  int64
+	window time.Duration
+	stop   chan struct{}
+
+	mu        sync.Mutex
+	flagged   map[string]*FlaggedUser
+	checkedAt time.Time
+}
+
+// FlaggedUser is a user over the token quota
+type FlaggedUser struct {
+	UserID string    `json:"user_id"`
+	Tokens int64     `json:"tokens"`
+	Since  time.Time `json:"since"`
+}
+
+// UserQuotaStatus is the monitor's current state
+type UserQuotaStatus struct {
+	TokenQuota int64         `json:"token_quota"`
+	WindowMS   int64         `json:"window_ms"`
+	CheckedAt  *time.Time    `json:"checked_at,omitempty"`
+	Flagged    []FlaggedUser `json:"flagged"`
+}
+
+// NewUserQuotaMonitor starts checking every interval; nil when quota or interval is zero or less
+func NewUserQuotaMonitor(db Database, prices ModelPrices, quota int64, window, interval time.Duration, logger *Logger) *UserQuotaMonitor {
+	metrics.Describe("simpletraces_users_over_quota", "gauge", "End users whose token consumption is above USER_TOKEN_QUOTA")
+	metrics.Describe("simpletraces_user_quota_alerts_total", "counter", "Warnings logged because an end user exceeded USER_TOKEN_QUOTA")
+	if quota <= 0 || interval <= 0 {
+		return nil
+	}
+	m := &UserQuotaMonitor{
+		db:      db,
+		prices:  prices,
+		logger:  logger,
+		quota:   quota,
+		window:  window,
+		stop:    make(chan struct{}),
+		flagged: make(map[string]*FlaggedUser),
+	}
+	go m.loop(interval)
+	return m
+}
+
+// Close stops the monitor
+func (m *UserQuotaMonitor) Close() {
+	close(m.stop)
+}
+
+func (m *UserQuotaMonitor) loop(interval time.Duration) {
+	m.check()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+			m.check()
+		}
+	}
+}
+
+func (m *UserQuotaMonitor) check() {
+	now := time.Now()
+	users, err := m.db.GetTopUsers(StatsFilter{Since: now.Add(-m.window)}, m.prices, "tokens", 0)
+	if err != nil {
+		m.logger.Error("Failed to check user token quotas: %v", err)
+		return
+	}
+	over := make(map[string]int64)
+	for _, u := range users {
+		if u.TotalTokens <= m.quota {
+			break
+		}
+		over[u.UserID] = u.TotalTokens
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.checkedAt = now
+	for id, tokens := range over {
+		if f := m.flagged[id]; f != nil {
+			f.Tokens = tokens
+			continue
+		}
+		m.flagged[id] = &FlaggedUser{UserID: id, Tokens: tokens, Since: now}
+		metrics.Inc("simpletraces_user_quota_alerts_total")
+		m.logger.Warn("User %s used %d tokens in the last %s, over the quota of %d", id, tokens, m.window, m.quota)
+	}
+	for id, f := range m.flagged {
+		if _, ok := over[id]; !ok {
+			delete(m.flagged, id)
+			m.logger.Info("User %s is back under the token quota after %s", id, now.Sub(f.Since).Round(time.Second))
+		}
+	}
+	metrics.Set("simpletraces_users_over_quota", float64(len(m.flagged)))
+}
+
+// Status reports the flagged users, most tokens first; nil when the monitor is disabled
+func (m *UserQuotaMonitor) Status() *UserQuotaStatus {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st := &UserQuotaStatus{TokenQuota: m.quota, WindowMS: m.window.Milliseconds(), Flagged: make([]FlaggedUser, 0, len(m.flagged))}
+	if !m.checkedAt.IsZero() {
+		checked := m.checkedAt
+		st.CheckedAt = &checked
+	}
+	for _, f := range m.flagged {
+		st.Flagged = append(st.Flagged, *f)
+	}
+	sort.Slice(st.Flagged, func(i, j int) bool { return st.Flagged[i].Tokens > st.Flagged[j].Tokens })
+	return st
+}
+
+// TopUsersStats lists the top consumers and, when quotas are enforced, the monitor's state
+type TopUsersStats struct {
+	Users []TopUser        `json:"users"`
+	Quota *UserQuotaStatus `json:"quota,omitempty"`
+}
+
+// getTopUsersHandler lists the end users consuming the most tokens (sort=tokens|cost|calls,
+// limit, window=24h by default)
+func getTopUsersHandler(db Database, prices ModelPrices, monitor *UserQuotaMonitor, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		filter, err := parseStatsFilter(q)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sortBy := strings.TrimSpace(q.Get("sort"))
+		if sortBy == "" {
+			sortBy = "tokens"
+		}
+		if _, ok := topUserSorts[sortBy]; !ok {
+			http.Error(w, "sort must be tokens, cost or calls", http.StatusBadRequest)
+			return
+		}
+		limit := 20
+		if s := strings.TrimSpace(q.Get("limit")); s != "" {
+			if v, err := strconv.Atoi(s); err == nil && v > 0 {
+				limit = v
+			}
+		}
+		users, err := db.GetTopUsers(filter, prices, sortBy, limit)
+		if err != nil {
+			logger.Error("Failed to get top users: %v", err)
+			http.Error(w, fmt.Sprintf("Failed to get top users: %v", err), http.StatusInternalServerError)
+			return
+		}
+		stats := TopUsersStats{Users: users, Quota: monitor.Status()}
+		if stats.Quota != nil {
+			flagged := make(map[string]bool, len(stats.Quota.Flagged))
+			for _, f := range stats.Quota.Flagged {
+				flagged[f.UserID] = true
+			}
+			for i := range stats.Users {
+				stats.Users[i].OverQuota = flagged[stats.Users[i].UserID]
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats)
+	}
+}
@@ -0,0 +1,228 @@
+package backend
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	tracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepbv1 "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+// watchOffsetsFile is the default name of the offsets file kept in the watched directory
+const watchOffsetsFile = ".simple-traces-offsets.json"
+
+// dirWatcher imports spans from the *.jsonl files of a directory, one span per line in the
+// import format (see parseImportLine), for agents that can only write local trace dumps.
+// The directory is polled; lines are read up to the last complete one, so files being
+// appended to are picked up as they grow. The offset read up to is kept per file in a state
+// file and only advances past lines whose spans were stored, so restarts and database
+// outages neither skip nor re-read lines. A file that shrinks is read again from the start.
+type dirWatcher struct {
+	dir, stateFile string
+	h              *OTLPHandler
+	logger         *Logger
+	offsets        map[string]int64
+	stop           chan struct{}
+	done           sync.WaitGroup
+}
+
+// newDirWatcher starts watching WATCH_DIR; nil when it is not set
+func newDirWatcher(config *Config, h *OTLPHandler, logger *Logger) (IngestSource, error) {
+	if config.WatchDir == "" {
+		return nil, nil
+	}
+	info, err := os.Stat(config.WatchDir)
+	if err != nil {
+		return nil, fmt.Errorf("watch dir: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("watch dir: %s is not a directory", config.WatchDir)
+	}
+	metrics.Describe("simpletraces_watch_lines_total", "counter", "Lines read from watched JSONL files by outcome (stored or invalid)")
+	w := &dirWatcher{
+		dir:       config.WatchDir,
+		stateFile: config.WatchStateFile,
+		h:         h,
+		logger:    logger,
+		offsets:   make(map[string]int64),
+		stop:      make(chan struct{}),
+	}
+	if w.stateFile == "" {
+		w.stateFile = filepath.Join(w.dir, watchOffsetsFile)
+	}
+	if b, err := os.ReadFile(w.stateFile); err == nil {
+		if err := json.Unmarshal(b, &w.offsets); err != nil {
+			return nil, fmt.Errorf("read watch offsets %s: %w", w.stateFile, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("read watch offsets: %w", err)
+	}
+	interval := config.WatchInterval
+	if interval <= 0 {
+		interval = 2 * time.Second
+	}
+	w.done.Add(1)
+	go w.run(interval)
+	return w, nil
+}
+
+func (w *dirWatcher) Name() string {
+	return "watch " + w.dir
+}
+
+func (w *dirWatcher) Close() error {
+	close(w.stop)
+	w.done.Wait()
+	return nil
+}
+
+func (w *dirWatcher) run(interval time.Duration) {
+	defer w.done.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		w.scan()
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// scan imports the new lines of every file and forgets the offsets of files that are gone
+func (w *dirWatcher) scan() {
+	files, err := filepath.Glob(filepath.Join(w.dir, "*.jsonl"))
+	if err != nil {
+		w.logger.Warn("Failed to list %s: %v", w.dir, err)
+		return
+	}
+	present := make(map[string]bool, len(files))
+	for _, path := range files {
+		name := filepath.Base(path)
+		present[name] = true
+		if err := w.importFile(path, name); err != nil {
+			w.logger.Warn("Failed to import %s: %v", path, err)
+		}
+		select {
+		case <-w.stop:
+			return
+		default:
+		}
+	}
+	changed := false
+	for name := range w.offsets {
+		if !present[name] {
+			delete(w.offsets, name)
+			changed = true
+		}
+	}
+	if changed {
+		w.saveOffsets()
+	}
+}
+
+// importFile stores the complete lines of a file past its offset, in batches of importBatchSize
+func (w *dirWatcher) importFile(path, name string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	offset := w.offsets[name]
+	if info.Size() < offset {
+		w.logger.Info("%s shrank, reading it again from the start", path)
+		offset = 0
+	}
+	if info.Size() == offset {
+		return nil
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	r := bufio.NewReaderSize(f, 64<<10)
+	pos := offset
+	batch := &tracepbv1.ScopeSpans{}
+	invalid := 0
+	commit := func() error {
+		if len(batch.Spans) > 0 {
+			if _, err := w.h.Export(&tracepb.ExportTraceServiceRequest{
+				ResourceSpans: []*tracepbv1.ResourceSpans{{ScopeSpans: []*tracepbv1.ScopeSpans{batch}}},
+			}); err != nil {
+				// the offset stays before the batch, it is read again on the next scan
+				return err
+			}
+			metrics.Add("simpletraces_watch_lines_total", float64(len(batch.Spans)), "outcome", "stored")
+		}
+		batch = &tracepbv1.ScopeSpans{}
+		w.offsets[name] = pos
+		w.saveOffsets()
+		return nil
+	}
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			// a partial last line is still being written
+			break
+		}
+		if err != nil {
+			return err
+		}
+		start := pos
+		pos += int64(len(line))
+		if line = bytes.TrimSpace(line); len(line) == 0 {
+			continue
+		}
+		span, err := parseImportLine(line)
+		if err != nil {
+			invalid++
+			metrics.Inc("simpletraces_watch_lines_total", "outcome", "invalid")
+			if invalid <= 3 {
+				w.logger.Warn("Skipping invalid line of %s at byte %d: %v", path, start, err)
+			}
+			continue
+		}
+		batch.Spans = append(batch.Spans, span)
+		if len(batch.Spans) >= importBatchSize {
+			if err := commit(); err != nil {
+				return err
+			}
+		}
+	}
+	if invalid > 3 {
+		w.logger.Warn("Skipped %d invalid lines of %s", invalid, path)
+	}
+	if pos == w.offsets[name] {
+		return nil
+	}
+	return commit()
+}
+
+// saveOffsets writes the offsets file atomically; failures are logged, the offsets are saved
+// again with the next batch
+func (w *dirWatcher) saveOffsets() {
+	b, err := json.MarshalIndent(w.offsets, "", "  ")
+	if err != nil {
+		return
+	}
+	tmp := w.stateFile + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		w.logger.Warn("Failed to save watch offsets: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, w.stateFile); err != nil {
+		w.logger.Warn("Failed to save watch offsets: %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package backend
+
+import (
+	"encoding/json"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maxBindParams is the bind parameter limit of one statement that both backends accept
+// (SQLite's SQLITE_MAX_VARIABLE_NUMBER; PostgreSQL allows 65535)
+const maxBindParams = 32766
+
+// maxBatchRows caps multi-row statements so a single write doesn't hold the lock for long
+const maxBatchRows = 500
+
+// batchRows returns how many rows of model fit in one multi-row INSERT
+func (g *GormDB) batchRows(model any) int {
+	stmt := &gorm.Statement{DB: g.db}
+	if err := stmt.Parse(model); err != nil || len(stmt.Schema.DBNames) == 0 {
+		return 100
+	}
+	return max(1, min(maxBatchRows, maxBindParams/len(stmt.Schema.DBNames)))
+}
+
+// PropagateConversationIDs links every span of the given traces (trace id -> conversation id)
+// to the conversation. Spans are read with one query and written back with multi-row upserts
+// instead of one UPDATE per span. Log records of the traces that arrived before their spans and
+// so have no conversation yet are linked too, as are the embeddings of the spans.
+func (g *GormDB) PropagateConversationIDs(byTrace map[string]string) (int64, error) {
+	if len(byTrace) == 0 {
+		return 0, nil
+	}
+	traceIDs := make([]string, 0, len(byTrace))
+	byConversation := make(map[string][]string)
+	for id, conv := range byTrace {
+		traceIDs = append(traceIDs, id)
+		byConversation[conv] = append(byConversation[conv], id)
+	}
+	for conv, ids := range byConversation {
+		if err := g.db.Model(&LogRecord{}).Where("trace_id IN ? AND conversation_id = ''", ids).
+			Update("conversation_id", conv).Error; err != nil {
+			return 0, err
+		}
+		if err := g.db.Model(&SpanEmbedding{}).Where("trace_id IN ? AND conversation_id <> ?", ids, conv).
+			Update("conversation_id", conv).Error; err != nil {
+			return 0, err
+		}
+	}
+	var spans []Span
+	// read from the primary: the spans were usually inserted a moment ago
+	if err := g.primary().Where("trace_id IN ?", traceIDs).Find(&spans).Error; err != nil {
+		return 0, err
+	}
+
+	changed := spans[:0]
+	for _, span := range spans {
+		convID := byTrace[span.TraceID]
+		var attrs map[string]interface{}
+		if span.Attributes != "" {
+			if err := json.Unmarshal([]byte(span.Attributes), &attrs); err != nil {
+				continue
+			}
+		} else {
+			attrs = make(map[string]interface{})
+		}
+		if span.ConversationID == convID && attrs["simpleTraces.conversation.id"] == convID {
+			continue
+		}
+		attrs["simpleTraces.conversation.id"] = convID
+		attrsJSON, err := json.Marshal(attrs)
+		if err != nil {
+			continue
+		}
+		span.Attributes = string(attrsJSON)
+		span.ConversationID = convID
+		changed = append(changed, span)
+	}
+	if len(changed) == 0 {
+		return 0, nil
+	}
+	err := g.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "span_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"attributes", "conversation_id"}),
+	}).CreateInBatches(changed, g.batchRows(&Span{})).Error
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(changed)), nil
+}
@@ -0,0 +1,197 @@
+package backend
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	tracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepbv1 "go.opentelemetry.io/proto/otlp/trace/v1"
+	"golang.org/x/net/websocket"
+)
+
+// wsFlushInterval is how long spans received over a WebSocket wait for a batch to fill up
+const wsFlushInterval = time.Second
+
+// Per-frame deadlines of ingest sockets: a client silent for wsReadTimeout is disconnected,
+// and so is one that doesn't take a summary within wsWriteTimeout
+const (
+	wsReadTimeout  = 5 * time.Minute
+	wsWriteTimeout = 30 * time.Second
+)
+
+// wsIngestHandler accepts spans over a WebSocket for clients that can't speak OTLP protobuf
+// (in-browser agents, notebooks). Every message holds one span JSON object in the import
+// format, a JSON array of them, or newline-delimited objects. Spans are buffered and stored in
+// batches of importBatchSize, or after wsFlushInterval; each stored batch is answered with an
+// ImportSummary whose lines count the messages and whose errors name the message number.
+//
+// Browsers may only connect from the server's own origin or one of origins (CORS_ALLOWED_ORIGINS);
+// clients that send no Origin are not browsers and are left to INGEST_TOKEN.
+func wsIngestHandler(h *OTLPHandler, origins string, logger *Logger) http.Handler {
+	metrics.Describe("simpletraces_ws_ingest_connections", "gauge", "Clients streaming spans over WebSocket")
+	var mu sync.Mutex
+	connections := 0
+	track := func(delta int) {
+		mu.Lock()
+		connections += delta
+		metrics.Set("simpletraces_ws_ingest_connections", float64(connections))
+		mu.Unlock()
+	}
+	allowed := make(map[string]bool)
+	for _, o := range splitList(origins) {
+		allowed[strings.TrimSuffix(o, "/")] = true
+	}
+	server := websocket.Server{
+		Handshake: func(_ *websocket.Config, r *http.Request) error {
+			origin := r.Header.Get("Origin")
+			if origin == "" || allowed["*"] || allowed[origin] {
+				return nil
+			}
+			if u, err := url.Parse(origin); err == nil && u.Host == r.Host {
+				return nil
+			}
+			logger.Warn("Refused WebSocket ingest from origin %s", origin)
+			return fmt.Errorf("origin %s not allowed", origin)
+		},
+		Handler: func(ws *websocket.Conn) {
+			track(1)
+			defer track(-1)
+			defer ws.Close()
+			// the socket outlives HTTP_READ_TIMEOUT and HTTP_WRITE_TIMEOUT; frames get their own
+			// deadlines instead
+			ws.SetDeadline(time.Time{})
+			ws.MaxPayloadBytes = importMaxLine
+			serveWSIngest(ws, h, logger)
+		},
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.ServeHTTP(hijackWriter{w}, r)
+	})
+}
+
+// hijackWriter exposes the connection of a response writer wrapped by middleware, which the
+// websocket package takes with a plain type assertion
+type hijackWriter struct {
+	http.ResponseWriter
+}
+
+func (hw hijackWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(hw.ResponseWriter).Hijack()
+}
+
+type wsMessage struct {
+	spans  []*tracepbv1.Span
+	errors []ImportLineError
+}
+
+// serveWSIngest reads messages until the client disconnects, storing spans as batches fill up
+func serveWSIngest(ws *websocket.Conn, h *OTLPHandler, logger *Logger) {
+	messages := make(chan wsMessage)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(messages)
+		for n := 1; ; n++ {
+			var data []byte
+			ws.SetReadDeadline(time.Now().Add(wsReadTimeout))
+			if err := websocket.Message.Receive(ws, &data); err != nil {
+				return
+			}
+			select {
+			case messages <- parseWSMessage(n, data):
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	summary := ImportSummary{Errors: []ImportLineError{}}
+	batch := &tracepbv1.ScopeSpans{}
+	flush := func() bool {
+		if len(batch.Spans) == 0 && summary.Failed == 0 {
+			return true
+		}
+		if len(batch.Spans) > 0 {
+			res, err := h.Export(&tracepb.ExportTraceServiceRequest{
+				ResourceSpans: []*tracepbv1.ResourceSpans{{ScopeSpans: []*tracepbv1.ScopeSpans{batch}}},
+			})
+			if msg := exportError(res, err); msg != "" {
+				summary.Errors = append(summary.Errors, ImportLineError{Line: summary.Lines, Error: msg})
+			}
+			summary.Inserted += res.Stored
+			summary.Skipped += res.Dropped
+			summary.Failed += res.Rejected
+		}
+		ws.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		err := websocket.JSON.Send(ws, summary)
+		summary = ImportSummary{Lines: summary.Lines, Errors: []ImportLineError{}}
+		batch = &tracepbv1.ScopeSpans{}
+		return err == nil
+	}
+
+	ticker := time.NewTicker(wsFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case m, ok := <-messages:
+			if !ok {
+				flush()
+				return
+			}
+			summary.Lines++
+			summary.Failed += len(m.errors)
+			if room := importMaxErrors - len(summary.Errors); room > 0 {
+				summary.Errors = append(summary.Errors, m.errors[:min(room, len(m.errors))]...)
+			}
+			batch.Spans = append(batch.Spans, m.spans...)
+			if len(batch.Spans) >= importBatchSize && !flush() {
+				logger.Debug("WebSocket ingest client went away")
+				return
+			}
+		case <-ticker.C:
+			if !flush() {
+				logger.Debug("WebSocket ingest client went away")
+				return
+			}
+		}
+	}
+}
+
+// parseWSMessage converts the spans of message n; invalid spans are reported and skipped
+func parseWSMessage(n int, data []byte) wsMessage {
+	var m wsMessage
+	add := func(raw []byte) {
+		span, err := parseImportLine(raw)
+		if err != nil {
+			m.errors = append(m.errors, ImportLineError{Line: n, Error: err.Error()})
+			return
+		}
+		m.spans = append(m.spans, span)
+	}
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		var items []json.RawMessage
+		if err := json.Unmarshal(data, &items); err != nil {
+			m.errors = append(m.errors, ImportLineError{Line: n, Error: "invalid JSON: " + err.Error()})
+			return m
+		}
+		for _, item := range items {
+			add(item)
+		}
+		return m
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			add(line)
+		}
+	}
+	return m
+}
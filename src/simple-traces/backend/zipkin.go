@@ -0,0 +1,179 @@
+package backend
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	tracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepbv1 "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+// zipkinSpan is a span in the Zipkin v2 JSON format. Timestamps and durations are microseconds.
+type zipkinSpan struct {
+	TraceID        string             `json:"traceId"`
+	ID             string             `json:"id"`
+	ParentID       string             `json:"parentId"`
+	Name           string             `json:"name"`
+	Kind           string             `json:"kind"`
+	Timestamp      uint64             `json:"timestamp"`
+	Duration       uint64             `json:"duration"`
+	LocalEndpoint  *zipkinEndpoint    `json:"localEndpoint"`
+	RemoteEndpoint *zipkinEndpoint    `json:"remoteEndpoint"`
+	Annotations    []zipkinAnnotation `json:"annotations"`
+	Tags           map[string]string  `json:"tags"`
+}
+
+type zipkinEndpoint struct {
+	ServiceName string `json:"serviceName"`
+	IPv4        string `json:"ipv4"`
+	IPv6        string `json:"ipv6"`
+	Port        int64  `json:"port"`
+}
+
+type zipkinAnnotation struct {
+	Timestamp uint64 `json:"timestamp"`
+	Value     string `json:"value"`
+}
+
+var zipkinKinds = map[string]tracepbv1.Span_SpanKind{
+	"CLIENT":   tracepbv1.Span_SPAN_KIND_CLIENT,
+	"SERVER":   tracepbv1.Span_SPAN_KIND_SERVER,
+	"PRODUCER": tracepbv1.Span_SPAN_KIND_PRODUCER,
+	"CONSUMER": tracepbv1.Span_SPAN_KIND_CONSUMER,
+}
+
+// zipkinHandler accepts Zipkin v2 JSON at /api/v2/spans, the endpoint Zipkin reporters post to,
+// and stores the spans like an OTLP export
+func zipkinHandler(h *OTLPHandler, logger *Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := readOTLPBody(w, r, h.maxPayload)
+		defer r.Body.Close()
+		if err != nil {
+			status := http.StatusBadRequest
+			switch err.(type) {
+			case errUnsupportedEncoding:
+				status = http.StatusUnsupportedMediaType
+			case errPayloadTooLarge:
+				status = http.StatusRequestEntityTooLarge
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		if ct := r.Header.Get("Content-Type"); strings.Contains(ct, "thrift") || strings.Contains(ct, "protobuf") {
+			http.Error(w, "only Zipkin v2 JSON is supported", http.StatusUnsupportedMediaType)
+			return
+		}
+		var spans []zipkinSpan
+		if err := json.Unmarshal(body, &spans); err != nil {
+			logger.Warn("Failed to parse Zipkin spans: %v", err)
+			http.Error(w, fmt.Sprintf("Invalid Zipkin v2 JSON: %v", err), http.StatusBadRequest)
+			return
+		}
+		req, err := zipkinToOTLP(spans)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		h.Export(req)
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// zipkinToOTLP converts Zipkin spans to an OTLP export with one resource per local service.
+// Tags become string attributes, the error tag marks the span as failed, the remote endpoint
+// becomes peer attributes and annotations become events.
+func zipkinToOTLP(spans []zipkinSpan) (*tracepb.ExportTraceServiceRequest, error) {
+	byService := make(map[string]*tracepbv1.ResourceSpans)
+	var order []string
+	for i, zs := range spans {
+		traceID, err := zipkinID(zs.TraceID, 16)
+		if err != nil {
+			return nil, fmt.Errorf("span %d: traceId: %w", i, err)
+		}
+		spanID, err := zipkinID(zs.ID, 8)
+		if err != nil {
+			return nil, fmt.Errorf("span %d: id: %w", i, err)
+		}
+		var parentID []byte
+		if zs.ParentID != "" {
+			if parentID, err = zipkinID(zs.ParentID, 8); err != nil {
+				return nil, fmt.Errorf("span %d: parentId: %w", i, err)
+			}
+		}
+
+		sp := &tracepbv1.Span{
+			TraceId:           traceID,
+			SpanId:            spanID,
+			ParentSpanId:      parentID,
+			Name:              zs.Name,
+			Kind:              zipkinKinds[strings.ToUpper(zs.Kind)],
+			StartTimeUnixNano: zs.Timestamp * 1000,
+			EndTimeUnixNano:   (zs.Timestamp + zs.Duration) * 1000,
+		}
+		if sp.Kind == tracepbv1.Span_SPAN_KIND_UNSPECIFIED {
+			sp.Kind = tracepbv1.Span_SPAN_KIND_INTERNAL
+		}
+		for k, v := range zs.Tags {
+			sp.Attributes = append(sp.Attributes, otlpAttr(k, v))
+		}
+		// Zipkin names the request path http.path
+		if p, ok := zs.Tags["http.path"]; ok && zs.Tags["url.path"] == "" {
+			sp.Attributes = append(sp.Attributes, otlpAttr("url.path", p))
+		}
+		if msg, failed := zs.Tags["error"]; failed {
+			sp.Status = &tracepbv1.Status{Code: tracepbv1.Status_STATUS_CODE_ERROR, Message: msg}
+		}
+		if re := zs.RemoteEndpoint; re != nil {
+			if re.ServiceName != "" {
+				sp.Attributes = append(sp.Attributes, otlpAttr("peer.service", re.ServiceName))
+			}
+			if addr := re.IPv4 + re.IPv6; addr != "" {
+				sp.Attributes = append(sp.Attributes, otlpAttr("network.peer.address", addr))
+			}
+			if re.Port > 0 {
+				sp.Attributes = append(sp.Attributes, otlpAttr("network.peer.port", re.Port))
+			}
+		}
+		for _, a := range zs.Annotations {
+			sp.Events = append(sp.Events, &tracepbv1.Span_Event{Name: a.Value, TimeUnixNano: a.Timestamp * 1000})
+		}
+
+		service := "unknown"
+		if zs.LocalEndpoint != nil && zs.LocalEndpoint.ServiceName != "" {
+			service = zs.LocalEndpoint.ServiceName
+		}
+		rs := byService[service]
+		if rs == nil {
+			rs = &tracepbv1.ResourceSpans{
+				Resource:   &resourcepb.Resource{Attributes: []*commonpb.KeyValue{otlpAttr("service.name", service)}},
+				ScopeSpans: []*tracepbv1.ScopeSpans{{}},
+			}
+			byService[service] = rs
+			order = append(order, service)
+		}
+		rs.ScopeSpans[0].Spans = append(rs.ScopeSpans[0].Spans, sp)
+	}
+
+	req := &tracepb.ExportTraceServiceRequest{}
+	for _, service := range order {
+		req.ResourceSpans = append(req.ResourceSpans, byService[service])
+	}
+	return req, nil
+}
+
+// zipkinID decodes a hex id, left-padding 64-bit trace ids to the 128 bits OTLP uses
+func zipkinID(s string, size int) ([]byte, error) {
+	if len(s) == 0 || len(s) > size*2 {
+		return nil, fmt.Errorf("%q is not a %d-byte hex id", s, size)
+	}
+	b, err := hex.DecodeString(strings.Repeat("0", size*2-len(s)) + s)
+	if err != nil {
+		return nil, fmt.Errorf("%q is not a %d-byte hex id", s, size)
+	}
+	return b, nil
+}
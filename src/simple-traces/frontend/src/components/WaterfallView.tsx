@@ -60,7 +60,7 @@ export default function WaterfallView({
 			m.get(id)!.push(sp)
 		}
 		const arr = Array.from(m.entries()).map(([traceId, list]) => {
-			const sorted = [...list].sort((a, b) => new Date(a.start_time!).getTime() - new Date(b.start_time!).getTime())
+			const sorted = [...list].sort((a, b) => new Date(a.start_time!).getTime() - new Date(b.start_time!).getTime() || (a.seq ?? 0) - (b.seq ?? 0))
 			const gMin = Math.min(...sorted.map((s) => new Date(s.start_time!).getTime()))
 			const gMax = Math.max(...sorted.map((s) => new Date(s.end_time!).getTime()))
 
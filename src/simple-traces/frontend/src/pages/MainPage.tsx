@@ -100,10 +100,10 @@ export default function MainPage({
       {!groupsLoading && groups.length === 0 && (
         <div className="empty-state">
           <h2>No trace groups yet</h2>
-          <p>You can import sample spans from the provided JSONL file to get started.</p>
+          <p>Send spans via OTLP to /v1/traces, or import spans from a JSONL file (one span per line) to get started.</p>
           <pre className="code-block">{`curl -X POST http://localhost:8080/api/spans/import \\
-  -H "Content-Type: application/json" \\
-  -d '{"path": "data/telegram_agent_traces.jsonl"}'`}</pre>
+  -H "Content-Type: application/x-ndjson" \\
+  --data-binary @spans.jsonl`}</pre>
         </div>
       )}
 
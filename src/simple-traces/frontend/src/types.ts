@@ -27,6 +27,8 @@ export interface SpanRecord {
   name?: string
   start_time?: string
   end_time?: string
+  // server receive sequence, breaks ties between identical start times
+  seq?: number
   status_code?: string
   status_message?: string
   attributes?: string // JSON string from backend